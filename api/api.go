@@ -12,6 +12,7 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/users"
 	"github.com/PlakarKorp/plakar/utils"
 )
 
@@ -19,6 +20,7 @@ var lstore storage.Store
 var lconfig storage.Configuration
 var lctx *appcontext.AppContext // XXX: Adding this for transition, it needs to go away. Some places we only have Repository and out of AppContext we only get a KContext, except sometimes you truly need an AppContext.
 var lrepository *repository.Repository
+var lauth *Authenticator
 
 type Item[T any] struct {
 	Item T `json:"item"`
@@ -115,34 +117,54 @@ func TokenAuthMiddleware(token string) func(http.Handler) http.Handler {
 }
 
 func apiInfo(w http.ResponseWriter, r *http.Request) error {
+	repo := repositoryOf(r)
 	authenticated := false
-	configuration := lrepository.Configuration()
+	configuration := repo.Configuration()
 	if authToken, err := lctx.GetCookies().GetAuthToken(); err == nil && authToken != "" {
 		authenticated = true
 	}
 
 	res := &struct {
-		RepositoryId  string `json:"repository_id"`
-		Authenticated bool   `json:"authenticated"`
-		Version       string `json:"version"`
-		Browsable     bool   `json:"browsable"`
+		RepositoryId  string     `json:"repository_id"`
+		Authenticated bool       `json:"authenticated"`
+		Version       string     `json:"version"`
+		Browsable     bool       `json:"browsable"`
+		User          string     `json:"user,omitempty"`
+		Role          users.Role `json:"role,omitempty"`
 	}{
 		RepositoryId:  configuration.RepositoryID.String(),
 		Authenticated: authenticated,
 		Version:       utils.GetVersion(),
-		Browsable:     lrepository.Store().Mode()&storage.ModeRead != 0,
+		Browsable:     repo.Store().Mode()&storage.ModeRead != 0,
+	}
+	if p := requestPrincipal(r); p != nil {
+		res.User = p.Name
+		res.Role = p.Role
 	}
 	return json.NewEncoder(w).Encode(res)
 }
 
 func SetupRoutes(server *http.ServeMux, repo *repository.Repository, ctx *appcontext.AppContext, token string) {
+	SetupRoutesWithAuth(server, repo, ctx, NewAuthenticator(token, nil, nil))
+}
+
+// SetupRoutesWithAuth registers the API routes, each one guarded by the
+// minimal role needed to use it.
+func SetupRoutesWithAuth(server *http.ServeMux, repo *repository.Repository, ctx *appcontext.AppContext, auth *Authenticator) {
 	lstore = repo.Store()
 	lconfig = repo.Configuration()
 	lrepository = repo
 	lctx = ctx
+	lauth = auth
+	lrepositories = NewRepositories(ctx)
+
+	viewer := auth.Require(users.RoleViewer)
+	restorer := auth.Require(users.RoleRestorer)
+	operator := auth.Require(users.RoleOperator)
+	admin := auth.Require(users.RoleAdmin)
 
-	authToken := TokenAuthMiddleware(token)
-	urlSigner := NewSnapshotReaderURLSigner(token)
+	urlSigner := NewSnapshotReaderURLSigner(auth.token)
+	urlSigner.fallback = restorer
 
 	// Catch all API endpoint, called if no more specific API endpoint is found
 	server.Handle("/api/", JSONAPIView(func(w http.ResponseWriter, r *http.Request) error {
@@ -153,36 +175,58 @@ func SetupRoutes(server *http.ServeMux, repo *repository.Repository, ctx *appcon
 		}
 	}))
 
-	server.Handle("GET /api/info", authToken(JSONAPIView(apiInfo)))
-
-	server.Handle("POST /api/authentication/login/github", authToken(JSONAPIView(servicesLoginGithub)))
-	server.Handle("POST /api/authentication/login/email", authToken(JSONAPIView(servicesLoginEmail)))
-	server.Handle("POST /api/authentication/logout", authToken(JSONAPIView(servicesLogout)))
-
-	server.Handle("GET /api/proxy/v1/account/me", authToken(JSONAPIView(servicesProxy)))
-	server.Handle("GET /api/proxy/v1/account/notifications", authToken(JSONAPIView(servicesProxy)))
-	server.Handle("POST /api/proxy/v1/account/notifications/set-status", authToken(JSONAPIView(servicesProxy)))
-	server.Handle("GET /api/proxy/v1/account/services/alerting", authToken(JSONAPIView(servicesGetAlertingServiceConfiguration)))
-	server.Handle("PUT /api/proxy/v1/account/services/alerting", authToken(JSONAPIView(servicesSetAlertingServiceConfiguration)))
-	server.Handle("GET /api/proxy/v1/reporting/reports", authToken(JSONAPIView(servicesProxy)))
-
-	server.Handle("GET /api/repository/info", authToken(JSONAPIView(repositoryInfo)))
-	server.Handle("GET /api/repository/snapshots", authToken(JSONAPIView(repositorySnapshots)))
-	server.Handle("GET /api/repository/locate-pathname", authToken(JSONAPIView(repositoryLocatePathname)))
-	server.Handle("GET /api/repository/importer-types", authToken(JSONAPIView(repositoryImporterTypes)))
-	server.Handle("GET /api/repository/states", authToken(JSONAPIView(repositoryStates)))
-	server.Handle("GET /api/repository/state/{state}", authToken(JSONAPIView(repositoryState)))
-
-	server.Handle("GET /api/snapshot/{snapshot}", authToken(JSONAPIView(snapshotHeader)))
-	server.Handle("GET /api/snapshot/reader/{snapshot_path...}", urlSigner.VerifyMiddleware(APIView(snapshotReader)))
-	server.Handle("POST /api/snapshot/reader-sign-url/{snapshot_path...}", authToken(JSONAPIView(urlSigner.Sign)))
-
-	server.Handle("GET /api/snapshot/vfs/{snapshot_path...}", authToken(JSONAPIView(snapshotVFSBrowse)))
-	server.Handle("GET /api/snapshot/vfs/children/{snapshot_path...}", authToken(JSONAPIView(snapshotVFSChildren)))
-	server.Handle("GET /api/snapshot/vfs/chunks/{snapshot_path...}", authToken(JSONAPIView(snapshotVFSChunks)))
-	server.Handle("GET /api/snapshot/vfs/search/{snapshot_path...}", authToken(JSONAPIView(snapshotVFSSearch)))
-	server.Handle("GET /api/snapshot/vfs/errors/{snapshot_path...}", authToken(JSONAPIView(snapshotVFSErrors)))
-
-	server.Handle("POST /api/snapshot/vfs/downloader/{snapshot_path...}", authToken(JSONAPIView(snapshotVFSDownloader)))
+	server.Handle("POST /api/authentication/session", JSONAPIView(auth.login))
+	server.Handle("GET /api/authentication/whoami", viewer(JSONAPIView(auth.whoami)))
+
+	server.Handle("GET /api/users", admin(JSONAPIView(auth.usersList)))
+	server.Handle("POST /api/users", admin(JSONAPIView(auth.usersCreate)))
+	server.Handle("DELETE /api/users/{name}", admin(JSONAPIView(auth.usersDelete)))
+
+	server.Handle("POST /api/authentication/login/github", operator(JSONAPIView(servicesLoginGithub)))
+	server.Handle("POST /api/authentication/login/email", operator(JSONAPIView(servicesLoginEmail)))
+	server.Handle("POST /api/authentication/logout", operator(JSONAPIView(servicesLogout)))
+
+	server.Handle("GET /api/proxy/v1/account/me", viewer(JSONAPIView(servicesProxy)))
+	server.Handle("GET /api/proxy/v1/account/notifications", viewer(JSONAPIView(servicesProxy)))
+	server.Handle("POST /api/proxy/v1/account/notifications/set-status", operator(JSONAPIView(servicesProxy)))
+	server.Handle("GET /api/proxy/v1/account/services/alerting", viewer(JSONAPIView(servicesGetAlertingServiceConfiguration)))
+	server.Handle("PUT /api/proxy/v1/account/services/alerting", operator(JSONAPIView(servicesSetAlertingServiceConfiguration)))
+	server.Handle("GET /api/proxy/v1/reporting/reports", viewer(JSONAPIView(servicesProxy)))
+
+	// Repository endpoints are served for the repository the UI was
+	// started on, and for every configured repository under
+	// /api/repositories/{repository}.
+	routes := func(prefix string, scope func(http.Handler) http.Handler) {
+		server.Handle("GET "+prefix+"/info", viewer(scope(JSONAPIView(apiInfo))))
+
+		server.Handle("GET "+prefix+"/repository/info", viewer(scope(JSONAPIView(repositoryInfo))))
+		server.Handle("GET "+prefix+"/repository/snapshots", viewer(scope(JSONAPIView(repositorySnapshots))))
+		server.Handle("GET "+prefix+"/repository/locate-pathname", viewer(scope(JSONAPIView(repositoryLocatePathname))))
+		server.Handle("GET "+prefix+"/repository/importer-types", viewer(scope(JSONAPIView(repositoryImporterTypes))))
+		server.Handle("GET "+prefix+"/repository/states", viewer(scope(JSONAPIView(repositoryStates))))
+		server.Handle("GET "+prefix+"/repository/state/{state}", viewer(scope(JSONAPIView(repositoryState))))
+		server.Handle("GET "+prefix+"/repository/history/{path...}", viewer(scope(JSONAPIView(repositoryHistory))))
+
+		server.Handle("GET "+prefix+"/snapshot/{snapshot}", viewer(scope(JSONAPIView(snapshotHeader))))
+		server.Handle("GET "+prefix+"/snapshot/diff/{a}/{b}/{path...}", viewer(scope(JSONAPIView(snapshotDiff))))
+		server.Handle("GET "+prefix+"/snapshot/unified-diff/{a}/{b}/{path...}", restorer(scope(APIView(snapshotUnifiedDiff))))
+		server.Handle("GET "+prefix+"/snapshot/reader/{snapshot_path...}", urlSigner.VerifyMiddleware(scope, APIView(snapshotReader)))
+		server.Handle("POST "+prefix+"/snapshot/reader-sign-url/{snapshot_path...}", restorer(scope(JSONAPIView(urlSigner.Sign))))
+
+		server.Handle("GET "+prefix+"/snapshot/vfs/{snapshot_path...}", viewer(scope(JSONAPIView(snapshotVFSBrowse))))
+		server.Handle("GET "+prefix+"/snapshot/vfs/children/{snapshot_path...}", viewer(scope(JSONAPIView(snapshotVFSChildren))))
+		server.Handle("GET "+prefix+"/snapshot/vfs/chunks/{snapshot_path...}", viewer(scope(JSONAPIView(snapshotVFSChunks))))
+		server.Handle("GET "+prefix+"/snapshot/vfs/search/{snapshot_path...}", viewer(scope(JSONAPIView(snapshotVFSSearch))))
+		server.Handle("GET "+prefix+"/snapshot/vfs/errors/{snapshot_path...}", viewer(scope(JSONAPIView(snapshotVFSErrors))))
+		server.Handle("GET "+prefix+"/snapshot/vfs/grep/{snapshot_path...}", restorer(scope(JSONAPIView(snapshotVFSGrep))))
+
+		server.Handle("POST "+prefix+"/snapshot/vfs/downloader/{snapshot_path...}", restorer(scope(JSONAPIView(snapshotVFSDownloader))))
+	}
+	routes("/api", func(next http.Handler) http.Handler { return next })
+	routes("/api/repositories/{repository}", withNamedRepository)
+
+	server.Handle("GET /api/repositories", viewer(JSONAPIView(repositoriesList)))
+	server.Handle("POST /api/repositories/{repository}/unlock", operator(JSONAPIView(repositoryUnlock)))
+
 	server.Handle("GET /api/snapshot/vfs/downloader-sign-url/{id}", JSONAPIView(snapshotVFSDownloaderSigned))
 }
@@ -0,0 +1,433 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/iterator"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/users"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// files larger than this are not loaded in memory to be diffed
+const maxUnifiedDiffSize = 8 << 20
+
+var (
+	ErrDiffDirectory = errors.New("cannot produce a unified diff of a directory")
+	ErrDiffTooLarge  = errors.New("file is too large to be diffed")
+)
+
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+)
+
+type SnapshotDiffEntry struct {
+	Path   string     `json:"path"`
+	Change string     `json:"change"`
+	Before *vfs.Entry `json:"before,omitempty"`
+	After  *vfs.Entry `json:"after,omitempty"`
+}
+
+// diffPath normalizes a {path...} parameter, which the mux hands over
+// without its leading slash.
+func diffPath(r *http.Request) string {
+	return path.Clean("/" + r.PathValue("path"))
+}
+
+// isPathWithin reports whether pathname is prefix itself or below it,
+// so that diffing a single file compares that file.
+func isPathWithin(prefix, pathname string) bool {
+	if prefix == "/" || pathname == prefix {
+		return true
+	}
+	return strings.HasPrefix(pathname, prefix+"/")
+}
+
+type diffCursor struct {
+	it     iterator.Iterator[string, objects.MAC]
+	prefix string
+
+	path  string
+	mac   objects.MAC
+	valid bool
+}
+
+func newDiffCursor(pvfs *vfs.Filesystem, prefix string) (*diffCursor, error) {
+	tree, _, _ := pvfs.BTrees()
+	it, err := tree.ScanAll()
+	if err != nil {
+		return nil, err
+	}
+	c := &diffCursor{it: it, prefix: prefix}
+	c.advance()
+	return c, nil
+}
+
+func (c *diffCursor) advance() {
+	for c.it.Next() {
+		c.path, c.mac = c.it.Current()
+		if isPathWithin(c.prefix, c.path) {
+			c.valid = true
+			return
+		}
+	}
+	c.valid = false
+}
+
+// diffFilesystems walks both trees in their natural order and yields
+// the entries below prefix that differ.  Directories present on both
+// sides are never reported as modified: their entry changes whenever
+// anything below them does.
+func diffFilesystems(fs1, fs2 *vfs.Filesystem, prefix string, yield func(*SnapshotDiffEntry) bool) error {
+	c1, err := newDiffCursor(fs1, prefix)
+	if err != nil {
+		return err
+	}
+	c2, err := newDiffCursor(fs2, prefix)
+	if err != nil {
+		return err
+	}
+
+	for c1.valid || c2.valid {
+		var cmp int
+		switch {
+		case !c1.valid:
+			cmp = 1
+		case !c2.valid:
+			cmp = -1
+		default:
+			cmp = vfs.PathCmp(c1.path, c2.path)
+		}
+
+		var change *SnapshotDiffEntry
+		switch {
+		case cmp < 0:
+			before, err := fs1.ResolveEntry(c1.mac)
+			if err != nil {
+				return err
+			}
+			change = &SnapshotDiffEntry{Path: c1.path, Change: DiffRemoved, Before: before}
+			c1.advance()
+
+		case cmp > 0:
+			after, err := fs2.ResolveEntry(c2.mac)
+			if err != nil {
+				return err
+			}
+			change = &SnapshotDiffEntry{Path: c2.path, Change: DiffAdded, After: after}
+			c2.advance()
+
+		default:
+			if c1.mac != c2.mac {
+				before, err := fs1.ResolveEntry(c1.mac)
+				if err != nil {
+					return err
+				}
+				after, err := fs2.ResolveEntry(c2.mac)
+				if err != nil {
+					return err
+				}
+				if !before.IsDir() || !after.IsDir() {
+					change = &SnapshotDiffEntry{Path: c1.path, Change: DiffModified, Before: before, After: after}
+				}
+			}
+			c1.advance()
+			c2.advance()
+		}
+
+		if change == nil {
+			continue
+		}
+		// These might be huge and we don't need them in this
+		// context in the UI.
+		for _, entry := range []*vfs.Entry{change.Before, change.After} {
+			if entry != nil && entry.ResolvedObject != nil {
+				entry.ResolvedObject.Chunks = nil
+			}
+		}
+		if !yield(change) {
+			return nil
+		}
+	}
+
+	if err := c1.it.Err(); err != nil {
+		return err
+	}
+	return c2.it.Err()
+}
+
+func snapshotDiff(w http.ResponseWriter, r *http.Request) error {
+	repo := repositoryOf(r)
+	snapshotID1, err := SnapshotIDParam(r, repo, "a")
+	if err != nil {
+		return err
+	}
+	snapshotID2, err := SnapshotIDParam(r, repo, "b")
+	if err != nil {
+		return err
+	}
+
+	offset, err := QueryParamToInt64(r, "offset", 0, 0)
+	if err != nil {
+		return err
+	}
+
+	limit, err := QueryParamToInt64(r, "limit", 1, 50)
+	if err != nil {
+		return err
+	}
+
+	snap1, err := loadsnap(repo, snapshotID1)
+	if err != nil {
+		return err
+	}
+	snap2, err := loadsnap(repo, snapshotID2)
+	if err != nil {
+		return err
+	}
+
+	fs1, err := snap1.Filesystem()
+	if err != nil {
+		return err
+	}
+	fs2, err := snap2.Filesystem()
+	if err != nil {
+		return err
+	}
+
+	items := ItemsPage[*SnapshotDiffEntry]{
+		Items: []*SnapshotDiffEntry{},
+	}
+
+	// the walk stops at the first change past the page, which
+	// tells whether there's a next page of results.
+	var i int64
+	err = diffFilesystems(fs1, fs2, diffPath(r), func(change *SnapshotDiffEntry) bool {
+		if i < offset {
+			i++
+			return true
+		}
+		if i >= offset+limit {
+			items.HasNext = true
+			return false
+		}
+		items.Items = append(items.Items, change)
+		i++
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(items)
+}
+
+type PathVersion struct {
+	Entry     *vfs.Entry `json:"entry"`
+	FirstSeen time.Time  `json:"first_seen"`
+	LastSeen  time.Time  `json:"last_seen"`
+	Snapshots []string   `json:"snapshots"`
+}
+
+func repositoryHistory(w http.ResponseWriter, r *http.Request) error {
+	repo := repositoryOf(r)
+	offset, err := QueryParamToUint32(r, "offset", 0, 0)
+	if err != nil {
+		return err
+	}
+	limit, err := QueryParamToUint32(r, "limit", 1, 50)
+	if err != nil {
+		return err
+	}
+
+	sortKeys, err := QueryParamToSortKeys(r, "sort", "Timestamp")
+	if err != nil {
+		return err
+	}
+
+	pathname := diffPath(r)
+
+	repo.RebuildState()
+
+	snapshotIDs, err := repo.GetSnapshots()
+	if err != nil {
+		return err
+	}
+
+	type sighting struct {
+		snap  *snapshot.Snapshot
+		entry *vfs.Entry
+	}
+
+	sightings := make([]sighting, 0, len(snapshotIDs))
+	for _, snapshotID := range snapshotIDs {
+		snap, err := snapshot.Load(repo, snapshotID)
+		if err != nil {
+			return err
+		}
+
+		pvfs, err := snap.Filesystem()
+		if err != nil {
+			snap.Close()
+			continue
+		}
+
+		entry, err := pvfs.GetEntry(pathname)
+		if err != nil {
+			snap.Close()
+			continue
+		}
+
+		if entry.ResolvedObject != nil {
+			entry.ResolvedObject.Chunks = nil
+		}
+		sightings = append(sightings, sighting{snap: snap, entry: entry})
+		snap.Close()
+	}
+
+	slices.SortFunc(sightings, func(a, b sighting) int {
+		return a.snap.Header.Timestamp.Compare(b.snap.Header.Timestamp)
+	})
+
+	// two snapshots hold the same version of a path when they share
+	// the content, or the entry itself for what has no content.
+	versions := make([]*PathVersion, 0)
+	byKey := make(map[objects.MAC]*PathVersion)
+	for _, s := range sightings {
+		key := s.entry.MAC
+		if s.entry.HasObject() {
+			key = s.entry.Object
+		}
+
+		version, ok := byKey[key]
+		if !ok {
+			version = &PathVersion{
+				Entry:     s.entry,
+				FirstSeen: s.snap.Header.Timestamp,
+				Snapshots: []string{},
+			}
+			byKey[key] = version
+			versions = append(versions, version)
+		}
+		version.LastSeen = s.snap.Header.Timestamp
+		version.Snapshots = append(version.Snapshots, fmt.Sprintf("%x", s.snap.Header.Identifier))
+	}
+
+	if len(sortKeys) > 0 && sortKeys[0] == "-Timestamp" {
+		slices.Reverse(versions)
+	}
+
+	items := Items[*PathVersion]{
+		Total: len(versions),
+		Items: []*PathVersion{},
+	}
+
+	if offset < uint32(len(versions)) {
+		versions = versions[offset:]
+		if limit < uint32(len(versions)) {
+			versions = versions[:limit]
+		}
+		items.Items = append(items.Items, versions...)
+	}
+
+	return json.NewEncoder(w).Encode(items)
+}
+
+func readForDiff(snap *snapshot.Snapshot, pathname string) ([]byte, error) {
+	pvfs, err := snap.Filesystem()
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := pvfs.GetEntry(pathname)
+	if err != nil {
+		return nil, err
+	}
+	if entry.IsDir() {
+		return nil, parameterError("path", InvalidArgument, ErrDiffDirectory)
+	}
+	if entry.Size() > maxUnifiedDiffSize {
+		return nil, parameterError("path", InvalidArgument, ErrDiffTooLarge)
+	}
+	if !entry.Stat().Mode().IsRegular() {
+		return []byte{}, nil
+	}
+
+	rd, err := snap.NewReader(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	return io.ReadAll(rd)
+}
+
+func snapshotUnifiedDiff(w http.ResponseWriter, r *http.Request) error {
+	repo := repositoryOf(r)
+	snapshotID1, err := SnapshotIDParam(r, repo, "a")
+	if err != nil {
+		return err
+	}
+	snapshotID2, err := SnapshotIDParam(r, repo, "b")
+	if err != nil {
+		return err
+	}
+
+	pathname := diffPath(r)
+
+	snap1, err := loadsnap(repo, snapshotID1)
+	if err != nil {
+		return err
+	}
+	snap2, err := loadsnap(repo, snapshotID2)
+	if err != nil {
+		return err
+	}
+
+	// a file missing on one side is diffed against empty content
+	buf1, err1 := readForDiff(snap1, pathname)
+	buf2, err2 := readForDiff(snap2, pathname)
+	if err1 != nil && (err2 != nil || !errors.Is(err1, fs.ErrNotExist)) {
+		return err1
+	}
+	if err2 != nil && !errors.Is(err2, fs.ErrNotExist) {
+		return err2
+	}
+
+	if lauth != nil {
+		lauth.Audit(r, &users.AuditEvent{
+			Action:   "diff",
+			Snapshot: fmt.Sprintf("%x,%x", snapshotID1, snapshotID2),
+			Paths:    []string{pathname},
+			Success:  true,
+		})
+	}
+
+	text, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(buf1)),
+		B:        difflib.SplitLines(string(buf2)),
+		FromFile: fmt.Sprintf("%x:%s", snap1.Header.GetIndexShortID(), pathname),
+		ToFile:   fmt.Sprintf("%x:%s", snap2.Header.GetIndexShortID(), pathname),
+		Context:  3,
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err = io.WriteString(w, text)
+	return err
+}
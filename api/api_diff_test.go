@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotDiffAndHistory(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+	snap1 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/same.txt", 0644, "unchanged\n"),
+		ptesting.NewMockFile("subdir/edited.txt", 0644, "one\ntwo\n"),
+		ptesting.NewMockFile("subdir/removed.txt", 0644, "bye\n"),
+	})
+	defer snap1.Close()
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/same.txt", 0644, "unchanged\n"),
+		ptesting.NewMockFile("subdir/edited.txt", 0644, "one\nthree\n"),
+		ptesting.NewMockFile("subdir/added.txt", 0644, "hi\n"),
+	})
+	defer snap2.Close()
+
+	mux := http.NewServeMux()
+	SetupRoutes(mux, repo, ctx, "")
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return w
+	}
+
+	id1 := fmt.Sprintf("%x", snap1.Header.Identifier)
+	id2 := fmt.Sprintf("%x", snap2.Header.Identifier)
+
+	var diff ItemsPage[*SnapshotDiffEntry]
+	w := get("/api/snapshot/diff/" + id1 + "/" + id2 + "/")
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&diff))
+	require.False(t, diff.HasNext)
+
+	var edited string
+	changes := map[string]string{}
+	for _, change := range diff.Items {
+		name := path.Base(change.Path)
+		changes[name] = change.Change
+		if name == "edited.txt" {
+			edited = change.Path
+		}
+	}
+	require.Equal(t, map[string]string{
+		"edited.txt":  DiffModified,
+		"removed.txt": DiffRemoved,
+		"added.txt":   DiffAdded,
+	}, changes)
+
+	w = get("/api/snapshot/diff/" + id1 + "/" + id2 + "/?limit=1")
+	diff = ItemsPage[*SnapshotDiffEntry]{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&diff))
+	require.True(t, diff.HasNext)
+	require.Len(t, diff.Items, 1)
+
+	w = get("/api/snapshot/diff/" + id1 + "/" + id2 + edited)
+	diff = ItemsPage[*SnapshotDiffEntry]{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&diff))
+	require.Len(t, diff.Items, 1)
+	require.Equal(t, edited, diff.Items[0].Path)
+	require.Equal(t, DiffModified, diff.Items[0].Change)
+
+	w = get("/api/snapshot/diff/" + id1 + "/" + id2 + path.Join(path.Dir(edited), "same.txt"))
+	diff = ItemsPage[*SnapshotDiffEntry]{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&diff))
+	require.Empty(t, diff.Items)
+
+	var history Items[*PathVersion]
+	w = get("/api/repository/history" + edited)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+	require.Equal(t, 2, history.Total)
+	require.Equal(t, []string{id1}, history.Items[0].Snapshots)
+
+	w = get("/api/repository/history" + path.Join(path.Dir(edited), "same.txt"))
+	history = Items[*PathVersion]{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+	require.Equal(t, 1, history.Total)
+	require.Len(t, history.Items[0].Snapshots, 2)
+
+	w = get("/api/snapshot/unified-diff/" + id1 + "/" + id2 + edited)
+	require.Contains(t, w.Body.String(), "-two\n")
+	require.Contains(t, w.Body.String(), "+three\n")
+}
@@ -26,6 +26,21 @@ func SnapshotPathParam(r *http.Request, repo *repository.Repository, param strin
 	return mac, path, nil
 }
 
+// Parse a URL parameter holding a snapshot identifier or a prefix of it.
+func SnapshotIDParam(r *http.Request, repo *repository.Repository, param string) (objects.MAC, error) {
+	idstr := r.PathValue(param)
+
+	if idstr == "" {
+		return objects.MAC{}, parameterError(param, MissingArgument, ErrMissingField)
+	}
+
+	mac, err := utils.LocateSnapshotByPrefix(repo, idstr)
+	if err != nil {
+		return objects.MAC{}, parameterError(param, InvalidArgument, err)
+	}
+	return mac, nil
+}
+
 func PathParamToID(r *http.Request, param string) (id [32]byte, err error) {
 	idstr := r.PathValue(param)
 
@@ -0,0 +1,325 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/encryption"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/kloset/versioning"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/keyring"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+var (
+	ErrRepositoryLocked  = errors.New("repository is locked, a passphrase is required")
+	ErrInvalidPassphrase = errors.New("invalid passphrase")
+	ErrNotEncrypted      = errors.New("repository is not encrypted")
+)
+
+type repositoryKey struct{}
+
+// repositoryOf returns the repository a request operates on: the one
+// selected through /api/repositories/{repository}/..., or the repository
+// the UI was started on.
+func repositoryOf(r *http.Request) *repository.Repository {
+	if repo, ok := r.Context().Value(repositoryKey{}).(*repository.Repository); ok {
+		return repo
+	}
+	return lrepository
+}
+
+type openedRepository struct {
+	ctx        *appcontext.AppContext
+	store      storage.Store
+	repo       *repository.Repository
+	encryption *encryption.Configuration
+}
+
+// Repositories lazily opens the repositories configured in klosets.yml
+// so that a single UI process can browse all of them.
+type Repositories struct {
+	ctx *appcontext.AppContext
+
+	mtx    sync.Mutex
+	opened map[string]*openedRepository
+}
+
+var lrepositories *Repositories
+
+func NewRepositories(ctx *appcontext.AppContext) *Repositories {
+	return &Repositories{
+		ctx:    ctx,
+		opened: make(map[string]*openedRepository),
+	}
+}
+
+func (rs *Repositories) names() []string {
+	if rs.ctx.Config == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(rs.ctx.Config.Repositories))
+	for name := range rs.ctx.Config.Repositories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (rs *Repositories) isOpened(name string) (*repository.Repository, bool) {
+	rs.mtx.Lock()
+	defer rs.mtx.Unlock()
+
+	if o, ok := rs.opened[name]; ok {
+		return o.repo, true
+	}
+	return nil, false
+}
+
+func (rs *Repositories) passphrase(storeConfig map[string]string) ([]byte, error) {
+	if pass, ok := storeConfig["passphrase"]; ok {
+		return []byte(pass), nil
+	}
+	if cmd, ok := storeConfig["passphrase_cmd"]; ok {
+		return utils.GetPassphraseFromCommand(cmd)
+	}
+	return nil, nil
+}
+
+// Open returns the named repository, opening it on first use.  The
+// passphrase is only needed for encrypted repositories whose
+// configuration has neither passphrase nor passphrase_cmd.
+func (rs *Repositories) Open(name string, passphrase []byte) (*repository.Repository, error) {
+	rs.mtx.Lock()
+	defer rs.mtx.Unlock()
+
+	if o, ok := rs.opened[name]; ok {
+		return o.repo, nil
+	}
+
+	if rs.ctx.Config == nil || !rs.ctx.Config.HasRepository(name) {
+		return nil, &ApiError{
+			HttpCode: 404,
+			ErrCode:  "not-found",
+			Message:  fmt.Sprintf("repository %q not found", name),
+		}
+	}
+
+	storeConfig, err := rs.ctx.Config.GetRepository("@" + name)
+	if err != nil {
+		return nil, err
+	}
+
+	store, serializedConfig, err := storage.Open(rs.ctx.GetInner(), storeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the repository %s: %w", name, err)
+	}
+
+	repoConfig, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	if repoConfig.Version != versioning.FromString(storage.VERSION) {
+		store.Close()
+		return nil, fmt.Errorf("incompatible repository version: %s != %s",
+			repoConfig.Version, storage.VERSION)
+	}
+
+	var secret []byte
+	if repoConfig.Encryption != nil {
+		if passphrase == nil {
+			passphrase, err = rs.passphrase(storeConfig)
+			if err != nil {
+				store.Close()
+				return nil, err
+			}
+		}
+		if passphrase == nil {
+			store.Close()
+			return nil, &ApiError{
+				HttpCode: http.StatusUnauthorized,
+				ErrCode:  "repository-locked",
+				Message:  ErrRepositoryLocked.Error(),
+			}
+		}
+
+		key, err := keyring.Unlock(store, repoConfig.Encryption, passphrase)
+		if err != nil && !errors.Is(err, keyring.ErrCantUnlock) {
+			store.Close()
+			return nil, err
+		}
+		if err != nil {
+			store.Close()
+			return nil, invalidPassphraseError()
+		}
+		secret = key
+	}
+
+	repoCtx := appcontext.NewAppContextFrom(rs.ctx)
+	repoCtx.SetSecret(secret)
+
+	repoStore, secret, err := keyring.OpenStore(store, serializedConfig, secret, rs.ctx.CacheDir)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	store = repoStore
+
+	repo, err := repository.New(repoCtx.GetInner(), secret, store, serializedConfig)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	rs.opened[name] = &openedRepository{
+		ctx:        repoCtx,
+		store:      store,
+		repo:       repo,
+		encryption: repoConfig.Encryption,
+	}
+	return repo, nil
+}
+
+// Unlock opens the named repository with passphrase.  If it is already
+// opened, the passphrase is still checked against its keyring, so that
+// a wrong one is never reported as unlocking it.
+func (rs *Repositories) Unlock(name string, passphrase []byte) (*repository.Repository, error) {
+	rs.mtx.Lock()
+	o, ok := rs.opened[name]
+	rs.mtx.Unlock()
+
+	if !ok {
+		return rs.Open(name, passphrase)
+	}
+
+	if o.encryption == nil {
+		return nil, &ApiError{
+			HttpCode: http.StatusConflict,
+			ErrCode:  "not-encrypted",
+			Message:  ErrNotEncrypted.Error(),
+		}
+	}
+
+	if _, err := keyring.Unlock(o.store, o.encryption, passphrase); err != nil {
+		if errors.Is(err, keyring.ErrCantUnlock) {
+			return nil, invalidPassphraseError()
+		}
+		return nil, err
+	}
+	return o.repo, nil
+}
+
+func invalidPassphraseError() error {
+	return &ApiError{
+		HttpCode: http.StatusUnauthorized,
+		ErrCode:  "invalid-passphrase",
+		Message:  ErrInvalidPassphrase.Error(),
+	}
+}
+
+func (rs *Repositories) Close() error {
+	rs.mtx.Lock()
+	defer rs.mtx.Unlock()
+
+	for name, o := range rs.opened {
+		if err := o.repo.Close(); err != nil {
+			rs.ctx.GetLogger().Warn("could not close repository %s: %s", name, err)
+		}
+		if err := o.store.Close(); err != nil {
+			rs.ctx.GetLogger().Warn("could not close store %s: %s", name, err)
+		}
+		o.ctx.Close()
+		delete(rs.opened, name)
+	}
+	return nil
+}
+
+// CloseRepositories closes every repository lazily opened by the API.
+func CloseRepositories() error {
+	if lrepositories == nil {
+		return nil
+	}
+	return lrepositories.Close()
+}
+
+// withNamedRepository resolves the {repository} path parameter and
+// attaches the matching repository to the request.
+func withNamedRepository(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo, err := lrepositories.Open(r.PathValue("repository"), nil)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			handleError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), repositoryKey{}, repo)))
+	})
+}
+
+type RepositoryListEntry struct {
+	Name         string `json:"name"`
+	Location     string `json:"location"`
+	Default      bool   `json:"default"`
+	Opened       bool   `json:"opened"`
+	Current      bool   `json:"current"`
+	RepositoryID string `json:"repository_id,omitempty"`
+}
+
+func repositoriesList(w http.ResponseWriter, r *http.Request) error {
+	items := Items[RepositoryListEntry]{
+		Items: []RepositoryListEntry{},
+	}
+
+	for _, name := range lrepositories.names() {
+		entry := RepositoryListEntry{
+			Name:     name,
+			Location: lrepositories.ctx.Config.Repositories[name]["location"],
+			Default:  lrepositories.ctx.Config.DefaultRepository == name,
+		}
+		if repo, ok := lrepositories.isOpened(name); ok {
+			entry.Opened = true
+			entry.RepositoryID = repo.Configuration().RepositoryID.String()
+			entry.Current = lrepository != nil &&
+				repo.Configuration().RepositoryID == lrepository.Configuration().RepositoryID
+		}
+		items.Items = append(items.Items, entry)
+	}
+	items.Total = len(items.Items)
+
+	return json.NewEncoder(w).Encode(items)
+}
+
+type RepositoryUnlockRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+func repositoryUnlock(w http.ResponseWriter, r *http.Request) error {
+	var req RepositoryUnlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return parameterError("BODY", InvalidArgument, err)
+	}
+
+	repo, err := lrepositories.Unlock(r.PathValue("repository"), []byte(req.Passphrase))
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(Item[RepositoryListEntry]{
+		Item: RepositoryListEntry{
+			Name:         r.PathValue("repository"),
+			Location:     repo.Location(),
+			Opened:       true,
+			RepositoryID: repo.Configuration().RepositoryID.String(),
+		},
+	})
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/config"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositories(t *testing.T) {
+	passphrase := []byte("correct horse")
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, &passphrase)
+
+	ctx.Config = config.NewConfig()
+	ctx.Config.DefaultRepository = "main"
+	ctx.Config.Repositories["main"] = map[string]string{"location": repo.Location()}
+
+	mux := http.NewServeMux()
+	SetupRoutes(mux, repo, ctx, "")
+	defer CloseRepositories()
+
+	req, err := http.NewRequest("GET", "/api/repositories", nil)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var list Items[RepositoryListEntry]
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	require.Equal(t, 1, list.Total)
+	require.Equal(t, "main", list.Items[0].Name)
+	require.True(t, list.Items[0].Default)
+	require.False(t, list.Items[0].Opened)
+
+	require.Equal(t, http.StatusNotFound, doRequest(mux, "GET", "/api/repositories/unknown/repository/info", ""))
+	require.Equal(t, http.StatusUnauthorized, doRequest(mux, "GET", "/api/repositories/main/repository/info", ""))
+
+	unlock := func(passphrase string) int {
+		body, err := json.Marshal(RepositoryUnlockRequest{Passphrase: passphrase})
+		require.NoError(t, err)
+		req, err := http.NewRequest("POST", "/api/repositories/main/unlock", bytes.NewReader(body))
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+	require.Equal(t, http.StatusUnauthorized, unlock("wrong"))
+	require.Equal(t, http.StatusOK, unlock(string(passphrase)))
+	require.Equal(t, http.StatusUnauthorized, unlock("wrong"))
+	require.Equal(t, http.StatusOK, unlock(string(passphrase)))
+
+	require.Equal(t, http.StatusOK, doRequest(mux, "GET", "/api/repositories/main/repository/info", ""))
+	require.Equal(t, http.StatusOK, doRequest(mux, "GET", "/api/repositories/main/repository/snapshots", ""))
+}
+
+func TestRepositoriesReaderAuthentication(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	ctx.Config = config.NewConfig()
+	ctx.Config.Repositories["main"] = map[string]string{"location": repo.Location()}
+
+	mux := http.NewServeMux()
+	SetupRoutes(mux, repo, ctx, "test-token")
+	defer CloseRepositories()
+
+	path := "/api/repositories/main/snapshot/reader/0000:/etc/passwd"
+	require.Equal(t, http.StatusUnauthorized, doRequest(mux, "GET", path, ""))
+	require.Equal(t, http.StatusUnauthorized, doRequest(mux, "GET", path+"?signature=invalid", ""))
+
+	// an anonymous request must not open the repository
+	_, opened := lrepositories.isOpened("main")
+	require.False(t, opened)
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot/header"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/identity"
 )
 
 type RepositoryInfoSnapshots struct {
@@ -31,14 +32,15 @@ type RepositoryInfoResponse struct {
 }
 
 func repositoryInfo(w http.ResponseWriter, r *http.Request) error {
-	configuration := lrepository.Configuration()
-	nSnapshots, logicalSize, err := snapshot.LogicalSize(lrepository)
+	repo := repositoryOf(r)
+	configuration := repo.Configuration()
+	nSnapshots, logicalSize, err := snapshot.LogicalSize(repo)
 	if err != nil {
 		return fmt.Errorf("unable to calculate logical size: %w", err)
 	}
 
 	efficiency := float64(0)
-	storageSize := lrepository.StorageSize()
+	storageSize := repo.StorageSize()
 	if storageSize == -1 || logicalSize == 0 {
 		efficiency = -1
 	} else {
@@ -57,10 +59,10 @@ func repositoryInfo(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	return json.NewEncoder(w).Encode(Item[RepositoryInfoResponse]{Item: RepositoryInfoResponse{
-		Location: lrepository.Location(),
+		Location: repo.Location(),
 		Snapshots: RepositoryInfoSnapshots{
 			Total:       nSnapshots,
-			StorageSize: int64(lrepository.StorageSize()),
+			StorageSize: int64(repo.StorageSize()),
 			LogicalSize: logicalSize,
 			Efficiency:  efficiency,
 		},
@@ -69,6 +71,7 @@ func repositoryInfo(w http.ResponseWriter, r *http.Request) error {
 }
 
 func repositorySnapshots(w http.ResponseWriter, r *http.Request) error {
+	repo := repositoryOf(r)
 	offset, err := QueryParamToUint32(r, "offset", 0, 0)
 	if err != nil {
 		return err
@@ -88,17 +91,20 @@ func repositorySnapshots(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	lrepository.RebuildState()
+	repo.RebuildState()
 
-	snapshotIDs, err := lrepository.GetSnapshots()
+	snapshotIDs, err := repo.GetSnapshots()
 	if err != nil {
 		return err
 	}
 
+	identities := identity.NewManager(lctx.ConfigDir)
+	signatures := make(map[objects.MAC]SnapshotSignature)
+
 	totalSnapshots := int(0)
 	headers := make([]header.Header, 0, len(snapshotIDs))
 	for _, snapshotID := range snapshotIDs {
-		snap, err := snapshot.Load(lrepository, snapshotID)
+		snap, err := snapshot.Load(repo, snapshotID)
 		if err != nil {
 			return err
 		}
@@ -109,6 +115,7 @@ func repositorySnapshots(w http.ResponseWriter, r *http.Request) error {
 		}
 
 		headers = append(headers, *snap.Header)
+		signatures[snap.Header.Identifier] = snapshotSignature(identities, snap)
 		totalSnapshots++
 		snap.Close()
 	}
@@ -126,19 +133,23 @@ func repositorySnapshots(w http.ResponseWriter, r *http.Request) error {
 		headers = headers[offset : offset+limit]
 	}
 
-	items := Items[header.Header]{
+	items := Items[SnapshotHeader]{
 		Total: totalSnapshots,
-		Items: make([]header.Header, len(headers)),
+		Items: make([]SnapshotHeader, len(headers)),
 	}
-	for i, header := range headers {
-		items.Items[i] = header
+	for i := range headers {
+		items.Items[i] = SnapshotHeader{
+			Header:    &headers[i],
+			Signature: signatures[headers[i].Identifier],
+		}
 	}
 
 	return json.NewEncoder(w).Encode(items)
 }
 
 func repositoryStates(w http.ResponseWriter, r *http.Request) error {
-	states, err := lrepository.GetStates()
+	repo := repositoryOf(r)
+	states, err := repo.GetStates()
 	if err != nil {
 		return err
 	}
@@ -155,12 +166,13 @@ func repositoryStates(w http.ResponseWriter, r *http.Request) error {
 }
 
 func repositoryState(w http.ResponseWriter, r *http.Request) error {
+	repo := repositoryOf(r)
 	stateBytes32, err := PathParamToID(r, "state")
 	if err != nil {
 		return err
 	}
 
-	_, rd, err := lrepository.GetState(stateBytes32)
+	_, rd, err := repo.GetState(stateBytes32)
 	if err != nil {
 		return err
 	}
@@ -172,16 +184,17 @@ func repositoryState(w http.ResponseWriter, r *http.Request) error {
 }
 
 func repositoryImporterTypes(w http.ResponseWriter, r *http.Request) error {
-	lrepository.RebuildState()
+	repo := repositoryOf(r)
+	repo.RebuildState()
 
-	snapshotIDs, err := lrepository.GetSnapshots()
+	snapshotIDs, err := repo.GetSnapshots()
 	if err != nil {
 		return err
 	}
 
 	importerTypesMap := make(map[string]struct{})
 	for _, snapshotID := range snapshotIDs {
-		snap, err := snapshot.Load(lrepository, snapshotID)
+		snap, err := snapshot.Load(repo, snapshotID)
 		if err != nil {
 			return err
 		}
@@ -217,6 +230,7 @@ type TimelineLocation struct {
 }
 
 func repositoryLocatePathname(w http.ResponseWriter, r *http.Request) error {
+	repo := repositoryOf(r)
 	offset, err := QueryParamToUint32(r, "offset", 0, 0)
 	if err != nil {
 		return err
@@ -246,9 +260,9 @@ func repositoryLocatePathname(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	lrepository.RebuildState()
+	repo.RebuildState()
 
-	snapshotIDs, err := lrepository.GetSnapshots()
+	snapshotIDs, err := repo.GetSnapshots()
 	if err != nil {
 		return err
 	}
@@ -256,7 +270,7 @@ func repositoryLocatePathname(w http.ResponseWriter, r *http.Request) error {
 	totalSnapshots := int(0)
 	locations := make([]TimelineLocation, 0, len(snapshotIDs))
 	for _, snapshotID := range snapshotIDs {
-		snap, err := snapshot.Load(lrepository, snapshotID)
+		snap, err := snapshot.Load(repo, snapshotID)
 		if err != nil {
 			return err
 		}
@@ -19,6 +19,9 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/header"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/identity"
+	"github.com/PlakarKorp/plakar/search"
+	"github.com/PlakarKorp/plakar/users"
 	"github.com/alecthomas/chroma/formatters"
 	"github.com/alecthomas/chroma/lexers"
 	"github.com/alecthomas/chroma/styles"
@@ -28,12 +31,21 @@ import (
 )
 
 type downloadSignedUrl struct {
+	repository *repository.Repository
 	snapshotID [32]byte
 	rebase     bool
 	files      []string
+	user       *principal
 }
 
-var snapcache = lru.New[[32]byte, *snapshot.Snapshot](30, nil)
+// snapshots are cached per repository, the same identifier may exist in
+// several of the repositories served by the API.
+type snapcacheKey struct {
+	repositoryID uuid.UUID
+	snapshotID   [32]byte
+}
+
+var snapcache = lru.New[snapcacheKey, *snapshot.Snapshot](30, nil)
 
 var downloadSignedUrls = ttlmap.New[string, downloadSignedUrl](1 * time.Hour)
 
@@ -42,7 +54,11 @@ func init() {
 }
 
 func loadsnap(repo *repository.Repository, id [32]byte) (*snapshot.Snapshot, error) {
-	if snap, ok := snapcache.Get(id); ok {
+	key := snapcacheKey{
+		repositoryID: repo.Configuration().RepositoryID,
+		snapshotID:   id,
+	}
+	if snap, ok := snapcache.Get(key); ok {
 		return snap, nil
 	}
 
@@ -51,26 +67,57 @@ func loadsnap(repo *repository.Repository, id [32]byte) (*snapshot.Snapshot, err
 		return nil, err
 	}
 
-	snapcache.Put(id, snap)
+	snapcache.Put(key, snap)
 	return snap, nil
 }
 
 func snapshotHeader(w http.ResponseWriter, r *http.Request) error {
+	repo := repositoryOf(r)
 	snapshotID32, err := PathParamToID(r, "snapshot")
 	if err != nil {
 		return err
 	}
 
-	snap, err := loadsnap(lrepository, snapshotID32)
+	snap, err := loadsnap(repo, snapshotID32)
 	if err != nil {
 		return err
 	}
 
-	return json.NewEncoder(w).Encode(Item[*header.Header]{Item: snap.Header})
+	return json.NewEncoder(w).Encode(Item[SnapshotHeader]{Item: SnapshotHeader{
+		Header:    snap.Header,
+		Signature: snapshotSignature(identity.NewManager(lctx.ConfigDir), snap),
+	}})
+}
+
+// SnapshotHeader is a snapshot header along with the signature status, so
+// that the UI can tell whether the snapshot was signed by a trusted
+// identity.
+type SnapshotHeader struct {
+	*header.Header
+	Signature SnapshotSignature `json:"signature"`
+}
+
+type SnapshotSignature struct {
+	Status identity.Status `json:"status"`
+	Signer string          `json:"signer,omitempty"`
+}
+
+func snapshotSignature(identities *identity.Manager, snap *snapshot.Snapshot) SnapshotSignature {
+	status, signer, err := identities.Verify(snap)
+	if err != nil {
+		lctx.GetLogger().Warn("snapshot %x: failed to verify signature: %s", snap.Header.Identifier, err)
+	}
+
+	ret := SnapshotSignature{Status: status}
+	if signer != nil {
+		ret.Signer = signer.Name
+	}
+	return ret
 }
 
 func snapshotReader(w http.ResponseWriter, r *http.Request) error {
-	snapshotID32, path, err := SnapshotPathParam(r, lrepository, "snapshot_path")
+	repo := repositoryOf(r)
+	snapshotID32, path, err := SnapshotPathParam(r, repo, "snapshot_path")
 	if err != nil {
 		return err
 	}
@@ -91,7 +138,7 @@ func snapshotReader(w http.ResponseWriter, r *http.Request) error {
 		return parameterError("render", InvalidArgument, errors.New("valid values are code, text, auto"))
 	}
 
-	snap, err := loadsnap(lrepository, snapshotID32)
+	snap, err := loadsnap(repo, snapshotID32)
 	if err != nil {
 		return err
 	}
@@ -113,9 +160,19 @@ func snapshotReader(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
+	action := "read"
 	if do_download {
+		action = "download"
 		w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(filepath.Base(path)))
 	}
+	if lauth != nil {
+		lauth.Audit(r, &users.AuditEvent{
+			Action:   action,
+			Snapshot: fmt.Sprintf("%x", snapshotID32),
+			Paths:    []string{path},
+			Success:  true,
+		})
+	}
 
 	if render != "code" {
 		if render == "text" {
@@ -171,28 +228,35 @@ func snapshotReader(w http.ResponseWriter, r *http.Request) error {
 }
 
 type SnapshotReaderURLSigner struct {
-	token string
+	token    string
+	fallback func(http.Handler) http.Handler
 }
 
 func NewSnapshotReaderURLSigner(token string) SnapshotReaderURLSigner {
-	return SnapshotReaderURLSigner{token}
+	return SnapshotReaderURLSigner{
+		token:    token,
+		fallback: TokenAuthMiddleware(token),
+	}
 }
 
 type SnapshotSignedURLClaims struct {
-	SnapshotID string `json:"snapshot_id"`
-	Path       string `json:"path"`
+	SnapshotID string     `json:"snapshot_id"`
+	Path       string     `json:"path"`
+	Username   string     `json:"username,omitempty"`
+	Role       users.Role `json:"role,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func (signer SnapshotReaderURLSigner) Sign(w http.ResponseWriter, r *http.Request) error {
-	snapshotID32, path, err := SnapshotPathParam(r, lrepository, "snapshot_path")
+	repo := repositoryOf(r)
+	snapshotID32, path, err := SnapshotPathParam(r, repo, "snapshot_path")
 	if err != nil {
 		return err
 	}
 	snapshotId := fmt.Sprintf("%0x", snapshotID32[:])
 
 	now := time.Now()
-	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, SnapshotSignedURLClaims{
+	claims := SnapshotSignedURLClaims{
 		SnapshotID: snapshotId,
 		Path:       path,
 		RegisteredClaims: jwt.RegisteredClaims{
@@ -200,7 +264,12 @@ func (signer SnapshotReaderURLSigner) Sign(w http.ResponseWriter, r *http.Reques
 			IssuedAt:  jwt.NewNumericDate(now),
 			Issuer:    "plakar-api",
 		},
-	})
+	}
+	if p := requestPrincipal(r); p != nil {
+		claims.Username = p.Name
+		claims.Role = p.Role
+	}
+	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	signature, err := jwtToken.SignedString([]byte(signer.token))
 	if err != nil {
@@ -218,14 +287,16 @@ func (signer SnapshotReaderURLSigner) Sign(w http.ResponseWriter, r *http.Reques
 
 // VerifyMiddleware is a middleware that checks if the request to read the file
 // content is authorized. It checks if the ?signature query parameter is valid.
-// If it is not valid, it falls back to the Authorization header.
-func (signer SnapshotReaderURLSigner) VerifyMiddleware(next http.Handler) http.Handler {
+// If it is not valid, it falls back to the Authorization header.  The
+// request is authenticated before scope resolves the repository, so that an
+// anonymous request can't have a repository opened.
+func (signer SnapshotReaderURLSigner) VerifyMiddleware(scope func(http.Handler) http.Handler, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		signature := r.URL.Query().Get("signature")
 
 		// No signature provided, fall back to Authorization header
 		if signature == "" {
-			TokenAuthMiddleware(signer.token)(next).ServeHTTP(w, r)
+			signer.fallback(scope(next)).ServeHTTP(w, r)
 			return
 		}
 
@@ -245,14 +316,20 @@ func (signer SnapshotReaderURLSigner) VerifyMiddleware(next http.Handler) http.H
 			return
 		}
 
-		snapshotID32, path, err := SnapshotPathParam(r, lrepository, "snapshot_path")
-		if err != nil {
-			handleError(w, r, parameterError("snapshot_path", InvalidArgument, err))
+		claims, ok := jwtToken.Claims.(*SnapshotSignedURLClaims)
+		if !ok {
+			handleError(w, r, authError("invalid URL signature"))
 			return
 		}
-		snapshotId := fmt.Sprintf("%0x", snapshotID32[:])
 
-		if claims, ok := jwtToken.Claims.(*SnapshotSignedURLClaims); ok {
+		scope(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			snapshotID32, path, err := SnapshotPathParam(r, repositoryOf(r), "snapshot_path")
+			if err != nil {
+				handleError(w, r, parameterError("snapshot_path", InvalidArgument, err))
+				return
+			}
+			snapshotId := fmt.Sprintf("%0x", snapshotID32[:])
+
 			if claims.Path != path {
 				handleError(w, r, authError("invalid URL path"))
 				return
@@ -261,22 +338,23 @@ func (signer SnapshotReaderURLSigner) VerifyMiddleware(next http.Handler) http.H
 				handleError(w, r, authError("invalid URL snapshot"))
 				return
 			}
-		} else {
-			handleError(w, r, authError("invalid URL signature"))
-			return
-		}
+			if claims.Username != "" {
+				r = withPrincipal(r, &principal{Name: claims.Username, Role: claims.Role})
+			}
 
-		next.ServeHTTP(w, r)
+			next.ServeHTTP(w, r)
+		})).ServeHTTP(w, r)
 	})
 }
 
 func snapshotVFSBrowse(w http.ResponseWriter, r *http.Request) error {
-	snapshotID32, path, err := SnapshotPathParam(r, lrepository, "snapshot_path")
+	repo := repositoryOf(r)
+	snapshotID32, path, err := SnapshotPathParam(r, repo, "snapshot_path")
 	if err != nil {
 		return err
 	}
 
-	snap, err := loadsnap(lrepository, snapshotID32)
+	snap, err := loadsnap(repo, snapshotID32)
 	if err != nil {
 		return err
 	}
@@ -298,7 +376,8 @@ func snapshotVFSBrowse(w http.ResponseWriter, r *http.Request) error {
 }
 
 func snapshotVFSChildren(w http.ResponseWriter, r *http.Request) error {
-	snapshotID32, entrypath, err := SnapshotPathParam(r, lrepository, "snapshot_path")
+	repo := repositoryOf(r)
+	snapshotID32, entrypath, err := SnapshotPathParam(r, repo, "snapshot_path")
 	if err != nil {
 		return err
 	}
@@ -323,7 +402,7 @@ func snapshotVFSChildren(w http.ResponseWriter, r *http.Request) error {
 	}
 	_ = sortKeys
 
-	snap, err := loadsnap(lrepository, snapshotID32)
+	snap, err := loadsnap(repo, snapshotID32)
 	if err != nil {
 		return err
 	}
@@ -407,7 +486,8 @@ func snapshotVFSChildren(w http.ResponseWriter, r *http.Request) error {
 }
 
 func snapshotVFSChunks(w http.ResponseWriter, r *http.Request) error {
-	snapshotID32, entrypath, err := SnapshotPathParam(r, lrepository, "snapshot_path")
+	repo := repositoryOf(r)
+	snapshotID32, entrypath, err := SnapshotPathParam(r, repo, "snapshot_path")
 	if err != nil {
 		return err
 	}
@@ -422,7 +502,7 @@ func snapshotVFSChunks(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	snap, err := loadsnap(lrepository, snapshotID32)
+	snap, err := loadsnap(repo, snapshotID32)
 	if err != nil {
 		return err
 	}
@@ -453,7 +533,8 @@ func snapshotVFSChunks(w http.ResponseWriter, r *http.Request) error {
 }
 
 func snapshotVFSSearch(w http.ResponseWriter, r *http.Request) error {
-	snapshotID32, path, err := SnapshotPathParam(r, lrepository, "snapshot_path")
+	repo := repositoryOf(r)
+	snapshotID32, path, err := SnapshotPathParam(r, repo, "snapshot_path")
 	if err != nil {
 		return err
 	}
@@ -486,7 +567,7 @@ func snapshotVFSSearch(w http.ResponseWriter, r *http.Request) error {
 		pattern = str
 	}
 
-	snap, err := loadsnap(lrepository, snapshotID32)
+	snap, err := loadsnap(repo, snapshotID32)
 	if err != nil {
 		return err
 	}
@@ -538,7 +619,8 @@ func snapshotVFSSearch(w http.ResponseWriter, r *http.Request) error {
 }
 
 func snapshotVFSErrors(w http.ResponseWriter, r *http.Request) error {
-	snapshotID32, path, err := SnapshotPathParam(r, lrepository, "snapshot_path")
+	repo := repositoryOf(r)
+	snapshotID32, path, err := SnapshotPathParam(r, repo, "snapshot_path")
 	if err != nil {
 		return err
 	}
@@ -561,7 +643,7 @@ func snapshotVFSErrors(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	snap, err := loadsnap(lrepository, snapshotID32)
+	snap, err := loadsnap(repo, snapshotID32)
 	if err != nil {
 		return err
 	}
@@ -612,7 +694,8 @@ type DownloadQuery struct {
 }
 
 func snapshotVFSDownloader(w http.ResponseWriter, r *http.Request) error {
-	snapshotID32, _, err := SnapshotPathParam(r, lrepository, "snapshot_path")
+	repo := repositoryOf(r)
+	snapshotID32, _, err := SnapshotPathParam(r, repo, "snapshot_path")
 	if err != nil {
 		return err
 	}
@@ -622,7 +705,7 @@ func snapshotVFSDownloader(w http.ResponseWriter, r *http.Request) error {
 		return parameterError("BODY", InvalidArgument, err)
 	}
 
-	if _, err = loadsnap(lrepository, snapshotID32); err != nil {
+	if _, err = loadsnap(repo, snapshotID32); err != nil {
 		return nil
 	}
 
@@ -633,8 +716,10 @@ func snapshotVFSDownloader(w http.ResponseWriter, r *http.Request) error {
 		}
 
 		url := downloadSignedUrl{
+			repository: repo,
 			snapshotID: snapshotID32,
 			rebase:     query.Rebase,
+			user:       requestPrincipal(r),
 		}
 
 		for _, item := range query.Items {
@@ -663,7 +748,7 @@ func snapshotVFSDownloaderSigned(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	snap, err := loadsnap(lrepository, link.snapshotID)
+	snap, err := loadsnap(link.repository, link.snapshotID)
 	if err != nil {
 		return err
 	}
@@ -701,5 +786,108 @@ func snapshotVFSDownloaderSigned(w http.ResponseWriter, r *http.Request) error {
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", name))
 	w.Header().Set("Content-Type", mime)
 
-	return snap.Archive(w, format, link.files, link.rebase)
+	err = snap.Archive(w, format, link.files, link.rebase)
+	if lauth != nil {
+		if link.user != nil {
+			r = withPrincipal(r, link.user)
+		}
+		lauth.Audit(r, &users.AuditEvent{
+			Action:     "archive",
+			Repository: link.repository.Configuration().RepositoryID.String(),
+			Snapshot:   fmt.Sprintf("%x", link.snapshotID),
+			Paths:      link.files,
+			Success:    err == nil,
+		})
+	}
+	return err
+}
+
+func snapshotVFSGrep(w http.ResponseWriter, r *http.Request) error {
+	repo := repositoryOf(r)
+	snapshotID32, path, err := SnapshotPathParam(r, repo, "snapshot_path")
+	if err != nil {
+		return err
+	}
+
+	offset, err := QueryParamToInt64(r, "offset", 0, 0)
+	if err != nil {
+		return err
+	}
+
+	limit, err := QueryParamToInt64(r, "limit", 1, 50)
+	if err != nil {
+		return err
+	}
+
+	maxSize, err := QueryParamToInt64(r, "max_size", 1, search.DefaultMaxSize)
+	if err != nil {
+		return err
+	}
+	if maxSize > search.DefaultMaxSize {
+		return parameterError("max_size", InvalidArgument, ErrNumberOutOfRange)
+	}
+
+	pattern, ok, err := QueryParamToString(r, "pattern")
+	if err != nil {
+		return err
+	}
+	if !ok || pattern == "" {
+		return parameterError("pattern", MissingArgument, ErrMissingField)
+	}
+
+	re, err := search.ParsePattern(pattern,
+		r.URL.Query().Get("fixed") == "true",
+		r.URL.Query().Get("ignore_case") == "true")
+	if err != nil {
+		return parameterError("pattern", InvalidArgument, err)
+	}
+
+	snap, err := loadsnap(repo, snapshotID32)
+	if err != nil {
+		return err
+	}
+
+	opts := &search.Options{MaxSize: maxSize}
+	if r.URL.Query().Get("index") == "true" {
+		indexes := search.NewIndexCache(lctx.CacheDir, repo.Configuration().RepositoryID)
+		opts.Index, err = indexes.GetOrBuild(r.Context(), snap, search.DefaultMaxSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	if lauth != nil {
+		lauth.Audit(r, &users.AuditEvent{
+			Action:   "grep",
+			Snapshot: fmt.Sprintf("%x", snapshotID32),
+			Paths:    []string{path},
+			Success:  true,
+		})
+	}
+
+	items := ItemsPage[*search.Match]{
+		Items: []*search.Match{},
+	}
+
+	var i int64
+	for match, err := range search.Grep(r.Context(), snap, path, re, opts) {
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		}
+		if i < offset {
+			i++
+			continue
+		}
+		if i >= offset+limit {
+			items.HasNext = true
+			break
+		}
+		items.Items = append(items.Items, match)
+		i++
+	}
+
+	return json.NewEncoder(w).Encode(items)
 }
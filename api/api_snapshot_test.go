@@ -13,11 +13,13 @@ import (
 	"github.com/PlakarKorp/kloset/caching"
 	"github.com/PlakarKorp/kloset/hashing"
 	"github.com/PlakarKorp/kloset/logging"
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/resources"
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/kloset/versioning"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/identity"
 	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/stretchr/testify/require"
 )
@@ -326,3 +328,58 @@ func _TestSnapshotSign(t *testing.T) {
 		})
 	}
 }
+
+func TestSnapshotSignature(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+	ctx.ConfigDir = t.TempDir()
+
+	unsigned := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("dummy.txt", 0644, "hello\n"),
+	})
+	defer unsigned.Close()
+
+	alice, err := identity.NewManager(ctx.ConfigDir).Create("alice")
+	require.NoError(t, err)
+	kp, err := alice.Keypair()
+	require.NoError(t, err)
+	repo.AppContext().Identity = alice.Identifier
+	repo.AppContext().Keypair = kp
+
+	signed := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("dummy.txt", 0644, "hello\n"),
+	})
+	defer signed.Close()
+
+	mux := http.NewServeMux()
+	SetupRoutes(mux, repo, ctx, "")
+
+	get := func(url string, v any) {
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, json.NewDecoder(w.Body).Decode(v))
+	}
+
+	var item Item[SnapshotHeader]
+	get(fmt.Sprintf("/api/snapshot/%x", signed.Header.Identifier), &item)
+	require.Equal(t, identity.StatusTrusted, item.Item.Signature.Status)
+	require.Equal(t, "alice", item.Item.Signature.Signer)
+	require.Equal(t, alice.Identifier, item.Item.Identity.Identifier)
+
+	item = Item[SnapshotHeader]{}
+	get(fmt.Sprintf("/api/snapshot/%x", unsigned.Header.Identifier), &item)
+	require.Equal(t, identity.StatusUnsigned, item.Item.Signature.Status)
+	require.Empty(t, item.Item.Signature.Signer)
+
+	var items Items[SnapshotHeader]
+	get("/api/repository/snapshots", &items)
+	require.Equal(t, 2, items.Total)
+	statuses := map[objects.MAC]identity.Status{}
+	for _, snap := range items.Items {
+		statuses[snap.Identifier] = snap.Signature.Status
+	}
+	require.Equal(t, identity.StatusTrusted, statuses[signed.Header.Identifier])
+	require.Equal(t, identity.StatusUnsigned, statuses[unsigned.Header.Identifier])
+}
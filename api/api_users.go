@@ -0,0 +1,348 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/plakar/users"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const sessionAudience = "plakar-session"
+
+const DefaultSessionTTL = 12 * time.Hour
+
+type principal struct {
+	Name string
+	Role users.Role
+}
+
+type principalKey struct{}
+
+func withPrincipal(r *http.Request, p *principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// requestPrincipal returns the identity attached to the request by the
+// authentication middleware, or nil for unauthenticated requests.
+func requestPrincipal(r *http.Request) *principal {
+	p, _ := r.Context().Value(principalKey{}).(*principal)
+	return p
+}
+
+type SessionClaims struct {
+	Username string     `json:"username"`
+	Role     users.Role `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// Authenticator authenticates API requests.  Without user accounts it
+// checks the single shared token as TokenAuthMiddleware does and grants
+// full access; with accounts it accepts session JWTs and API keys and
+// enforces the role required by each route.
+type Authenticator struct {
+	token      string
+	users      *users.Manager
+	audit      *users.AuditLog
+	sessionTTL time.Duration
+}
+
+func NewAuthenticator(token string, um *users.Manager, audit *users.AuditLog) *Authenticator {
+	return &Authenticator{
+		token:      token,
+		users:      um,
+		audit:      audit,
+		sessionTTL: DefaultSessionTTL,
+	}
+}
+
+func (a *Authenticator) SetSessionTTL(ttl time.Duration) {
+	a.sessionTTL = ttl
+}
+
+func (a *Authenticator) multiUser() bool {
+	return a.users != nil && !a.users.Empty()
+}
+
+func (a *Authenticator) Audit(r *http.Request, event *users.AuditEvent) {
+	if p := requestPrincipal(r); p != nil && event.User == "" {
+		event.User = p.Name
+		event.Role = p.Role
+	}
+	if repo := repositoryOf(r); event.Repository == "" && repo != nil {
+		event.Repository = repo.Configuration().RepositoryID.String()
+	}
+	event.RemoteAddr = r.RemoteAddr
+	if err := a.audit.Record(event); err != nil && lctx != nil {
+		lctx.GetLogger().Warn("failed to write audit event: %s", err)
+	}
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (*principal, error) {
+	key := r.Header.Get("Authorization")
+	if key == "" {
+		return nil, authError("missing Authorization header")
+	}
+	bearer, ok := strings.CutPrefix(key, "Bearer ")
+	if !ok {
+		return nil, authError("invalid Authorization header")
+	}
+
+	if users.IsAPIKey(bearer) {
+		user, err := a.users.AuthenticateAPIKey(bearer)
+		if err != nil {
+			return nil, authError("invalid api key")
+		}
+		return &principal{Name: user.Name, Role: user.Role}, nil
+	}
+
+	claims := &SessionClaims{}
+	_, err := jwt.ParseWithClaims(bearer, claims, func(jwtToken *jwt.Token) (interface{}, error) {
+		if _, ok := jwtToken.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", jwtToken.Header["alg"])
+		}
+		return []byte(a.token), nil
+	}, jwt.WithAudience(sessionAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, authError("session expired")
+		}
+		return nil, authError("invalid session")
+	}
+
+	// the account may have been removed or downgraded since the session
+	// was issued, always use the current role.
+	user, err := a.users.Get(claims.Username)
+	if err != nil {
+		return nil, authError("invalid session")
+	}
+	return &principal{Name: user.Name, Role: user.Role}, nil
+}
+
+// Require returns a middleware that only lets through requests from users
+// holding at least the given role.
+func (a *Authenticator) Require(role users.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		legacy := TokenAuthMiddleware(a.token)(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !a.multiUser() {
+				name := "anonymous"
+				if a.token != "" {
+					name = "token"
+				}
+				r = withPrincipal(r, &principal{Name: name, Role: users.RoleAdmin})
+				legacy.ServeHTTP(w, r)
+				return
+			}
+
+			p, err := a.authenticate(r)
+			if err != nil {
+				handleError(w, r, err)
+				return
+			}
+
+			if !p.Role.Allows(role) {
+				handleError(w, r, &ApiError{
+					HttpCode: http.StatusForbidden,
+					ErrCode:  "forbidden",
+					Message:  fmt.Sprintf("this operation requires the %s role", role),
+				})
+				return
+			}
+
+			next.ServeHTTP(w, withPrincipal(r, p))
+		})
+	}
+}
+
+type SessionRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type SessionResponse struct {
+	Token     string     `json:"token"`
+	Username  string     `json:"username"`
+	Role      users.Role `json:"role"`
+	ExpiresAt time.Time  `json:"expires_at"`
+}
+
+func (a *Authenticator) login(w http.ResponseWriter, r *http.Request) error {
+	if !a.multiUser() {
+		return &ApiError{
+			HttpCode: http.StatusNotFound,
+			ErrCode:  "not-found",
+			Message:  "no user accounts configured",
+		}
+	}
+
+	var req SessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return parameterError("BODY", InvalidArgument, err)
+	}
+
+	user, err := a.users.Authenticate(req.Username, []byte(req.Password))
+	a.Audit(r, &users.AuditEvent{
+		User:    req.Username,
+		Action:  "login",
+		Success: err == nil,
+	})
+	if err != nil {
+		return authError("invalid username or password")
+	}
+
+	now := time.Now()
+	expires := now.Add(a.sessionTTL)
+	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, SessionClaims{
+		Username: user.Name,
+		Role:     user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.Name,
+			Audience:  jwt.ClaimStrings{sessionAudience},
+			ExpiresAt: jwt.NewNumericDate(expires),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "plakar-api",
+		},
+	})
+
+	signed, err := jwtToken.SignedString([]byte(a.token))
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(Item[SessionResponse]{
+		Item: SessionResponse{
+			Token:     signed,
+			Username:  user.Name,
+			Role:      user.Role,
+			ExpiresAt: expires,
+		},
+	})
+}
+
+type UserInfo struct {
+	Username string     `json:"username"`
+	Role     users.Role `json:"role"`
+	Created  time.Time  `json:"created,omitempty"`
+	APIKeys  int        `json:"api_keys"`
+}
+
+func (a *Authenticator) whoami(w http.ResponseWriter, r *http.Request) error {
+	p := requestPrincipal(r)
+	if p == nil {
+		return authError("not authenticated")
+	}
+	return json.NewEncoder(w).Encode(Item[UserInfo]{
+		Item: UserInfo{Username: p.Name, Role: p.Role},
+	})
+}
+
+func (a *Authenticator) usersList(w http.ResponseWriter, r *http.Request) error {
+	items := Items[UserInfo]{
+		Items: []UserInfo{},
+	}
+	if a.users != nil {
+		list, err := a.users.List()
+		if err != nil {
+			return err
+		}
+		for _, user := range list {
+			items.Items = append(items.Items, UserInfo{
+				Username: user.Name,
+				Role:     user.Role,
+				Created:  user.Created,
+				APIKeys:  len(user.APIKeys),
+			})
+		}
+	}
+	items.Total = len(items.Items)
+	return json.NewEncoder(w).Encode(items)
+}
+
+type UserCreateRequest struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Password string `json:"password"`
+}
+
+func (a *Authenticator) usersCreate(w http.ResponseWriter, r *http.Request) error {
+	if a.users == nil {
+		return &ApiError{
+			HttpCode: http.StatusBadRequest,
+			ErrCode:  "bad-request",
+			Message:  "user accounts are not enabled",
+		}
+	}
+
+	var req UserCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return parameterError("BODY", InvalidArgument, err)
+	}
+
+	role, err := users.ParseRole(req.Role)
+	if err != nil {
+		return parameterError("role", InvalidArgument, err)
+	}
+	if req.Password == "" {
+		return parameterError("password", MissingArgument, ErrMissingField)
+	}
+
+	err = a.users.Add(req.Username, role, []byte(req.Password))
+	switch {
+	case errors.Is(err, users.ErrInvalidUsername):
+		return parameterError("username", InvalidArgument, err)
+	case errors.Is(err, users.ErrUserExists):
+		return &ApiError{
+			HttpCode: http.StatusConflict,
+			ErrCode:  "conflict",
+			Message:  err.Error(),
+		}
+	case err != nil:
+		return err
+	}
+
+	a.Audit(r, &users.AuditEvent{
+		Action:  "user-add",
+		Paths:   []string{req.Username},
+		Success: true,
+	})
+
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(Item[UserInfo]{
+		Item: UserInfo{Username: req.Username, Role: role},
+	})
+}
+
+func (a *Authenticator) usersDelete(w http.ResponseWriter, r *http.Request) error {
+	name := r.PathValue("name")
+
+	err := users.ErrUserNotFound
+	if a.users != nil {
+		err = a.users.Remove(name)
+	}
+	if err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			return &ApiError{
+				HttpCode: http.StatusNotFound,
+				ErrCode:  "not-found",
+				Message:  err.Error(),
+			}
+		}
+		return err
+	}
+
+	a.Audit(r, &users.AuditEvent{
+		Action:  "user-rm",
+		Paths:   []string{name},
+		Success: true,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
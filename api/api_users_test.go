@@ -0,0 +1,122 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/caching"
+	"github.com/PlakarKorp/kloset/hashing"
+	"github.com/PlakarKorp/kloset/logging"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/kloset/versioning"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/cookies"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/users"
+	"github.com/stretchr/testify/require"
+)
+
+func setupUsersMux(t *testing.T) (*http.ServeMux, *users.Manager) {
+	config := ptesting.NewConfiguration()
+
+	serializedConfig, err := config.ToBytes()
+	require.NoError(t, err)
+
+	hasher := hashing.GetHasher(hashing.DEFAULT_HASHING_ALGORITHM)
+	wrappedConfigRd, err := storage.Serialize(hasher, resources.RT_CONFIG, versioning.GetCurrentVersion(resources.RT_CONFIG), bytes.NewReader(serializedConfig))
+	require.NoError(t, err)
+
+	wrappedConfig, err := io.ReadAll(wrappedConfigRd)
+	require.NoError(t, err)
+
+	ctx := appcontext.NewAppContext()
+	cache := caching.NewManager("/tmp/test_plakar")
+	t.Cleanup(func() { cache.Close() })
+	ctx.SetCache(cache)
+	ctx.SetLogger(logging.NewLogger(os.Stdout, os.Stderr))
+	ctx.SetCookies(cookies.NewManager("/tmp/test_plakar"))
+	ctx.Client = "plakar-test/1.0.0"
+
+	lstore, err := storage.Create(ctx.GetInner(), map[string]string{"location": "mock:///test/location"}, wrappedConfig)
+	require.NoError(t, err)
+	repo, err := repository.New(ctx.GetInner(), nil, lstore, wrappedConfig)
+	require.NoError(t, err)
+
+	configDir := t.TempDir()
+	um, err := users.NewManager(configDir)
+	require.NoError(t, err)
+	require.NoError(t, um.Add("admin", users.RoleAdmin, []byte("admin-password")))
+	require.NoError(t, um.Add("viewer", users.RoleViewer, []byte("viewer-password")))
+
+	audit, err := users.NewAuditLog(configDir)
+	require.NoError(t, err)
+	t.Cleanup(func() { audit.Close() })
+
+	mux := http.NewServeMux()
+	SetupRoutesWithAuth(mux, repo, ctx, NewAuthenticator("test-token", um, audit))
+	return mux, um
+}
+
+func login(t *testing.T, mux *http.ServeMux, username, password string) (int, string) {
+	body, err := json.Marshal(SessionRequest{Username: username, Password: password})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/api/authentication/session", bytes.NewReader(body))
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		return w.Code, ""
+	}
+
+	var res Item[SessionResponse]
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	return w.Code, res.Item.Token
+}
+
+func doRequest(mux *http.ServeMux, method, path, bearer string) int {
+	req, _ := http.NewRequest(method, path, strings.NewReader("{}"))
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestUsersAuthentication(t *testing.T) {
+	mux, um := setupUsersMux(t)
+
+	// the shared token is no longer accepted once accounts exist
+	require.Equal(t, http.StatusUnauthorized, doRequest(mux, "GET", "/api/info", "test-token"))
+	require.Equal(t, http.StatusUnauthorized, doRequest(mux, "GET", "/api/info", ""))
+
+	code, _ := login(t, mux, "viewer", "wrong")
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	code, viewerToken := login(t, mux, "viewer", "viewer-password")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, http.StatusOK, doRequest(mux, "GET", "/api/info", viewerToken))
+	require.Equal(t, http.StatusForbidden, doRequest(mux, "GET", "/api/users", viewerToken))
+	require.Equal(t, http.StatusForbidden, doRequest(mux, "POST", "/api/snapshot/vfs/downloader/abcd:/", viewerToken))
+
+	code, adminToken := login(t, mux, "admin", "admin-password")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, http.StatusOK, doRequest(mux, "GET", "/api/users", adminToken))
+
+	key, err := um.NewAPIKey("admin")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, doRequest(mux, "GET", "/api/users", key))
+
+	// sessions of removed accounts are rejected
+	require.Equal(t, http.StatusNoContent, doRequest(mux, "DELETE", "/api/users/viewer", adminToken))
+	require.Equal(t, http.StatusUnauthorized, doRequest(mux, "GET", "/api/info", viewerToken))
+}
@@ -122,6 +122,19 @@ func (s *Store) Open(ctx context.Context) ([]byte, error) {
 	return data, nil
 }
 
+func (s *Store) GetKeyring() (io.Reader, error) {
+	fp, err := os.Open(s.Path("KEYRING"))
+	if err != nil {
+		return nil, err
+	}
+	return ClosingReader(fp)
+}
+
+func (s *Store) PutKeyring(rd io.Reader) error {
+	_, err := WriteToFileAtomic(s.Path("KEYRING"), rd)
+	return err
+}
+
 func (s *Store) Mode() storage.Mode {
 	return storage.ModeRead | storage.ModeWrite
 }
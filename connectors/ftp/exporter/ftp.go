@@ -18,13 +18,15 @@ package ftp
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net/url"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/snapshot/exporter"
+	plakarftp "github.com/PlakarKorp/plakar/ftp"
 	"github.com/secsy/goftp"
 )
 
@@ -32,18 +34,12 @@ type FTPExporter struct {
 	host    string
 	rootDir string
 	client  *goftp.Client
-}
 
-func connectToFTP(host, username, password string) (*goftp.Client, error) {
-	config := goftp.Config{}
-	if username != "" {
-		config.User = username
-	}
-	if password != "" {
-		config.Password = password
-	}
-	config.Timeout = 10 * time.Second
-	return goftp.DialConfig(config, host)
+	// raw is the connection SITE CHMOD is sent over, opened on
+	// first use.
+	mtx   sync.Mutex
+	raw   goftp.RawConn
+	chmod bool
 }
 
 func init() {
@@ -58,17 +54,7 @@ func NewFTPExporter(ctx context.Context, opts *exporter.Options, name string, co
 		return nil, err
 	}
 
-	var username string
-	if tmp, ok := config["username"]; ok {
-		username = tmp
-	}
-
-	var password string
-	if tmp, ok := config["password"]; ok {
-		password = tmp
-	}
-
-	client, err := connectToFTP(parsed.Host, username, password)
+	client, err := plakarftp.Connect(parsed, config)
 	if err != nil {
 		return nil, err
 	}
@@ -77,6 +63,7 @@ func NewFTPExporter(ctx context.Context, opts *exporter.Options, name string, co
 		host:    parsed.Host,
 		rootDir: parsed.Path,
 		client:  client,
+		chmod:   true,
 	}, nil
 }
 
@@ -101,12 +88,45 @@ func (p *FTPExporter) StoreFile(pathname string, fp io.Reader, size int64) error
 	return p.client.Store(pathname, fp)
 }
 
+// SetPermissions sets the mode with SITE CHMOD, which most servers
+// support.  It is given up on at the first refusal: there is no way to
+// change the owner over FTP anyway.
 func (p *FTPExporter) SetPermissions(pathname string, fileinfo *objects.FileInfo) error {
-	// can't chown/chmod on FTP
-	return nil
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if !p.chmod {
+		return nil
+	}
+
+	if p.raw == nil {
+		raw, err := p.client.OpenRawConn()
+		if err != nil {
+			return err
+		}
+		p.raw = raw
+	}
+
+	code, msg, err := p.raw.SendCommand("SITE CHMOD %04o %s", fileinfo.Mode().Perm(), pathname)
+	if err != nil {
+		p.raw.Close()
+		p.raw = nil
+		return err
+	}
+	switch {
+	case code >= 200 && code < 300:
+		return nil
+	case code == 500 || code == 502 || code == 504:
+		p.chmod = false
+		return nil
+	}
+	return fmt.Errorf("chmod %s: %d %s", pathname, code, msg)
 }
 
 func (p *FTPExporter) Close() error {
+	if p.raw != nil {
+		p.raw.Close()
+	}
 	if p.client != nil {
 		return p.client.Close()
 	}
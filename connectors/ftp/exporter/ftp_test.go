@@ -84,4 +84,7 @@ func TestExporter(t *testing.T) {
 	if err := exporter.SetPermissions("file1.txt", fileInfo); err != nil {
 		t.Errorf("Failed to set permissions: %v", err)
 	}
+	if mode := server.Modes["file1.txt"]; mode != 0644 {
+		t.Errorf("Mode mismatch. Expected: 0644, Got: %o", mode)
+	}
 }
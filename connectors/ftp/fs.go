@@ -3,4 +3,5 @@ package ftp
 import (
 	_ "github.com/PlakarKorp/plakar/connectors/ftp/exporter"
 	_ "github.com/PlakarKorp/plakar/connectors/ftp/importer"
+	_ "github.com/PlakarKorp/plakar/connectors/ftp/storage"
 )
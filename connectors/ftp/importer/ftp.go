@@ -25,10 +25,10 @@ import (
 	"path"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/snapshot/importer"
+	plakarftp "github.com/PlakarKorp/plakar/ftp"
 	"github.com/secsy/goftp"
 )
 
@@ -42,15 +42,6 @@ func init() {
 	importer.Register("ftp", 0, NewFTPImporter)
 }
 
-func connectToFTP(host, username, password string) (*goftp.Client, error) {
-	config := goftp.Config{
-		User:     username,
-		Password: password,
-		Timeout:  10 * time.Second,
-	}
-	return goftp.DialConfig(config, host)
-}
-
 func NewFTPImporter(appCtx context.Context, opts *importer.Options, name string, config map[string]string) (importer.Importer, error) {
 	target := config["location"]
 
@@ -59,9 +50,15 @@ func NewFTPImporter(appCtx context.Context, opts *importer.Options, name string,
 		return nil, err
 	}
 
+	client, err := plakarftp.Connect(parsed, config)
+	if err != nil {
+		return nil, err
+	}
+
 	return &FTPImporter{
 		host:    parsed.Host,
 		rootDir: parsed.Path,
+		client:  client,
 	}, nil
 }
 
@@ -136,12 +133,6 @@ func (p *FTPImporter) walkDir(root string, results chan<- string, wg *sync.WaitG
 }
 
 func (p *FTPImporter) Scan() (<-chan *importer.ScanResult, error) {
-	client, err := connectToFTP(p.host, "", "")
-	if err != nil {
-		return nil, err
-	}
-	p.client = client
-
 	results := make(chan *importer.ScanResult, 1000) // Larger buffer for results
 	jobs := make(chan string, 1000)                  // Buffered channel to feed paths to workers
 	var wg sync.WaitGroup
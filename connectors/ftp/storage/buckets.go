@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ftp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/objects"
+	plakarftp "github.com/PlakarKorp/plakar/ftp"
+	"github.com/secsy/goftp"
+	"golang.org/x/sync/errgroup"
+)
+
+type Buckets struct {
+	client *goftp.Client
+	path   string
+}
+
+func NewBuckets(client *goftp.Client, path string) Buckets {
+	return Buckets{
+		client: client,
+		path:   path,
+	}
+}
+
+func (buckets *Buckets) Create() error {
+	if _, err := buckets.client.Mkdir(buckets.path); err != nil {
+		return err
+	}
+
+	var g errgroup.Group
+	for i := 0; i < 256; i++ {
+		dir := path.Join(buckets.path, fmt.Sprintf("%02x", i))
+		g.Go(func() error {
+			_, err := buckets.client.Mkdir(dir)
+			return err
+		})
+	}
+	return g.Wait()
+}
+
+func (buckets *Buckets) List() ([]objects.MAC, error) {
+	ret := make([]objects.MAC, 0)
+	var mu sync.Mutex
+
+	var g errgroup.Group
+	for i := 0; i < 256; i++ {
+		dir := path.Join(buckets.path, fmt.Sprintf("%02x", i))
+		g.Go(func() error {
+			entries, err := buckets.client.ReadDir(dir)
+			if err != nil {
+				if plakarftp.IsTransient(err) {
+					return err
+				}
+				return nil
+			}
+			for _, entry := range entries {
+				if entry.IsDir() {
+					continue
+				}
+				t, err := hex.DecodeString(entry.Name())
+				if err != nil {
+					continue
+				}
+				if len(t) != 32 {
+					continue
+				}
+				var t32 objects.MAC
+				copy(t32[:], t)
+
+				mu.Lock()
+				ret = append(ret, t32)
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (buckets *Buckets) Path(mac objects.MAC) string {
+	return path.Join(buckets.path,
+		fmt.Sprintf("%02x", mac[0]),
+		fmt.Sprintf("%064x", mac))
+}
+
+func (buckets *Buckets) Get(mac objects.MAC) (io.Reader, error) {
+	return Open(buckets.client, buckets.Path(mac))
+}
+
+func (buckets *Buckets) GetBlob(mac objects.MAC, offset uint64, length uint32) (io.Reader, error) {
+	rd, err := plakarftp.OpenRange(buckets.client, buckets.Path(mac), int64(offset), int64(length))
+	if err != nil {
+		return nil, err
+	}
+	return &closingReader{rd: rd}, nil
+}
+
+func (buckets *Buckets) Remove(mac objects.MAC) error {
+	return buckets.client.Delete(buckets.Path(mac))
+}
+
+func (buckets *Buckets) Put(mac objects.MAC, rd io.Reader) (int64, error) {
+	return WriteToFileAtomicTempDir(buckets.client, buckets.Path(mac), rd, buckets.path)
+}
@@ -0,0 +1,245 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/storage"
+	plakarftp "github.com/PlakarKorp/plakar/ftp"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+	"github.com/secsy/goftp"
+)
+
+type Store struct {
+	packfiles Buckets
+	states    Buckets
+	client    *goftp.Client
+
+	config   map[string]string
+	endpoint *url.URL
+}
+
+func init() {
+	storage.Register("ftp", 0, NewStore)
+}
+
+func NewStore(ctx context.Context, proto string, storeConfig map[string]string) (storage.Store, error) {
+	location := storeConfig["location"]
+	if location == "" {
+		return nil, fmt.Errorf("missing location")
+	}
+
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := plakarftp.Connect(parsed, storeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := plakarstorage.ParseRetryPolicy(storeConfig)
+	if err != nil {
+		return nil, err
+	}
+	policy.Transient = plakarftp.IsTransient
+
+	return plakarstorage.WithRetry(&Store{
+		client:   client,
+		config:   storeConfig,
+		endpoint: parsed,
+	}, policy), nil
+}
+
+func (s *Store) Location() string {
+	return s.config["location"]
+}
+
+func (s *Store) Path(args ...string) string {
+	args = append(args, "")
+	copy(args[1:], args)
+	args[0] = "/" + s.endpoint.Path
+
+	return path.Join(args...)
+}
+
+func (s *Store) Create(ctx context.Context, config []byte) error {
+	entries, err := s.client.ReadDir(s.Path())
+	if err != nil {
+		if err := MkdirAll(s.client, s.Path()); err != nil {
+			return err
+		}
+	} else if len(entries) > 0 {
+		return fmt.Errorf("directory %s is not empty", s.Location())
+	}
+
+	s.packfiles = NewBuckets(s.client, s.Path("packfiles"))
+	if err := s.packfiles.Create(); err != nil {
+		return err
+	}
+
+	s.states = NewBuckets(s.client, s.Path("states"))
+	if err := s.states.Create(); err != nil {
+		return err
+	}
+
+	if _, err := s.client.Mkdir(s.Path("locks")); err != nil {
+		return err
+	}
+
+	_, err = WriteToFileAtomic(s.client, s.Path("CONFIG"), bytes.NewReader(config))
+	return err
+}
+
+func (s *Store) Open(ctx context.Context) ([]byte, error) {
+	rd, err := Open(s.client, s.Path("CONFIG"))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	s.packfiles = NewBuckets(s.client, s.Path("packfiles"))
+	s.states = NewBuckets(s.client, s.Path("states"))
+
+	return data, nil
+}
+
+func (s *Store) GetKeyring() (io.Reader, error) {
+	return Open(s.client, s.Path("KEYRING"))
+}
+
+func (s *Store) PutKeyring(rd io.Reader) error {
+	_, err := WriteToFileAtomic(s.client, s.Path("KEYRING"), rd)
+	return err
+}
+
+func (s *Store) Mode() storage.Mode {
+	return storage.ModeRead | storage.ModeWrite
+}
+
+func (s *Store) Size() int64 {
+	return -1
+}
+
+func (s *Store) GetPackfiles() ([]objects.MAC, error) {
+	return s.packfiles.List()
+}
+
+func (s *Store) GetPackfile(mac objects.MAC) (io.Reader, error) {
+	fp, err := s.packfiles.Get(mac)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = repository.ErrPackfileNotFound
+		}
+		return nil, err
+	}
+
+	return fp, nil
+}
+
+func (s *Store) GetPackfileBlob(mac objects.MAC, offset uint64, length uint32) (io.Reader, error) {
+	res, err := s.packfiles.GetBlob(mac, offset, length)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = repository.ErrPackfileNotFound
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Store) DeletePackfile(mac objects.MAC) error {
+	return s.packfiles.Remove(mac)
+}
+
+func (s *Store) PutPackfile(mac objects.MAC, rd io.Reader) (int64, error) {
+	return s.packfiles.Put(mac, rd)
+}
+
+func (s *Store) Close() error {
+	return s.client.Close()
+}
+
+/* Indexes */
+func (s *Store) GetStates() ([]objects.MAC, error) {
+	return s.states.List()
+}
+
+func (s *Store) PutState(mac objects.MAC, rd io.Reader) (int64, error) {
+	return s.states.Put(mac, rd)
+}
+
+func (s *Store) GetState(mac objects.MAC) (io.Reader, error) {
+	return s.states.Get(mac)
+}
+
+func (s *Store) DeleteState(mac objects.MAC) error {
+	return s.states.Remove(mac)
+}
+
+/* Locks */
+func (s *Store) GetLocks() ([]objects.MAC, error) {
+	ret := make([]objects.MAC, 0)
+
+	entries, err := s.client.ReadDir(s.Path("locks"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		t, err := hex.DecodeString(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if len(t) != 32 {
+			continue
+		}
+		ret = append(ret, objects.MAC(t))
+	}
+	return ret, nil
+}
+
+func (s *Store) PutLock(lockID objects.MAC, rd io.Reader) (int64, error) {
+	return WriteToFileAtomicTempDir(s.client, path.Join(s.Path("locks"), hex.EncodeToString(lockID[:])), rd, s.Path(""))
+}
+
+func (s *Store) GetLock(lockID objects.MAC) (io.Reader, error) {
+	return Open(s.client, path.Join(s.Path("locks"), hex.EncodeToString(lockID[:])))
+}
+
+func (s *Store) DeleteLock(lockID objects.MAC) error {
+	return s.client.Delete(path.Join(s.Path("locks"), hex.EncodeToString(lockID[:])))
+}
@@ -0,0 +1,107 @@
+package ftp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFTPBackend(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	defer ctx.Close()
+
+	server, err := ptesting.NewMockFTPSServer(false)
+	require.NoError(t, err)
+	defer server.Close()
+
+	config := map[string]string{
+		"location":                 "ftp://test@" + server.Addr + "/backups/repo",
+		"password_cmd":             "echo test",
+		"tls":                      "explicit",
+		"tls_insecure_skip_verify": "true",
+	}
+	repo, err := NewStore(ctx, "ftp", config)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	require.Equal(t, config["location"], repo.Location())
+	require.Equal(t, int64(-1), repo.Size())
+
+	serializedConfig, err := storage.NewConfiguration().ToBytes()
+	require.NoError(t, err)
+	require.NoError(t, repo.Create(ctx, serializedConfig))
+	require.True(t, server.Dirs["/backups/repo/packfiles/ff"])
+	require.True(t, server.Dirs["/backups/repo/locks"])
+
+	err = repo.Create(ctx, serializedConfig)
+	require.ErrorContains(t, err, "is not empty")
+
+	read, err := repo.Open(ctx)
+	require.NoError(t, err)
+	require.Equal(t, serializedConfig, read)
+
+	data := bytes.Repeat([]byte("packfile"), 64*1024)
+	mac := objects.MAC{0x10, 0x20}
+	n, err := repo.PutPackfile(mac, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+
+	packfiles, err := repo.GetPackfiles()
+	require.NoError(t, err)
+	require.Equal(t, []objects.MAC{mac}, packfiles)
+
+	rd, err := repo.GetPackfile(mac)
+	require.NoError(t, err)
+	read, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+
+	rd, err = repo.GetPackfileBlob(mac, 8, 8)
+	require.NoError(t, err)
+	read, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "packfile", string(read))
+
+	require.NoError(t, repo.DeletePackfile(mac))
+	packfiles, err = repo.GetPackfiles()
+	require.NoError(t, err)
+	require.Empty(t, packfiles)
+
+	_, err = repo.GetPackfile(mac)
+	require.ErrorIs(t, err, repository.ErrPackfileNotFound)
+	_, err = repo.GetPackfileBlob(mac, 0, 8)
+	require.ErrorIs(t, err, repository.ErrPackfileNotFound)
+
+	state := objects.MAC{0x30}
+	_, err = repo.PutState(state, bytes.NewReader([]byte("state")))
+	require.NoError(t, err)
+	states, err := repo.GetStates()
+	require.NoError(t, err)
+	require.Equal(t, []objects.MAC{state}, states)
+
+	lock := objects.MAC{0x40}
+	_, err = repo.PutLock(lock, bytes.NewReader([]byte("lock")))
+	require.NoError(t, err)
+	locks, err := repo.GetLocks()
+	require.NoError(t, err)
+	require.Equal(t, []objects.MAC{lock}, locks)
+	rd, err = repo.GetLock(lock)
+	require.NoError(t, err)
+	read, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "lock", string(read))
+	require.NoError(t, repo.DeleteLock(lock))
+
+	// no temporary file is left behind
+	for name := range server.Files {
+		require.False(t, strings.Contains(name, "tmp."), name)
+	}
+}
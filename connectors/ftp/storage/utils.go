@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ftp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+
+	plakarftp "github.com/PlakarKorp/plakar/ftp"
+	"github.com/secsy/goftp"
+)
+
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// closingReader closes the connection of a blob once it is read.
+type closingReader struct {
+	rd     io.ReadCloser
+	closed bool
+}
+
+func (cr *closingReader) Read(p []byte) (int, error) {
+	if cr.closed {
+		return 0, io.EOF
+	}
+
+	n, err := cr.rd.Read(p)
+	if err == io.EOF {
+		cr.closed = true
+		if closeErr := cr.rd.Close(); closeErr != nil {
+			return n, fmt.Errorf("error closing connection: %w", closeErr)
+		}
+	}
+	return n, err
+}
+
+// Open streams pathname as it is retrieved.  The first bytes are waited
+// for, so that a missing file is reported here rather than on read.
+func Open(client *goftp.Client, pathname string) (io.Reader, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(client.Retrieve(pathname, pw))
+	}()
+
+	rd := bufio.NewReader(pr)
+	if _, err := rd.Peek(1); err != nil && err != io.EOF {
+		pr.Close()
+		return nil, plakarftp.NotExist(pathname, err)
+	}
+	return rd, nil
+}
+
+// MkdirAll creates the missing components of pathname, the servers
+// don't tell an existing directory apart from other failures so errors
+// are only reported if pathname can't be listed in the end.
+func MkdirAll(client *goftp.Client, pathname string) error {
+	pathname = path.Clean("/" + pathname)
+	if pathname == "/" {
+		return nil
+	}
+	if _, err := client.ReadDir(pathname); err == nil {
+		return nil
+	}
+
+	if err := MkdirAll(client, path.Dir(pathname)); err != nil {
+		return err
+	}
+	if _, err := client.Mkdir(pathname); err != nil {
+		if _, err := client.ReadDir(pathname); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func WriteToFileAtomic(client *goftp.Client, filename string, rd io.Reader) (int64, error) {
+	return WriteToFileAtomicTempDir(client, filename, rd, path.Dir(filename))
+}
+
+// WriteToFileAtomicTempDir stores rd to a temporary file of tmpdir, then
+// renames it over filename so that readers never see a partial file.
+func WriteToFileAtomicTempDir(client *goftp.Client, filename string, rd io.Reader, tmpdir string) (int64, error) {
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return 0, err
+	}
+	tmp := path.Join(tmpdir, "tmp."+hex.EncodeToString(suffix[:]))
+
+	counter := &countingReader{Reader: rd}
+	if err := client.Store(tmp, counter); err != nil {
+		client.Delete(tmp)
+		return 0, err
+	}
+
+	if err := client.Rename(tmp, filename); err != nil {
+		client.Delete(tmp)
+		return 0, err
+	}
+
+	return counter.n, nil
+}
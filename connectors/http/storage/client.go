@@ -33,6 +33,8 @@ type Store struct {
 	config     storage.Configuration
 	Repository string
 	location   string
+	endpoint   string
+	client     *http.Client
 }
 
 func init() {
@@ -43,9 +45,22 @@ func init() {
 func NewStore(ctx context.Context, proto string, storeConfig map[string]string) (storage.Store, error) {
 	return &Store{
 		location: storeConfig["location"],
+		endpoint: storeConfig["location"],
+		client:   &http.Client{},
 	}, nil
 }
 
+// NewRemoteStore returns a store for location that sends its requests
+// to endpoint through client, asking for the repository at pathname.
+func NewRemoteStore(location, endpoint, pathname string, client *http.Client) *Store {
+	return &Store{
+		Repository: pathname,
+		location:   location,
+		endpoint:   endpoint,
+		client:     client,
+	}
+}
+
 func (s *Store) Location() string {
 	return s.location
 }
@@ -55,13 +70,25 @@ func (s *Store) sendRequest(method string, requestType string, payload interface
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest(method, s.location+requestType, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequest(method, s.endpoint+requestType, bytes.NewBuffer(requestBody))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	return client.Do(req)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// read the body in full so that the connection can be reused
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	return res, nil
 }
 
 func (s *Store) Create(ctx context.Context, config []byte) error {
@@ -69,9 +96,8 @@ func (s *Store) Create(ctx context.Context, config []byte) error {
 }
 
 func (s *Store) Open(ctx context.Context) ([]byte, error) {
-	s.Repository = s.location
 	r, err := s.sendRequest("GET", "/", network.ReqOpen{
-		Repository: "",
+		Repository: s.Repository,
 	})
 	if err != nil {
 		return nil, err
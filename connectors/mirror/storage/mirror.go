@@ -0,0 +1,597 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package mirror implements the mirror:// store, which writes to several
+// stores at once so that a backup lands in all of them.  Its parameters
+// are:
+//
+//	members		the comma-separated stores to mirror, locations or
+//			@names of configured stores
+//	write_quorum	the number of members a write must succeed on, all
+//			of them by default
+//
+// The repository is created on every member, and the members opened
+// must all hold the same one: a new member is populated with plakar
+// clone before being added.  A member that can't be opened is left out
+// until the next run, as long as enough remain for the quorum.
+//
+// Writes are sent to every member and succeed if the quorum of them
+// stored the data.  Reads go to the healthy member that answered the
+// fastest so far, then to the others.  A member that misses a write
+// diverges from the others until it is synced again, which Reconcile
+// reports.
+package mirror
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/kcontext"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+)
+
+// downDelay is how long a member that failed is only read from when
+// the others fail too.
+const downDelay = 30 * time.Second
+
+type member struct {
+	name  string
+	store storage.Store
+
+	// err is set if the member could not be opened.
+	err error
+
+	mtx       sync.Mutex
+	latency   time.Duration
+	downUntil time.Time
+	failures  int
+}
+
+// success records the time taken by a read as a moving average.
+func (m *member) success(elapsed time.Duration) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.latency == 0 {
+		m.latency = elapsed
+	} else {
+		m.latency = (3*m.latency + elapsed) / 4
+	}
+	m.downUntil = time.Time{}
+}
+
+func (m *member) failure(write bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.downUntil = time.Now().Add(downDelay)
+	if write {
+		m.failures++
+	}
+}
+
+type Store struct {
+	ctx      *kcontext.KContext
+	location string
+	members  []*member
+	quorum   int
+}
+
+func init() {
+	storage.Register("mirror", 0, NewStore)
+}
+
+func NewStore(ctx context.Context, proto string, storeConfig map[string]string) (storage.Store, error) {
+	kctx, ok := ctx.(*kcontext.KContext)
+	if !ok {
+		return nil, fmt.Errorf("mirror: unexpected context")
+	}
+
+	var names []string
+	for _, name := range strings.Split(storeConfig["members"], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) < 2 {
+		return nil, fmt.Errorf("mirror: at least two members are needed")
+	}
+
+	quorum := len(names)
+	if value, ok := storeConfig["write_quorum"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > len(names) {
+			return nil, fmt.Errorf("invalid write_quorum value")
+		}
+		quorum = n
+	}
+
+	s := &Store{
+		ctx:      kctx,
+		location: storeConfig["location"],
+		quorum:   quorum,
+	}
+	for _, name := range names {
+		config, err := resolve(kctx, name)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+
+		if strings.HasPrefix(config["location"], "mirror:") {
+			s.Close()
+			return nil, fmt.Errorf("mirror: %s is a mirror itself", name)
+		}
+
+		store, err := storage.New(kctx, config)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		s.members = append(s.members, &member{name: name, store: store})
+	}
+
+	for _, m := range s.members {
+		if _, ok := plakarstorage.HasKeyring(m.store); !ok {
+			return s, nil
+		}
+	}
+	return &keyringStore{s}, nil
+}
+
+// resolve returns the configuration of a member, which is either a
+// location or the @name of a configured store.
+func resolve(ctx *kcontext.KContext, name string) (map[string]string, error) {
+	if !strings.HasPrefix(name, "@") {
+		return map[string]string{"location": name}, nil
+	}
+	if ctx.Config == nil {
+		return nil, fmt.Errorf("could not resolve repository: %s", name)
+	}
+	return ctx.Config.GetRepository(name)
+}
+
+func (s *Store) Location() string {
+	return s.location
+}
+
+// each runs fn on every member that was opened, concurrently, and
+// returns the errors of each of them.
+func (s *Store) each(fn func(int, *member) error) []error {
+	errs := make([]error, len(s.members))
+
+	var wg sync.WaitGroup
+	for i, m := range s.members {
+		if m.err != nil {
+			errs[i] = m.err
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(i, m)
+		}()
+	}
+	wg.Wait()
+
+	return errs
+}
+
+func (s *Store) Create(ctx context.Context, config []byte) error {
+	var failed []error
+	for i, err := range s.each(func(i int, m *member) error {
+		return m.store.Create(ctx, config)
+	}) {
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", s.members[i].name, err))
+		}
+	}
+	return errors.Join(failed...)
+}
+
+func (s *Store) Open(ctx context.Context) ([]byte, error) {
+	configs := make([][]byte, len(s.members))
+	errs := s.each(func(i int, m *member) error {
+		t0 := time.Now()
+		config, err := m.store.Open(ctx)
+		if err != nil {
+			return err
+		}
+		m.success(time.Since(t0))
+		configs[i] = config
+		return nil
+	})
+
+	var config []byte
+	var reference string
+	var failed []error
+	for i, m := range s.members {
+		if errs[i] != nil {
+			m.err = errs[i]
+			failed = append(failed, fmt.Errorf("%s: %w", m.name, errs[i]))
+			continue
+		}
+		if config == nil {
+			config, reference = configs[i], m.name
+		} else if !bytes.Equal(config, configs[i]) {
+			return nil, fmt.Errorf("mirror: %s and %s hold different repositories", reference, m.name)
+		}
+	}
+
+	if len(s.members)-len(failed) < s.quorum {
+		return nil, errors.Join(failed...)
+	}
+	for _, err := range failed {
+		s.ctx.GetLogger().Warn("mirror: left out %s", err)
+	}
+	return config, nil
+}
+
+func (s *Store) Mode() storage.Mode {
+	mode := storage.ModeRead | storage.ModeWrite
+	for _, m := range s.members {
+		if m.err == nil {
+			mode &= m.store.Mode()
+		}
+	}
+	return mode
+}
+
+func (s *Store) Size() int64 {
+	var size int64
+	for _, m := range s.members {
+		if m.err != nil {
+			continue
+		}
+		n := m.store.Size()
+		if n == -1 {
+			return -1
+		}
+		size = max(size, n)
+	}
+	return size
+}
+
+// write runs fn on every member and succeeds if it did on the quorum.
+func (s *Store) write(fn func(storage.Store) error) error {
+	var failed []error
+	for i, err := range s.each(func(i int, m *member) error {
+		return fn(m.store)
+	}) {
+		if err != nil {
+			s.members[i].failure(true)
+			failed = append(failed, fmt.Errorf("%s: %w", s.members[i].name, err))
+		}
+	}
+
+	if len(s.members)-len(failed) < s.quorum {
+		return errors.Join(failed...)
+	}
+	return nil
+}
+
+// put spools the data written so that every member reads it at its
+// own pace.
+func (s *Store) put(rd io.Reader, fn func(storage.Store, io.Reader) (int64, error)) (int64, error) {
+	data, err := plakarstorage.NewSpool(rd)
+	if err != nil {
+		return 0, err
+	}
+	defer data.Close()
+
+	err = s.write(func(store storage.Store) error {
+		_, err := fn(store, data.Reader())
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return data.Size(), nil
+}
+
+// remove deletes from every member, those which don't have the
+// resource count as succeeding.
+func (s *Store) remove(fn func(storage.Store) error) error {
+	return s.write(func(store storage.Store) error {
+		if err := fn(store); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	})
+}
+
+// readable returns the members opened, the healthy ones first from the
+// fastest to the slowest.  A member that wasn't read from yet comes
+// first, to measure it.
+func (s *Store) readable() []*member {
+	type candidate struct {
+		member  *member
+		down    bool
+		latency time.Duration
+	}
+
+	now := time.Now()
+	var candidates []candidate
+	for _, m := range s.members {
+		if m.err != nil {
+			continue
+		}
+		m.mtx.Lock()
+		candidates = append(candidates, candidate{m, now.Before(m.downUntil), m.latency})
+		m.mtx.Unlock()
+	}
+
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		if a.down != b.down {
+			if a.down {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Compare(a.latency, b.latency)
+	})
+
+	members := make([]*member, len(candidates))
+	for i := range candidates {
+		members[i] = candidates[i].member
+	}
+	return members
+}
+
+// read tries the members until one succeeds.  Only the time to get the
+// reader is measured, the backends streaming the data are timed on
+// their first response.
+func (s *Store) read(fn func(storage.Store) (io.Reader, error)) (io.Reader, error) {
+	var failed []error
+	for _, m := range s.readable() {
+		t0 := time.Now()
+		rd, err := fn(m.store)
+		if err == nil {
+			m.success(time.Since(t0))
+			return rd, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			m.failure(false)
+		}
+		failed = append(failed, fmt.Errorf("%s: %w", m.name, err))
+	}
+	return nil, errors.Join(failed...)
+}
+
+// list returns the union of what the members list, the members failing
+// are skipped unless all of them fail.
+func (s *Store) list(fn func(storage.Store) ([]objects.MAC, error)) ([]objects.MAC, error) {
+	lists := make([][]objects.MAC, len(s.members))
+	errs := s.each(func(i int, m *member) error {
+		macs, err := fn(m.store)
+		lists[i] = macs
+		return err
+	})
+
+	seen := make(map[objects.MAC]struct{})
+	var failed []error
+	var macs []objects.MAC
+	for i, m := range s.members {
+		if errs[i] != nil {
+			if m.err == nil {
+				m.failure(false)
+			}
+			failed = append(failed, fmt.Errorf("%s: %w", m.name, errs[i]))
+			continue
+		}
+		for _, mac := range lists[i] {
+			if _, ok := seen[mac]; !ok {
+				seen[mac] = struct{}{}
+				macs = append(macs, mac)
+			}
+		}
+	}
+
+	if len(failed) == len(s.members) {
+		return nil, errors.Join(failed...)
+	}
+	return macs, nil
+}
+
+// states
+func (s *Store) GetStates() ([]objects.MAC, error) {
+	return s.list(storage.Store.GetStates)
+}
+
+func (s *Store) PutState(mac objects.MAC, rd io.Reader) (int64, error) {
+	return s.put(rd, func(store storage.Store, rd io.Reader) (int64, error) {
+		return store.PutState(mac, rd)
+	})
+}
+
+func (s *Store) GetState(mac objects.MAC) (io.Reader, error) {
+	return s.read(func(store storage.Store) (io.Reader, error) {
+		return store.GetState(mac)
+	})
+}
+
+func (s *Store) DeleteState(mac objects.MAC) error {
+	return s.remove(func(store storage.Store) error {
+		return store.DeleteState(mac)
+	})
+}
+
+// packfiles
+func (s *Store) GetPackfiles() ([]objects.MAC, error) {
+	return s.list(storage.Store.GetPackfiles)
+}
+
+func (s *Store) PutPackfile(mac objects.MAC, rd io.Reader) (int64, error) {
+	return s.put(rd, func(store storage.Store, rd io.Reader) (int64, error) {
+		return store.PutPackfile(mac, rd)
+	})
+}
+
+func (s *Store) GetPackfile(mac objects.MAC) (io.Reader, error) {
+	return s.read(func(store storage.Store) (io.Reader, error) {
+		return store.GetPackfile(mac)
+	})
+}
+
+func (s *Store) GetPackfileBlob(mac objects.MAC, offset uint64, length uint32) (io.Reader, error) {
+	return s.read(func(store storage.Store) (io.Reader, error) {
+		return store.GetPackfileBlob(mac, offset, length)
+	})
+}
+
+func (s *Store) DeletePackfile(mac objects.MAC) error {
+	return s.remove(func(store storage.Store) error {
+		return store.DeletePackfile(mac)
+	})
+}
+
+// locks
+func (s *Store) GetLocks() ([]objects.MAC, error) {
+	return s.list(storage.Store.GetLocks)
+}
+
+func (s *Store) PutLock(lockID objects.MAC, rd io.Reader) (int64, error) {
+	return s.put(rd, func(store storage.Store, rd io.Reader) (int64, error) {
+		return store.PutLock(lockID, rd)
+	})
+}
+
+func (s *Store) GetLock(lockID objects.MAC) (io.Reader, error) {
+	return s.read(func(store storage.Store) (io.Reader, error) {
+		return store.GetLock(lockID)
+	})
+}
+
+func (s *Store) DeleteLock(lockID objects.MAC) error {
+	return s.remove(func(store storage.Store) error {
+		return store.DeleteLock(lockID)
+	})
+}
+
+// Reconcile compares the states and packfiles of the members opened,
+// reporting for each of them those the others have and it misses.  The
+// members not opened are reported with their error.
+func (s *Store) Reconcile() ([]plakarstorage.MemberReport, error) {
+	type content struct {
+		states    []objects.MAC
+		packfiles []objects.MAC
+	}
+
+	contents := make([]content, len(s.members))
+	errs := s.each(func(i int, m *member) error {
+		var c content
+		var err error
+		if c.states, err = m.store.GetStates(); err != nil {
+			return err
+		}
+		if c.packfiles, err = m.store.GetPackfiles(); err != nil {
+			return err
+		}
+		contents[i] = c
+		return nil
+	})
+
+	var states, packfiles []objects.MAC
+	for i := range s.members {
+		if errs[i] == nil {
+			states = append(states, contents[i].states...)
+			packfiles = append(packfiles, contents[i].packfiles...)
+		}
+	}
+
+	reports := make([]plakarstorage.MemberReport, len(s.members))
+	for i, m := range s.members {
+		reports[i] = plakarstorage.MemberReport{Member: m.name, Err: errs[i]}
+		if errs[i] == nil {
+			reports[i].States = missing(states, contents[i].states)
+			reports[i].Packfiles = missing(packfiles, contents[i].packfiles)
+		}
+	}
+	return reports, nil
+}
+
+// missing returns the MACs of all that are not in have, once each and
+// sorted.
+func missing(all, have []objects.MAC) []objects.MAC {
+	found := make(map[objects.MAC]struct{}, len(have))
+	for _, mac := range have {
+		found[mac] = struct{}{}
+	}
+
+	var res []objects.MAC
+	for _, mac := range all {
+		if _, ok := found[mac]; !ok {
+			found[mac] = struct{}{}
+			res = append(res, mac)
+		}
+	}
+	slices.SortFunc(res, func(a, b objects.MAC) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	return res
+}
+
+func (s *Store) Close() error {
+	var errs []error
+	for _, m := range s.members {
+		m.mtx.Lock()
+		failures := m.failures
+		m.mtx.Unlock()
+		if failures != 0 {
+			s.ctx.GetLogger().Warn("mirror: %d writes failed on %s, see plakar info for the members' state",
+				failures, m.name)
+		}
+		if err := m.store.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// keyringStore is the mirror of stores that can all hold key slots.
+type keyringStore struct {
+	*Store
+}
+
+func (s *keyringStore) GetKeyring() (io.Reader, error) {
+	return s.read(func(store storage.Store) (io.Reader, error) {
+		keyringStore, _ := plakarstorage.HasKeyring(store)
+		return keyringStore.GetKeyring()
+	})
+}
+
+func (s *keyringStore) PutKeyring(rd io.Reader) error {
+	data, err := plakarstorage.NewSpool(rd)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	return s.write(func(store storage.Store) error {
+		keyringStore, _ := plakarstorage.HasKeyring(store)
+		return keyringStore.PutKeyring(data.Reader())
+	})
+}
@@ -0,0 +1,302 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/config"
+	"github.com/PlakarKorp/kloset/kcontext"
+	"github.com/PlakarKorp/kloset/logging"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/appcontext"
+	_ "github.com/PlakarKorp/plakar/connectors/fs/storage"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+	"github.com/stretchr/testify/require"
+)
+
+var errBroken = errors.New("broken")
+
+// memStore keeps its content in memory and fails every call once
+// broken.  The stores are kept by location for the tests to reach them.
+type memStore struct {
+	storage.Store
+
+	mtx       sync.Mutex
+	broken    bool
+	reads     int
+	config    []byte
+	states    map[objects.MAC][]byte
+	packfiles map[objects.MAC][]byte
+}
+
+var memStores = make(map[string]*memStore)
+
+func init() {
+	storage.Register("mem", 0, func(ctx context.Context, proto string, storeConfig map[string]string) (storage.Store, error) {
+		s, ok := memStores[storeConfig["location"]]
+		if !ok {
+			s = &memStore{states: make(map[objects.MAC][]byte), packfiles: make(map[objects.MAC][]byte)}
+			memStores[storeConfig["location"]] = s
+		}
+		return s, nil
+	})
+}
+
+func (s *memStore) fail() error {
+	if s.broken {
+		return errBroken
+	}
+	return nil
+}
+
+func (s *memStore) Create(ctx context.Context, config []byte) error {
+	s.config = config
+	return s.fail()
+}
+
+func (s *memStore) Open(ctx context.Context) ([]byte, error) {
+	return s.config, s.fail()
+}
+
+func (s *memStore) Mode() storage.Mode {
+	return storage.ModeRead | storage.ModeWrite
+}
+
+func (s *memStore) Close() error {
+	return nil
+}
+
+func (s *memStore) list(m map[objects.MAC][]byte) ([]objects.MAC, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	var macs []objects.MAC
+	for mac := range m {
+		macs = append(macs, mac)
+	}
+	return macs, s.fail()
+}
+
+func (s *memStore) put(m map[objects.MAC][]byte, mac objects.MAC, rd io.Reader) (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.fail(); err != nil {
+		return 0, err
+	}
+	data, err := io.ReadAll(rd)
+	m[mac] = data
+	return int64(len(data)), err
+}
+
+func (s *memStore) get(m map[objects.MAC][]byte, mac objects.MAC) (io.Reader, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.reads++
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+	data, ok := m[mac]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (s *memStore) GetStates() ([]objects.MAC, error) {
+	return s.list(s.states)
+}
+
+func (s *memStore) PutState(mac objects.MAC, rd io.Reader) (int64, error) {
+	return s.put(s.states, mac, rd)
+}
+
+func (s *memStore) GetState(mac objects.MAC) (io.Reader, error) {
+	return s.get(s.states, mac)
+}
+
+func (s *memStore) GetPackfiles() ([]objects.MAC, error) {
+	return s.list(s.packfiles)
+}
+
+func (s *memStore) PutPackfile(mac objects.MAC, rd io.Reader) (int64, error) {
+	return s.put(s.packfiles, mac, rd)
+}
+
+func (s *memStore) GetPackfile(mac objects.MAC) (io.Reader, error) {
+	return s.get(s.packfiles, mac)
+}
+
+func newContext() *kcontext.KContext {
+	ctx := appcontext.NewAppContext().GetInner()
+	ctx.Config = config.NewConfig()
+	ctx.SetLogger(logging.NewLogger(io.Discard, io.Discard))
+	return ctx
+}
+
+func readAll(t *testing.T, rd io.Reader, err error) string {
+	require.NoError(t, err)
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestMirrorBackend(t *testing.T) {
+	ctx := newContext()
+	local, offsite := t.TempDir(), t.TempDir()
+	ctx.Config.Repositories["offsite"] = config.RepositoryConfig{"location": "fs://" + offsite}
+
+	store, err := storage.New(ctx, map[string]string{
+		"location": "mirror://backups",
+		"members":  local + ", @offsite",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "mirror://backups", store.Location())
+
+	serializedConfig, err := storage.NewConfiguration().ToBytes()
+	require.NoError(t, err)
+	require.NoError(t, store.Create(ctx, serializedConfig))
+
+	config, err := store.Open(ctx)
+	require.NoError(t, err)
+	require.Equal(t, serializedConfig, config)
+	require.Equal(t, storage.ModeRead|storage.ModeWrite, store.Mode())
+
+	mac := objects.MAC{0x10}
+	n, err := store.PutPackfile(mac, bytes.NewReader([]byte("packfile data")))
+	require.NoError(t, err)
+	require.Equal(t, int64(13), n)
+
+	for _, dir := range []string{local, offsite} {
+		data, err := os.ReadFile(filepath.Join(dir, "packfiles", "10", fmt.Sprintf("%064x", mac)))
+		require.NoError(t, err)
+		require.Equal(t, "packfile data", string(data))
+	}
+
+	rd, err := store.GetPackfileBlob(mac, 9, 4)
+	require.Equal(t, "data", readAll(t, rd, err))
+
+	_, err = store.PutState(mac, bytes.NewReader([]byte("state")))
+	require.NoError(t, err)
+	rd, err = store.GetState(mac)
+	require.Equal(t, "state", readAll(t, rd, err))
+
+	_, err = store.PutLock(mac, bytes.NewReader([]byte("lock")))
+	require.NoError(t, err)
+	locks, err := store.GetLocks()
+	require.NoError(t, err)
+	require.Equal(t, []objects.MAC{mac}, locks)
+	require.NoError(t, store.DeleteLock(mac))
+
+	reports, err := store.(plakarstorage.Reconciler).Reconcile()
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	require.Equal(t, "@offsite", reports[1].Member)
+	for _, report := range reports {
+		require.True(t, report.InSync())
+	}
+
+	require.NoError(t, store.DeletePackfile(mac))
+	require.NoError(t, store.DeletePackfile(mac))
+	packfiles, err := store.GetPackfiles()
+	require.NoError(t, err)
+	require.Empty(t, packfiles)
+
+	require.NoError(t, store.Close())
+}
+
+func TestMirrorQuorum(t *testing.T) {
+	ctx := newContext()
+	serializedConfig, err := storage.NewConfiguration().ToBytes()
+	require.NoError(t, err)
+
+	store, err := storage.New(ctx, map[string]string{
+		"location":     "mirror://",
+		"members":      "mem://quorum-a,mem://quorum-b,mem://quorum-c",
+		"write_quorum": "2",
+	})
+	require.NoError(t, err)
+	require.NoError(t, store.Create(ctx, serializedConfig))
+	_, err = store.Open(ctx)
+	require.NoError(t, err)
+
+	a, b, c := memStores["mem://quorum-a"], memStores["mem://quorum-b"], memStores["mem://quorum-c"]
+
+	// one member down, the quorum is still reached
+	c.broken = true
+	_, err = store.PutPackfile(objects.MAC{0x01}, bytes.NewReader([]byte("one")))
+	require.NoError(t, err)
+
+	// two members down, it isn't
+	b.broken = true
+	_, err = store.PutPackfile(objects.MAC{0x02}, bytes.NewReader([]byte("two")))
+	require.ErrorIs(t, err, errBroken)
+
+	// the members down are only read from last
+	rd, err := store.GetPackfile(objects.MAC{0x01})
+	require.Equal(t, "one", readAll(t, rd, err))
+	b.broken, c.broken = false, false
+	a.reads = 0
+	rd, err = store.GetPackfile(objects.MAC{0x01})
+	require.Equal(t, "one", readAll(t, rd, err))
+	require.Equal(t, 1, a.reads)
+
+	// a missing packfile is read from another member
+	rd, err = store.GetPackfile(objects.MAC{0x02})
+	require.Equal(t, "two", readAll(t, rd, err))
+	_, err = store.GetPackfile(objects.MAC{0x03})
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	reports, err := store.(plakarstorage.Reconciler).Reconcile()
+	require.NoError(t, err)
+	require.True(t, reports[0].InSync())
+	require.Equal(t, []objects.MAC{{0x02}}, reports[1].Packfiles)
+	require.Equal(t, []objects.MAC{{0x01}, {0x02}}, reports[2].Packfiles)
+	require.Empty(t, reports[2].States)
+
+	require.NoError(t, store.Close())
+}
+
+func TestMirrorOpen(t *testing.T) {
+	ctx := newContext()
+
+	for _, members := range []string{"", "mem://alone", "mirror://a,mem://b", "@missing,mem://b"} {
+		_, err := storage.New(ctx, map[string]string{"location": "mirror://", "members": members})
+		require.Error(t, err, members)
+	}
+	_, err := storage.New(ctx, map[string]string{"location": "mirror://", "members": "mem://a,mem://b", "write_quorum": "3"})
+	require.EqualError(t, err, "invalid write_quorum value")
+
+	first, err := storage.NewConfiguration().ToBytes()
+	require.NoError(t, err)
+	second, err := storage.NewConfiguration().ToBytes()
+	require.NoError(t, err)
+
+	store, err := storage.New(ctx, map[string]string{"location": "mirror://", "members": "mem://open-a,mem://open-b,mem://open-c", "write_quorum": "2"})
+	require.NoError(t, err)
+	memStores["mem://open-a"].config = first
+	memStores["mem://open-b"].config = first
+	memStores["mem://open-c"].broken = true
+
+	// a member can be left out if the quorum remains
+	config, err := store.Open(ctx)
+	require.NoError(t, err)
+	require.Equal(t, first, config)
+
+	reports, err := store.(plakarstorage.Reconciler).Reconcile()
+	require.NoError(t, err)
+	require.ErrorIs(t, reports[2].Err, errBroken)
+
+	memStores["mem://open-b"].config = second
+	store, err = storage.New(ctx, map[string]string{"location": "mirror://", "members": "mem://open-a,mem://open-b"})
+	require.NoError(t, err)
+	_, err = store.Open(ctx)
+	require.EqualError(t, err, "mirror: mem://open-a and mem://open-b hold different repositories")
+}
@@ -18,70 +18,41 @@ package s3
 
 import (
 	"context"
-	"fmt"
 	"io"
 	"net/url"
-	"strconv"
 	"strings"
 
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/snapshot/exporter"
+	plakarexporter "github.com/PlakarKorp/plakar/exporter"
+	plakars3 "github.com/PlakarKorp/plakar/s3"
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/tags"
 )
 
 type S3Exporter struct {
-	minioClient *minio.Client
+	minioClient *plakars3.Client
 	ctx         context.Context
 
 	rootDir string
+
+	// extended attributes of the files to store, as recorded by
+	// the importer with metadata=true
+	xattrs plakarexporter.XattrLookup
 }
 
 func init() {
 	exporter.Register("s3", 0, NewS3Exporter)
 }
 
-func connect(location *url.URL, useSsl bool, accessKeyID, secretAccessKey string) (*minio.Client, error) {
-	endpoint := location.Host
-
-	// Initialize minio client object.
-	return minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
-		Secure: useSsl,
-	})
-}
-
 func NewS3Exporter(ctx context.Context, opts *exporter.Options, name string, config map[string]string) (exporter.Exporter, error) {
 	target := config["location"]
-	var accessKey string
-	if tmp, ok := config["access_key"]; !ok {
-		return nil, fmt.Errorf("missing access_key")
-	} else {
-		accessKey = tmp
-	}
-
-	var secretAccessKey string
-	if tmp, ok := config["secret_access_key"]; !ok {
-		return nil, fmt.Errorf("missing secret_access_key")
-	} else {
-		secretAccessKey = tmp
-	}
-
-	useSsl := true
-	if value, ok := config["use_tls"]; ok {
-		tmp, err := strconv.ParseBool(value)
-		if err != nil {
-			return nil, fmt.Errorf("invalid use_tls value")
-		}
-		useSsl = tmp
-	}
-
 	parsed, err := url.Parse(target)
 	if err != nil {
 		return nil, err
 	}
 
-	conn, err := connect(parsed, useSsl, accessKey, secretAccessKey)
+	conn, err := plakars3.Connect(parsed, config)
 	if err != nil {
 		return nil, err
 	}
@@ -108,11 +79,53 @@ func (p *S3Exporter) CreateDirectory(pathname string) error {
 	return nil
 }
 
+func (p *S3Exporter) SetXattrLookup(lookup plakarexporter.XattrLookup) {
+	p.xattrs = lookup
+}
+
+// putObjectOptions puts back the content type, user metadata and tags
+// of an object.
+func (p *S3Exporter) putObjectOptions(pathname string) (minio.PutObjectOptions, error) {
+	var opts minio.PutObjectOptions
+	if p.xattrs == nil {
+		return opts, nil
+	}
+
+	xattrs, err := p.xattrs(pathname)
+	if err != nil {
+		return opts, err
+	}
+
+	for name, value := range xattrs {
+		switch {
+		case name == "content-type":
+			opts.ContentType = string(value)
+		case name == "x-amz-tagging":
+			t, err := tags.ParseObjectTags(string(value))
+			if err != nil {
+				return opts, err
+			}
+			opts.UserTags = t.ToMap()
+		case strings.HasPrefix(name, "x-amz-meta-"):
+			if opts.UserMetadata == nil {
+				opts.UserMetadata = make(map[string]string)
+			}
+			opts.UserMetadata[strings.TrimPrefix(name, "x-amz-meta-")] = string(value)
+		}
+	}
+	return opts, nil
+}
+
 func (p *S3Exporter) StoreFile(pathname string, fp io.Reader, size int64) error {
-	_, err := p.minioClient.PutObject(p.ctx,
+	opts, err := p.putObjectOptions(pathname)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.minioClient.PutObject(p.ctx,
 		strings.TrimPrefix(p.rootDir, "/"),
 		strings.TrimPrefix(pathname, p.rootDir+"/"),
-		fp, size, minio.PutObjectOptions{})
+		fp, size, p.minioClient.PutObjectOptions(opts))
 	return err
 }
 
@@ -1,6 +1,8 @@
 package s3
 
 import (
+	"bytes"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
@@ -8,6 +10,7 @@ import (
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/snapshot/exporter"
 	"github.com/PlakarKorp/plakar/appcontext"
+	plakarexporter "github.com/PlakarKorp/plakar/exporter"
 	"github.com/johannesboyne/gofakes3"
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
 	"github.com/stretchr/testify/require"
@@ -56,3 +59,46 @@ func TestExporter(t *testing.T) {
 	err = exporterInstance.SetPermissions("bucket/subdir", &objects.FileInfo{Lmode: 0644})
 	require.NoError(t, err)
 }
+
+func TestExporterExtendedAttributes(t *testing.T) {
+	backend := s3mem.New()
+	faker := gofakes3.New(backend)
+
+	var tagging string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.URL.Path == "/bucket/dummy.txt" {
+			tagging = r.Header.Get("X-Amz-Tagging")
+		}
+		faker.Server().ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	appCtx := appcontext.NewAppContext()
+	exporterInstance, err := exporter.NewExporter(appCtx.GetInner(), map[string]string{"location": "s3://" + ts.Listener.Addr().String() + "/bucket", "access_key": "", "secret_access_key": "", "use_tls": "false"})
+	require.NoError(t, err)
+	defer exporterInstance.Close()
+
+	xattrExporter, ok := exporterInstance.(plakarexporter.XattrExporter)
+	require.True(t, ok)
+	xattrExporter.SetXattrLookup(func(pathname string) (map[string][]byte, error) {
+		if pathname != "/bucket/dummy.txt" {
+			return nil, nil
+		}
+		return map[string][]byte{
+			"content-type":     []byte("text/plain"),
+			"x-amz-meta-owner": []byte("legal"),
+			"x-amz-tagging":    []byte("retention=legal"),
+			"x-amz-version-id": []byte("ignored"),
+			"user.not-from-s3": []byte("ignored"),
+		}, nil
+	})
+
+	data := []byte("test exporter s3")
+	require.NoError(t, exporterInstance.StoreFile("/bucket/dummy.txt", bytes.NewReader(data), int64(len(data))))
+	require.Equal(t, "retention=legal", tagging)
+
+	object, err := backend.HeadObject("bucket", "dummy.txt")
+	require.NoError(t, err)
+	require.Equal(t, "text/plain", object.Metadata["Content-Type"])
+	require.Equal(t, "legal", object.Metadata["X-Amz-Meta-Owner"])
+}
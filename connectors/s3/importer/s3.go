@@ -16,7 +16,17 @@
 
 package s3
 
+// With metadata=true, the content type, user metadata and tags of the
+// objects are recorded as extended attributes named after the headers
+// that carry them: content-type, x-amz-meta-<name> and x-amz-tagging.
+//
+// With versions=true, every version of the objects, including delete
+// markers, is also recorded below a .versions directory at the root of
+// the scan, as <key>/<version id>, along with its metadata and the
+// x-amz-version-id and x-amz-delete-marker attributes.
+
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -28,20 +38,25 @@ import (
 	"time"
 
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/snapshot/importer"
+	plakars3 "github.com/PlakarKorp/plakar/s3"
 )
 
+const versionsDir = ".versions"
+
 type S3Importer struct {
-	minioClient *minio.Client
+	minioClient *plakars3.Client
 	ctx         context.Context
 
 	bucket  string
 	host    string
 	scanDir string
 
+	metadata bool
+	versions bool
+
 	ino uint64
 }
 
@@ -49,48 +64,36 @@ func init() {
 	importer.Register("s3", 0, NewS3Importer)
 }
 
-func connect(location *url.URL, useSsl bool, accessKeyID, secretAccessKey string) (*minio.Client, error) {
-	endpoint := location.Host
-
-	// Initialize minio client object.
-	return minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
-		Secure: useSsl,
-	})
+func parseBool(config map[string]string, key string) (bool, error) {
+	value, ok := config[key]
+	if !ok {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value", key)
+	}
+	return b, nil
 }
 
 func NewS3Importer(ctx context.Context, opts *importer.Options, name string, config map[string]string) (importer.Importer, error) {
 	target := config["location"]
 
-	var accessKey string
-	if tmp, ok := config["access_key"]; !ok {
-		return nil, fmt.Errorf("missing access_key")
-	} else {
-		accessKey = tmp
-	}
-
-	var secretAccessKey string
-	if tmp, ok := config["secret_access_key"]; !ok {
-		return nil, fmt.Errorf("missing secret_access_key")
-	} else {
-		secretAccessKey = tmp
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return nil, err
 	}
 
-	useSsl := true
-	if value, ok := config["use_tls"]; ok {
-		tmp, err := strconv.ParseBool(value)
-		if err != nil {
-			return nil, fmt.Errorf("invalid use_tls value")
-		}
-		useSsl = tmp
+	metadata, err := parseBool(config, "metadata")
+	if err != nil {
+		return nil, err
 	}
-
-	parsed, err := url.Parse(target)
+	versions, err := parseBool(config, "versions")
 	if err != nil {
 		return nil, err
 	}
 
-	conn, err := connect(parsed, useSsl, accessKey, secretAccessKey)
+	conn, err := plakars3.Connect(parsed, config)
 	if err != nil {
 		return nil, err
 	}
@@ -104,10 +107,116 @@ func NewS3Importer(ctx context.Context, opts *importer.Options, name string, con
 		scanDir:     scanDir,
 		minioClient: conn,
 		host:        parsed.Host,
+		metadata:    metadata,
+		versions:    versions,
 		ctx:         ctx,
 	}, nil
 }
 
+func dirInfo(pathname string) objects.FileInfo {
+	return objects.NewFileInfo(
+		path.Base(pathname),
+		0,
+		0700|os.ModeDir,
+		time.Unix(0, 0),
+		0,
+		0,
+		0,
+		0,
+		0,
+	)
+}
+
+// parents emits a record for each of the parent directories of an
+// object below the scan directory.  Two objects in a same directory
+// generate the same records for this directory, but the backup layer
+// ignores duplicates.
+func (p *S3Importer) parents(result chan<- *importer.ScanResult, pathname string) {
+	parent := path.Dir(pathname)
+	for parent != p.scanDir && parent != "/" {
+		result <- importer.NewScanRecord(parent, "", dirInfo(parent), nil, nil)
+		parent = path.Dir(parent)
+	}
+}
+
+// attributes returns the extended attributes of an object version.
+func (p *S3Importer) attributes(object minio.ObjectInfo) (map[string][]byte, error) {
+	xattrs := make(map[string][]byte)
+	if p.versions {
+		xattrs["x-amz-version-id"] = []byte(object.VersionID)
+	}
+	if object.IsDeleteMarker {
+		xattrs["x-amz-delete-marker"] = []byte("true")
+		return xattrs, nil
+	}
+
+	opts := p.minioClient.GetObjectOptions()
+	opts.VersionID = object.VersionID
+	info, err := p.minioClient.StatObject(p.ctx, p.bucket, object.Key, opts)
+	if err != nil {
+		return nil, err
+	}
+	if info.ContentType != "" {
+		xattrs["content-type"] = []byte(info.ContentType)
+	}
+	for name, value := range info.UserMetadata {
+		xattrs["x-amz-meta-"+strings.ToLower(name)] = []byte(value)
+	}
+
+	tags, err := p.minioClient.GetObjectTagging(p.ctx, p.bucket, object.Key, minio.GetObjectTaggingOptions{
+		VersionID: object.VersionID,
+	})
+	if err != nil {
+		// not every provider implements tagging
+		if minio.ToErrorResponse(err).Code != "NotImplemented" {
+			return nil, err
+		}
+	} else if len(tags.ToMap()) != 0 {
+		xattrs["x-amz-tagging"] = []byte(tags.String())
+	}
+	return xattrs, nil
+}
+
+func (p *S3Importer) record(result chan<- *importer.ScanResult, pathname string, object minio.ObjectInfo, xattrs map[string][]byte) {
+	p.parents(result, pathname)
+
+	var size int64
+	if !object.IsDeleteMarker {
+		size = object.Size
+	}
+	fi := objects.NewFileInfo(
+		path.Base(pathname),
+		size,
+		0700,
+		object.LastModified,
+		1,
+		0,
+		0,
+		0,
+		0,
+	)
+
+	names := make([]string, 0, len(xattrs))
+	for name := range xattrs {
+		names = append(names, name)
+	}
+
+	result <- importer.NewScanRecord(pathname, "", fi, names, func() (io.ReadCloser, error) {
+		if object.IsDeleteMarker {
+			return io.NopCloser(bytes.NewReader(nil)), nil
+		}
+		opts := p.minioClient.GetObjectOptions()
+		opts.VersionID = object.VersionID
+		return p.minioClient.GetObject(p.ctx, p.bucket, object.Key, opts)
+	})
+	for _, name := range names {
+		value := xattrs[name]
+		result <- importer.NewScanXattr(pathname, name, objects.AttributeExtended, func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(value)), nil
+		})
+	}
+}
+
 func (p *S3Importer) Scan() (<-chan *importer.ScanResult, error) {
 	result := make(chan *importer.ScanResult)
 	go func() {
@@ -116,66 +225,53 @@ func (p *S3Importer) Scan() (<-chan *importer.ScanResult, error) {
 		// Create scandir entries.
 		parent := p.scanDir
 		for {
-			fi := objects.NewFileInfo(
-				path.Base(parent),
-				0,
-				0700|os.ModeDir,
-				time.Unix(0, 0),
-				0,
-				0,
-				0,
-				0,
-				0,
-			)
-			result <- importer.NewScanRecord(parent, "", fi, nil, nil)
+			result <- importer.NewScanRecord(parent, "", dirInfo(parent), nil, nil)
 
 			if parent == "/" {
 				break
 			}
 			parent = path.Dir(parent)
 		}
+		if p.versions {
+			dir := path.Join(p.scanDir, versionsDir)
+			result <- importer.NewScanRecord(dir, "", dirInfo(dir), nil, nil)
+		}
 
 		prefix := strings.TrimPrefix(p.scanDir, "/")
 
-		for object := range p.minioClient.ListObjects(p.ctx, p.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
-			// Create a record for each of the parent directories of the object.
-			// Two objects in a same directory will generate the same records for this directory, but the backup layer ignores duplicates.
-			parent := path.Dir("/" + object.Key)
-			for {
-				// p.scanDir directories have already been created above.
-				if parent == "/"+prefix {
-					break
+		for object := range p.minioClient.ListObjects(p.ctx, p.bucket, minio.ListObjectsOptions{
+			Prefix:       prefix,
+			Recursive:    true,
+			WithVersions: p.versions,
+		}) {
+			if object.Err != nil {
+				result <- importer.NewScanError(p.scanDir, object.Err)
+				return
+			}
+
+			var xattrs map[string][]byte
+			if p.metadata || p.versions {
+				var err error
+				xattrs, err = p.attributes(object)
+				if err != nil {
+					result <- importer.NewScanError("/"+object.Key, err)
+					continue
 				}
+			}
 
-				fi := objects.NewFileInfo(
-					path.Base(parent),
-					0,
-					0700|os.ModeDir,
-					time.Unix(0, 0),
-					0,
-					0,
-					0,
-					0,
-					0,
-				)
-				result <- importer.NewScanRecord("/"+parent, "", fi, nil, nil)
-				parent = path.Dir(parent)
+			if p.versions {
+				relative := strings.TrimPrefix("/"+object.Key, p.scanDir)
+				pathname := path.Join(p.scanDir, versionsDir, relative, object.VersionID)
+				p.record(result, pathname, object, xattrs)
 			}
 
-			fi := objects.NewFileInfo(
-				path.Base("/"+object.Key),
-				object.Size,
-				0700,
-				object.LastModified,
-				1,
-				0,
-				0,
-				0,
-				0,
-			)
-			result <- importer.NewScanRecord("/"+object.Key, "", fi, nil, func() (io.ReadCloser, error) {
-				return p.minioClient.GetObject(p.ctx, p.bucket, object.Key, minio.GetObjectOptions{})
-			})
+			if object.IsDeleteMarker || (p.versions && !object.IsLatest) {
+				continue
+			}
+			if xattrs != nil {
+				delete(xattrs, "x-amz-version-id")
+			}
+			p.record(result, "/"+object.Key, object, xattrs)
 		}
 
 	}()
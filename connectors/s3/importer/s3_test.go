@@ -1,14 +1,22 @@
 package s3
 
 import (
+	"fmt"
+	"io"
+	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"sort"
+	"strings"
 	"testing"
 
+	"github.com/PlakarKorp/kloset/snapshot/importer"
 	"github.com/PlakarKorp/plakar/appcontext"
+	plakars3 "github.com/PlakarKorp/plakar/s3"
 	"github.com/johannesboyne/gofakes3"
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/minio/minio-go/v7"
 	"github.com/stretchr/testify/require"
 )
 
@@ -78,3 +86,121 @@ func TestS3Importer(t *testing.T) {
 	err = importer.Close()
 	require.NoError(t, err)
 }
+
+// taggingServer answers the tagging requests in front of gofakes3,
+// which doesn't implement them, and serves HEAD requests on versions,
+// which it ignores, as a GET without body.
+type taggingServer struct {
+	next http.Handler
+}
+
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (w headWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (s *taggingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead && r.URL.Query().Has("versionId") {
+		r.Method = http.MethodGet
+		s.next.ServeHTTP(headWriter{w}, r)
+		return
+	}
+	if r.URL.Query().Has("tagging") {
+		if r.URL.Path == "/bucket/tagged.txt" {
+			fmt.Fprint(w, `<Tagging><TagSet><Tag><Key>retention</Key><Value>legal</Value></Tag></TagSet></Tagging>`)
+		} else {
+			fmt.Fprint(w, `<Tagging><TagSet></TagSet></Tagging>`)
+		}
+		return
+	}
+	s.next.ServeHTTP(w, r)
+}
+
+func TestS3ImporterVersionsAndMetadata(t *testing.T) {
+	backend := s3mem.New()
+	faker := gofakes3.New(backend)
+	ts := httptest.NewServer(&taggingServer{next: faker.Server()})
+	defer ts.Close()
+
+	location, err := url.Parse("s3://" + ts.Listener.Addr().String() + "/bucket")
+	require.NoError(t, err)
+	client, err := plakars3.Connect(location, map[string]string{"use_tls": "false"})
+	require.NoError(t, err)
+
+	ctx := appcontext.NewAppContext()
+	require.NoError(t, client.MakeBucket(ctx, "bucket", minio.MakeBucketOptions{}))
+	require.NoError(t, client.EnableVersioning(ctx, "bucket"))
+
+	put := func(key, content string, opts minio.PutObjectOptions) minio.UploadInfo {
+		info, err := client.PutObject(ctx, "bucket", key, strings.NewReader(content), int64(len(content)), opts)
+		require.NoError(t, err)
+		return info
+	}
+	first := put("tagged.txt", "first", minio.PutObjectOptions{})
+	second := put("tagged.txt", "second", minio.PutObjectOptions{
+		ContentType:  "text/plain",
+		UserMetadata: map[string]string{"owner": "legal"},
+	})
+	put("dir/removed.txt", "removed", minio.PutObjectOptions{})
+	require.NoError(t, client.RemoveObject(ctx, "bucket", "dir/removed.txt", minio.RemoveObjectOptions{}))
+
+	config := map[string]string{"location": "s3://" + ts.Listener.Addr().String() + "/bucket", "use_tls": "false", "versions": "maybe"}
+	_, err = NewS3Importer(ctx, ctx.ImporterOpts(), "s3", config)
+	require.EqualError(t, err, "invalid versions value")
+
+	config["versions"] = "true"
+	imp, err := NewS3Importer(ctx, ctx.ImporterOpts(), "s3", config)
+	require.NoError(t, err)
+
+	scanChan, err := imp.Scan()
+	require.NoError(t, err)
+
+	records := make(map[string]*importer.ScanRecord)
+	xattrs := make(map[string]string)
+	for record := range scanChan {
+		require.Nil(t, record.Error)
+		if record.Record.IsXattr {
+			data, err := io.ReadAll(record.Record.Reader)
+			require.NoError(t, err)
+			xattrs[record.Record.Pathname+":"+record.Record.XattrName] = string(data)
+			continue
+		}
+		records[record.Record.Pathname] = record.Record
+	}
+
+	// the current version is at its key, without its version id
+	require.Contains(t, records, "/tagged.txt")
+	require.NotContains(t, records, "/dir/removed.txt")
+	require.Equal(t, "text/plain", xattrs["/tagged.txt:content-type"])
+	require.Equal(t, "legal", xattrs["/tagged.txt:x-amz-meta-owner"])
+	require.Equal(t, "retention=legal", xattrs["/tagged.txt:x-amz-tagging"])
+	require.NotContains(t, xattrs, "/tagged.txt:x-amz-version-id")
+
+	// every version is below .versions
+	for _, info := range []minio.UploadInfo{first, second} {
+		pathname := "/.versions/tagged.txt/" + info.VersionID
+		require.Contains(t, records, pathname)
+		require.Equal(t, info.VersionID, xattrs[pathname+":x-amz-version-id"])
+
+		content, err := io.ReadAll(records[pathname].Reader)
+		require.NoError(t, err)
+		if info == first {
+			require.Equal(t, "first", string(content))
+		} else {
+			require.Equal(t, "second", string(content))
+		}
+	}
+
+	markers := 0
+	for pathname, record := range records {
+		if strings.HasPrefix(pathname, "/.versions/dir/removed.txt/") && xattrs[pathname+":x-amz-delete-marker"] == "true" {
+			require.Zero(t, record.FileInfo.Size())
+			markers++
+		}
+	}
+	require.Equal(t, 1, markers)
+	require.Contains(t, records, "/.versions/dir")
+}
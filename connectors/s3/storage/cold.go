@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package s3
+
+// With storage_class=GLACIER or DEEP_ARCHIVE, packfiles are archived
+// and must be restored to a temporary copy before they can be read.
+// CONFIG, states and locks are always written in STANDARD so that the
+// repository can be opened and its snapshots listed without waiting.
+//
+// Reading a packfile that isn't restored fails with an ArchivedError,
+// and Thaw issues the restore request, using the retrieval tier set by
+// restore_tier (standard, bulk or expedited) for restore_days days.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/resources"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+	"github.com/minio/minio-go/v7"
+)
+
+func parseRestore(config map[string]string) (minio.TierType, int, error) {
+	tier := minio.TierStandard
+	if value, ok := config["restore_tier"]; ok {
+		switch strings.ToLower(value) {
+		case "standard":
+			tier = minio.TierStandard
+		case "bulk":
+			tier = minio.TierBulk
+		case "expedited":
+			tier = minio.TierExpedited
+		default:
+			return "", 0, fmt.Errorf("invalid restore_tier value")
+		}
+	}
+
+	days := 7
+	if value, ok := config["restore_days"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return "", 0, fmt.Errorf("invalid restore_days value")
+		}
+		days = n
+	}
+	return tier, days, nil
+}
+
+func isArchiveClass(storageClass string) bool {
+	return storageClass == "GLACIER" || storageClass == "DEEP_ARCHIVE"
+}
+
+// readable stats a packfile to tell whether its data can be read: it
+// was stored in another class, or a restored copy is available.
+func (s *Store) readable(key string) (bool, *minio.RestoreInfo, error) {
+	info, err := s.minioClient.StatObject(s.ctx, s.bucketName, key, s.minioClient.GetObjectOptions())
+	if err != nil {
+		return false, nil, fmt.Errorf("stat object: %w", err)
+	}
+	// StatObject doesn't fill info.StorageClass
+	if !isArchiveClass(info.Metadata.Get("X-Amz-Storage-Class")) {
+		return true, info.Restore, nil
+	}
+	if info.Restore != nil && !info.Restore.OngoingRestore {
+		return true, info.Restore, nil
+	}
+	return false, info.Restore, nil
+}
+
+// checkThawed is called before reading a packfile, the answer is only
+// remembered once it is readable.
+func (s *Store) checkThawed(mac objects.MAC) error {
+	if !isArchiveClass(s.storageClass) {
+		return nil
+	}
+
+	s.thawedMtx.Lock()
+	_, ok := s.thawed[mac]
+	s.thawedMtx.Unlock()
+	if ok {
+		return nil
+	}
+
+	key, err := s.objectPath(resources.RT_PACKFILE, mac)
+	if err != nil {
+		return err
+	}
+	ok, _, err = s.readable(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &plakarstorage.ArchivedError{Packfile: mac}
+	}
+
+	s.thawedMtx.Lock()
+	s.thawed[mac] = struct{}{}
+	s.thawedMtx.Unlock()
+	return nil
+}
+
+func (s *Store) ColdStorage() bool {
+	return isArchiveClass(s.storageClass)
+}
+
+func (s *Store) Thaw(mac objects.MAC) (bool, error) {
+	if !isArchiveClass(s.storageClass) {
+		return true, nil
+	}
+
+	key, err := s.objectPath(resources.RT_PACKFILE, mac)
+	if err != nil {
+		return false, err
+	}
+
+	ok, restore, err := s.readable(key)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		s.thawedMtx.Lock()
+		s.thawed[mac] = struct{}{}
+		s.thawedMtx.Unlock()
+		return true, nil
+	}
+	if restore != nil {
+		// already requested
+		return false, nil
+	}
+
+	req := minio.RestoreRequest{}
+	req.SetDays(s.restoreDays)
+	req.SetGlacierJobParameters(minio.GlacierJobParameters{Tier: s.restoreTier})
+	err = s.minioClient.RestoreObject(s.ctx, s.bucketName, key, "", req)
+	if err != nil && minio.ToErrorResponse(err).Code != "RestoreAlreadyInProgress" {
+		return false, fmt.Errorf("restore object: %w", err)
+	}
+	return false, nil
+}
@@ -0,0 +1,156 @@
+package s3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/appcontext"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/require"
+)
+
+// archiveServer emulates archive storage classes and restore requests
+// in front of gofakes3, which doesn't implement them.
+type archiveServer struct {
+	next http.Handler
+
+	mtx      sync.Mutex
+	class    map[string]string
+	restores map[string]bool // key -> ongoing
+	tiers    []string
+}
+
+func (s *archiveServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/testbucket/")
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Query().Has("restore"):
+		body, _ := io.ReadAll(r.Body)
+		if _, ok := s.restores[key]; ok {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `<Error><Code>RestoreAlreadyInProgress</Code></Error>`)
+			return
+		}
+		s.restores[key] = true
+		s.tiers = append(s.tiers, string(body))
+		w.WriteHeader(http.StatusAccepted)
+		return
+
+	case r.Method == http.MethodPost && r.URL.Query().Has("uploads"),
+		r.Method == http.MethodPut && !r.URL.Query().Has("partNumber"):
+		s.class[key] = r.Header.Get("X-Amz-Storage-Class")
+
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		class := s.class[key]
+		if class == "GLACIER" {
+			w.Header().Set("X-Amz-Storage-Class", class)
+			ongoing, ok := s.restores[key]
+			if ok {
+				w.Header().Set("X-Amz-Restore", fmt.Sprintf(`ongoing-request="%t"`, ongoing))
+			}
+			if r.Method == http.MethodGet && (!ok || ongoing) {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `<Error><Code>InvalidObjectState</Code></Error>`)
+				return
+			}
+		}
+	}
+
+	s.next.ServeHTTP(w, r)
+}
+
+func (s *archiveServer) complete(key string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.restores[key] = false
+}
+
+func TestS3ColdStorage(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	defer ctx.Close()
+
+	server := &archiveServer{
+		next:     gofakes3.New(s3mem.New()).Server(),
+		class:    make(map[string]string),
+		restores: make(map[string]bool),
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := map[string]string{
+		"location":          ts.URL + "/testbucket",
+		"access_key":        "",
+		"secret_access_key": "",
+		"use_tls":           "false",
+		"storage_class":     "glacier",
+		"restore_tier":      "fast",
+	}
+	_, err := NewStore(ctx, "s3", config)
+	require.EqualError(t, err, "invalid restore_tier value")
+
+	config["restore_tier"] = "bulk"
+	repo, err := NewStore(ctx, "s3", config)
+	require.NoError(t, err)
+	require.NotZero(t, repo.Mode()&storage.ModeRead)
+
+	thawer, ok := plakarstorage.IsColdStorage(repo)
+	require.True(t, ok)
+
+	serializedConfig, err := storage.NewConfiguration().ToBytes()
+	require.NoError(t, err)
+	require.NoError(t, repo.Create(ctx, serializedConfig))
+	require.Equal(t, "STANDARD", server.class["CONFIG"])
+
+	state := objects.MAC{0x01}
+	_, err = repo.PutState(state, bytes.NewReader([]byte("state")))
+	require.NoError(t, err)
+	require.Equal(t, "STANDARD", server.class[fmt.Sprintf("states/%02x/%016x", state[0], state)])
+
+	mac := objects.MAC{0x10, 0x20}
+	key := fmt.Sprintf("packfiles/%02x/%016x", mac[0], mac)
+	_, err = repo.PutPackfile(mac, bytes.NewReader([]byte("packfile")))
+	require.NoError(t, err)
+	require.Equal(t, "GLACIER", server.class[key])
+
+	_, err = repo.GetPackfileBlob(mac, 0, 4)
+	require.True(t, errors.Is(err, plakarstorage.ErrArchived), "%v", err)
+	var archived *plakarstorage.ArchivedError
+	require.True(t, errors.As(err, &archived))
+	require.Equal(t, mac, archived.Packfile)
+
+	// the restore is only requested once
+	readable, err := thawer.Thaw(mac)
+	require.NoError(t, err)
+	require.False(t, readable)
+	readable, err = thawer.Thaw(mac)
+	require.NoError(t, err)
+	require.False(t, readable)
+	require.Len(t, server.tiers, 1)
+	require.Contains(t, server.tiers[0], "<Tier>Bulk</Tier>")
+	require.Contains(t, server.tiers[0], "<Days>7</Days>")
+
+	server.complete(key)
+	readable, err = thawer.Thaw(mac)
+	require.NoError(t, err)
+	require.True(t, readable)
+
+	rd, err := repo.GetPackfile(mac)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "packfile", string(data))
+}
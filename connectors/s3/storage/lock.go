@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package s3
+
+// In immutable mode, enabled with object_lock=governance|compliance and
+// a retention such as retention=30d, states and packfiles are written
+// with an S3 Object Lock retention so that they can't be deleted, even
+// with the credentials of the store, before it expires.  The bucket
+// must have Object Lock enabled, which is done when it is created by
+// plakar.
+//
+// Maintenance extends the retention of what is still in use and defers
+// the deletion of the rest until the retention expires.  Locks and the
+// CONFIG are not retained, as they must remain deletable.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/resources"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+	"github.com/minio/minio-go/v7"
+)
+
+func parseObjectLock(config map[string]string) (minio.RetentionMode, time.Duration, error) {
+	value, ok := config["object_lock"]
+	if !ok || value == "" || value == "none" {
+		return "", 0, nil
+	}
+
+	mode := minio.RetentionMode(strings.ToUpper(value))
+	if !mode.IsValid() {
+		return "", 0, fmt.Errorf("invalid object_lock value")
+	}
+
+	value, ok = config["retention"]
+	if !ok {
+		return "", 0, fmt.Errorf("missing retention")
+	}
+	retention, err := parseRetention(value)
+	if err != nil || retention <= 0 {
+		return "", 0, fmt.Errorf("invalid retention value")
+	}
+	return mode, retention, nil
+}
+
+// parseRetention accepts a number of days, such as 30d, on top of what
+// time.ParseDuration does.
+func parseRetention(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseUint(days, 10, 32)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func (s *Store) checkObjectLock() error {
+	if s.lockMode == "" {
+		return nil
+	}
+
+	enabled, _, _, _, err := s.minioClient.GetObjectLockConfig(s.ctx, s.bucketName)
+	if err != nil {
+		return fmt.Errorf("get object lock configuration: %w", err)
+	}
+	if enabled != "Enabled" {
+		return fmt.Errorf("object lock is not enabled on bucket %s", s.bucketName)
+	}
+	return nil
+}
+
+func (s *Store) retainedPutObjectOptions() minio.PutObjectOptions {
+	opts := s.putObjectOptions
+	if s.lockMode != "" {
+		opts.Mode = s.lockMode
+		opts.RetainUntilDate = time.Now().Add(s.retention).UTC()
+	}
+	return opts
+}
+
+func (s *Store) objectPath(rtype resources.Type, mac objects.MAC) (string, error) {
+	switch rtype {
+	case resources.RT_STATE:
+		return s.realpath(fmt.Sprintf("states/%02x/%016x", mac[0], mac)), nil
+	case resources.RT_PACKFILE:
+		return s.realpath(fmt.Sprintf("packfiles/%02x/%016x", mac[0], mac)), nil
+	default:
+		return "", fmt.Errorf("unexpected resource type %s", rtype)
+	}
+}
+
+func (s *Store) retainedUntil(key string) (time.Time, error) {
+	_, until, err := s.minioClient.GetObjectRetention(s.ctx, s.bucketName, key, "")
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchObjectLockConfiguration" {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("get object retention: %w", err)
+	}
+	if until == nil || !until.After(time.Now()) {
+		return time.Time{}, nil
+	}
+	return *until, nil
+}
+
+func (s *Store) Immutable() bool {
+	return s.lockMode != ""
+}
+
+func (s *Store) Retention(rtype resources.Type, mac objects.MAC) (time.Time, error) {
+	if s.lockMode == "" {
+		return time.Time{}, nil
+	}
+
+	key, err := s.objectPath(rtype, mac)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return s.retainedUntil(key)
+}
+
+// ExtendRetention only updates the retention once less than half of
+// the policy remains, which keeps the requests made by maintenance to
+// one per object most of the time.
+func (s *Store) ExtendRetention(rtype resources.Type, mac objects.MAC) error {
+	if s.lockMode == "" {
+		return nil
+	}
+
+	key, err := s.objectPath(rtype, mac)
+	if err != nil {
+		return err
+	}
+
+	current, err := s.retainedUntil(key)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if current.Sub(now) > s.retention/2 {
+		return nil
+	}
+
+	until := now.Add(s.retention).UTC()
+	err = s.minioClient.PutObjectRetention(s.ctx, s.bucketName, key, minio.PutObjectRetentionOptions{
+		Mode:            &s.lockMode,
+		RetainUntilDate: &until,
+	})
+	if err != nil {
+		return fmt.Errorf("put object retention: %w", err)
+	}
+	return nil
+}
+
+// removeObject refuses to delete an object still under retention.  As
+// Object Lock requires versioning, removing an object that isn't would
+// only hide it behind a delete marker, so all its versions are removed
+// instead.
+func (s *Store) removeObject(rtype resources.Type, mac objects.MAC) error {
+	key, err := s.objectPath(rtype, mac)
+	if err != nil {
+		return err
+	}
+
+	if s.lockMode == "" {
+		err := s.minioClient.RemoveObject(s.ctx, s.bucketName, key, minio.RemoveObjectOptions{})
+		if err != nil {
+			return fmt.Errorf("remove object: %w", err)
+		}
+		return nil
+	}
+
+	until, err := s.retainedUntil(key)
+	if err != nil {
+		return err
+	}
+	if !until.IsZero() {
+		return fmt.Errorf("%x: %w until %s", mac, plakarstorage.ErrRetained, until.Format(time.RFC3339))
+	}
+
+	for object := range s.minioClient.ListObjects(s.ctx, s.bucketName, minio.ListObjectsOptions{
+		Prefix:       key,
+		WithVersions: true,
+	}) {
+		if object.Err != nil {
+			return fmt.Errorf("list object versions: %w", object.Err)
+		}
+		if object.Key != key {
+			continue
+		}
+		err := s.minioClient.RemoveObject(s.ctx, s.bucketName, key, minio.RemoveObjectOptions{
+			VersionID: object.VersionID,
+		})
+		if err != nil {
+			return fmt.Errorf("remove object version: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/appcontext"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/require"
+)
+
+type retention struct {
+	XMLName         xml.Name  `xml:"Retention"`
+	Mode            string    `xml:"Mode"`
+	RetainUntilDate time.Time `xml:"RetainUntilDate"`
+}
+
+// objectLockServer emulates the Object Lock API in front of gofakes3,
+// which doesn't implement it.
+type objectLockServer struct {
+	next http.Handler
+
+	mtx       sync.Mutex
+	retention map[string]time.Time
+}
+
+func (s *objectLockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/testbucket/")
+	query := r.URL.Query()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	switch {
+	case query.Has("object-lock"):
+		fmt.Fprint(w, `<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled></ObjectLockConfiguration>`)
+		return
+
+	case query.Has("retention") && r.Method == http.MethodGet:
+		until, ok := s.retention[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchObjectLockConfiguration</Code></Error>`)
+			return
+		}
+		xml.NewEncoder(w).Encode(&retention{Mode: "GOVERNANCE", RetainUntilDate: until})
+		return
+
+	case query.Has("retention") && r.Method == http.MethodPut:
+		var body retention
+		if err := xml.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.retention[key] = body.RetainUntilDate
+		return
+
+	case r.Method == http.MethodPut:
+		if value := r.Header.Get("X-Amz-Object-Lock-Retain-Until-Date"); value != "" {
+			until, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			s.retention[key] = until
+		}
+	}
+
+	s.next.ServeHTTP(w, r)
+}
+
+func (s *objectLockServer) set(key string, until time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.retention[key] = until
+}
+
+func (s *objectLockServer) get(key string) time.Time {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.retention[key]
+}
+
+func TestS3ObjectLock(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	defer ctx.Close()
+
+	server := &objectLockServer{
+		next:      gofakes3.New(s3mem.New()).Server(),
+		retention: make(map[string]time.Time),
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := map[string]string{
+		"location":          ts.URL + "/testbucket",
+		"access_key":        "",
+		"secret_access_key": "",
+		"use_tls":           "false",
+		"object_lock":       "governance",
+	}
+	_, err := NewStore(ctx, "s3", config)
+	require.EqualError(t, err, "missing retention")
+
+	config["retention"] = "1d"
+	repo, err := NewStore(ctx, "s3", config)
+	require.NoError(t, err)
+
+	retainer, ok := plakarstorage.IsImmutable(repo)
+	require.True(t, ok)
+
+	serializedConfig, err := storage.NewConfiguration().ToBytes()
+	require.NoError(t, err)
+	require.NoError(t, repo.Create(ctx, serializedConfig))
+
+	mac := objects.MAC{0x10, 0x20}
+	key := fmt.Sprintf("packfiles/%02x/%016x", mac[0], mac)
+	_, err = repo.PutPackfile(mac, bytes.NewReader([]byte("packfile")))
+	require.NoError(t, err)
+
+	until, err := retainer.Retention(resources.RT_PACKFILE, mac)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(24*time.Hour), until, time.Minute)
+
+	// deleting is deferred while the retention holds
+	err = repo.DeletePackfile(mac)
+	require.True(t, errors.Is(err, plakarstorage.ErrRetained), "%v", err)
+
+	// the retention is only extended once half of it has elapsed
+	require.NoError(t, retainer.ExtendRetention(resources.RT_PACKFILE, mac))
+	require.Equal(t, until.Unix(), server.get(key).Unix())
+
+	server.set(key, time.Now().Add(time.Hour))
+	require.NoError(t, retainer.ExtendRetention(resources.RT_PACKFILE, mac))
+	require.WithinDuration(t, time.Now().Add(24*time.Hour), server.get(key), time.Minute)
+
+	server.set(key, time.Now().Add(-time.Hour))
+	until, err = retainer.Retention(resources.RT_PACKFILE, mac)
+	require.NoError(t, err)
+	require.True(t, until.IsZero())
+
+	require.NoError(t, repo.DeletePackfile(mac))
+	packfiles, err := repo.GetPackfiles()
+	require.NoError(t, err)
+	require.Empty(t, packfiles)
+}
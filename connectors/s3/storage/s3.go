@@ -22,32 +22,43 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/url"
-	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/resources"
 	"github.com/PlakarKorp/kloset/storage"
+	plakars3 "github.com/PlakarKorp/plakar/s3"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
 
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
 type Store struct {
 	location    string
 	Repository  string
-	minioClient *minio.Client
+	minioClient *plakars3.Client
 	ctx         context.Context
 	bucketName  string
 	prefixDir   string
 
-	useSsl          bool
-	accessKey       string
-	secretAccessKey string
+	config map[string]string
 
 	storageClass string
 
+	// set in immutable mode, see lock.go
+	lockMode  minio.RetentionMode
+	retention time.Duration
+
+	// set for archive storage classes, see cold.go
+	restoreTier minio.TierType
+	restoreDays int
+	thawed      map[objects.MAC]struct{}
+	thawedMtx   sync.Mutex
+
 	bufPool sync.Pool
 
 	putObjectOptions minio.PutObjectOptions
@@ -58,29 +69,6 @@ func init() {
 }
 
 func NewStore(ctx context.Context, proto string, storeConfig map[string]string) (storage.Store, error) {
-	var accessKey string
-	if value, ok := storeConfig["access_key"]; !ok {
-		return nil, fmt.Errorf("missing access_key")
-	} else {
-		accessKey = value
-	}
-
-	var secretAccessKey string
-	if value, ok := storeConfig["secret_access_key"]; !ok {
-		return nil, fmt.Errorf("missing secret_access_key")
-	} else {
-		secretAccessKey = value
-	}
-
-	useSsl := true
-	if value, ok := storeConfig["use_tls"]; ok {
-		tmp, err := strconv.ParseBool(value)
-		if err != nil {
-			return nil, fmt.Errorf("invalid use_tls value")
-		}
-		useSsl = tmp
-	}
-
 	storageClass := "STANDARD"
 	if value, ok := storeConfig["storage_class"]; ok {
 		storageClass = strings.ToUpper(value)
@@ -89,13 +77,32 @@ func NewStore(ctx context.Context, proto string, storeConfig map[string]string)
 		}
 	}
 
-	return &Store{
-		location:        storeConfig["location"],
-		accessKey:       accessKey,
-		secretAccessKey: secretAccessKey,
-		useSsl:          useSsl,
-		storageClass:    storageClass,
-		ctx:             ctx,
+	lockMode, retention, err := parseObjectLock(storeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	restoreTier, restoreDays, err := parseRestore(storeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := plakarstorage.ParseRetryPolicy(storeConfig)
+	if err != nil {
+		return nil, err
+	}
+	policy.Transient = plakars3.IsTransient
+
+	return plakarstorage.WithRetry(&Store{
+		location:     storeConfig["location"],
+		config:       storeConfig,
+		storageClass: storageClass,
+		lockMode:     lockMode,
+		retention:    retention,
+		restoreTier:  restoreTier,
+		restoreDays:  restoreDays,
+		thawed:       make(map[objects.MAC]struct{}),
+		ctx:          ctx,
 
 		bufPool: sync.Pool{
 			New: func() any {
@@ -110,7 +117,7 @@ func NewStore(ctx context.Context, proto string, storeConfig map[string]string)
 			StorageClass:   storageClass,
 			SendContentMd5: true,
 		},
-	}, nil
+	}, policy), nil
 }
 
 func (s *Store) Location() string {
@@ -122,19 +129,13 @@ func (s *Store) realpath(path string) string {
 }
 
 func (s *Store) connect(location *url.URL) error {
-	endpoint := location.Host
-	useSSL := s.useSsl
-
-	// Initialize minio client object.
-	minioClient, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(s.accessKey, s.secretAccessKey, ""),
-		Secure: useSSL,
-	})
+	minioClient, err := plakars3.Connect(location, s.config)
 	if err != nil {
-		return fmt.Errorf("create minio client: %w", err)
+		return err
 	}
 
 	s.minioClient = minioClient
+	s.putObjectOptions = minioClient.PutObjectOptions(s.putObjectOptions)
 	return nil
 }
 
@@ -159,13 +160,18 @@ func (s *Store) Create(ctx context.Context, config []byte) error {
 		return fmt.Errorf("check if bucket exists: %w", err)
 	}
 	if !exists {
-		err = s.minioClient.MakeBucket(s.ctx, s.bucketName, minio.MakeBucketOptions{})
+		err = s.minioClient.MakeBucket(s.ctx, s.bucketName, minio.MakeBucketOptions{
+			ObjectLocking: s.lockMode != "",
+		})
 		if err != nil {
 			return fmt.Errorf("make bucket: %w", err)
 		}
 	}
+	if err := s.checkObjectLock(); err != nil {
+		return err
+	}
 
-	_, err = s.minioClient.StatObject(s.ctx, s.bucketName, s.realpath("CONFIG"), minio.StatObjectOptions{})
+	_, err = s.minioClient.StatObject(s.ctx, s.bucketName, s.realpath("CONFIG"), s.minioClient.GetObjectOptions())
 	if err != nil {
 		if minio.ToErrorResponse(err).Code != "NoSuchKey" {
 			return fmt.Errorf("stat object CONFIG: %w", err)
@@ -174,7 +180,7 @@ func (s *Store) Create(ctx context.Context, config []byte) error {
 		return fmt.Errorf("bucket already initialized")
 	}
 
-	if s.Mode()&storage.ModeRead == 0 {
+	if isArchiveClass(s.storageClass) {
 		_, err = s.minioClient.PutObject(s.ctx, s.bucketName, s.realpath("CONFIG.frozen"), bytes.NewReader(config), int64(len(config)), s.putObjectOptions)
 		if err != nil {
 			return fmt.Errorf("put object CONFIG.frozen: %w", err)
@@ -215,8 +221,11 @@ func (s *Store) Open(ctx context.Context) ([]byte, error) {
 	if !exists {
 		return nil, fmt.Errorf("bucket does not exist")
 	}
+	if err := s.checkObjectLock(); err != nil {
+		return nil, err
+	}
 
-	object, err := s.minioClient.GetObject(s.ctx, s.bucketName, s.realpath("CONFIG"), minio.GetObjectOptions{})
+	object, err := s.minioClient.GetObject(s.ctx, s.bucketName, s.realpath("CONFIG"), s.minioClient.GetObjectOptions())
 	if err != nil {
 		return nil, fmt.Errorf("error getting object: %w", err)
 	}
@@ -237,14 +246,39 @@ func (s *Store) Open(ctx context.Context) ([]byte, error) {
 	return data, nil
 }
 
+// The keyring is rewritten as key slots change, it is stored in the
+// standard class and without retention.
+func (s *Store) GetKeyring() (io.Reader, error) {
+	object, err := s.minioClient.GetObject(s.ctx, s.bucketName, s.realpath("KEYRING"), s.minioClient.GetObjectOptions())
+	if err != nil {
+		return nil, fmt.Errorf("get object KEYRING: %w", err)
+	}
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fs.ErrNotExist
+		}
+		return nil, fmt.Errorf("stat object KEYRING: %w", err)
+	}
+	return object, nil
+}
+
+func (s *Store) PutKeyring(rd io.Reader) error {
+	putObjectOptions := s.putObjectOptions
+	putObjectOptions.StorageClass = "STANDARD"
+
+	_, err := s.minioClient.PutObject(s.ctx, s.bucketName, s.realpath("KEYRING"), rd, -1, putObjectOptions)
+	if err != nil {
+		return fmt.Errorf("put object KEYRING: %w", err)
+	}
+	return nil
+}
+
 func (s *Store) Close() error {
 	return nil
 }
 
 func (s *Store) Mode() storage.Mode {
-	if s.storageClass == "GLACIER" || s.storageClass == "DEEP_ARCHIVE" {
-		return storage.ModeWrite
-	}
 	return storage.ModeRead | storage.ModeWrite
 }
 
@@ -279,7 +313,10 @@ func (s *Store) GetStates() ([]objects.MAC, error) {
 }
 
 func (s *Store) PutState(mac objects.MAC, rd io.Reader) (int64, error) {
-	info, err := s.minioClient.PutObject(s.ctx, s.bucketName, s.realpath(fmt.Sprintf("states/%02x/%016x", mac[0], mac)), rd, -1, s.putObjectOptions)
+	putObjectOptions := s.retainedPutObjectOptions()
+	putObjectOptions.StorageClass = "STANDARD"
+
+	info, err := s.minioClient.PutObject(s.ctx, s.bucketName, s.realpath(fmt.Sprintf("states/%02x/%016x", mac[0], mac)), rd, -1, putObjectOptions)
 	if err != nil {
 		return 0, fmt.Errorf("put object: %w", err)
 	}
@@ -288,7 +325,7 @@ func (s *Store) PutState(mac objects.MAC, rd io.Reader) (int64, error) {
 }
 
 func (s *Store) GetState(mac objects.MAC) (io.Reader, error) {
-	object, err := s.minioClient.GetObject(s.ctx, s.bucketName, s.realpath(fmt.Sprintf("states/%02x/%016x", mac[0], mac)), minio.GetObjectOptions{})
+	object, err := s.minioClient.GetObject(s.ctx, s.bucketName, s.realpath(fmt.Sprintf("states/%02x/%016x", mac[0], mac)), s.minioClient.GetObjectOptions())
 	if err != nil {
 		return nil, fmt.Errorf("get object: %w", err)
 	}
@@ -297,11 +334,7 @@ func (s *Store) GetState(mac objects.MAC) (io.Reader, error) {
 }
 
 func (s *Store) DeleteState(mac objects.MAC) error {
-	err := s.minioClient.RemoveObject(s.ctx, s.bucketName, s.realpath(fmt.Sprintf("states/%02x/%016x", mac[0], mac)), minio.RemoveObjectOptions{})
-	if err != nil {
-		return fmt.Errorf("remove object: %w", err)
-	}
-	return nil
+	return s.removeObject(resources.RT_STATE, mac)
 }
 
 // packfiles
@@ -337,7 +370,7 @@ func (s *Store) PutPackfile(mac objects.MAC, rd io.Reader) (int64, error) {
 		return 0, fmt.Errorf("read packfile: %w", err)
 	}
 
-	info, err := s.minioClient.PutObject(s.ctx, s.bucketName, s.realpath(fmt.Sprintf("packfiles/%02x/%016x", mac[0], mac)), buf, copied, s.putObjectOptions)
+	info, err := s.minioClient.PutObject(s.ctx, s.bucketName, s.realpath(fmt.Sprintf("packfiles/%02x/%016x", mac[0], mac)), buf, copied, s.retainedPutObjectOptions())
 	if err != nil {
 		return 0, fmt.Errorf("put object: %w", err)
 	}
@@ -348,7 +381,11 @@ func (s *Store) PutPackfile(mac objects.MAC, rd io.Reader) (int64, error) {
 }
 
 func (s *Store) GetPackfile(mac objects.MAC) (io.Reader, error) {
-	object, err := s.minioClient.GetObject(s.ctx, s.bucketName, s.realpath(fmt.Sprintf("packfiles/%02x/%016x", mac[0], mac)), minio.GetObjectOptions{})
+	if err := s.checkThawed(mac); err != nil {
+		return nil, err
+	}
+
+	object, err := s.minioClient.GetObject(s.ctx, s.bucketName, s.realpath(fmt.Sprintf("packfiles/%02x/%016x", mac[0], mac)), s.minioClient.GetObjectOptions())
 	if err != nil {
 		return nil, fmt.Errorf("get object: %w", err)
 	}
@@ -356,7 +393,11 @@ func (s *Store) GetPackfile(mac objects.MAC) (io.Reader, error) {
 }
 
 func (s *Store) GetPackfileBlob(mac objects.MAC, offset uint64, length uint32) (io.Reader, error) {
-	opts := minio.GetObjectOptions{}
+	if err := s.checkThawed(mac); err != nil {
+		return nil, err
+	}
+
+	opts := s.minioClient.GetObjectOptions()
 	object, err := s.minioClient.GetObject(s.ctx, s.bucketName, s.realpath(fmt.Sprintf("packfiles/%02x/%016x", mac[0], mac)), opts)
 	if err != nil {
 		return nil, fmt.Errorf("get object: %w", err)
@@ -366,11 +407,7 @@ func (s *Store) GetPackfileBlob(mac objects.MAC, offset uint64, length uint32) (
 }
 
 func (s *Store) DeletePackfile(mac objects.MAC) error {
-	err := s.minioClient.RemoveObject(s.ctx, s.bucketName, s.realpath(fmt.Sprintf("packfiles/%02x/%016x", mac[0], mac)), minio.RemoveObjectOptions{})
-	if err != nil {
-		return fmt.Errorf("remove object: %w", err)
-	}
-	return nil
+	return s.removeObject(resources.RT_PACKFILE, mac)
 }
 
 func (s *Store) GetLocks() ([]objects.MAC, error) {
@@ -409,7 +446,7 @@ func (s *Store) PutLock(lockID objects.MAC, rd io.Reader) (int64, error) {
 }
 
 func (s *Store) GetLock(lockID objects.MAC) (io.Reader, error) {
-	object, err := s.minioClient.GetObject(s.ctx, s.bucketName, s.realpath(fmt.Sprintf("locks/%016x", lockID)), minio.GetObjectOptions{})
+	object, err := s.minioClient.GetObject(s.ctx, s.bucketName, s.realpath(fmt.Sprintf("locks/%016x", lockID)), s.minioClient.GetObjectOptions())
 	if err != nil {
 		return nil, fmt.Errorf("get object: %w", err)
 	}
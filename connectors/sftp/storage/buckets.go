@@ -24,19 +24,19 @@ import (
 	"sync"
 
 	"github.com/PlakarKorp/kloset/objects"
-	"github.com/pkg/sftp"
+	plakarsftp "github.com/PlakarKorp/plakar/sftp"
 	"golang.org/x/sync/errgroup"
 )
 
 type Buckets struct {
-	client *sftp.Client
-	path   string
+	pool *plakarsftp.Pool
+	path string
 }
 
-func NewBuckets(sftpClient *sftp.Client, path string) Buckets {
+func NewBuckets(pool *plakarsftp.Pool, path string) Buckets {
 	return Buckets{
-		client: sftpClient,
-		path:   path,
+		pool: pool,
+		path: path,
 	}
 }
 
@@ -46,11 +46,15 @@ func (buckets *Buckets) Create() error {
 	for i := 0; i < 256; i++ {
 		i := i // capture the current value of i
 		g.Go(func() error {
+			client, err := buckets.pool.Client()
+			if err != nil {
+				return err
+			}
 			dir := path.Join(buckets.path, fmt.Sprintf("%02x", i))
-			if err := buckets.client.MkdirAll(dir); err != nil {
+			if err := client.MkdirAll(dir); err != nil {
 				return err
 			}
-			if err := buckets.client.Chmod(dir, 0755); err != nil {
+			if err := client.Chmod(dir, 0755); err != nil {
 				return err
 			}
 			return nil
@@ -64,15 +68,20 @@ func (buckets *Buckets) List() ([]objects.MAC, error) {
 	ret := make([]objects.MAC, 0)
 	var mu sync.Mutex
 
-	wg := sync.WaitGroup{}
+	var g errgroup.Group
 	for i := 0; i < 256; i++ {
 		path := path.Join(buckets.path, fmt.Sprintf("%02x", i))
-		wg.Add(1)
-		go func(path string) {
-			defer wg.Done()
-			entries, err := buckets.client.ReadDir(path)
+		g.Go(func() error {
+			client, err := buckets.pool.Client()
+			if err != nil {
+				return err
+			}
+			entries, err := client.ReadDir(path)
 			if err != nil {
-				return
+				if plakarsftp.IsTransient(err) {
+					return err
+				}
+				return nil
 			}
 			for _, entry := range entries {
 				if entry.Name() == "." || entry.Name() == ".." {
@@ -95,9 +104,12 @@ func (buckets *Buckets) List() ([]objects.MAC, error) {
 				ret = append(ret, t32)
 				mu.Unlock()
 			}
-		}(path)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
 	return ret, nil
 }
 
@@ -108,7 +120,11 @@ func (buckets *Buckets) Path(mac objects.MAC) string {
 }
 
 func (buckets *Buckets) Get(mac objects.MAC) (io.Reader, error) {
-	fp, err := buckets.client.Open(buckets.Path(mac))
+	client, err := buckets.pool.Client()
+	if err != nil {
+		return nil, err
+	}
+	fp, err := client.Open(buckets.Path(mac))
 	if err != nil {
 		return nil, err
 	}
@@ -116,7 +132,11 @@ func (buckets *Buckets) Get(mac objects.MAC) (io.Reader, error) {
 }
 
 func (buckets *Buckets) GetBlob(mac objects.MAC, offset uint64, length uint32) (io.Reader, error) {
-	fp, err := buckets.client.Open(buckets.Path(mac))
+	client, err := buckets.pool.Client()
+	if err != nil {
+		return nil, err
+	}
+	fp, err := client.Open(buckets.Path(mac))
 	if err != nil {
 		return nil, err
 	}
@@ -124,9 +144,17 @@ func (buckets *Buckets) GetBlob(mac objects.MAC, offset uint64, length uint32) (
 }
 
 func (buckets *Buckets) Remove(mac objects.MAC) error {
-	return buckets.client.Remove(buckets.Path(mac))
+	client, err := buckets.pool.Client()
+	if err != nil {
+		return err
+	}
+	return client.Remove(buckets.Path(mac))
 }
 
 func (buckets *Buckets) Put(mac objects.MAC, rd io.Reader) (int64, error) {
-	return WriteToFileAtomicTempDir(buckets.client, buckets.Path(mac), rd, buckets.path)
+	client, err := buckets.pool.Client()
+	if err != nil {
+		return 0, err
+	}
+	return WriteToFileAtomicTempDir(client, buckets.Path(mac), rd, buckets.path)
 }
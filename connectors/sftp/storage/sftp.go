@@ -32,13 +32,13 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/storage"
 	plakarsftp "github.com/PlakarKorp/plakar/sftp"
-	"github.com/pkg/sftp"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
 )
 
 type Store struct {
 	packfiles Buckets
 	states    Buckets
-	client    *sftp.Client
+	pool      *plakarsftp.Pool
 
 	config   map[string]string
 	endpoint *url.URL
@@ -59,10 +59,22 @@ func NewStore(ctx context.Context, proto string, storeConfig map[string]string)
 		return nil, err
 	}
 
-	return &Store{
+	pool, err := plakarsftp.NewPool(parsed, storeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := plakarstorage.ParseRetryPolicy(storeConfig)
+	if err != nil {
+		return nil, err
+	}
+	policy.Transient = plakarsftp.IsTransient
+
+	return plakarstorage.WithRetry(&Store{
+		pool:     pool,
 		config:   storeConfig,
 		endpoint: parsed,
-	}, nil
+	}, policy), nil
 }
 
 func (s *Store) Location() string {
@@ -86,11 +98,10 @@ func (s *Store) Path(args ...string) string {
 }
 
 func (s *Store) Create(ctx context.Context, config []byte) error {
-	client, err := plakarsftp.Connect(s.endpoint, s.config)
+	client, err := s.pool.Client()
 	if err != nil {
 		return err
 	}
-	s.client = client
 
 	dirfp, err := client.ReadDir(s.Path())
 	if err != nil {
@@ -110,12 +121,12 @@ func (s *Store) Create(ctx context.Context, config []byte) error {
 			return fmt.Errorf("directory %s is not empty", s.Location())
 		}
 	}
-	s.packfiles = NewBuckets(client, s.Path("packfiles"))
+	s.packfiles = NewBuckets(s.pool, s.Path("packfiles"))
 	if err := s.packfiles.Create(); err != nil {
 		return err
 	}
 
-	s.states = NewBuckets(client, s.Path("states"))
+	s.states = NewBuckets(s.pool, s.Path("states"))
 	if err := s.states.Create(); err != nil {
 		return err
 	}
@@ -130,11 +141,10 @@ func (s *Store) Create(ctx context.Context, config []byte) error {
 }
 
 func (s *Store) Open(ctx context.Context) ([]byte, error) {
-	client, err := plakarsftp.Connect(s.endpoint, s.config)
+	client, err := s.pool.Client()
 	if err != nil {
 		return nil, err
 	}
-	s.client = client
 
 	rd, err := client.Open(s.Path("CONFIG"))
 	if err != nil {
@@ -147,13 +157,36 @@ func (s *Store) Open(ctx context.Context) ([]byte, error) {
 		return nil, err
 	}
 
-	s.packfiles = NewBuckets(client, s.Path("packfiles"))
+	s.packfiles = NewBuckets(s.pool, s.Path("packfiles"))
 
-	s.states = NewBuckets(client, s.Path("states"))
+	s.states = NewBuckets(s.pool, s.Path("states"))
 
 	return data, nil
 }
 
+func (s *Store) GetKeyring() (io.Reader, error) {
+	client, err := s.pool.Client()
+	if err != nil {
+		return nil, err
+	}
+
+	fp, err := client.Open(s.Path("KEYRING"))
+	if err != nil {
+		return nil, err
+	}
+	return ClosingReader(fp)
+}
+
+func (s *Store) PutKeyring(rd io.Reader) error {
+	client, err := s.pool.Client()
+	if err != nil {
+		return err
+	}
+
+	_, err = WriteToFileAtomic(client, s.Path("KEYRING"), rd)
+	return err
+}
+
 func (s *Store) GetPackfiles() ([]objects.MAC, error) {
 	return s.packfiles.List()
 }
@@ -198,7 +231,7 @@ func (s *Store) PutPackfile(mac objects.MAC, rd io.Reader) (int64, error) {
 }
 
 func (s *Store) Close() error {
-	return nil
+	return s.pool.Close()
 }
 
 /* Indexes */
@@ -220,7 +253,12 @@ func (s *Store) DeleteState(mac objects.MAC) error {
 
 /* Locks */
 func (s *Store) GetLocks() (ret []objects.MAC, err error) {
-	entries, err := s.client.ReadDir(s.Path("locks"))
+	client, err := s.pool.Client()
+	if err != nil {
+		return
+	}
+
+	entries, err := client.ReadDir(s.Path("locks"))
 	if err != nil {
 		return
 	}
@@ -240,11 +278,20 @@ func (s *Store) GetLocks() (ret []objects.MAC, err error) {
 }
 
 func (s *Store) PutLock(lockID objects.MAC, rd io.Reader) (int64, error) {
-	return WriteToFileAtomicTempDir(s.client, path.Join(s.Path("locks"), hex.EncodeToString(lockID[:])), rd, s.Path(""))
+	client, err := s.pool.Client()
+	if err != nil {
+		return 0, err
+	}
+	return WriteToFileAtomicTempDir(client, path.Join(s.Path("locks"), hex.EncodeToString(lockID[:])), rd, s.Path(""))
 }
 
 func (s *Store) GetLock(lockID objects.MAC) (io.Reader, error) {
-	fp, err := s.client.Open(path.Join(s.Path("locks"), hex.EncodeToString(lockID[:])))
+	client, err := s.pool.Client()
+	if err != nil {
+		return nil, err
+	}
+
+	fp, err := client.Open(path.Join(s.Path("locks"), hex.EncodeToString(lockID[:])))
 	if err != nil {
 		return nil, err
 	}
@@ -253,5 +300,9 @@ func (s *Store) GetLock(lockID objects.MAC) (io.Reader, error) {
 }
 
 func (s *Store) DeleteLock(lockID objects.MAC) error {
-	return s.client.Remove(path.Join(s.Path("locks"), hex.EncodeToString(lockID[:])))
+	client, err := s.pool.Client()
+	if err != nil {
+		return err
+	}
+	return client.Remove(path.Join(s.Path("locks"), hex.EncodeToString(lockID[:])))
 }
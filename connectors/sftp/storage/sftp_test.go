@@ -0,0 +1,69 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSFTPBackend(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	defer ctx.Close()
+
+	server, err := ptesting.NewMockSFTPServer(t)
+	require.NoError(t, err)
+	defer server.Close()
+
+	config := map[string]string{
+		"location":                 "sftp://" + server.Addr + t.TempDir() + "/repo",
+		"identity":                 server.KeyFile,
+		"insecure_ignore_host_key": "true",
+		"connections":              "2",
+		"pipeline":                 "8",
+	}
+	repo, err := NewStore(ctx, "sftp", config)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	serializedConfig, err := storage.NewConfiguration().ToBytes()
+	require.NoError(t, err)
+	require.NoError(t, repo.Create(ctx, serializedConfig))
+
+	// large enough for the writes to be pipelined
+	data := bytes.Repeat([]byte("packfile"), 64*1024)
+	mac := objects.MAC{0x10, 0x20}
+	n, err := repo.PutPackfile(mac, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+
+	packfiles, err := repo.GetPackfiles()
+	require.NoError(t, err)
+	require.Equal(t, []objects.MAC{mac}, packfiles)
+
+	rd, err := repo.GetPackfile(mac)
+	require.NoError(t, err)
+	read, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+
+	rd, err = repo.GetPackfileBlob(mac, 8, 8)
+	require.NoError(t, err)
+	read, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "packfile", string(read))
+
+	require.NoError(t, repo.DeletePackfile(mac))
+	packfiles, err = repo.GetPackfiles()
+	require.NoError(t, err)
+	require.Empty(t, packfiles)
+
+	config["connections"] = "0"
+	_, err = NewStore(ctx, "sftp", config)
+	require.EqualError(t, err, "invalid connections value")
+}
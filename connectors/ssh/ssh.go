@@ -0,0 +1,5 @@
+package ssh
+
+import (
+	_ "github.com/PlakarKorp/plakar/connectors/ssh/storage"
+)
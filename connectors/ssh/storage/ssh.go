@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package ssh implements the ssh:// store, which runs plakar server
+// -stdio on the remote host and sends it the requests of the http store
+// over the SSH session.  Each connection is a session of its own, up to
+// the connections parameter (4 by default).  The plakar parameter sets
+// the command run remotely, plakar by default.
+//
+// The remote host decides what is allowed: forcing the command in the
+// authorized_keys of the account, without -allow-delete or with -jail,
+// makes the repository append-only or confines the client to a
+// directory.
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/storage"
+	httpstorage "github.com/PlakarKorp/plakar/connectors/http/storage"
+	plakarsftp "github.com/PlakarKorp/plakar/sftp"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+type Store struct {
+	*httpstorage.Store
+
+	config   map[string]string
+	endpoint *url.URL
+	command  string
+
+	// dial opens a connection to a remote plakar server, ssh
+	// sessions unless replaced by tests.
+	dial      func() (net.Conn, error)
+	transport *http.Transport
+
+	mtx    sync.Mutex
+	client *ssh.Client
+}
+
+func init() {
+	storage.Register("ssh", 0, NewStore)
+}
+
+func NewStore(ctx context.Context, proto string, storeConfig map[string]string) (storage.Store, error) {
+	location := storeConfig["location"]
+	if location == "" {
+		return nil, fmt.Errorf("missing location")
+	}
+
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Path == "" {
+		return nil, fmt.Errorf("missing repository path")
+	}
+
+	connections := 4
+	if value, ok := storeConfig["connections"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid connections value")
+		}
+		connections = n
+	}
+
+	command := "plakar"
+	if value, ok := storeConfig["plakar"]; ok && value != "" {
+		command = value
+	}
+
+	policy, err := plakarstorage.ParseRetryPolicy(storeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		config:   storeConfig,
+		endpoint: parsed,
+		command:  command + " server -stdio -allow-delete",
+	}
+	s.dial = s.session
+	s.transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return s.dial()
+		},
+		MaxConnsPerHost:     connections,
+		MaxIdleConnsPerHost: connections,
+		DisableCompression:  true,
+	}
+	s.Store = httpstorage.NewRemoteStore(location, "http://plakar", parsed.Path, &http.Client{
+		Transport: s.transport,
+	})
+
+	return plakarstorage.WithRetry(s, policy), nil
+}
+
+func (s *Store) Create(ctx context.Context, config []byte) error {
+	return fmt.Errorf("ssh: the repository must be created on the remote host")
+}
+
+func (s *Store) Close() error {
+	s.transport.CloseIdleConnections()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.client != nil {
+		return s.client.Close()
+	}
+	return nil
+}
+
+// session runs the remote plakar server in a new session of the SSH
+// connection, dialed on first use.  Its errors are passed through to
+// ours.
+func (s *Store) session() (net.Conn, error) {
+	s.mtx.Lock()
+	if s.client == nil {
+		client, err := plakarsftp.Dial(s.endpoint, s.config)
+		if err != nil {
+			s.mtx.Unlock()
+			return nil, err
+		}
+		s.client = client
+	}
+	client := s.client
+	s.mtx.Unlock()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	session.Stderr = os.Stderr
+
+	if err := session.Start(s.command); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("ssh: %s: %w", s.command, err)
+	}
+
+	return &sessionConn{
+		Reader:  stdout,
+		Writer:  stdin,
+		stdin:   stdin,
+		session: session,
+	}, nil
+}
+
+type sessionConn struct {
+	io.Reader
+	io.Writer
+
+	stdin   io.Closer
+	session *ssh.Session
+}
+
+func (c *sessionConn) Close() error {
+	c.stdin.Close()
+	return c.session.Close()
+}
+
+func (c *sessionConn) LocalAddr() net.Addr                { return sessionAddr{} }
+func (c *sessionConn) RemoteAddr() net.Addr               { return sessionAddr{} }
+func (c *sessionConn) SetDeadline(t time.Time) error      { return nil }
+func (c *sessionConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *sessionConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type sessionAddr struct{}
+
+func (sessionAddr) Network() string { return "ssh" }
+func (sessionAddr) String() string  { return "ssh" }
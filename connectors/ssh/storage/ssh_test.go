@@ -0,0 +1,83 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/appcontext"
+	_ "github.com/PlakarKorp/plakar/connectors/fs/storage"
+	"github.com/PlakarKorp/plakar/server/httpd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSHBackend(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	defer ctx.Close()
+
+	jail := t.TempDir()
+	serializedConfig, err := storage.NewConfiguration().ToBytes()
+	require.NoError(t, err)
+	_, err = storage.Create(ctx.GetInner(), map[string]string{"location": "fs://" + filepath.Join(jail, "repo")}, serializedConfig)
+	require.NoError(t, err)
+
+	// a pipe to a local server stands in for the ssh session
+	open := func(pathname string, noDelete bool) storage.Store {
+		repo, err := NewStore(ctx, "ssh", map[string]string{
+			"location":    "ssh://backup.example.org" + pathname,
+			"connections": "1",
+			"retry":       "1",
+		})
+		require.NoError(t, err)
+		repo.(*Store).dial = func() (net.Conn, error) {
+			client, server := net.Pipe()
+			go httpd.ServeStdio(ctx.GetInner(), server, server, noDelete, jail)
+			return client, nil
+		}
+		return repo
+	}
+
+	repo := open(filepath.Join(jail, "repo"), true)
+	config, err := repo.Open(ctx)
+	require.NoError(t, err)
+	require.Equal(t, serializedConfig, config)
+
+	mac := objects.MAC{0x10, 0x20}
+	_, err = repo.PutPackfile(mac, bytes.NewReader([]byte("packfile")))
+	require.NoError(t, err)
+
+	packfiles, err := repo.GetPackfiles()
+	require.NoError(t, err)
+	require.Equal(t, []objects.MAC{mac}, packfiles)
+
+	rd, err := repo.GetPackfileBlob(mac, 4, 4)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "file", string(data))
+
+	// the server doesn't allow deletes unless told to
+	require.Error(t, repo.DeletePackfile(mac))
+	require.NoError(t, repo.Close())
+
+	repo = open(filepath.Join(jail, "repo"), false)
+	_, err = repo.Open(ctx)
+	require.NoError(t, err)
+	require.NoError(t, repo.DeletePackfile(mac))
+	require.NoError(t, repo.Close())
+
+	// nor repositories outside of its jail
+	outside := filepath.Join(t.TempDir(), "repo")
+	_, err = storage.Create(ctx.GetInner(), map[string]string{"location": "fs://" + outside}, serializedConfig)
+	require.NoError(t, err)
+	repo = open(filepath.Join(jail, "..", filepath.Base(filepath.Dir(outside)), "repo"), false)
+	_, err = repo.Open(ctx)
+	require.ErrorContains(t, err, "not within")
+	require.NoError(t, repo.Close())
+
+	require.Error(t, open(filepath.Join(jail, "repo"), false).Create(ctx, serializedConfig))
+}
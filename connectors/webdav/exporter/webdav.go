@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package webdav
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"path"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/exporter"
+	plakarwebdav "github.com/PlakarKorp/plakar/webdav"
+)
+
+type WebDAVExporter struct {
+	location string
+	client   *plakarwebdav.Client
+}
+
+func init() {
+	exporter.Register("webdav", 0, NewWebDAVExporter)
+}
+
+func NewWebDAVExporter(ctx context.Context, opt *exporter.Options, name string, config map[string]string) (exporter.Exporter, error) {
+	target := config["location"]
+
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := plakarwebdav.Connect(parsed, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebDAVExporter{
+		location: path.Clean("/" + parsed.Path),
+		client:   client,
+	}, nil
+}
+
+func (p *WebDAVExporter) Root() string {
+	return p.location
+}
+
+func (p *WebDAVExporter) CreateDirectory(pathname string) error {
+	return p.client.MkdirAll(pathname)
+}
+
+func (p *WebDAVExporter) StoreFile(pathname string, fp io.Reader, size int64) error {
+	return p.client.Put(pathname, fp, size)
+}
+
+// SetPermissions is a no-op, WebDAV has no notion of modes or owners.
+func (p *WebDAVExporter) SetPermissions(pathname string, fileinfo *objects.FileInfo) error {
+	return nil
+}
+
+func (p *WebDAVExporter) Close() error {
+	return nil
+}
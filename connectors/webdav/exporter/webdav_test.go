@@ -0,0 +1,40 @@
+package webdav
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/exporter"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExporter(t *testing.T) {
+	server := ptesting.NewMockWebDAVServer(t, "basic")
+
+	exp, err := NewWebDAVExporter(context.Background(), &exporter.Options{}, "webdav", map[string]string{
+		"location": "webdav://test:test@" + server.Addr + "/restore",
+		"use_tls":  "false",
+	})
+	require.NoError(t, err)
+	defer exp.Close()
+
+	require.Equal(t, "/restore", exp.Root())
+
+	require.NoError(t, exp.CreateDirectory("/restore/dir/subdir"))
+	require.NoError(t, exp.StoreFile("/restore/dir/subdir/file.txt", strings.NewReader("content"), 7))
+	require.NoError(t, exp.StoreFile("/restore/dir/empty", strings.NewReader(""), 0))
+	require.NoError(t, exp.SetPermissions("/restore/dir/subdir/file.txt", &objects.FileInfo{Lmode: 0644}))
+
+	data, err := os.ReadFile(filepath.Join(server.RootDir, "restore/dir/subdir/file.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "content", string(data))
+
+	fi, err := os.Stat(filepath.Join(server.RootDir, "restore/dir/empty"))
+	require.NoError(t, err)
+	require.Equal(t, int64(0), fi.Size())
+}
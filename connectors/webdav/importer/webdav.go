@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package webdav
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/importer"
+	plakarwebdav "github.com/PlakarKorp/plakar/webdav"
+)
+
+type WebDAVImporter struct {
+	rootDir    string
+	remoteHost string
+	client     *plakarwebdav.Client
+}
+
+func init() {
+	importer.Register("webdav", 0, NewWebDAVImporter)
+}
+
+func NewWebDAVImporter(appCtx context.Context, opts *importer.Options, name string, config map[string]string) (importer.Importer, error) {
+	target := config["location"]
+
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := plakarwebdav.Connect(parsed, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebDAVImporter{
+		rootDir:    path.Clean("/" + parsed.Path),
+		remoteHost: parsed.Host,
+		client:     client,
+	}, nil
+}
+
+func (p *WebDAVImporter) Origin() string {
+	return p.remoteHost
+}
+
+func (p *WebDAVImporter) Type() string {
+	return "webdav"
+}
+
+func (p *WebDAVImporter) Root() string {
+	return p.rootDir
+}
+
+func (p *WebDAVImporter) Close() error {
+	return nil
+}
+
+func (p *WebDAVImporter) Scan() (<-chan *importer.ScanResult, error) {
+	results := make(chan *importer.ScanResult, 1000)
+
+	go func() {
+		defer close(results)
+
+		// Add prefix directories first
+		atoms := strings.Split(p.rootDir, "/")
+		for i := 1; i < len(atoms)-1; i++ {
+			p.scanEntry(results, "/"+path.Join(atoms[1:i+1]...), nil)
+		}
+
+		info, err := p.client.Stat(p.rootDir)
+		if err != nil {
+			results <- importer.NewScanError(p.rootDir, err)
+			return
+		}
+		p.walk(results, p.rootDir, info)
+	}()
+
+	return results, nil
+}
+
+// scanEntry emits the record of pathname, info is looked up if nil.
+func (p *WebDAVImporter) scanEntry(results chan<- *importer.ScanResult, pathname string, info *plakarwebdav.FileInfo) {
+	if pathname == "/" {
+		return
+	}
+	if info == nil {
+		var err error
+		if info, err = p.client.Stat(pathname); err != nil {
+			results <- importer.NewScanError(pathname, err)
+			return
+		}
+	}
+
+	results <- importer.NewScanRecord(pathname, "", objects.FileInfoFromStat(info), []string{},
+		func() (io.ReadCloser, error) { return p.client.Open(pathname) })
+}
+
+func (p *WebDAVImporter) walk(results chan<- *importer.ScanResult, pathname string, info *plakarwebdav.FileInfo) {
+	p.scanEntry(results, pathname, info)
+	if !info.IsDir() {
+		return
+	}
+
+	entries, err := p.client.ReadDir(pathname)
+	if err != nil {
+		results <- importer.NewScanError(pathname, err)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	for _, entry := range entries {
+		p.walk(results, path.Join(pathname, entry.Name()), entry)
+	}
+}
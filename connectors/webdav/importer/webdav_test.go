@@ -0,0 +1,55 @@
+package webdav
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/snapshot/importer"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImporter(t *testing.T) {
+	server := ptesting.NewMockWebDAVServer(t, "digest")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(server.RootDir, "share/docs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(server.RootDir, "share/docs/a.txt"), []byte("alpha"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(server.RootDir, "share/b.txt"), []byte("beta"), 0644))
+
+	imp, err := NewWebDAVImporter(context.Background(), &importer.Options{}, "webdav", map[string]string{
+		"location": "webdav://test:test@" + server.Addr + "/share",
+		"use_tls":  "false",
+	})
+	require.NoError(t, err)
+	defer imp.Close()
+
+	require.Equal(t, "/share", imp.Root())
+	require.Equal(t, server.Addr, imp.Origin())
+	require.Equal(t, "webdav", imp.Type())
+
+	results, err := imp.Scan()
+	require.NoError(t, err)
+
+	var paths []string
+	contents := make(map[string]string)
+	for result := range results {
+		require.Nil(t, result.Error)
+		record := result.Record
+		paths = append(paths, record.Pathname)
+		if record.FileInfo.Mode().IsRegular() {
+			data, err := io.ReadAll(record.Reader)
+			require.NoError(t, err)
+			require.NoError(t, record.Close())
+			contents[record.Pathname] = string(data)
+		}
+	}
+
+	require.Equal(t, []string{"/share", "/share/b.txt", "/share/docs", "/share/docs/a.txt"}, paths)
+	require.Equal(t, map[string]string{
+		"/share/b.txt":      "beta",
+		"/share/docs/a.txt": "alpha",
+	}, contents)
+}
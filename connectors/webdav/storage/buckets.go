@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package webdav
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/objects"
+	plakarwebdav "github.com/PlakarKorp/plakar/webdav"
+	"golang.org/x/sync/errgroup"
+)
+
+// concurrency bounds the requests sent at once when creating or listing
+// the buckets, most appliances don't cope well with 256 of them.
+const concurrency = 16
+
+type Buckets struct {
+	client *plakarwebdav.Client
+	path   string
+}
+
+func NewBuckets(client *plakarwebdav.Client, path string) Buckets {
+	return Buckets{
+		client: client,
+		path:   path,
+	}
+}
+
+func (buckets *Buckets) Create() error {
+	if err := buckets.client.Mkdir(buckets.path); err != nil {
+		return err
+	}
+
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for i := 0; i < 256; i++ {
+		dir := path.Join(buckets.path, fmt.Sprintf("%02x", i))
+		g.Go(func() error {
+			return buckets.client.Mkdir(dir)
+		})
+	}
+	return g.Wait()
+}
+
+func (buckets *Buckets) List() ([]objects.MAC, error) {
+	ret := make([]objects.MAC, 0)
+	var mu sync.Mutex
+
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for i := 0; i < 256; i++ {
+		dir := path.Join(buckets.path, fmt.Sprintf("%02x", i))
+		g.Go(func() error {
+			entries, err := buckets.client.ReadDir(dir)
+			if err != nil {
+				if plakarwebdav.IsTransient(err) {
+					return err
+				}
+				return nil
+			}
+			for _, entry := range entries {
+				if entry.IsDir() {
+					continue
+				}
+				t, err := hex.DecodeString(entry.Name())
+				if err != nil {
+					continue
+				}
+				if len(t) != 32 {
+					continue
+				}
+				var t32 objects.MAC
+				copy(t32[:], t)
+
+				mu.Lock()
+				ret = append(ret, t32)
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (buckets *Buckets) Path(mac objects.MAC) string {
+	return path.Join(buckets.path,
+		fmt.Sprintf("%02x", mac[0]),
+		fmt.Sprintf("%064x", mac))
+}
+
+func (buckets *Buckets) Get(mac objects.MAC) (io.Reader, error) {
+	return ClosingReader(buckets.client.Open(buckets.Path(mac)))
+}
+
+func (buckets *Buckets) GetBlob(mac objects.MAC, offset uint64, length uint32) (io.Reader, error) {
+	return ClosingReader(buckets.client.OpenRange(buckets.Path(mac), int64(offset), int64(length)))
+}
+
+func (buckets *Buckets) Remove(mac objects.MAC) error {
+	return buckets.client.Remove(buckets.Path(mac))
+}
+
+func (buckets *Buckets) Put(mac objects.MAC, rd io.Reader) (int64, error) {
+	return WriteToFileAtomicTempDir(buckets.client, buckets.Path(mac), rd, buckets.path)
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package webdav
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+
+	plakarwebdav "github.com/PlakarKorp/plakar/webdav"
+)
+
+type ClosingFileReader struct {
+	reader     io.ReadCloser
+	fileClosed bool
+}
+
+func (cr *ClosingFileReader) Read(p []byte) (int, error) {
+	if cr.fileClosed {
+		return 0, io.EOF
+	}
+
+	n, err := cr.reader.Read(p)
+	if err == io.EOF {
+		// Close the response when EOF is reached
+		closeErr := cr.reader.Close()
+		cr.fileClosed = true
+		if closeErr != nil {
+			return n, fmt.Errorf("error closing file: %w", closeErr)
+		}
+	}
+	return n, err
+}
+
+// ClosingReader takes the results of Client.Open or Client.OpenRange.
+func ClosingReader(rd io.ReadCloser, err error) (io.Reader, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &ClosingFileReader{
+		reader: rd,
+	}, nil
+}
+
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func WriteToFileAtomic(client *plakarwebdav.Client, filename string, rd io.Reader) (int64, error) {
+	return WriteToFileAtomicTempDir(client, filename, rd, path.Dir(filename))
+}
+
+// WriteToFileAtomicTempDir uploads rd to a temporary file of tmpdir, then
+// moves it over filename so that readers never see a partial file.
+func WriteToFileAtomicTempDir(client *plakarwebdav.Client, filename string, rd io.Reader, tmpdir string) (int64, error) {
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return 0, err
+	}
+	tmp := path.Join(tmpdir, "tmp."+hex.EncodeToString(suffix[:]))
+
+	size := int64(-1)
+	if sized, ok := rd.(interface{ Len() int }); ok {
+		size = int64(sized.Len())
+	}
+
+	counter := &countingReader{Reader: rd}
+	if err := client.Put(tmp, counter, size); err != nil {
+		client.Remove(tmp)
+		return 0, err
+	}
+
+	if err := client.Rename(tmp, filename); err != nil {
+		client.Remove(tmp)
+		return 0, fmt.Errorf("rename %s: %w", tmp, err)
+	}
+
+	return counter.n, nil
+}
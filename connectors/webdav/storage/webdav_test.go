@@ -0,0 +1,109 @@
+package webdav
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebDAVBackend(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	defer ctx.Close()
+
+	server := ptesting.NewMockWebDAVServer(t, "digest")
+
+	config := map[string]string{
+		"location": "webdav://" + server.Addr + "/nas/repo",
+		"username": server.Username,
+		"password": server.Password,
+		"use_tls":  "false",
+	}
+	repo, err := NewStore(ctx, "webdav", config)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	require.Equal(t, config["location"], repo.Location())
+	require.Equal(t, int64(-1), repo.Size())
+
+	serializedConfig, err := storage.NewConfiguration().ToBytes()
+	require.NoError(t, err)
+	require.NoError(t, repo.Create(ctx, serializedConfig))
+
+	// the layout is the one of the fs store
+	for _, dir := range []string{"packfiles/00", "packfiles/ff", "states/80", "locks"} {
+		fi, err := os.Stat(filepath.Join(server.RootDir, "nas/repo", dir))
+		require.NoError(t, err)
+		require.True(t, fi.IsDir())
+	}
+
+	err = repo.Create(ctx, serializedConfig)
+	require.ErrorContains(t, err, "is not empty")
+
+	read, err := repo.Open(ctx)
+	require.NoError(t, err)
+	require.Equal(t, serializedConfig, read)
+
+	data := bytes.Repeat([]byte("packfile"), 1024)
+	mac := objects.MAC{0x10, 0x20}
+	n, err := repo.PutPackfile(mac, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+
+	packfiles, err := repo.GetPackfiles()
+	require.NoError(t, err)
+	require.Equal(t, []objects.MAC{mac}, packfiles)
+
+	rd, err := repo.GetPackfile(mac)
+	require.NoError(t, err)
+	read, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+
+	rd, err = repo.GetPackfileBlob(mac, 8, 8)
+	require.NoError(t, err)
+	read, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "packfile", string(read))
+
+	require.NoError(t, repo.DeletePackfile(mac))
+	packfiles, err = repo.GetPackfiles()
+	require.NoError(t, err)
+	require.Empty(t, packfiles)
+
+	state := objects.MAC{0x30}
+	_, err = repo.PutState(state, bytes.NewReader([]byte("state")))
+	require.NoError(t, err)
+	states, err := repo.GetStates()
+	require.NoError(t, err)
+	require.Equal(t, []objects.MAC{state}, states)
+
+	lock := objects.MAC{0x40}
+	_, err = repo.PutLock(lock, bytes.NewReader([]byte("lock")))
+	require.NoError(t, err)
+	locks, err := repo.GetLocks()
+	require.NoError(t, err)
+	require.Equal(t, []objects.MAC{lock}, locks)
+	rd, err = repo.GetLock(lock)
+	require.NoError(t, err)
+	read, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "lock", string(read))
+	require.NoError(t, repo.DeleteLock(lock))
+
+	// no temporary file is left behind
+	entries, err := os.ReadDir(filepath.Join(server.RootDir, "nas/repo"))
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.ElementsMatch(t, []string{"CONFIG", "locks", "packfiles", "states"}, names)
+}
@@ -0,0 +1,285 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package derive builds a snapshot from a subset of the entries of an
+// existing one.
+//
+// The entries kept are fed to a regular backup through an importer
+// reading the source snapshot, so that the summaries and indexes of the
+// derived snapshot are computed as for any other.  The content is read
+// back from the source repository, and chunks already present in the
+// destination are not stored again.
+package derive
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/kloset/snapshot/importer"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+)
+
+type Options struct {
+	Filter *Filter
+
+	// KeepIdentifier gives the derived snapshot the identifier of
+	// its source, as sync does for the snapshots it copies.
+	KeepIdentifier bool
+
+	// Context is recorded in the header of the derived snapshot, in
+	// place of the values the source may hold for the same keys.
+	Context []header.KeyValue
+
+	MaxConcurrency uint64
+}
+
+// Snapshot builds in dst a snapshot holding the entries of src kept by
+// the filter and returns its identifier.  The header of src is kept,
+// apart from what describes the content and the identity of the
+// signer, and records the snapshot it derives from and the filter
+// applied.
+func Snapshot(src *snapshot.Snapshot, dst *repository.Repository, opts *Options) (objects.MAC, error) {
+	filter := opts.Filter
+	if filter == nil {
+		filter = &Filter{}
+	}
+	if err := filter.Compile(); err != nil {
+		return objects.MAC{}, err
+	}
+
+	srcfs, err := src.Filesystem()
+	if err != nil {
+		return objects.MAC{}, err
+	}
+
+	// Backup records how long it took in the header it writes: the
+	// snapshot is built under a scratch identifier and left
+	// uncommitted, then committed under its own identifier with the
+	// duration of src, the scratch header being deleted in the same
+	// transaction.
+	scratchID := objects.RandomMAC()
+	scanCache, err := dst.AppContext().GetCache().Scan(scratchID)
+	if err != nil {
+		return objects.MAC{}, err
+	}
+	defer scanCache.Close()
+
+	writer := dst.NewRepositoryWriter(scanCache, scratchID, repository.DefaultType)
+	builder, err := snapshot.CreateWithRepositoryWriter(writer)
+	if err != nil {
+		return objects.MAC{}, err
+	}
+	defer builder.Close()
+
+	hdr := *src.Header
+	hdr.Identity = builder.Header.Identity
+	if !opts.KeepIdentifier {
+		hdr.Identifier = builder.Header.Identifier
+	}
+	snapshotID := hdr.Identifier
+	hdr.Identifier = scratchID
+	hdr.Classifications = slices.Clone(src.Header.Classifications)
+	hdr.Tags = slices.Clone(src.Header.Tags)
+	hdr.Context = slices.Clone(src.Header.Context)
+
+	source := header.NewSource()
+	source.Importer = src.Header.GetSource(0).Importer
+	source.Context = slices.Clone(src.Header.GetSource(0).Context)
+	hdr.Sources = []header.Source{source}
+
+	setContext(&hdr, "DerivedFrom", hex.EncodeToString(src.Header.Identifier[:]))
+	if !filter.Empty() {
+		setContext(&hdr, "DerivedFilter", filter.String())
+	}
+	for _, kv := range opts.Context {
+		setContext(&hdr, kv.Key, kv.Value)
+	}
+	builder.Header = &hdr
+
+	imp := &snapshotImporter{
+		hdr:    src.Header,
+		fs:     srcfs,
+		filter: filter,
+	}
+
+	err = builder.Backup(imp, &snapshot.BackupOptions{
+		MaxConcurrency: opts.MaxConcurrency,
+		Name:           src.Header.Name,
+		NoCheckpoint:   true,
+		NoCommit:       true,
+	})
+	if err != nil {
+		return objects.MAC{}, err
+	}
+
+	if err := writer.DeleteStateResource(resources.RT_SNAPSHOT, scratchID); err != nil {
+		return objects.MAC{}, err
+	}
+	if dst.AppContext().Keypair != nil {
+		if err := writer.DeleteStateResource(resources.RT_SIGNATURE, scratchID); err != nil {
+			return objects.MAC{}, err
+		}
+	}
+
+	hdr.Identifier = snapshotID
+	hdr.Duration = src.Header.Duration
+	if err := builder.Commit(nil, true); err != nil {
+		return objects.MAC{}, err
+	}
+	return snapshotID, nil
+}
+
+// setContext is like header.SetContext but replaces the value of a key
+// already present, which is the case when deriving a derived snapshot.
+func setContext(hdr *header.Header, key, value string) {
+	for i := range hdr.Context {
+		if hdr.Context[i].Key == key {
+			hdr.Context[i].Value = value
+			return
+		}
+	}
+	hdr.SetContext(key, value)
+}
+
+// snapshotImporter scans the entries of a snapshot kept by a filter,
+// along with the directories leading to them.
+type snapshotImporter struct {
+	hdr    *header.Header
+	fs     *vfs.Filesystem
+	filter *Filter
+}
+
+func (imp *snapshotImporter) Origin() string {
+	return imp.hdr.GetSource(0).Importer.Origin
+}
+
+func (imp *snapshotImporter) Type() string {
+	return imp.hdr.GetSource(0).Importer.Type
+}
+
+func (imp *snapshotImporter) Root() string {
+	return imp.hdr.GetSource(0).Importer.Directory
+}
+
+func (imp *snapshotImporter) Close() error {
+	return nil
+}
+
+func (imp *snapshotImporter) Scan() (<-chan *importer.ScanResult, error) {
+	results := make(chan *importer.ScanResult, 1000)
+	go func() {
+		defer close(results)
+		imp.scan(results)
+	}()
+	return results, nil
+}
+
+func (imp *snapshotImporter) scan(results chan<- *importer.ScanResult) {
+	// directories are only sent once an entry below them is kept
+	dirs := make(map[string]*vfs.Entry)
+	sent := make(map[string]bool)
+
+	var sendParents func(pathname string)
+	sendParents = func(pathname string) {
+		if pathname == "/" {
+			return
+		}
+		parent := path.Dir(pathname)
+		if sent[parent] {
+			return
+		}
+		sendParents(parent)
+		if entry, ok := dirs[parent]; ok {
+			imp.send(results, parent, entry)
+			sent[parent] = true
+		}
+	}
+
+	err := imp.fs.WalkDir("/", func(pathname string, entry *vfs.Entry, err error) error {
+		if err != nil {
+			results <- importer.NewScanError(pathname, err)
+			return nil
+		}
+
+		if imp.filter.Excluded(pathname) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if entry.IsDir() {
+			dirs[pathname] = entry
+		}
+
+		if pathname != "/" && !imp.filter.Keep(pathname) {
+			return nil
+		}
+
+		sendParents(pathname)
+		if !sent[pathname] {
+			imp.send(results, pathname, entry)
+			sent[pathname] = true
+		}
+		return nil
+	})
+	if err != nil {
+		results <- importer.NewScanError("/", err)
+	}
+
+	errs, err := imp.fs.Errors("/")
+	if err != nil {
+		return
+	}
+	for item, err := range errs {
+		if err != nil {
+			results <- importer.NewScanError("/", err)
+			break
+		}
+		if imp.filter.Keep(item.Name) {
+			results <- importer.NewScanError(item.Name, errors.New(item.Error))
+		}
+	}
+}
+
+func (imp *snapshotImporter) send(results chan<- *importer.ScanResult, pathname string, entry *vfs.Entry) {
+	result := importer.NewScanRecord(pathname, entry.SymlinkTarget, entry.FileInfo, slices.Clone(entry.ExtendedAttributes),
+		func() (io.ReadCloser, error) {
+			return entry.Open(imp.fs), nil
+		})
+	result.Record.FileAttributes = entry.FileAttributes
+	results <- result
+
+	for _, name := range entry.ExtendedAttributes {
+		results <- importer.NewScanXattr(pathname, name, objects.AttributeExtended,
+			func() (io.ReadCloser, error) {
+				rd, err := entry.Xattr(imp.fs, name)
+				if err != nil {
+					return nil, err
+				}
+				return io.NopCloser(rd), nil
+			})
+	}
+}
@@ -0,0 +1,91 @@
+package derive
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	filter, err := NewFilter([]string{"/etc", "/var/lib/app"}, []string{"/etc/ssl", "*.tmp"})
+	require.NoError(t, err)
+
+	require.True(t, filter.Keep("/etc"))
+	require.True(t, filter.Keep("/etc/passwd"))
+	require.True(t, filter.Keep("/var/lib/app/data"))
+	require.False(t, filter.Keep("/var/lib"))
+	require.False(t, filter.Keep("/etc/ssl/cert.pem"))
+	require.False(t, filter.Keep("/etc/foo.tmp"))
+	require.False(t, filter.Keep("/home"))
+	require.False(t, filter.Excluded("/"))
+
+	filter, err = NewFilter(nil, []string{"/var/cache"})
+	require.NoError(t, err)
+	require.True(t, filter.Keep("/etc/passwd"))
+	require.False(t, filter.Keep("/var/cache/big"))
+
+	_, err = NewFilter([]string{"[a"}, nil)
+	require.Error(t, err)
+}
+
+func TestSnapshot(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	src := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockDir("another_subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("subdir/foo.txt", 0644, "hello foo"),
+		ptesting.NewMockFile("another_subdir/bar.txt", 0644, "hello bar"),
+	})
+	defer src.Close()
+
+	filter, err := NewFilter([]string{"*/subdir"}, []string{"*/foo.txt"})
+	require.NoError(t, err)
+
+	snapshotID, err := Snapshot(src, repo, &Options{Filter: filter})
+	require.NoError(t, err)
+	require.NotEqual(t, src.Header.Identifier, snapshotID)
+	require.NoError(t, repo.RebuildState())
+
+	// only the source and the derived snapshot are listed
+	snapshotIDs, err := repo.GetSnapshots()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []objects.MAC{src.Header.Identifier, snapshotID}, snapshotIDs)
+
+	derived, err := snapshot.Load(repo, snapshotID)
+	require.NoError(t, err)
+	defer derived.Close()
+
+	require.Equal(t, src.Header.Name, derived.Header.Name)
+	require.Equal(t, src.Header.Timestamp.Unix(), derived.Header.Timestamp.Unix())
+	require.Equal(t, src.Header.Duration, derived.Header.Duration)
+	require.Contains(t, derived.Header.Context, header.KeyValue{Key: "DerivedFrom", Value: hex.EncodeToString(src.Header.Identifier[:])})
+
+	fs, err := derived.Filesystem()
+	require.NoError(t, err)
+
+	var pathnames []string
+	for pathname, err := range fs.Pathnames() {
+		require.NoError(t, err)
+		pathnames = append(pathnames, pathname)
+	}
+	require.Contains(t, pathnames, "/subdir/dummy.txt")
+	require.NotContains(t, pathnames, "/subdir/foo.txt")
+	require.NotContains(t, pathnames, "/another_subdir/bar.txt")
+
+	rd, err := fs.Open("/subdir/dummy.txt")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "hello dummy", string(data))
+}
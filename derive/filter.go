@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package derive
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// Filter selects the entries of a snapshot kept in a derived snapshot.
+// A pattern matching a directory applies to everything below it.  With
+// no include pattern every entry is kept unless excluded, and excludes
+// take precedence over includes.
+type Filter struct {
+	Includes []string
+	Excludes []string
+
+	includes []glob.Glob
+	excludes []glob.Glob
+}
+
+// NewFilter returns a filter keeping the entries matching includes and
+// not matching excludes.
+func NewFilter(includes, excludes []string) (*Filter, error) {
+	f := &Filter{Includes: includes, Excludes: excludes}
+	if err := f.Compile(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type patternFlag []string
+
+func (p *patternFlag) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternFlag) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+func (f *Filter) InstallFlags(flags *flag.FlagSet) {
+	flags.Var((*patternFlag)(&f.Includes), "include", "glob pattern of the paths to keep, can be specified multiple times")
+	flags.Var((*patternFlag)(&f.Excludes), "exclude", "glob pattern of the paths to drop, can be specified multiple times")
+}
+
+// Empty reports whether the filter keeps every entry.
+func (f *Filter) Empty() bool {
+	return len(f.Includes) == 0 && len(f.Excludes) == 0
+}
+
+func (f *Filter) String() string {
+	var parts []string
+	for _, pattern := range f.Includes {
+		parts = append(parts, "+"+pattern)
+	}
+	for _, pattern := range f.Excludes {
+		parts = append(parts, "-"+pattern)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Compile checks the patterns and prepares them for matching.  It is
+// needed when the patterns were set by InstallFlags or decoded.
+func (f *Filter) Compile() error {
+	if len(f.includes) == len(f.Includes) && len(f.excludes) == len(f.Excludes) {
+		return nil
+	}
+
+	f.includes = f.includes[:0]
+	for _, pattern := range f.Includes {
+		g, err := glob.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("failed to compile include pattern: %s", pattern)
+		}
+		f.includes = append(f.includes, g)
+	}
+
+	f.excludes = f.excludes[:0]
+	for _, pattern := range f.Excludes {
+		g, err := glob.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("failed to compile exclude pattern: %s", pattern)
+		}
+		f.excludes = append(f.excludes, g)
+	}
+	return nil
+}
+
+// match reports whether one of the patterns matches pathname or one of
+// its parent directories.
+func match(patterns []glob.Glob, pathname string) bool {
+	for {
+		for _, pattern := range patterns {
+			if pattern.Match(pathname) {
+				return true
+			}
+		}
+		if pathname == "/" || pathname == "." {
+			return false
+		}
+		pathname = path.Dir(pathname)
+	}
+}
+
+// Excluded reports whether pathname, and everything below it, is dropped.
+func (f *Filter) Excluded(pathname string) bool {
+	return pathname != "/" && match(f.excludes, pathname)
+}
+
+// Keep reports whether the entry at pathname is kept.  Directories
+// that are not kept are still part of a derived snapshot when one of
+// the entries below them is.
+func (f *Filter) Keep(pathname string) bool {
+	if f.Excluded(pathname) {
+		return false
+	}
+	return len(f.includes) == 0 || match(f.includes, pathname)
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package exporter holds the extensions to the kloset exporter interface
+// that some of the plakar connectors implement.
+package exporter
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/snapshot"
+)
+
+// XattrLookup returns the extended attributes of the file restored at
+// pathname, nil if it has none.
+type XattrLookup func(pathname string) (map[string][]byte, error)
+
+// XattrExporter is implemented by the exporters that can restore the
+// extended attributes of a file.  They look them up as each file is
+// stored, so that exporters writing a file at once can apply them.
+type XattrExporter interface {
+	SetXattrLookup(lookup XattrLookup)
+}
+
+// ExtendedAttributes returns the lookup of the extended attributes of
+// the files of a snapshot, by the name Restore gives to them with the
+// same base and strip.
+func ExtendedAttributes(snap *snapshot.Snapshot, base, strip string) (XattrLookup, error) {
+	vfs, err := snap.Filesystem()
+	if err != nil {
+		return nil, err
+	}
+
+	base = path.Clean(base)
+	return func(pathname string) (map[string][]byte, error) {
+		rel := pathname
+		if base != "/" {
+			rel = strings.TrimPrefix(pathname, base)
+		}
+
+		e, err := vfs.GetEntry(path.Clean(strip + rel))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if !e.Stat().Mode().IsRegular() || len(e.ExtendedAttributes) == 0 {
+			return nil, nil
+		}
+
+		xattrs := make(map[string][]byte, len(e.ExtendedAttributes))
+		for _, name := range e.ExtendedAttributes {
+			rd, err := e.Xattr(vfs, name)
+			if err != nil {
+				return nil, err
+			}
+			value, err := io.ReadAll(rd)
+			if err != nil {
+				return nil, err
+			}
+			xattrs[name] = value
+		}
+		return xattrs, nil
+	}, nil
+}
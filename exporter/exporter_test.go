@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/importer"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtendedAttributes(t *testing.T) {
+	repo, _ := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	content := func(s string) func() (io.ReadCloser, error) {
+		return func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader([]byte(s))), nil
+		}
+	}
+	record := func(pathname, name string, mode os.FileMode, xattrs []string) *importer.ScanResult {
+		return importer.NewScanRecord(pathname, "", objects.FileInfo{
+			Lname:  name,
+			Lsize:  4,
+			Lmode:  mode,
+			Lnlink: 1,
+		}, xattrs, content("data"))
+	}
+
+	snap := ptesting.GenerateSnapshot(t, repo, nil, ptesting.WithGenerator(func(ch chan<- *importer.ScanResult) {
+		ch <- record("/", "/", os.ModeDir|0755, nil)
+		ch <- record("/home", "home", os.ModeDir|0755, nil)
+		ch <- record("/home/file", "file", 0644, []string{"content-type"})
+		ch <- importer.NewScanXattr("/home/file", "content-type", objects.AttributeExtended, content("text/plain"))
+		ch <- record("/home/plain", "plain", 0644, nil)
+		close(ch)
+	}))
+	defer snap.Close()
+
+	for _, test := range []struct {
+		base, strip, pathname string
+	}{
+		{"/bucket", "/home", "/bucket/file"},
+		{"/bucket", "/home/", "/bucket/file"},
+		{"/bucket/", "", "/bucket/home/file"},
+		{"/", "/home", "/file"},
+	} {
+		lookup, err := ExtendedAttributes(snap, test.base, test.strip)
+		require.NoError(t, err)
+
+		xattrs, err := lookup(test.pathname)
+		require.NoError(t, err)
+		require.Equal(t, map[string][]byte{"content-type": []byte("text/plain")}, xattrs, test)
+	}
+
+	lookup, err := ExtendedAttributes(snap, "/bucket", "/home")
+	require.NoError(t, err)
+	for _, pathname := range []string{"/bucket/plain", "/bucket/missing", "/bucket"} {
+		xattrs, err := lookup(pathname)
+		require.NoError(t, err)
+		require.Nil(t, xattrs, pathname)
+	}
+}
@@ -0,0 +1,265 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package ftp builds the FTP clients shared by the storage, importer and
+// exporter connectors from their configuration:
+//
+//	username	defaults to the user of the location, or anonymous
+//	password	defaults to the password of the location
+//	password_cmd	command printing the password, when it shouldn't
+//			be stored in the configuration
+//	tls		none (the default), explicit to upgrade the
+//			connection with AUTH TLS, or implicit to connect
+//			over TLS, usually to port 990
+//	ca_bundle	PEM file of additional certificate authorities
+//	tls_insecure_skip_verify	don't verify the server certificate
+//	mode		passive (the default) or active, in which case the
+//			server connects back to active_addr
+//	connections	number of connections to the server, 5 by default
+//	timeout		defaults to 10s
+package ftp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/secsy/goftp"
+
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+)
+
+func Connect(location *url.URL, params map[string]string) (*goftp.Client, error) {
+	config := goftp.Config{
+		User:               location.User.Username(),
+		ConnectionsPerHost: 5,
+		Timeout:            10 * time.Second,
+	}
+	config.Password, _ = location.User.Password()
+
+	if value, ok := params["username"]; ok {
+		config.User = value
+	}
+	if value, ok := params["password"]; ok {
+		config.Password = value
+	} else if cmd, ok := params["password_cmd"]; ok {
+		password, err := utils.GetPassphraseFromCommand(cmd)
+		if err != nil {
+			return nil, fmt.Errorf("password_cmd: %w", err)
+		}
+		config.Password = string(password)
+	}
+
+	if value, ok := params["connections"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid connections value")
+		}
+		config.ConnectionsPerHost = n
+	}
+
+	if value, ok := params["timeout"]; ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout value")
+		}
+		config.Timeout = timeout
+	}
+
+	switch params["mode"] {
+	case "", "passive":
+	case "active":
+		config.ActiveTransfers = true
+		config.ActiveListenAddr = params["active_addr"]
+	default:
+		return nil, fmt.Errorf("invalid mode value")
+	}
+
+	switch params["tls"] {
+	case "", "none":
+	case "explicit", "implicit":
+		if config.ActiveTransfers {
+			// the data connections would need a certificate of
+			// our own to be accepted over TLS.
+			return nil, fmt.Errorf("active mode is not supported over tls")
+		}
+		tlsConfig, err := tlsConfig(location, params)
+		if err != nil {
+			return nil, err
+		}
+		config.TLSConfig = tlsConfig
+		if params["tls"] == "implicit" {
+			config.TLSMode = goftp.TLSImplicit
+		} else {
+			config.TLSMode = goftp.TLSExplicit
+		}
+	default:
+		return nil, fmt.Errorf("invalid tls value")
+	}
+
+	return goftp.DialConfig(config, location.Host)
+}
+
+func tlsConfig(location *url.URL, params map[string]string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: location.Hostname(),
+		// servers commonly require the data connections to resume
+		// the session of the control connection.
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+
+	if value, ok := params["tls_insecure_skip_verify"]; ok {
+		skip, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls_insecure_skip_verify value")
+		}
+		config.InsecureSkipVerify = skip
+	}
+
+	if bundle := params["ca_bundle"]; bundle != "" {
+		pem, err := os.ReadFile(bundle)
+		if err != nil {
+			return nil, fmt.Errorf("read ca_bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in ca_bundle %s", bundle)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
+// OpenRange reads length bytes of pathname at offset.  It is done over
+// a connection of its own, which is closed with the reader, as stopping
+// a transfer midway leaves the connection unusable.
+func OpenRange(client *goftp.Client, pathname string, offset, length int64) (io.ReadCloser, error) {
+	raw, err := client.OpenRawConn()
+	if err != nil {
+		return nil, err
+	}
+
+	rd, err := openRange(raw, pathname, offset, length)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return rd, nil
+}
+
+func openRange(raw goftp.RawConn, pathname string, offset, length int64) (io.ReadCloser, error) {
+	if err := expect(raw, "200", "TYPE I"); err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if err := expect(raw, "350", "REST %d", offset); err != nil {
+			return nil, err
+		}
+	}
+
+	getter, err := raw.PrepareDataConn()
+	if err != nil {
+		return nil, err
+	}
+	if err := expect(raw, "1", "RETR %s", pathname); err != nil {
+		return nil, NotExist(pathname, err)
+	}
+	dc, err := getter()
+	if err != nil {
+		return nil, err
+	}
+
+	return &rangeReader{
+		Reader:  io.LimitReader(dc, length),
+		closers: []io.Closer{dc, raw},
+	}, nil
+}
+
+type rangeReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *rangeReader) Close() error {
+	var errs []error
+	for _, closer := range r.closers {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// expect sends a command and checks that the reply code starts with
+// prefix.
+func expect(raw goftp.RawConn, prefix string, format string, args ...any) error {
+	code, msg, err := raw.SendCommand(format, args...)
+	if err != nil {
+		return err
+	}
+	if reply := strconv.Itoa(code); reply[:len(prefix)] != prefix {
+		return &replyError{code: code, msg: msg}
+	}
+	return nil
+}
+
+type replyError struct {
+	code int
+	msg  string
+}
+
+func (e *replyError) Error() string {
+	return fmt.Sprintf("unexpected response: %d-%s", e.code, e.msg)
+}
+
+func (e *replyError) Temporary() bool {
+	return e.code >= 400 && e.code < 500
+}
+
+func (e *replyError) Code() int       { return e.code }
+func (e *replyError) Message() string { return e.msg }
+
+// NotExist turns the "file unavailable" reply of the server into an
+// fs.ErrNotExist, the server can't tell us more.
+func NotExist(pathname string, err error) error {
+	var ftpErr goftp.Error
+	if errors.As(err, &ftpErr) && ftpErr.Code() == 550 {
+		return &fs.PathError{Op: "ftp", Path: pathname, Err: fs.ErrNotExist}
+	}
+	return err
+}
+
+// IsTransient tells the errors worth retrying: transient negative
+// replies of the server and timeouts, on top of network errors.
+func IsTransient(err error) bool {
+	var ftpErr goftp.Error
+	if errors.As(err, &ftpErr) && ftpErr.Temporary() {
+		return true
+	}
+	return plakarstorage.IsTransient(err)
+}
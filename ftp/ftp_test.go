@@ -0,0 +1,113 @@
+package ftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnect(t *testing.T) {
+	explicit, err := ptesting.NewMockFTPSServer(false)
+	require.NoError(t, err)
+	defer explicit.Close()
+
+	implicit, err := ptesting.NewMockFTPSServer(true)
+	require.NoError(t, err)
+	defer implicit.Close()
+
+	plain, err := ptesting.NewMockFTPServer()
+	require.NoError(t, err)
+	defer plain.Close()
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caBundle, explicit.CertPEM, 0600))
+	implicitBundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(implicitBundle, implicit.CertPEM, 0600))
+
+	tests := []struct {
+		name   string
+		server *ptesting.MockFTPServer
+		params map[string]string
+	}{
+		{"explicit", explicit, map[string]string{"tls": "explicit", "ca_bundle": caBundle}},
+		{"implicit", implicit, map[string]string{"tls": "implicit", "ca_bundle": implicitBundle}},
+		{"insecure", explicit, map[string]string{"tls": "explicit", "tls_insecure_skip_verify": "true"}},
+		{"active", plain, map[string]string{"mode": "active", "active_addr": "127.0.0.1:0"}},
+		{"password_cmd", plain, map[string]string{"password_cmd": "echo test"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			location, err := url.Parse("ftp://" + test.server.Addr + "/")
+			require.NoError(t, err)
+
+			params := map[string]string{"username": "test"}
+			if test.name != "password_cmd" {
+				params["password"] = "test"
+			}
+			for key, value := range test.params {
+				params[key] = value
+			}
+
+			client, err := Connect(location, params)
+			require.NoError(t, err)
+			defer client.Close()
+
+			pathname := "/" + test.name
+			require.NoError(t, client.Store(pathname, bytes.NewReader([]byte("0123456789"))))
+
+			var buf bytes.Buffer
+			require.NoError(t, client.Retrieve(pathname, &buf))
+			require.Equal(t, "0123456789", buf.String())
+
+			rd, err := OpenRange(client, pathname, 3, 4)
+			require.NoError(t, err)
+			data, err := io.ReadAll(rd)
+			require.NoError(t, err)
+			rd.Close()
+			require.Equal(t, "3456", string(data))
+
+			_, err = OpenRange(client, "/missing", 0, 1)
+			require.True(t, errors.Is(err, fs.ErrNotExist), "%v", err)
+		})
+	}
+
+	// the certificate of the server isn't trusted
+	location, err := url.Parse("ftp://test:test@" + explicit.Addr + "/")
+	require.NoError(t, err)
+	client, err := Connect(location, map[string]string{"tls": "explicit"})
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.ReadDir("/")
+	require.ErrorContains(t, err, "certificate")
+}
+
+func TestConnectOptions(t *testing.T) {
+	location, err := url.Parse("ftp://localhost/")
+	require.NoError(t, err)
+
+	for key, value := range map[string]string{
+		"tls":                      "maybe",
+		"mode":                     "sideways",
+		"connections":              "0",
+		"timeout":                  "soon",
+		"tls_insecure_skip_verify": "maybe",
+	} {
+		params := map[string]string{key: value}
+		if key == "tls_insecure_skip_verify" {
+			params["tls"] = "explicit"
+		}
+		_, err := Connect(location, params)
+		require.EqualError(t, err, "invalid "+key+" value")
+	}
+
+	_, err = Connect(location, map[string]string{"tls": "explicit", "mode": "active"})
+	require.EqualError(t, err, "active mode is not supported over tls")
+}
@@ -0,0 +1,503 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package identity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/encryption/keypair"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+const IDENTITIES_FILE = "identities.yml"
+
+var (
+	ErrIdentityExists   = errors.New("identity already exists")
+	ErrIdentityNotFound = errors.New("identity not found")
+	ErrAmbiguous        = errors.New("ambiguous identity")
+	ErrInvalidName      = errors.New("invalid identity name")
+	ErrInvalidIdentity  = errors.New("invalid identity")
+	ErrNoPrivateKey     = errors.New("identity has no private key")
+)
+
+// Key is an ed25519 key, base64-encoded in the identities file and in
+// exported identities.
+type Key []byte
+
+func (k Key) MarshalYAML() (interface{}, error) {
+	return base64.StdEncoding.EncodeToString(k), nil
+}
+
+func (k *Key) UnmarshalYAML(value *yaml.Node) error {
+	data, err := base64.StdEncoding.DecodeString(value.Value)
+	if err != nil {
+		return fmt.Errorf("%w: bad key encoding", ErrInvalidIdentity)
+	}
+	*k = data
+	return nil
+}
+
+// Identity is an ed25519 keypair used to sign snapshots.  Identities
+// created locally carry their private key, the ones imported from other
+// hosts usually only have the public key and are merely trusted.
+type Identity struct {
+	Identifier uuid.UUID `yaml:"id"`
+	Name       string    `yaml:"name"`
+	Created    time.Time `yaml:"created"`
+	PublicKey  Key       `yaml:"public_key"`
+	PrivateKey Key       `yaml:"private_key,omitempty"`
+}
+
+func (id *Identity) HasPrivateKey() bool {
+	return len(id.PrivateKey) != 0
+}
+
+// Keypair returns the keypair to sign snapshots with.
+func (id *Identity) Keypair() (*keypair.KeyPair, error) {
+	if !id.HasPrivateKey() {
+		return nil, ErrNoPrivateKey
+	}
+	return keypair.FromPrivateKey(ed25519.PrivateKey(id.PrivateKey)), nil
+}
+
+func (id *Identity) validate() error {
+	if id.Identifier == uuid.Nil {
+		return fmt.Errorf("%w: missing id", ErrInvalidIdentity)
+	}
+	if !validName(id.Name) {
+		return ErrInvalidName
+	}
+	if len(id.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: bad public key", ErrInvalidIdentity)
+	}
+	if id.HasPrivateKey() {
+		if len(id.PrivateKey) != ed25519.PrivateKeySize {
+			return fmt.Errorf("%w: bad private key", ErrInvalidIdentity)
+		}
+		public := ed25519.PrivateKey(id.PrivateKey).Public().(ed25519.PublicKey)
+		if !bytes.Equal(public, id.PublicKey) {
+			return fmt.Errorf("%w: private key does not match the public key", ErrInvalidIdentity)
+		}
+	}
+	return nil
+}
+
+func validName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == '@':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type identitiesFile struct {
+	Default    string      `yaml:"default,omitempty"`
+	Identities []*Identity `yaml:"identities"`
+}
+
+// Manager maintains the identities stored in the configuration
+// directory.  Every identity it knows of is trusted, the default one is
+// used to sign backups when no other identity is requested.
+type Manager struct {
+	path string
+	mtx  sync.Mutex
+}
+
+func NewManager(configDir string) *Manager {
+	return &Manager{
+		path: filepath.Join(configDir, IDENTITIES_FILE),
+	}
+}
+
+func (m *Manager) load() (*identitiesFile, error) {
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &identitiesFile{}, nil
+		}
+		return nil, err
+	}
+
+	var f identitiesFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", m.path, err)
+	}
+	return &f, nil
+}
+
+func (m *Manager) save(f *identitiesFile) error {
+	if err := os.MkdirAll(filepath.Dir(m.path), 0700); err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(m.path), "identities.*.yml")
+	if err != nil {
+		return err
+	}
+
+	err = yaml.NewEncoder(tmpFile).Encode(f)
+	tmpFile.Close()
+
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), 0600)
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), m.path)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return nil
+}
+
+// lookup resolves an identity by name, identifier or unambiguous
+// identifier prefix.
+func (f *identitiesFile) lookup(ref string) (int, error) {
+	for i, id := range f.Identities {
+		if id.Name == ref || id.Identifier.String() == ref {
+			return i, nil
+		}
+	}
+
+	found := -1
+	if len(ref) >= 4 {
+		for i, id := range f.Identities {
+			if strings.HasPrefix(id.Identifier.String(), strings.ToLower(ref)) {
+				if found != -1 {
+					return -1, fmt.Errorf("%w: %s", ErrAmbiguous, ref)
+				}
+				found = i
+			}
+		}
+	}
+	if found == -1 {
+		return -1, fmt.Errorf("%w: %s", ErrIdentityNotFound, ref)
+	}
+	return found, nil
+}
+
+func (m *Manager) List() ([]Identity, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	f, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]Identity, 0, len(f.Identities))
+	for _, id := range f.Identities {
+		ret = append(ret, *id)
+	}
+	slices.SortFunc(ret, func(a, b Identity) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return ret, nil
+}
+
+func (m *Manager) Get(ref string) (*Identity, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	f, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := f.lookup(ref)
+	if err != nil {
+		return nil, err
+	}
+	ret := *f.Identities[idx]
+	return &ret, nil
+}
+
+// Default returns the identity used to sign backups by default, or nil if
+// there is none.
+func (m *Manager) Default() (*Identity, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	f, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	if f.Default == "" {
+		return nil, nil
+	}
+
+	idx, err := f.lookup(f.Default)
+	if err != nil {
+		return nil, err
+	}
+	ret := *f.Identities[idx]
+	return &ret, nil
+}
+
+func (m *Manager) SetDefault(ref string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	f, err := m.load()
+	if err != nil {
+		return err
+	}
+
+	idx, err := f.lookup(ref)
+	if err != nil {
+		return err
+	}
+	if !f.Identities[idx].HasPrivateKey() {
+		return ErrNoPrivateKey
+	}
+	f.Default = f.Identities[idx].Identifier.String()
+	return m.save(f)
+}
+
+// Signer returns the identity to sign snapshots with: the one given by
+// name or identifier, otherwise the default one if any.
+func (m *Manager) Signer(ref string) (*Identity, error) {
+	if ref == "" {
+		return m.Default()
+	}
+
+	id, err := m.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	if !id.HasPrivateKey() {
+		return nil, fmt.Errorf("%w: %s", ErrNoPrivateKey, ref)
+	}
+	return id, nil
+}
+
+// Create generates a new identity.  The first identity with a private key
+// becomes the default one.
+func (m *Manager) Create(name string) (*Identity, error) {
+	if !validName(name) {
+		return nil, ErrInvalidName
+	}
+
+	kp, err := keypair.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	id := &Identity{
+		Identifier: uuid.New(),
+		Name:       name,
+		Created:    time.Now(),
+		PublicKey:  Key(kp.PublicKey),
+		PrivateKey: Key(kp.PrivateKey),
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	f, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	if err := f.add(id); err != nil {
+		return nil, err
+	}
+	if err := m.save(f); err != nil {
+		return nil, err
+	}
+
+	ret := *id
+	return &ret, nil
+}
+
+func (f *identitiesFile) add(id *Identity) error {
+	for _, other := range f.Identities {
+		if other.Name == id.Name {
+			return fmt.Errorf("%w: %s", ErrIdentityExists, id.Name)
+		}
+		if other.Identifier == id.Identifier {
+			return fmt.Errorf("%w: %s", ErrIdentityExists, id.Identifier)
+		}
+	}
+	f.Identities = append(f.Identities, id)
+	if f.Default == "" && id.HasPrivateKey() {
+		f.Default = id.Identifier.String()
+	}
+	return nil
+}
+
+func (m *Manager) Remove(ref string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	f, err := m.load()
+	if err != nil {
+		return err
+	}
+
+	idx, err := f.lookup(ref)
+	if err != nil {
+		return err
+	}
+	if f.Default == f.Identities[idx].Identifier.String() {
+		f.Default = ""
+	}
+	f.Identities = slices.Delete(f.Identities, idx, idx+1)
+	return m.save(f)
+}
+
+// Export serializes an identity so that it can be imported on another
+// host, the private key is only included if requested.
+func (m *Manager) Export(ref string, private bool) ([]byte, error) {
+	id, err := m.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	if private && !id.HasPrivateKey() {
+		return nil, ErrNoPrivateKey
+	}
+	if !private {
+		id.PrivateKey = nil
+	}
+	return yaml.Marshal(id)
+}
+
+// Import adds an exported identity to the trusted ones.  A private key
+// may be imported for an identity whose public key is already known.
+func (m *Manager) Import(data []byte) (*Identity, error) {
+	var id Identity
+	if err := yaml.Unmarshal(data, &id); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIdentity, err)
+	}
+	if err := id.validate(); err != nil {
+		return nil, err
+	}
+	if id.Created.IsZero() {
+		id.Created = time.Now()
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	f, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, other := range f.Identities {
+		if other.Identifier != id.Identifier {
+			continue
+		}
+		if !bytes.Equal(other.PublicKey, id.PublicKey) {
+			return nil, fmt.Errorf("%w: %s has a different public key", ErrIdentityExists, id.Identifier)
+		}
+		if other.HasPrivateKey() || !id.HasPrivateKey() {
+			return nil, fmt.Errorf("%w: %s", ErrIdentityExists, id.Identifier)
+		}
+		other.PrivateKey = id.PrivateKey
+		if f.Default == "" {
+			f.Default = other.Identifier.String()
+		}
+		if err := m.save(f); err != nil {
+			return nil, err
+		}
+		ret := *other
+		return &ret, nil
+	}
+
+	if err := f.add(&id); err != nil {
+		return nil, err
+	}
+	if err := m.save(f); err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// Trusted returns the local identity matching the signer of a snapshot,
+// or nil if the signer is unknown.
+func (m *Manager) Trusted(signer header.Identity) (*Identity, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	f, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range f.Identities {
+		if id.Identifier == signer.Identifier && bytes.Equal(id.PublicKey, signer.PublicKey) {
+			ret := *id
+			return &ret, nil
+		}
+	}
+	return nil, nil
+}
+
+type Status string
+
+const (
+	StatusUnsigned  Status = "unsigned"
+	StatusInvalid   Status = "invalid"
+	StatusUntrusted Status = "untrusted"
+	StatusTrusted   Status = "trusted"
+)
+
+// Verify checks the signature of a snapshot and whether its signer is
+// trusted.  The identity is only returned for trusted signers.
+func (m *Manager) Verify(snap *snapshot.Snapshot) (Status, *Identity, error) {
+	if snap.Header.Identity.Identifier == uuid.Nil {
+		return StatusUnsigned, nil, nil
+	}
+
+	ok, err := snap.Verify()
+	if err != nil {
+		return StatusInvalid, nil, err
+	}
+	if !ok {
+		return StatusInvalid, nil, nil
+	}
+
+	id, err := m.Trusted(snap.Header.Identity)
+	if err != nil {
+		return StatusUntrusted, nil, err
+	}
+	if id == nil {
+		return StatusUntrusted, nil, nil
+	}
+	return StatusTrusted, id, nil
+}
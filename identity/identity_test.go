@@ -0,0 +1,124 @@
+package identity
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager(t *testing.T) {
+	tmpDir := t.TempDir()
+	m := NewManager(tmpDir)
+
+	def, err := m.Default()
+	require.NoError(t, err)
+	require.Nil(t, def)
+
+	alice, err := m.Create("alice")
+	require.NoError(t, err)
+	require.True(t, alice.HasPrivateKey())
+
+	_, err = m.Create("alice")
+	require.ErrorIs(t, err, ErrIdentityExists)
+	_, err = m.Create("bad name")
+	require.ErrorIs(t, err, ErrInvalidName)
+
+	info, err := os.Stat(filepath.Join(tmpDir, IDENTITIES_FILE))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// the first identity becomes the default one
+	def, err = m.Default()
+	require.NoError(t, err)
+	require.Equal(t, alice.Identifier, def.Identifier)
+
+	bob, err := m.Create("bob")
+	require.NoError(t, err)
+	require.NoError(t, m.SetDefault("bob"))
+	def, err = m.Default()
+	require.NoError(t, err)
+	require.Equal(t, bob.Identifier, def.Identifier)
+
+	id, err := m.Get(alice.Identifier.String()[:8])
+	require.NoError(t, err)
+	require.Equal(t, "alice", id.Name)
+	_, err = m.Get("carol")
+	require.ErrorIs(t, err, ErrIdentityNotFound)
+
+	kp, err := alice.Keypair()
+	require.NoError(t, err)
+	require.Equal(t, []byte(alice.PublicKey), []byte(kp.PublicKey))
+
+	list, err := m.List()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, "alice", list[0].Name)
+	require.Equal(t, "bob", list[1].Name)
+
+	require.NoError(t, m.Remove("bob"))
+	def, err = m.Default()
+	require.NoError(t, err)
+	require.Nil(t, def)
+}
+
+func TestExportImport(t *testing.T) {
+	src := NewManager(t.TempDir())
+	dst := NewManager(t.TempDir())
+
+	alice, err := src.Create("alice")
+	require.NoError(t, err)
+
+	signer := header.Identity{
+		Identifier: alice.Identifier,
+		PublicKey:  alice.PublicKey,
+	}
+	trusted, err := dst.Trusted(signer)
+	require.NoError(t, err)
+	require.Nil(t, trusted)
+
+	public, err := src.Export("alice", false)
+	require.NoError(t, err)
+	require.NotContains(t, string(public), "private_key")
+
+	imported, err := dst.Import(public)
+	require.NoError(t, err)
+	require.Equal(t, alice.Identifier, imported.Identifier)
+	require.False(t, imported.HasPrivateKey())
+
+	trusted, err = dst.Trusted(signer)
+	require.NoError(t, err)
+	require.NotNil(t, trusted)
+	require.Equal(t, "alice", trusted.Name)
+
+	// a public-only identity can't sign
+	require.ErrorIs(t, dst.SetDefault("alice"), ErrNoPrivateKey)
+	_, err = dst.Import(public)
+	require.ErrorIs(t, err, ErrIdentityExists)
+
+	// the private key can be added later on
+	private, err := src.Export("alice", true)
+	require.NoError(t, err)
+	imported, err = dst.Import(private)
+	require.NoError(t, err)
+	require.True(t, imported.HasPrivateKey())
+	def, err := dst.Default()
+	require.NoError(t, err)
+	require.Equal(t, alice.Identifier, def.Identifier)
+
+	// same identifier, another key
+	other, err := NewManager(t.TempDir()).Create("alice")
+	require.NoError(t, err)
+	other.Identifier = alice.Identifier
+	other.PrivateKey = nil
+	trusted, err = dst.Trusted(header.Identity{Identifier: other.Identifier, PublicKey: other.PublicKey})
+	require.NoError(t, err)
+	require.Nil(t, trusted)
+
+	_, err = dst.Import([]byte("id: not-a-uuid\n"))
+	require.ErrorIs(t, err, ErrInvalidIdentity)
+	_, err = dst.Import([]byte("id: 7d3b1fbe-9f36-4c1a-8c2e-4f5d2f1c0a11\nname: x\npublic_key: AAAA\n"))
+	require.ErrorIs(t, err, ErrInvalidIdentity)
+}
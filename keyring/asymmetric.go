@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package keyring
+
+import (
+	"crypto/ecdh"
+	"crypto/subtle"
+	"path/filepath"
+
+	"github.com/PlakarKorp/kloset/encryption"
+	"github.com/PlakarKorp/kloset/storage"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+)
+
+// An asymmetric repository has an X25519 key pair instead of a
+// symmetric key: its packfiles and states are sealed to the public key,
+// which is the canary of its configuration, and the private key is what
+// the slots hold.  Anyone can back up into it, reading it takes the
+// private key.  kloset sees it as unencrypted: the sealing is done by a
+// plakarstorage.SealedStore wrapping the store, see OpenStore.
+const AsymmetricAlgorithm = "X25519"
+
+// IsAsymmetric reports whether an encryption configuration is the one
+// of an asymmetric repository.
+func IsAsymmetric(config *encryption.Configuration) bool {
+	return config != nil && config.SubKeyAlgorithm == AsymmetricAlgorithm
+}
+
+// NewAsymmetricConfiguration turns the encryption configuration of a
+// new repository into the one of an asymmetric repository, keeping its
+// KDF parameters for the slots and its chunk size for the segments the
+// data is sealed in.
+func NewAsymmetricConfiguration(config *encryption.Configuration) {
+	config.SubKeyAlgorithm = AsymmetricAlgorithm
+	config.DataAlgorithm = "AES256-GCM"
+	config.Canary = nil
+}
+
+// PublicKey returns the public key of the private key of an asymmetric
+// repository.
+func PublicKey(key []byte) ([]byte, error) {
+	private, err := ecdh.X25519().NewPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return private.PublicKey().Bytes(), nil
+}
+
+// DeriveCanary returns the canary of a new repository with key.
+func DeriveCanary(config *encryption.Configuration, key []byte) ([]byte, error) {
+	if IsAsymmetric(config) {
+		return PublicKey(key)
+	}
+	return encryption.DeriveCanary(config, key)
+}
+
+// verify reports whether key is the key of the repository.
+func verify(config *encryption.Configuration, key []byte) bool {
+	if IsAsymmetric(config) {
+		public, err := PublicKey(key)
+		return err == nil && subtle.ConstantTimeCompare(public, config.Canary) == 1
+	}
+	return encryption.VerifyCanary(config, key)
+}
+
+// OpenStore returns the store and the secret to open a repository with.
+// An asymmetric repository is opened through a SealedStore and without
+// a secret, write-only if secret is nil; other repositories are opened
+// as they are.
+func OpenStore(store storage.Store, serializedConfig, secret []byte, cacheDir string) (storage.Store, []byte, error) {
+	config, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !IsAsymmetric(config.Encryption) {
+		return store, secret, nil
+	}
+
+	dir := filepath.Join(cacheDir, "sealed", config.RepositoryID.String())
+	sealed, err := plakarstorage.NewSealedStore(store, config.Encryption.Canary, secret,
+		config.Encryption.ChunkSize, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sealed, nil, nil
+}
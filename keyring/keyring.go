@@ -0,0 +1,324 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package keyring implements the key slots of encrypted repositories.
+// The repository key is random and each slot holds a copy of it
+// encrypted with a key derived from a passphrase or a keyfile, with KDF
+// parameters of its own: a slot can be added, changed or removed
+// without touching the data, which stays encrypted with the same key.
+//
+// Repositories created before key slots have their key derived from the
+// passphrase given at creation with the KDF parameters of their
+// configuration.  Slots added to them wrap that key, and the keyring is
+// marked legacy since the passphrase keeps unlocking the repository.
+package keyring
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/PlakarKorp/kloset/encryption"
+	"github.com/PlakarKorp/kloset/storage"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const VERSION = 1
+
+// The algorithm used to encrypt the key in the slots.
+const wrapAlgorithm = "AES256-GCM"
+
+// slotKDF is the KDF of the new slots.
+var slotKDF = encryption.DEFAULT_KDF
+
+const (
+	TypePassphrase = "passphrase"
+	TypeKeyfile    = "keyfile"
+)
+
+var ErrCantUnlock = errors.New("failed to unlock repository")
+var ErrUnsupported = errors.New("the store can't hold key slots")
+
+type Slot struct {
+	ID        string               `msgpack:"id"`
+	Name      string               `msgpack:"name"`
+	Type      string               `msgpack:"type"`
+	Created   time.Time            `msgpack:"created"`
+	KDFParams encryption.KDFParams `msgpack:"kdf_params"`
+	Key       []byte               `msgpack:"key"`
+}
+
+type Keyring struct {
+	Version int `msgpack:"version"`
+
+	// Legacy is set when the passphrase given at creation derives
+	// the key, and unlocks the repository without a slot.
+	Legacy bool   `msgpack:"legacy"`
+	Slots  []Slot `msgpack:"slots"`
+
+	// KDF holds the costs of the KDF of new slots, the ones chosen
+	// at creation.  The defaults apply if it is nil.
+	KDF *encryption.KDFParams `msgpack:"kdf,omitempty"`
+}
+
+// NewKey returns the random key of a new repository.
+func NewKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Create returns the key of a new repository and the keyring holding a
+// slot for secret, to be saved once the repository is created.  If the
+// store can't hold key slots, the keyring is nil and the key is derived
+// from secret as before them.
+func Create(store storage.Store, config *encryption.Configuration, name, typ string, secret []byte) (*Keyring, []byte, error) {
+	if _, ok := plakarstorage.HasKeyring(store); !ok {
+		key, err := encryption.DeriveKey(config.KDFParams, secret)
+		return nil, key, err
+	}
+
+	key, err := NewKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kdf := config.KDFParams
+	kdf.Salt = nil
+
+	k := &Keyring{Version: VERSION, KDF: &kdf}
+	if _, err := k.Add(name, typ, secret, key); err != nil {
+		return nil, nil, err
+	}
+	return k, key, nil
+}
+
+// Load returns the keyring of a repository, nil if it has none.
+func Load(store storage.Store) (*Keyring, error) {
+	keyringStore, ok := plakarstorage.HasKeyring(store)
+	if !ok {
+		return nil, nil
+	}
+
+	rd, err := keyringStore.GetKeyring()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if closer, ok := rd.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	var k Keyring
+	if err := msgpack.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("invalid keyring: %w", err)
+	}
+	if k.Version > VERSION {
+		return nil, fmt.Errorf("unsupported keyring version %d", k.Version)
+	}
+	return &k, nil
+}
+
+func Save(store storage.Store, k *Keyring) error {
+	keyringStore, ok := plakarstorage.HasKeyring(store)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	data, err := msgpack.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return keyringStore.PutKeyring(bytes.NewReader(data))
+}
+
+// Unlock returns the key of a repository that secret unlocks, through
+// one of its slots or the KDF parameters of its configuration if it
+// predates them.
+func Unlock(store storage.Store, config *encryption.Configuration, secret []byte) ([]byte, error) {
+	k, err := Load(store)
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		k = &Keyring{Version: VERSION, Legacy: true}
+	}
+
+	key, _, err := k.Unlock(config, secret)
+	return key, err
+}
+
+// Unlock returns the key that secret unlocks and the slot it matched,
+// nil if it is the passphrase of a legacy repository.
+func (k *Keyring) Unlock(config *encryption.Configuration, secret []byte) ([]byte, *Slot, error) {
+	for i := range k.Slots {
+		slot := &k.Slots[i]
+		key, err := slot.open(secret)
+		if err != nil {
+			return nil, nil, err
+		}
+		if key != nil && verify(config, key) {
+			return key, slot, nil
+		}
+	}
+
+	if k.Legacy {
+		key, err := encryption.DeriveKey(config.KDFParams, secret)
+		if err != nil {
+			return nil, nil, err
+		}
+		if verify(config, key) {
+			return key, nil, nil
+		}
+	}
+
+	return nil, nil, ErrCantUnlock
+}
+
+// open returns the key of the slot if secret is the right one, nil
+// otherwise.
+func (slot *Slot) open(secret []byte) ([]byte, error) {
+	wrapKey, err := encryption.DeriveKey(slot.KDFParams, secret)
+	if err != nil {
+		return nil, err
+	}
+	key, err := encryption.DecryptSubkey(wrapAlgorithm, wrapKey, bytes.NewReader(slot.Key))
+	if err != nil {
+		return nil, nil
+	}
+	return key, nil
+}
+
+// kdfParams returns the KDF parameters of a new slot.
+func (k *Keyring) kdfParams() (*encryption.KDFParams, error) {
+	if k.KDF == nil {
+		return encryption.NewDefaultKDFParams(slotKDF)
+	}
+
+	params, err := encryption.NewDefaultKDFParams(k.KDF.KDF)
+	if err != nil {
+		return nil, err
+	}
+	salt := params.Salt
+	*params = *k.KDF
+	params.Salt = salt
+	return params, nil
+}
+
+// seal encrypts key in the slot with a key derived from secret, with
+// new KDF parameters.
+func (slot *Slot) seal(params *encryption.KDFParams, secret, key []byte) error {
+	wrapKey, err := encryption.DeriveKey(*params, secret)
+	if err != nil {
+		return err
+	}
+	wrapped, err := encryption.EncryptSubkey(wrapAlgorithm, wrapKey, key)
+	if err != nil {
+		return err
+	}
+
+	slot.KDFParams = *params
+	slot.Key = wrapped
+	return nil
+}
+
+// Add creates a slot unlocking key with secret.
+func (k *Keyring) Add(name, typ string, secret, key []byte) (*Slot, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("empty %s", typ)
+	}
+	if name != "" && k.Find(name) != nil {
+		return nil, fmt.Errorf("key slot %s already exists", name)
+	}
+
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+
+	slot := Slot{
+		ID:      hex.EncodeToString(id[:]),
+		Name:    name,
+		Type:    typ,
+		Created: time.Now(),
+	}
+	params, err := k.kdfParams()
+	if err != nil {
+		return nil, err
+	}
+	if err := slot.seal(params, secret, key); err != nil {
+		return nil, err
+	}
+
+	k.Slots = append(k.Slots, slot)
+	return &k.Slots[len(k.Slots)-1], nil
+}
+
+// Find returns the slot with the given ID or name.
+func (k *Keyring) Find(idOrName string) *Slot {
+	for i := range k.Slots {
+		if k.Slots[i].ID == idOrName || k.Slots[i].Name == idOrName {
+			return &k.Slots[i]
+		}
+	}
+	return nil
+}
+
+// Change replaces the secret of a slot, which gets new KDF parameters.
+func (k *Keyring) Change(slot *Slot, typ string, secret, key []byte) error {
+	if len(secret) == 0 {
+		return fmt.Errorf("empty %s", typ)
+	}
+	params, err := k.kdfParams()
+	if err != nil {
+		return err
+	}
+	if err := slot.seal(params, secret, key); err != nil {
+		return err
+	}
+	slot.Type = typ
+	return nil
+}
+
+// Remove deletes a slot, unless it is the last one of a repository that
+// nothing else would unlock.
+func (k *Keyring) Remove(slot *Slot) error {
+	if len(k.Slots) == 1 && !k.Legacy {
+		return fmt.Errorf("can't remove the last key slot")
+	}
+
+	for i := range k.Slots {
+		if k.Slots[i].ID == slot.ID {
+			k.Slots = append(k.Slots[:i], k.Slots[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no such key slot: %s", slot.ID)
+}
@@ -0,0 +1,231 @@
+package keyring
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/encryption"
+	"github.com/PlakarKorp/kloset/hashing"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/kloset/versioning"
+	bfs "github.com/PlakarKorp/plakar/connectors/fs/storage"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	// keep the tests fast
+	slotKDF = "PBKDF2"
+}
+
+func newStore(t *testing.T) storage.Store {
+	store, err := bfs.NewStore(context.Background(), "fs", map[string]string{"location": "fs://" + t.TempDir() + "/repo"})
+	require.NoError(t, err)
+	require.NoError(t, store.Create(context.Background(), []byte("CONFIG")))
+	return store
+}
+
+func newConfiguration(t *testing.T, key []byte) *encryption.Configuration {
+	config := encryption.NewDefaultConfiguration()
+	params, err := encryption.NewDefaultKDFParams(slotKDF)
+	require.NoError(t, err)
+	config.KDFParams = *params
+
+	canary, err := encryption.DeriveCanary(config, key)
+	require.NoError(t, err)
+	config.Canary = canary
+	return config
+}
+
+func TestKeyring(t *testing.T) {
+	store := newStore(t)
+	config := newConfiguration(t, make([]byte, 32))
+
+	k, key, err := Create(store, config, "default", TypePassphrase, []byte("first"))
+	require.NoError(t, err)
+	require.NotNil(t, k)
+	require.False(t, k.Legacy)
+	require.Len(t, key, 32)
+
+	// the slots get the KDF costs of the repository, with their own salt
+	require.Equal(t, config.KDFParams.KDF, k.Slots[0].KDFParams.KDF)
+	require.Equal(t, config.KDFParams.Pbkdf2Params, k.Slots[0].KDFParams.Pbkdf2Params)
+	require.NotEqual(t, config.KDFParams.Salt, k.Slots[0].KDFParams.Salt)
+	require.Nil(t, k.KDF.Salt)
+
+	canary, err := encryption.DeriveCanary(config, key)
+	require.NoError(t, err)
+	config.Canary = canary
+
+	// nothing unlocks the repository until the keyring is saved
+	_, err = Unlock(store, config, []byte("first"))
+	require.ErrorIs(t, err, ErrCantUnlock)
+	require.NoError(t, Save(store, k))
+
+	unlocked, err := Unlock(store, config, []byte("first"))
+	require.NoError(t, err)
+	require.Equal(t, key, unlocked)
+	_, err = Unlock(store, config, []byte("wrong"))
+	require.ErrorIs(t, err, ErrCantUnlock)
+
+	k, err = Load(store)
+	require.NoError(t, err)
+	slot, err := k.Add("backup", TypeKeyfile, []byte("second"), key)
+	require.NoError(t, err)
+	_, err = k.Add("backup", TypeKeyfile, []byte("third"), key)
+	require.Error(t, err)
+	require.Same(t, slot, k.Find(slot.ID))
+
+	unlocked, matched, err := k.Unlock(config, []byte("second"))
+	require.NoError(t, err)
+	require.Equal(t, key, unlocked)
+	require.Equal(t, "backup", matched.Name)
+
+	require.NoError(t, k.Change(k.Find("default"), TypePassphrase, []byte("changed"), key))
+	_, _, err = k.Unlock(config, []byte("first"))
+	require.ErrorIs(t, err, ErrCantUnlock)
+	_, _, err = k.Unlock(config, []byte("changed"))
+	require.NoError(t, err)
+
+	require.NoError(t, k.Remove(k.Find("default")))
+	require.EqualError(t, k.Remove(k.Find("backup")), "can't remove the last key slot")
+}
+
+func TestKeyringLegacy(t *testing.T) {
+	store := newStore(t)
+
+	// a repository created before key slots, without a keyring
+	config := newConfiguration(t, make([]byte, 32))
+	key, err := encryption.DeriveKey(config.KDFParams, []byte("creation"))
+	require.NoError(t, err)
+	config.Canary, err = encryption.DeriveCanary(config, key)
+	require.NoError(t, err)
+
+	unlocked, err := Unlock(store, config, []byte("creation"))
+	require.NoError(t, err)
+	require.Equal(t, key, unlocked)
+
+	k, err := Load(store)
+	require.NoError(t, err)
+	require.Nil(t, k)
+
+	k = &Keyring{Version: VERSION, Legacy: true}
+	slot, err := k.Add("", TypePassphrase, []byte("added"), key)
+	require.NoError(t, err)
+	require.NoError(t, Save(store, k))
+
+	for _, secret := range []string{"creation", "added"} {
+		unlocked, err = Unlock(store, config, []byte(secret))
+		require.NoError(t, err)
+		require.Equal(t, key, unlocked)
+	}
+
+	// the passphrase given at creation remains
+	require.NoError(t, k.Remove(slot))
+}
+
+func TestKeyringUnsupported(t *testing.T) {
+	var store struct{ storage.Store }
+	config := newConfiguration(t, make([]byte, 32))
+
+	k, key, err := Create(store, config, "default", TypePassphrase, []byte("secret"))
+	require.NoError(t, err)
+	require.Nil(t, k)
+
+	derived, err := encryption.DeriveKey(config.KDFParams, []byte("secret"))
+	require.NoError(t, err)
+	require.Equal(t, derived, key)
+
+	require.ErrorIs(t, Save(store, &Keyring{}), ErrUnsupported)
+}
+
+func TestRecoveryKey(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+	config := newConfiguration(t, key)
+
+	recovery := FormatRecoveryKey(key)
+	require.Len(t, recovery, 14*4+13)
+
+	unlocked, err := UnlockRecovery(config, recovery)
+	require.NoError(t, err)
+	require.Equal(t, key, unlocked)
+
+	// sloppy transcription
+	sloppy := strings.ToLower(strings.ReplaceAll(recovery, "-", " "))
+	sloppy = strings.ReplaceAll(strings.ReplaceAll(sloppy, "o", "0"), "i", "1")
+	unlocked, err = UnlockRecovery(config, sloppy)
+	require.NoError(t, err)
+	require.Equal(t, key, unlocked)
+
+	typo := []byte(recovery)
+	if typo[0] == 'A' {
+		typo[0] = 'B'
+	} else {
+		typo[0] = 'A'
+	}
+	_, err = UnlockRecovery(config, string(typo))
+	require.ErrorIs(t, err, ErrInvalidRecoveryKey)
+
+	_, err = UnlockRecovery(config, "not a key!")
+	require.ErrorIs(t, err, ErrInvalidRecoveryKey)
+
+	other, err := NewKey()
+	require.NoError(t, err)
+	_, err = UnlockRecovery(config, FormatRecoveryKey(other))
+	require.ErrorIs(t, err, ErrCantUnlock)
+}
+
+func TestKeyringAsymmetric(t *testing.T) {
+	store := newStore(t)
+	config := newConfiguration(t, make([]byte, 32))
+	NewAsymmetricConfiguration(config)
+	require.True(t, IsAsymmetric(config))
+
+	k, key, err := Create(store, config, "default", TypePassphrase, []byte("secret"))
+	require.NoError(t, err)
+	config.Canary, err = DeriveCanary(config, key)
+	require.NoError(t, err)
+	public, err := PublicKey(key)
+	require.NoError(t, err)
+	require.Equal(t, public, config.Canary)
+	require.NoError(t, Save(store, k))
+
+	unlocked, err := Unlock(store, config, []byte("secret"))
+	require.NoError(t, err)
+	require.Equal(t, key, unlocked)
+	_, err = Unlock(store, config, []byte("wrong"))
+	require.ErrorIs(t, err, ErrCantUnlock)
+
+	unlocked, err = UnlockRecovery(config, FormatRecoveryKey(key))
+	require.NoError(t, err)
+	require.Equal(t, key, unlocked)
+
+	repoConfig := storage.NewConfiguration()
+	repoConfig.Encryption = config
+	data, err := repoConfig.ToBytes()
+	require.NoError(t, err)
+	rd, err := storage.Serialize(hashing.GetHasher(storage.DEFAULT_HASHING_ALGORITHM), resources.RT_CONFIG,
+		versioning.GetCurrentVersion(resources.RT_CONFIG), bytes.NewReader(data))
+	require.NoError(t, err)
+	serialized, err := io.ReadAll(rd)
+	require.NoError(t, err)
+
+	// kloset opens the repository without a key, through the sealed store
+	opened, secret, err := OpenStore(store, serialized, key, t.TempDir())
+	require.NoError(t, err)
+	require.Nil(t, secret)
+	sealed, ok := plakarstorage.IsSealed(opened)
+	require.True(t, ok)
+	require.False(t, sealed.WriteOnly())
+
+	opened, _, err = OpenStore(store, serialized, nil, t.TempDir())
+	require.NoError(t, err)
+	sealed, ok = plakarstorage.IsSealed(opened)
+	require.True(t, ok)
+	require.True(t, sealed.WriteOnly())
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package keyring
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/encryption"
+)
+
+// A recovery key is the repository key itself, meant to be written
+// down and kept away from the passphrases: it opens the repository
+// whatever happens to the slots, and can't be revoked.  It's encoded in
+// base32 with a 3 bytes checksum, in dash-separated groups of four.
+const recoveryChecksumSize = 3
+
+var ErrInvalidRecoveryKey = errors.New("invalid recovery key")
+
+// FormatRecoveryKey returns the recovery key of the repository key.
+func FormatRecoveryKey(key []byte) string {
+	sum := sha256.Sum256(key)
+	data := append(append([]byte{}, key...), sum[:recoveryChecksumSize]...)
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(data)
+
+	groups := make([]string, 0, (len(encoded)+3)/4)
+	for len(encoded) > 4 {
+		groups = append(groups, encoded[:4])
+		encoded = encoded[4:]
+	}
+	groups = append(groups, encoded)
+	return strings.Join(groups, "-")
+}
+
+// ParseRecoveryKey returns the repository key of a recovery key as
+// transcribed: case, blanks and dashes don't matter, and the digits
+// easily mistaken for letters are read as such.
+func ParseRecoveryKey(recovery string) ([]byte, error) {
+	recovery = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n', '-':
+			return -1
+		case '0':
+			return 'O'
+		case '1':
+			return 'I'
+		case '8':
+			return 'B'
+		}
+		return r
+	}, strings.ToUpper(recovery))
+
+	data, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(recovery)
+	if err != nil || len(data) <= recoveryChecksumSize {
+		return nil, ErrInvalidRecoveryKey
+	}
+
+	key, checksum := data[:len(data)-recoveryChecksumSize], data[len(data)-recoveryChecksumSize:]
+	sum := sha256.Sum256(key)
+	if string(sum[:recoveryChecksumSize]) != string(checksum) {
+		return nil, fmt.Errorf("%w: checksum mismatch, check for typos", ErrInvalidRecoveryKey)
+	}
+	return key, nil
+}
+
+// UnlockRecovery returns the repository key of a recovery key.
+func UnlockRecovery(config *encryption.Configuration, recovery string) ([]byte, error) {
+	key, err := ParseRecoveryKey(recovery)
+	if err != nil {
+		return nil, err
+	}
+	if !verify(config, key) {
+		return nil, ErrCantUnlock
+	}
+	return key, nil
+}
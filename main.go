@@ -1,14 +1,11 @@
 package main
 
 import (
-	"bufio"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"os/exec"
 	"os/signal"
 	"os/user"
 	"path/filepath"
@@ -19,7 +16,6 @@ import (
 	"time"
 
 	"github.com/PlakarKorp/kloset/caching"
-	"github.com/PlakarKorp/kloset/encryption"
 	"github.com/PlakarKorp/kloset/logging"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/storage"
@@ -27,7 +23,9 @@ import (
 	"github.com/PlakarKorp/plakar/agent"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/cookies"
+	"github.com/PlakarKorp/plakar/keyring"
 	"github.com/PlakarKorp/plakar/plugins"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/task"
 	"github.com/PlakarKorp/plakar/utils"
@@ -45,8 +43,11 @@ import (
 	_ "github.com/PlakarKorp/plakar/subcommands/diag"
 	_ "github.com/PlakarKorp/plakar/subcommands/diff"
 	_ "github.com/PlakarKorp/plakar/subcommands/digest"
+	_ "github.com/PlakarKorp/plakar/subcommands/grep"
 	_ "github.com/PlakarKorp/plakar/subcommands/help"
+	_ "github.com/PlakarKorp/plakar/subcommands/identity"
 	_ "github.com/PlakarKorp/plakar/subcommands/info"
+	_ "github.com/PlakarKorp/plakar/subcommands/key"
 	_ "github.com/PlakarKorp/plakar/subcommands/locate"
 	_ "github.com/PlakarKorp/plakar/subcommands/login"
 	_ "github.com/PlakarKorp/plakar/subcommands/ls"
@@ -54,25 +55,30 @@ import (
 	_ "github.com/PlakarKorp/plakar/subcommands/mount"
 	_ "github.com/PlakarKorp/plakar/subcommands/pkg"
 	_ "github.com/PlakarKorp/plakar/subcommands/ptar"
+	_ "github.com/PlakarKorp/plakar/subcommands/purge"
 	_ "github.com/PlakarKorp/plakar/subcommands/restore"
 	_ "github.com/PlakarKorp/plakar/subcommands/rm"
 	_ "github.com/PlakarKorp/plakar/subcommands/server"
 	_ "github.com/PlakarKorp/plakar/subcommands/services"
+	_ "github.com/PlakarKorp/plakar/subcommands/snapshot"
 	_ "github.com/PlakarKorp/plakar/subcommands/ui"
 	_ "github.com/PlakarKorp/plakar/subcommands/version"
 
 	_ "github.com/PlakarKorp/plakar/connectors/fs"
 	_ "github.com/PlakarKorp/plakar/connectors/ftp"
 	_ "github.com/PlakarKorp/plakar/connectors/http"
+	_ "github.com/PlakarKorp/plakar/connectors/mirror"
 	_ "github.com/PlakarKorp/plakar/connectors/ptar"
 	_ "github.com/PlakarKorp/plakar/connectors/s3"
 	_ "github.com/PlakarKorp/plakar/connectors/sftp"
 	_ "github.com/PlakarKorp/plakar/connectors/sqlite"
+	_ "github.com/PlakarKorp/plakar/connectors/ssh"
 	_ "github.com/PlakarKorp/plakar/connectors/stdio"
 	_ "github.com/PlakarKorp/plakar/connectors/tar"
+	_ "github.com/PlakarKorp/plakar/connectors/webdav"
 )
 
-var ErrCantUnlock = errors.New("failed to unlock repository")
+var ErrCantUnlock = keyring.ErrCantUnlock
 
 func EntryPoint() int {
 	// default values
@@ -123,6 +129,7 @@ func EntryPoint() int {
 	var opt_trace string
 	var opt_quiet bool
 	var opt_keyfile string
+	var opt_recoveryKey bool
 	var opt_agentless bool
 	var opt_enableSecurityCheck bool
 	var opt_disableSecurityCheck bool
@@ -137,6 +144,7 @@ func EntryPoint() int {
 	flag.StringVar(&opt_trace, "trace", "", "display trace logs, comma-separated (all, trace, repository, snapshot, server)")
 	flag.BoolVar(&opt_quiet, "quiet", false, "no output except errors")
 	flag.StringVar(&opt_keyfile, "keyfile", "", "use passphrase from key file when prompted")
+	flag.BoolVar(&opt_recoveryKey, "recovery-key", false, "prompt for the recovery key instead of the passphrase")
 	flag.BoolVar(&opt_agentless, "no-agent", false, "run without agent")
 	flag.BoolVar(&opt_enableSecurityCheck, "enable-security-check", false, "enable update check")
 	flag.BoolVar(&opt_disableSecurityCheck, "disable-security-check", false, "disable update check")
@@ -366,25 +374,39 @@ func EntryPoint() int {
 			return 1
 		}
 
+		store, err = plakarstorage.OpenBlockCache(store, storeConfig, ctx.CacheDir, serializedConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed to open the block cache: %s\n", flag.CommandLine.Name(), err)
+			return 1
+		}
+
 		if repoConfig.Version != versioning.FromString(storage.VERSION) {
 			fmt.Fprintf(os.Stderr, "%s: incompatible repository version: %s != %s\n",
 				flag.CommandLine.Name(), repoConfig.Version, storage.VERSION)
 			return 1
 		}
 
-		if err := setupEncryption(ctx, repoConfig, storeConfig); err != nil {
+		writeOnly := cmd.GetFlags()&subcommands.WriteOnly != 0
+		if err := setupEncryption(ctx, store, repoConfig, storeConfig, opt_recoveryKey, writeOnly); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
+			return 1
+		}
+
+		var secret []byte
+		store, secret, err = keyring.OpenStore(store, serializedConfig, ctx.GetSecret(), ctx.CacheDir)
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
 			return 1
 		}
 
 		if opt_agentless {
-			repo, err = repository.New(ctx.GetInner(), ctx.GetSecret(), store, serializedConfig)
+			repo, err = repository.New(ctx.GetInner(), secret, store, serializedConfig)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
 				return 1
 			}
 		} else {
-			repo, err = repository.NewNoRebuild(ctx.GetInner(), ctx.GetSecret(), store, serializedConfig)
+			repo, err = repository.NewNoRebuild(ctx.GetInner(), secret, store, serializedConfig)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
 				return 1
@@ -522,47 +544,7 @@ func getpassphrase(ctx *appcontext.AppContext, params map[string]string) ([]byte
 	}
 
 	if cmd, ok := params["passphrase_cmd"]; ok {
-		var c *exec.Cmd
-		switch runtime.GOOS {
-		case "windows":
-			c = exec.Command("cmd", "/C", cmd)
-		default: // assume unix-esque
-			c = exec.Command("/bin/sh", "-c", cmd)
-		}
-
-		stdout, err := c.StdoutPipe()
-		if err != nil {
-			return nil, err
-		}
-
-		if err := c.Start(); err != nil {
-			return nil, err
-		}
-
-		var pass string
-		var lines int
-		scan := bufio.NewScanner(stdout)
-		for scan.Scan() {
-			pass = scan.Text()
-			lines++
-		}
-
-		// don't deadlock in case the scanner fails
-		io.Copy(io.Discard, stdout)
-
-		if err := c.Wait(); err != nil {
-			return nil, err
-		}
-
-		if err := scan.Err(); err != nil {
-			return nil, err
-		}
-
-		if lines != 1 {
-			return nil, fmt.Errorf("passphrase_cmd returned too many lines")
-		}
-
-		return []byte(pass), nil
+		return utils.GetPassphraseFromCommand(cmd)
 	}
 
 	if pass, ok := os.LookupEnv("PLAKAR_PASSPHRASE"); ok {
@@ -572,30 +554,60 @@ func getpassphrase(ctx *appcontext.AppContext, params map[string]string) ([]byte
 	return nil, nil
 }
 
-func setupEncryption(ctx *appcontext.AppContext, config *storage.Configuration, params map[string]string) error {
+// setupEncryption sets the key of the repository in ctx.  writeOnly
+// commands open asymmetric repositories without their private key
+// unless a passphrase is configured.
+func setupEncryption(ctx *appcontext.AppContext, store storage.Store, config *storage.Configuration, params map[string]string, recoveryKey bool, writeOnly bool) error {
 	if config.Encryption == nil {
 		return nil
 	}
 
+	if recoveryKey {
+		for range 3 {
+			recovery, err := utils.GetRecoveryKey("repository")
+			if err != nil {
+				return err
+			}
+
+			key, err := keyring.UnlockRecovery(config.Encryption, string(recovery))
+			if err == nil {
+				ctx.SetSecret(key)
+				return nil
+			} else if errors.Is(err, keyring.ErrInvalidRecoveryKey) {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
+			} else if !errors.Is(err, ErrCantUnlock) {
+				return err
+			}
+		}
+		return ErrCantUnlock
+	}
+
+	k, err := keyring.Load(store)
+	if err != nil {
+		return err
+	}
+	if k == nil {
+		k = &keyring.Keyring{Version: keyring.VERSION, Legacy: true}
+	}
+
 	secret, err := getpassphrase(ctx, params)
 	if err != nil {
 		return err
 	}
 
 	if secret != nil {
-		key, err := encryption.DeriveKey(config.Encryption.KDFParams,
-			secret)
+		key, _, err := k.Unlock(config.Encryption, secret)
 		if err != nil {
 			return err
 		}
-
-		if !encryption.VerifyCanary(config.Encryption, key) {
-			return ErrCantUnlock
-		}
 		ctx.SetSecret(key)
 		return nil
 	}
 
+	if writeOnly && keyring.IsAsymmetric(config.Encryption) {
+		return nil
+	}
+
 	// fall back to prompting
 	for range 3 {
 		passphrase, err := utils.GetPassphrase("repository")
@@ -603,14 +615,12 @@ func setupEncryption(ctx *appcontext.AppContext, config *storage.Configuration,
 			return err
 		}
 
-		key, err := encryption.DeriveKey(config.Encryption.KDFParams,
-			passphrase)
-		if err != nil {
-			return err
-		}
-		if encryption.VerifyCanary(config.Encryption, key) {
+		key, _, err := k.Unlock(config.Encryption, passphrase)
+		if err == nil {
 			ctx.SetSecret(key)
 			return nil
+		} else if !errors.Is(err, ErrCantUnlock) {
+			return err
 		}
 	}
 
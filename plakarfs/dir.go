@@ -4,156 +4,145 @@ package plakarfs
 
 import (
 	"context"
-	"encoding/hex"
+	"errors"
 	"fmt"
-	"math/rand/v2"
+	"io"
+	"io/fs"
 	"os"
-	"path/filepath"
+	"path"
 	"syscall"
 
 	"github.com/PlakarKorp/kloset/objects"
-	"github.com/PlakarKorp/kloset/repository"
-	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/anacrolix/fuse"
-	"github.com/anacrolix/fuse/fs"
+	fusefs "github.com/anacrolix/fuse/fs"
 )
 
-type Dir struct {
-	parent   *Dir
-	name     string
-	fullpath string
-	repo     *repository.Repository
-	snap     *snapshot.Snapshot
-	vfs      *vfs.Filesystem
+// entryNode holds what is common to all the nodes backed by an entry
+// of a snapshot.
+type entryNode struct {
+	fs     *FS
+	handle *snapshotHandle
+	entry  *vfs.Entry
+
+	// overrides the inode derived from the entry, for the root of a
+	// single snapshot mount
+	ino uint64
 }
 
-func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
-	if d.name == "/" {
-		d.fullpath = d.name
-		a.Inode = 1
-		a.Mode = os.ModeDir | 0o700
-		a.Uid = uint32(os.Geteuid())
-		a.Gid = uint32(os.Getgid())
-	} else if d.parent.name == "/" {
-		snapshotID, err := hex.DecodeString(d.name)
-		if err != nil {
-			return err
-		}
-		if len(snapshotID) != 32 {
-			return fmt.Errorf("invalid snapshot id length %d", len(snapshotID))
-		}
-		snap, err := snapshot.Load(d.repo, objects.MAC(snapshotID))
-		if err != nil {
-			return err
-		}
-		snapfs, err := snap.Filesystem()
-		if err != nil {
-			return err
-		}
+func newEntryNode(f *FS, handle *snapshotHandle, entry *vfs.Entry) fusefs.Node {
+	node := entryNode{fs: f, handle: handle, entry: entry}
+
+	mode := entry.Stat().Mode()
+	switch {
+	case mode.IsDir():
+		return &Dir{entryNode: node}
+	case mode&os.ModeSymlink != 0:
+		return &Symlink{entryNode: node}
+	default:
+		return &File{entryNode: node}
+	}
+}
 
-		d.snap = snap
-		d.repo = d.parent.repo
-		d.vfs = snapfs
-		d.fullpath = "/"
-
-		a.Inode = rand.Uint64()
-		a.Mode = os.ModeDir | 0o700
-		a.Uid = uint32(os.Geteuid())
-		a.Gid = uint32(os.Getgid())
-		a.Ctime = snap.Header.Timestamp
-		a.Mtime = snap.Header.Timestamp
-		a.Atime = snap.Header.Timestamp
-		a.Size = snap.Header.GetSource(0).Summary.Directory.Size + snap.Header.GetSource(0).Summary.Below.Size
-	} else {
-		d.snap = d.parent.snap
-		d.repo = d.parent.repo
-		d.vfs = d.parent.vfs
-		d.fullpath = d.parent.fullpath + "/" + d.name
-
-		d.fullpath = filepath.Clean(d.fullpath)
-
-		fi, err := d.vfs.GetEntry(d.fullpath)
-		if err != nil {
-			return syscall.ENOENT
-		}
+// entryInode derives the inode of a path within a snapshot, so that
+// it stays the same across lookups and remounts.
+func entryInode(snapshotID objects.MAC, pathname string) uint64 {
+	return inode(fmt.Sprintf("%x", snapshotID), pathname)
+}
 
-		if !fi.Stat().IsDir() {
-			panic(fmt.Sprintf("unexpected type %T", fi))
-		}
+func direntType(mode fs.FileMode) fuse.DirentType {
+	switch {
+	case mode.IsDir():
+		return fuse.DT_Dir
+	case mode&os.ModeSymlink != 0:
+		return fuse.DT_Link
+	case mode&os.ModeNamedPipe != 0:
+		return fuse.DT_FIFO
+	case mode&os.ModeSocket != 0:
+		return fuse.DT_Socket
+	case mode&os.ModeCharDevice != 0:
+		return fuse.DT_Char
+	case mode&os.ModeDevice != 0:
+		return fuse.DT_Block
+	default:
+		return fuse.DT_File
+	}
+}
+
+func (n *entryNode) Attr(ctx context.Context, a *fuse.Attr) error {
+	st := n.entry.Stat()
 
-		a.Rdev = uint32(fi.Stat().Dev())
-		a.Inode = fi.Stat().Ino()
-		a.Mode = fi.Stat().Mode()
-		a.Uid = uint32(fi.Stat().Uid())
-		a.Gid = uint32(fi.Stat().Gid())
-		a.Ctime = fi.Stat().ModTime()
-		a.Mtime = fi.Stat().ModTime()
-		a.Size = uint64(fi.Stat().Size())
+	a.Inode = n.ino
+	if a.Inode == 0 {
+		a.Inode = entryInode(n.handle.snap.Header.Identifier, n.entry.Path())
+	}
+	a.Rdev = uint32(st.Dev())
+	a.Mode = st.Mode()
+	a.Uid = uint32(st.Uid())
+	a.Gid = uint32(st.Gid())
+	a.Ctime = st.ModTime()
+	a.Mtime = st.ModTime()
+	a.Atime = st.ModTime()
+	a.Size = uint64(st.Size())
+	a.Blocks = (a.Size + 511) / 512
+	a.Nlink = uint32(st.Nlink())
+	if a.Nlink == 0 {
+		a.Nlink = 1
 	}
 	return nil
 }
 
-func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
-	if d.name == "/" {
-		return &Dir{parent: d, name: name, repo: d.repo}, nil
-	} else if d.parent.name == "/" {
-		return &Dir{parent: d, name: name}, nil
-	} else {
-		cleanpath := filepath.Clean(d.fullpath + "/" + name)
-		entry, err := d.vfs.GetEntry(cleanpath)
-		if err != nil {
-			return nil, err
-		}
+func (n *entryNode) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	resp.Append(n.entry.ExtendedAttributes...)
+	return nil
+}
 
-		if entry.Stat().IsDir() {
-			return &Dir{parent: d, name: name}, nil
+func (n *entryNode) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	rd, err := n.entry.Xattr(n.handle.vfs, req.Name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fuse.ErrNoXattr
 		}
-		return &File{parent: d, name: name}, nil
+		return err
 	}
-}
 
-func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
-	if d.name == "/" {
+	resp.Xattr, err = io.ReadAll(rd)
+	return err
+}
 
-		d.repo.RebuildState()
+type Dir struct {
+	entryNode
+}
 
-		snapshotIDs, err := d.repo.GetSnapshots()
-		if err != nil {
-			return nil, err
-		}
-		dirDirs := make([]fuse.Dirent, 0)
-		for idx, snapshotID := range snapshotIDs {
-			dirDirs = append(dirDirs, fuse.Dirent{
-				Inode: uint64(idx),
-				Name:  fmt.Sprintf("%x", snapshotID),
-				Type:  fuse.DT_Dir,
-			})
+func (d *Dir) Lookup(ctx context.Context, name string) (fusefs.Node, error) {
+	entry, err := d.handle.entry(path.Join(d.entry.Path(), name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, syscall.ENOENT
 		}
-		return dirDirs, nil
+		return nil, err
 	}
+	return newEntryNode(d.fs, d.handle, entry), nil
+}
 
-	children, err := d.vfs.Children(d.fullpath)
+func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	children, err := d.handle.vfs.Children(d.entry.Path())
 	if err != nil {
 		return nil, err
 	}
 
-	dirDirs := make([]fuse.Dirent, 0)
+	snapshotID := d.handle.snap.Header.Identifier
+	dirents := make([]fuse.Dirent, 0)
 	for entry, err := range children {
 		if err != nil {
 			return nil, err
 		}
 
-		dirEnt := fuse.Dirent{
-			Inode: entry.Stat().Ino(),
+		dirents = append(dirents, fuse.Dirent{
+			Inode: entryInode(snapshotID, path.Join(d.entry.Path(), entry.Name())),
 			Name:  entry.Name(),
-			Type:  fuse.DT_File,
-		}
-		if entry.Stat().IsDir() {
-			dirEnt.Type = fuse.DT_Dir
-		}
-
-		dirDirs = append(dirDirs, dirEnt)
+			Type:  direntType(entry.Stat().Mode()),
+		})
 	}
-	return dirDirs, nil
+	return dirents, nil
 }
@@ -4,57 +4,75 @@ package plakarfs
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"io"
-	"path/filepath"
+	"io/fs"
+	"sync"
 	"syscall"
 
-	"github.com/PlakarKorp/kloset/repository"
-	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/anacrolix/fuse"
+	fusefs "github.com/anacrolix/fuse/fs"
 )
 
-// File implements both Node and Handle for the hello file.
+// File is a regular file, or any other non-directory entry that is
+// not a symlink such as a device or a fifo.
 type File struct {
-	parent   *Dir
-	name     string
-	fullpath string
-	repo     *repository.Repository
-	vfs      *vfs.Filesystem
+	entryNode
 }
 
-func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
-	f.repo = f.parent.repo
-	f.vfs = f.parent.vfs
-	f.fullpath = f.parent.fullpath + "/" + f.name
-
-	f.fullpath = filepath.Clean(f.fullpath)
+func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fusefs.Handle, error) {
+	if !f.entry.Stat().Mode().IsRegular() {
+		return nil, syscall.EACCES
+	}
 
-	entry, err := f.vfs.GetEntry(f.fullpath)
-	if err != nil {
-		return syscall.ENOENT
+	rd, ok := f.entry.Open(f.handle.vfs).(io.ReadSeekCloser)
+	if !ok {
+		return nil, syscall.EIO
 	}
 
-	if entry.Stat().IsDir() {
-		panic(fmt.Sprintf("unexpected type %T", entry))
+	// snapshots are immutable, the page cache never needs to be
+	// invalidated.
+	resp.Flags |= fuse.OpenKeepCache
+	return &fileHandle{rd: rd}, nil
+}
+
+type fileHandle struct {
+	mtx sync.Mutex
+	rd  io.ReadSeekCloser
+}
+
+func (h *fileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if _, err := h.rd.Seek(req.Offset, io.SeekStart); err != nil {
+		return err
 	}
 
-	a.Rdev = uint32(entry.Stat().Dev())
-	a.Inode = entry.Stat().Ino()
-	a.Mode = entry.Stat().Mode()
-	a.Uid = uint32(entry.Stat().Uid())
-	a.Gid = uint32(entry.Stat().Gid())
-	a.Ctime = entry.Stat().ModTime()
-	a.Mtime = entry.Stat().ModTime()
-	a.Size = uint64(entry.Stat().Size())
-	a.Nlink = uint32(entry.Stat().Nlink())
+	buf := make([]byte, req.Size)
+	n, err := io.ReadFull(h.rd, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	resp.Data = buf[:n]
 	return nil
 }
 
-func (f *File) ReadAll(ctx context.Context) ([]byte, error) {
-	rd, err := f.parent.snap.NewReader(f.fullpath)
-	if err != nil {
-		return nil, err
+func (h *fileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	err := h.rd.Close()
+	if errors.Is(err, fs.ErrClosed) {
+		return nil
 	}
-	return io.ReadAll(rd)
+	return err
+}
+
+type Symlink struct {
+	entryNode
+}
+
+func (s *Symlink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	return s.entry.SymlinkTarget, nil
 }
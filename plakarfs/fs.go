@@ -3,21 +3,203 @@
 package plakarfs
 
 import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/anacrolix/fuse/fs"
 )
 
 type FS struct {
 	repo *repository.Repository
+
+	// set when a single snapshot directory is mounted
+	snapshotID objects.MAC
+	pathname   string
+	single     bool
+
+	// timestamp of the virtual directories
+	mounted time.Time
+
+	mtx       sync.Mutex
+	snapshots map[objects.MAC]*snapshotHandle
+	headers   map[objects.MAC]*header.Header
+	catalogue []*header.Header
+}
+
+// snapshotHandle is loaded on first access to a snapshot and kept for
+// the lifetime of the mount.
+type snapshotHandle struct {
+	snap *snapshot.Snapshot
+	vfs  *vfs.Filesystem
+}
+
+func newFS(repo *repository.Repository) *FS {
+	return &FS{
+		repo:      repo,
+		mounted:   time.Now(),
+		snapshots: make(map[objects.MAC]*snapshotHandle),
+		headers:   make(map[objects.MAC]*header.Header),
+	}
 }
 
 func NewFS(repo *repository.Repository, mountpoint string) *FS {
-	fs := &FS{
-		repo: repo,
+	return newFS(repo)
+}
+
+// NewSnapshotFS returns a filesystem exposing a single directory of a
+// snapshot at the root of the mountpoint.
+func NewSnapshotFS(repo *repository.Repository, snapshotID objects.MAC, pathname string) (*FS, error) {
+	f := newFS(repo)
+	f.snapshotID = snapshotID
+	f.pathname = path.Clean("/" + pathname)
+	f.single = true
+
+	handle, err := f.snapshot(snapshotID)
+	if err != nil {
+		return nil, err
 	}
-	return fs
+	entry, err := handle.entry(f.pathname)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", f.pathname)
+	}
+	return f, nil
 }
 
 func (f *FS) Root() (fs.Node, error) {
-	return &Dir{name: "/", repo: f.repo}, nil
+	if f.single {
+		handle, err := f.snapshot(f.snapshotID)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := handle.entry(f.pathname)
+		if err != nil {
+			return nil, err
+		}
+		return &Dir{entryNode: entryNode{fs: f, handle: handle, entry: entry, ino: 1}}, nil
+	}
+	return f.rootDir(), nil
+}
+
+func (f *FS) Close() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	for id, handle := range f.snapshots {
+		handle.snap.Close()
+		delete(f.snapshots, id)
+	}
+	return nil
+}
+
+func (f *FS) snapshot(id objects.MAC) (*snapshotHandle, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if handle, ok := f.snapshots[id]; ok {
+		return handle, nil
+	}
+
+	snap, err := snapshot.Load(f.repo, id)
+	if err != nil {
+		return nil, err
+	}
+	snapfs, err := snap.Filesystem()
+	if err != nil {
+		snap.Close()
+		return nil, err
+	}
+
+	handle := &snapshotHandle{snap: snap, vfs: snapfs}
+	f.snapshots[id] = handle
+	return handle, nil
+}
+
+// entry resolves a path without following a symlink in its last
+// component, so that links are presented as such.
+func (h *snapshotHandle) entry(pathname string) (*vfs.Entry, error) {
+	tree, _, _ := h.vfs.BTrees()
+	mac, found, err := tree.Find(pathname)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, os.ErrNotExist
+	}
+	return h.vfs.ResolveEntry(mac)
+}
+
+// catalog returns the headers of the snapshots in the repository,
+// sorted by date.  The repository state is only rebuilt when refresh is
+// set, which happens when a directory is listed, and headers never
+// change so only those of new snapshots are fetched.
+func (f *FS) catalog(refresh bool) ([]*header.Header, error) {
+	if !refresh {
+		f.mtx.Lock()
+		catalogue := f.catalogue
+		f.mtx.Unlock()
+		if catalogue != nil {
+			return catalogue, nil
+		}
+	}
+
+	if err := f.repo.RebuildState(); err != nil {
+		return nil, err
+	}
+
+	snapshotIDs, err := f.repo.GetSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	headers := make([]*header.Header, 0, len(snapshotIDs))
+	for _, id := range snapshotIDs {
+		hdr, ok := f.headers[id]
+		if !ok {
+			hdr, _, err = snapshot.GetSnapshot(f.repo, id)
+			if err != nil {
+				return nil, err
+			}
+			f.headers[id] = hdr
+		}
+		headers = append(headers, hdr)
+	}
+
+	sort.Slice(headers, func(i, j int) bool {
+		return headers[i].Timestamp.Before(headers[j].Timestamp)
+	})
+	f.catalogue = headers
+	return headers, nil
+}
+
+// inode derives a stable inode number from the components identifying
+// a node, so that it survives remounts and tools such as find and rsync
+// see consistent values.
+func inode(components ...string) uint64 {
+	h := fnv.New64a()
+	for _, c := range components {
+		h.Write([]byte(c))
+		h.Write([]byte{0})
+	}
+	ino := h.Sum64()
+	if ino <= 1 {
+		// 0 is invalid and 1 is the root
+		ino += 2
+	}
+	return ino
 }
@@ -0,0 +1,217 @@
+//go:build linux || darwin
+
+package plakarfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/importer"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/anacrolix/fuse"
+	fusefs "github.com/anacrolix/fuse/fs"
+	"github.com/stretchr/testify/require"
+)
+
+func generateSnapshot(t *testing.T) (*FS, *snapshot.Snapshot) {
+	repo, _ := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	content := func(s string) func() (io.ReadCloser, error) {
+		return func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader([]byte(s))), nil
+		}
+	}
+	dir := func(pathname, name string) *importer.ScanResult {
+		return importer.NewScanRecord(pathname, "", objects.FileInfo{
+			Lname:  name,
+			Lmode:  os.ModeDir | 0755,
+			Lnlink: 1,
+		}, nil, nil)
+	}
+
+	snap := ptesting.GenerateSnapshot(t, repo, nil, ptesting.WithTags("daily"),
+		ptesting.WithGenerator(func(ch chan<- *importer.ScanResult) {
+			ch <- dir("/", "/")
+			ch <- dir("/dir", "dir")
+			ch <- importer.NewScanRecord("/dir/file.txt", "", objects.FileInfo{
+				Lname:  "file.txt",
+				Lsize:  int64(len("hello world\n")),
+				Lmode:  0644,
+				Lnlink: 1,
+			}, []string{"user.comment"}, content("hello world\n"))
+			ch <- importer.NewScanXattr("/dir/file.txt", "user.comment", objects.AttributeExtended, content("greeting"))
+			ch <- importer.NewScanRecord("/dir/link", "file.txt", objects.FileInfo{
+				Lname:  "link",
+				Lmode:  os.ModeSymlink | 0777,
+				Lnlink: 1,
+			}, nil, nil)
+			close(ch)
+		}))
+	t.Cleanup(func() { snap.Close() })
+
+	f := NewFS(repo, "")
+	t.Cleanup(func() { f.Close() })
+	return f, snap
+}
+
+func lookup(t *testing.T, node fusefs.Node, names ...string) fusefs.Node {
+	for _, name := range names {
+		var err error
+		node, err = node.(fusefs.NodeStringLookuper).Lookup(context.Background(), name)
+		require.NoError(t, err, name)
+	}
+	return node
+}
+
+func readlink(t *testing.T, node fusefs.Node) string {
+	target, err := node.(fusefs.NodeReadlinker).Readlink(context.Background(), &fuse.ReadlinkRequest{})
+	require.NoError(t, err)
+	return target
+}
+
+func attr(t *testing.T, node fusefs.Node) fuse.Attr {
+	var a fuse.Attr
+	require.NoError(t, node.Attr(context.Background(), &a))
+	return a
+}
+
+func dirents(t *testing.T, node fusefs.Node) map[string]fuse.Dirent {
+	list, err := node.(fusefs.HandleReadDirAller).ReadDirAll(context.Background())
+	require.NoError(t, err)
+
+	ret := make(map[string]fuse.Dirent)
+	for _, dirent := range list {
+		ret[dirent.Name] = dirent
+	}
+	return ret
+}
+
+func TestRootLayout(t *testing.T) {
+	f, snap := generateSnapshot(t)
+	id := fmt.Sprintf("%x", snap.Header.Identifier)
+
+	root, err := f.Root()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), attr(t, root).Inode)
+
+	children := dirents(t, root)
+	for _, name := range []string{"by-id", "by-date", "by-tag", "by-job"} {
+		require.Equal(t, fuse.DT_Dir, children[name].Type, name)
+	}
+	require.Equal(t, fuse.DT_Link, children["latest"].Type)
+	require.Equal(t, "by-id/"+id, readlink(t, lookup(t, root, "latest")))
+
+	require.Contains(t, dirents(t, lookup(t, root, "by-id")), id)
+
+	ts := snap.Header.Timestamp.Local()
+	link := lookup(t, root, "by-date", ts.Format("2006"), ts.Format("01"), ts.Format("02"), id)
+	require.Equal(t, "../../../../by-id/"+id, readlink(t, link))
+	require.Equal(t, os.ModeSymlink|0o777, attr(t, link).Mode)
+
+	tags := dirents(t, lookup(t, root, "by-tag"))
+	require.Len(t, tags, 1)
+	require.Contains(t, tags, "daily")
+	require.Equal(t, "../../by-id/"+id, readlink(t, lookup(t, root, "by-tag", "daily", id)))
+
+	job := snap.Header.Job
+	require.Len(t, dirents(t, lookup(t, root, "by-job")), 1)
+	require.Equal(t, "../../by-id/"+id, readlink(t, lookup(t, root, "by-job", job, id)))
+
+	_, err = root.(fusefs.NodeStringLookuper).Lookup(context.Background(), "nonexistent")
+	require.Error(t, err)
+}
+
+func TestInodes(t *testing.T) {
+	f, snap := generateSnapshot(t)
+	id := fmt.Sprintf("%x", snap.Header.Identifier)
+
+	root, err := f.Root()
+	require.NoError(t, err)
+
+	// lookups and listings agree, and repeated lookups are stable
+	for _, names := range [][]string{
+		{"by-id"},
+		{"by-tag", "daily"},
+		{"by-id", id},
+		{"by-id", id, "dir"},
+		{"by-id", id, "dir", "file.txt"},
+		{"by-id", id, "dir", "link"},
+	} {
+		parent := lookup(t, root, names[:len(names)-1]...)
+		ino := attr(t, lookup(t, parent, names[len(names)-1])).Inode
+		require.Equal(t, ino, attr(t, lookup(t, root, names...)).Inode, names)
+		require.Equal(t, ino, dirents(t, parent)[names[len(names)-1]].Inode, names)
+	}
+
+	// and survive a remount
+	file := attr(t, lookup(t, root, "by-id", id, "dir", "file.txt")).Inode
+	other := NewFS(f.repo, "")
+	defer other.Close()
+	root, err = other.Root()
+	require.NoError(t, err)
+	require.Equal(t, file, attr(t, lookup(t, root, "by-id", id, "dir", "file.txt")).Inode)
+
+	dir := attr(t, lookup(t, root, "by-id", id, "dir")).Inode
+	require.NotEqual(t, file, dir)
+}
+
+func TestEntries(t *testing.T) {
+	f, snap := generateSnapshot(t)
+	id := fmt.Sprintf("%x", snap.Header.Identifier)
+
+	root, err := f.Root()
+	require.NoError(t, err)
+	dir := lookup(t, root, "by-id", id, "dir")
+
+	children := dirents(t, dir)
+	require.Equal(t, fuse.DT_File, children["file.txt"].Type)
+	require.Equal(t, fuse.DT_Link, children["link"].Type)
+
+	link := lookup(t, dir, "link")
+	require.IsType(t, &Symlink{}, link)
+	require.Equal(t, "file.txt", readlink(t, link))
+	require.Equal(t, os.ModeSymlink, attr(t, link).Mode&os.ModeSymlink)
+
+	file := lookup(t, dir, "file.txt")
+	require.Equal(t, uint64(len("hello world\n")), attr(t, file).Size)
+
+	handle, err := file.(fusefs.NodeOpener).Open(context.Background(), &fuse.OpenRequest{}, &fuse.OpenResponse{})
+	require.NoError(t, err)
+	var resp fuse.ReadResponse
+	require.NoError(t, handle.(fusefs.HandleReader).Read(context.Background(), &fuse.ReadRequest{Offset: 6, Size: 64}, &resp))
+	require.Equal(t, "world\n", string(resp.Data))
+	require.NoError(t, handle.(fusefs.HandleReleaser).Release(context.Background(), &fuse.ReleaseRequest{}))
+
+	var list fuse.ListxattrResponse
+	require.NoError(t, file.(fusefs.NodeListxattrer).Listxattr(context.Background(), &fuse.ListxattrRequest{}, &list))
+	require.Equal(t, "user.comment\x00", string(list.Xattr))
+
+	var xattr fuse.GetxattrResponse
+	getxattr := file.(fusefs.NodeGetxattrer)
+	require.NoError(t, getxattr.Getxattr(context.Background(), &fuse.GetxattrRequest{Name: "user.comment"}, &xattr))
+	require.Equal(t, "greeting", string(xattr.Xattr))
+	require.ErrorIs(t, getxattr.Getxattr(context.Background(), &fuse.GetxattrRequest{Name: "user.missing"}, &xattr), fuse.ErrNoXattr)
+}
+
+func TestSnapshotFS(t *testing.T) {
+	f, snap := generateSnapshot(t)
+
+	single, err := NewSnapshotFS(f.repo, snap.Header.Identifier, "dir")
+	require.NoError(t, err)
+	defer single.Close()
+
+	root, err := single.Root()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), attr(t, root).Inode)
+	require.Contains(t, dirents(t, root), "file.txt")
+	require.Equal(t, "file.txt", readlink(t, lookup(t, root, "link")))
+
+	_, err = NewSnapshotFS(f.repo, snap.Header.Identifier, "/dir/file.txt")
+	require.Error(t, err)
+}
@@ -0,0 +1,296 @@
+//go:build linux || darwin
+
+package plakarfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/anacrolix/fuse"
+	"github.com/anacrolix/fuse/fs"
+)
+
+// The root of a repository mount is organised as follows:
+//
+//	by-id/<snapshot>/...		the content of each snapshot
+//	by-date/YYYY/MM/DD/<snapshot>	links to by-id, in local time
+//	by-tag/<tag>/<snapshot>		links to by-id
+//	by-job/<job>/<snapshot>		links to by-id
+//	latest				link to the most recent snapshot
+
+type virtualChild struct {
+	name string
+	typ  fuse.DirentType
+	ino  uint64
+	node func() (fs.Node, error)
+}
+
+type lister func(d *virtualDir, refresh bool) ([]virtualChild, error)
+
+// virtualDir is a directory whose children are computed from the
+// snapshot catalog.
+type virtualDir struct {
+	fs   *FS
+	ino  uint64
+	list lister
+}
+
+func (d *virtualDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = d.ino
+	a.Mode = os.ModeDir | 0o500
+	a.Uid = uint32(os.Geteuid())
+	a.Gid = uint32(os.Getgid())
+	a.Ctime = d.fs.mounted
+	a.Mtime = d.fs.mounted
+	a.Atime = d.fs.mounted
+	a.Nlink = 2
+	return nil
+}
+
+func (d *virtualDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	// a snapshot created since the last listing is only found after
+	// refreshing the catalog.
+	for _, refresh := range []bool{false, true} {
+		children, err := d.list(d, refresh)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			if child.name == name {
+				return child.node()
+			}
+		}
+	}
+	return nil, syscall.ENOENT
+}
+
+func (d *virtualDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	children, err := d.list(d, true)
+	if err != nil {
+		return nil, err
+	}
+
+	dirents := make([]fuse.Dirent, 0, len(children))
+	for _, child := range children {
+		dirents = append(dirents, fuse.Dirent{
+			Inode: child.ino,
+			Name:  child.name,
+			Type:  child.typ,
+		})
+	}
+	return dirents, nil
+}
+
+func (d *virtualDir) childInode(name string) uint64 {
+	return inode(strconv.FormatUint(d.ino, 10), name)
+}
+
+func (d *virtualDir) dirChild(name string, list lister) virtualChild {
+	ino := d.childInode(name)
+	return virtualChild{
+		name: name,
+		typ:  fuse.DT_Dir,
+		ino:  ino,
+		node: func() (fs.Node, error) {
+			return &virtualDir{fs: d.fs, ino: ino, list: list}, nil
+		},
+	}
+}
+
+func (d *virtualDir) linkChild(name, target string, hdr *header.Header) virtualChild {
+	ino := d.childInode(name)
+	return virtualChild{
+		name: name,
+		typ:  fuse.DT_Link,
+		ino:  ino,
+		node: func() (fs.Node, error) {
+			return &virtualLink{ino: ino, target: target, hdr: hdr}, nil
+		},
+	}
+}
+
+type virtualLink struct {
+	ino    uint64
+	target string
+	hdr    *header.Header
+}
+
+func (l *virtualLink) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = l.ino
+	a.Mode = os.ModeSymlink | 0o777
+	a.Uid = uint32(os.Geteuid())
+	a.Gid = uint32(os.Getgid())
+	a.Ctime = l.hdr.Timestamp
+	a.Mtime = l.hdr.Timestamp
+	a.Atime = l.hdr.Timestamp
+	a.Size = uint64(len(l.target))
+	a.Nlink = 1
+	return nil
+}
+
+func (l *virtualLink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	return l.target, nil
+}
+
+// sanitize turns a tag or job name into a valid file name.
+func sanitize(name string) string {
+	name = strings.ReplaceAll(name, "/", "_")
+	if name == "." || name == ".." {
+		name = strings.Repeat("_", len(name))
+	}
+	return name
+}
+
+func (f *FS) rootDir() *virtualDir {
+	return &virtualDir{fs: f, ino: 1, list: f.listRoot}
+}
+
+func (f *FS) listRoot(d *virtualDir, refresh bool) ([]virtualChild, error) {
+	children := []virtualChild{
+		d.dirChild("by-id", f.listByID),
+		d.dirChild("by-date", f.listByDate(nil)),
+		d.dirChild("by-tag", f.listGroups(func(hdr *header.Header) []string {
+			return hdr.Tags
+		})),
+		d.dirChild("by-job", f.listGroups(func(hdr *header.Header) []string {
+			if hdr.Job == "" {
+				return nil
+			}
+			return []string{hdr.Job}
+		})),
+	}
+
+	headers, err := f.catalog(refresh)
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) != 0 {
+		latest := headers[len(headers)-1]
+		children = append(children, d.linkChild("latest", fmt.Sprintf("by-id/%x", latest.Identifier), latest))
+	}
+	return children, nil
+}
+
+func (f *FS) listByID(d *virtualDir, refresh bool) ([]virtualChild, error) {
+	headers, err := f.catalog(refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	children := make([]virtualChild, 0, len(headers))
+	for _, hdr := range headers {
+		snapshotID := hdr.Identifier
+		children = append(children, virtualChild{
+			name: fmt.Sprintf("%x", snapshotID),
+			typ:  fuse.DT_Dir,
+			ino:  entryInode(snapshotID, "/"),
+			node: func() (fs.Node, error) {
+				return f.snapshotRoot(snapshotID)
+			},
+		})
+	}
+	return children, nil
+}
+
+func (f *FS) snapshotRoot(snapshotID objects.MAC) (fs.Node, error) {
+	handle, err := f.snapshot(snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := handle.entry("/")
+	if err != nil {
+		return nil, err
+	}
+	return newEntryNode(f, handle, entry), nil
+}
+
+// listByDate lists the years, months or days holding snapshots depending
+// on how deep in the by-date hierarchy the directory is, and the
+// snapshots themselves at the deepest level.
+func (f *FS) listByDate(components []string) lister {
+	return func(d *virtualDir, refresh bool) ([]virtualChild, error) {
+		headers, err := f.catalog(refresh)
+		if err != nil {
+			return nil, err
+		}
+
+		children := make([]virtualChild, 0)
+		seen := make(map[string]bool)
+		for _, hdr := range headers {
+			ts := hdr.Timestamp.Local()
+			date := []string{ts.Format("2006"), ts.Format("01"), ts.Format("02")}
+			if !slices.Equal(date[:len(components)], components) {
+				continue
+			}
+
+			if len(components) == len(date) {
+				name := fmt.Sprintf("%x", hdr.Identifier)
+				target := strings.Repeat("../", len(date)+1) + "by-id/" + name
+				children = append(children, d.linkChild(name, target, hdr))
+				continue
+			}
+
+			name := date[len(components)]
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			children = append(children, d.dirChild(name, f.listByDate(append(slices.Clone(components), name))))
+		}
+		return children, nil
+	}
+}
+
+// listGroups lists the groups snapshots belong to, such as their tags,
+// and in each of them links to the snapshots of that group.
+func (f *FS) listGroups(groups func(*header.Header) []string) lister {
+	return func(d *virtualDir, refresh bool) ([]virtualChild, error) {
+		headers, err := f.catalog(refresh)
+		if err != nil {
+			return nil, err
+		}
+
+		children := make([]virtualChild, 0)
+		seen := make(map[string]bool)
+		for _, hdr := range headers {
+			for _, group := range groups(hdr) {
+				group = sanitize(group)
+				if group == "" || seen[group] {
+					continue
+				}
+				seen[group] = true
+				children = append(children, d.dirChild(group, f.listGroup(group, groups)))
+			}
+		}
+		return children, nil
+	}
+}
+
+func (f *FS) listGroup(group string, groups func(*header.Header) []string) lister {
+	return func(d *virtualDir, refresh bool) ([]virtualChild, error) {
+		headers, err := f.catalog(refresh)
+		if err != nil {
+			return nil, err
+		}
+
+		children := make([]virtualChild, 0)
+		for _, hdr := range headers {
+			for _, g := range groups(hdr) {
+				if sanitize(g) != group {
+					continue
+				}
+				name := fmt.Sprintf("%x", hdr.Identifier)
+				children = append(children, d.linkChild(name, "../../by-id/"+name, hdr))
+				break
+			}
+		}
+		return children, nil
+	}
+}
@@ -0,0 +1,277 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package s3 builds the S3 clients shared by the storage, importer and
+// exporter connectors from their configuration:
+//
+//	access_key, secret_access_key, session_token
+//		static credentials.  When no access_key is configured,
+//		credentials are looked up in the environment, then in the
+//		AWS credentials file (credentials_file, profile), then
+//		through web identity, container or instance metadata.
+//	region		region of the bucket, detected if unset
+//	bucket_lookup	auto, dns or path
+//	use_tls		defaults to true
+//	ca_bundle	PEM file of additional certificate authorities
+//	sse		none, sse-s3, sse-kms or sse-c
+//	sse_kms_key_id	KMS key used with sse-kms
+//	sse_c_key	base64-encoded 256-bit key used with sse-c
+//	part_size	size of the parts of multipart uploads, 16MiB by
+//			default and 5MiB at least
+//	part_concurrency	number of parts uploaded in parallel, 4
+//			by default
+package s3
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+)
+
+// Client is a minio client that knows which server-side encryption
+// and multipart options to pass along with requests.
+type Client struct {
+	*minio.Client
+
+	sse encrypt.ServerSide
+
+	partSize        uint64
+	partConcurrency uint
+}
+
+func Connect(location *url.URL, params map[string]string) (*Client, error) {
+	useTls := true
+	if value, ok := params["use_tls"]; ok {
+		tmp, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid use_tls value")
+		}
+		useTls = tmp
+	}
+
+	creds, err := credentialsFromConfig(params)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup, err := bucketLookup(params["bucket_lookup"])
+	if err != nil {
+		return nil, err
+	}
+
+	sse, err := serverSideEncryption(params)
+	if err != nil {
+		return nil, err
+	}
+	if sse != nil && sse.Type() == encrypt.SSEC && !useTls {
+		return nil, fmt.Errorf("sse-c requires use_tls")
+	}
+
+	partSize, partConcurrency, err := multipart(params)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := minio.DefaultTransport(useTls)
+	if err != nil {
+		return nil, fmt.Errorf("create transport: %w", err)
+	}
+	if bundle := params["ca_bundle"]; bundle != "" {
+		pem, err := os.ReadFile(bundle)
+		if err != nil {
+			return nil, fmt.Errorf("read ca_bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in ca_bundle %s", bundle)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	region := params["region"]
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+
+	// Initialize minio client object.
+	client, err := minio.New(location.Host, &minio.Options{
+		Creds:        creds,
+		Secure:       useTls,
+		Region:       region,
+		BucketLookup: lookup,
+		Transport:    transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create minio client: %w", err)
+	}
+
+	return &Client{
+		Client:          client,
+		sse:             sse,
+		partSize:        partSize,
+		partConcurrency: partConcurrency,
+	}, nil
+}
+
+func multipart(params map[string]string) (uint64, uint, error) {
+	var partSize uint64 = 16 * humanize.MiByte
+	if value, ok := params["part_size"]; ok {
+		size, err := humanize.ParseBytes(value)
+		if err != nil || size < 5*humanize.MiByte {
+			return 0, 0, fmt.Errorf("invalid part_size value")
+		}
+		partSize = size
+	}
+
+	var partConcurrency uint = 4
+	if value, ok := params["part_concurrency"]; ok {
+		n, err := strconv.ParseUint(value, 10, 16)
+		if err != nil || n == 0 {
+			return 0, 0, fmt.Errorf("invalid part_concurrency value")
+		}
+		partConcurrency = uint(n)
+	}
+	return partSize, partConcurrency, nil
+}
+
+func credentialsFromConfig(params map[string]string) (*credentials.Credentials, error) {
+	if accessKey, ok := params["access_key"]; ok {
+		secretAccessKey, ok := params["secret_access_key"]
+		if !ok {
+			return nil, fmt.Errorf("missing secret_access_key")
+		}
+		return credentials.NewStaticV4(accessKey, secretAccessKey, params["session_token"]), nil
+	}
+	if _, ok := params["secret_access_key"]; ok {
+		return nil, fmt.Errorf("missing access_key")
+	}
+
+	file := &credentials.FileAWSCredentials{
+		Filename: params["credentials_file"],
+		Profile:  params["profile"],
+	}
+
+	// an explicitly requested profile takes precedence over the
+	// environment.
+	providers := []credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		file,
+	}
+	if file.Profile != "" {
+		providers = []credentials.Provider{
+			file,
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+		}
+	}
+	providers = append(providers, &credentials.IAM{})
+
+	return credentials.NewChainCredentials(providers), nil
+}
+
+func bucketLookup(value string) (minio.BucketLookupType, error) {
+	switch strings.ToLower(value) {
+	case "", "auto":
+		return minio.BucketLookupAuto, nil
+	case "dns", "virtual-host":
+		return minio.BucketLookupDNS, nil
+	case "path":
+		return minio.BucketLookupPath, nil
+	default:
+		return minio.BucketLookupAuto, fmt.Errorf("invalid bucket_lookup value")
+	}
+}
+
+func serverSideEncryption(params map[string]string) (encrypt.ServerSide, error) {
+	switch strings.ToLower(params["sse"]) {
+	case "", "none":
+		return nil, nil
+
+	case "sse-s3", "aes256":
+		return encrypt.NewSSE(), nil
+
+	case "sse-kms", "aws:kms":
+		return encrypt.NewSSEKMS(params["sse_kms_key_id"], nil)
+
+	case "sse-c":
+		value, ok := params["sse_c_key"]
+		if !ok {
+			return nil, fmt.Errorf("missing sse_c_key")
+		}
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sse_c_key: %w", err)
+		}
+		return encrypt.NewSSEC(key)
+
+	default:
+		return nil, fmt.Errorf("invalid sse value")
+	}
+}
+
+// PutObjectOptions returns opts with the server-side encryption and the
+// multipart upload settings set.  Parts are read into memory so that
+// they can be sent in parallel whether the size is known or not.
+func (c *Client) PutObjectOptions(opts minio.PutObjectOptions) minio.PutObjectOptions {
+	opts.ServerSideEncryption = c.sse
+	opts.PartSize = c.partSize
+	opts.NumThreads = c.partConcurrency
+	opts.ConcurrentStreamParts = c.partConcurrency > 1
+	return opts
+}
+
+// IsTransient tells the errors worth retrying: the server failing or
+// throttling requests, on top of network errors.
+func IsTransient(err error) bool {
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) {
+		switch resp.Code {
+		case "RequestTimeout", "SlowDown", "InternalError", "ServiceUnavailable":
+			return true
+		}
+		return resp.StatusCode >= http.StatusInternalServerError ||
+			resp.StatusCode == http.StatusRequestTimeout ||
+			resp.StatusCode == http.StatusTooManyRequests
+	}
+	return plakarstorage.IsTransient(err)
+}
+
+// GetObjectOptions returns the options needed to read or stat an
+// object.  Only SSE-C requires the key to be sent again on reads.
+func (c *Client) GetObjectOptions() minio.GetObjectOptions {
+	opts := minio.GetObjectOptions{}
+	if c.sse != nil && c.sse.Type() == encrypt.SSEC {
+		opts.ServerSideEncryption = c.sse
+	}
+	return opts
+}
@@ -0,0 +1,128 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectInvalidConfig(t *testing.T) {
+	location, err := url.Parse("s3://localhost:9000/bucket")
+	require.NoError(t, err)
+
+	for _, params := range []map[string]string{
+		{"access_key": "key"},
+		{"secret_access_key": "secret"},
+		{"use_tls": "maybe"},
+		{"bucket_lookup": "sideways"},
+		{"sse": "rot13"},
+		{"sse": "sse-c"},
+		{"sse": "sse-c", "sse_c_key": base64.StdEncoding.EncodeToString([]byte("short"))},
+		{"sse": "sse-c", "sse_c_key": base64.StdEncoding.EncodeToString(make([]byte, 32)), "use_tls": "false"},
+		{"ca_bundle": filepath.Join(t.TempDir(), "missing.pem")},
+		{"part_size": "1MiB"},
+		{"part_concurrency": "0"},
+	} {
+		_, err := Connect(location, params)
+		require.Error(t, err, "%v", params)
+	}
+}
+
+func TestMultipartOptions(t *testing.T) {
+	location, err := url.Parse("s3://localhost:9000/bucket")
+	require.NoError(t, err)
+
+	client, err := Connect(location, map[string]string{})
+	require.NoError(t, err)
+	opts := client.PutObjectOptions(minio.PutObjectOptions{})
+	require.Equal(t, uint64(16<<20), opts.PartSize)
+	require.Equal(t, uint(4), opts.NumThreads)
+	require.True(t, opts.ConcurrentStreamParts)
+
+	client, err = Connect(location, map[string]string{"part_size": "64MiB", "part_concurrency": "1"})
+	require.NoError(t, err)
+	opts = client.PutObjectOptions(minio.PutObjectOptions{})
+	require.Equal(t, uint64(64<<20), opts.PartSize)
+	require.False(t, opts.ConcurrentStreamParts)
+}
+
+func TestServerSideEncryptionOptions(t *testing.T) {
+	location, err := url.Parse("s3://localhost:9000/bucket")
+	require.NoError(t, err)
+
+	client, err := Connect(location, map[string]string{"sse": "sse-kms", "sse_kms_key_id": "key"})
+	require.NoError(t, err)
+	require.Equal(t, encrypt.KMS, client.PutObjectOptions(minio.PutObjectOptions{}).ServerSideEncryption.Type())
+	require.Nil(t, client.GetObjectOptions().ServerSideEncryption)
+
+	client, err = Connect(location, map[string]string{
+		"sse":       "sse-c",
+		"sse_c_key": base64.StdEncoding.EncodeToString(make([]byte, 32)),
+	})
+	require.NoError(t, err)
+	require.Equal(t, encrypt.SSEC, client.PutObjectOptions(minio.PutObjectOptions{}).ServerSideEncryption.Type())
+	require.Equal(t, encrypt.SSEC, client.GetObjectOptions().ServerSideEncryption.Type())
+}
+
+func TestConnectWithCredentialChainAndCABundle(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "access")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	faker := gofakes3.New(s3mem.New())
+	ts := httptest.NewTLSServer(faker.Server())
+	defer ts.Close()
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: ts.Certificate().Raw,
+	}), 0600))
+
+	location, err := url.Parse(ts.URL + "/testbucket")
+	require.NoError(t, err)
+
+	// without the bundle, the self-signed certificate is rejected
+	client, err := Connect(location, map[string]string{"bucket_lookup": "path"})
+	require.NoError(t, err)
+	err = client.MakeBucket(context.Background(), "testbucket", minio.MakeBucketOptions{})
+	require.Error(t, err)
+
+	client, err = Connect(location, map[string]string{
+		"bucket_lookup": "path",
+		"region":        "eu-west-3",
+		"ca_bundle":     bundle,
+	})
+	require.NoError(t, err)
+
+	creds, err := credentialsFromConfig(map[string]string{})
+	require.NoError(t, err)
+	value, err := creds.Get()
+	require.NoError(t, err)
+	require.Equal(t, "access", value.AccessKeyID)
+
+	ctx := context.Background()
+	require.NoError(t, client.MakeBucket(ctx, "testbucket", minio.MakeBucketOptions{}))
+
+	_, err = client.PutObject(ctx, "testbucket", "hello", bytes.NewReader([]byte("world")), 5,
+		client.PutObjectOptions(minio.PutObjectOptions{}))
+	require.NoError(t, err)
+
+	object, err := client.GetObject(ctx, "testbucket", "hello", client.GetObjectOptions())
+	require.NoError(t, err)
+	data, err := io.ReadAll(object)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(data))
+}
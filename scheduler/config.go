@@ -39,6 +39,7 @@ type BackupConfig struct {
 	Interval  time.Duration `validate:"required"`
 	Check     BackupConfigCheck
 	Retention time.Duration
+	Sign      string
 }
 
 // CheckDecodeHook is a mapstructure decode hook to allow users to specify
@@ -140,9 +141,11 @@ func DurationDecodeHook() mapstructure.DecodeHookFunc {
 }
 
 type SyncConfig struct {
-	Peer      string        `validate:"required"`
-	Direction SyncDirection `validate:"required"`
-	Interval  time.Duration `validate:"required"`
+	Peer        string        `validate:"required"`
+	Direction   SyncDirection `validate:"required"`
+	Interval    time.Duration `validate:"required"`
+	Concurrency uint64
+	Mirror      bool
 }
 
 type MaintenanceConfig struct {
@@ -1,14 +1,16 @@
 package scheduler
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
-	"github.com/PlakarKorp/kloset/encryption"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/kloset/versioning"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/identity"
+	"github.com/PlakarKorp/plakar/keyring"
 	"github.com/PlakarKorp/plakar/subcommands/backup"
 	"github.com/PlakarKorp/plakar/subcommands/check"
 	"github.com/PlakarKorp/plakar/subcommands/maintenance"
@@ -41,19 +43,25 @@ func loadRepository(newCtx *appcontext.AppContext, name string) (*repository.Rep
 	}
 
 	if passphrase, ok := storeConfig["passphrase"]; ok {
-		key, err := encryption.DeriveKey(repoConfig.Encryption.KDFParams, []byte(passphrase))
-		if err != nil {
-			store.Close()
-			return nil, nil, fmt.Errorf("error deriving key: %w", err)
-		}
-		if !encryption.VerifyCanary(repoConfig.Encryption, key) {
+		key, err := keyring.Unlock(store, repoConfig.Encryption, []byte(passphrase))
+		if errors.Is(err, keyring.ErrCantUnlock) {
 			store.Close()
 			return nil, nil, fmt.Errorf("invalid passphrase")
+		} else if err != nil {
+			store.Close()
+			return nil, nil, fmt.Errorf("error deriving key: %w", err)
 		}
 		newCtx.SetSecret(key)
 	}
 
-	repo, err := repository.New(newCtx.GetInner(), newCtx.GetSecret(), store, config)
+	repoStore, secret, err := keyring.OpenStore(store, config, newCtx.GetSecret(), newCtx.CacheDir)
+	if err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+	store = repoStore
+
+	repo, err := repository.New(newCtx.GetInner(), secret, store, config)
 	if err != nil {
 		store.Close()
 		return nil, store, fmt.Errorf("unable to open repository: %w", err)
@@ -81,15 +89,26 @@ func (s *Scheduler) backupTask(taskset Task, task BackupConfig) {
 		case <-s.ctx.Done():
 			return
 		case <-tick:
-			repo, store, err := loadRepository(s.ctx, taskset.Repository)
+			signer, err := identity.NewManager(s.ctx.ConfigDir).Signer(task.Sign)
+			if err != nil {
+				s.ctx.GetLogger().Error("Error loading signing identity: %s", err)
+				continue
+			}
+			backupSubcommand.Identity = signer
+
+			// the signing identity is set on the repository
+			// context, don't share it with the other tasks.
+			taskCtx := appcontext.NewAppContextFrom(s.ctx)
+			repo, store, err := loadRepository(taskCtx, taskset.Repository)
 			if err != nil {
 				s.ctx.GetLogger().Error("Error loading repository: %s", err)
+				taskCtx.Close()
 				continue
 			}
 			reporter := s.NewTaskReporter(s.ctx, repo, "backup", taskset.Name, taskset.Repository)
 
 			var reportWarning error
-			if retval, err, snapId, warning := backupSubcommand.DoBackup(s.ctx, repo); err != nil || retval != 0 {
+			if retval, err, snapId, warning := backupSubcommand.DoBackup(taskCtx, repo); err != nil || retval != 0 {
 				s.ctx.GetLogger().Error("Error creating backup: %s", err)
 				reporter.TaskFailed(1, "Error creating backup: retval=%d, err=%s", retval, err)
 				goto close
@@ -115,6 +134,7 @@ func (s *Scheduler) backupTask(taskset Task, task BackupConfig) {
 		close:
 			repo.Close()
 			store.Close()
+			taskCtx.Close()
 		}
 	}
 }
@@ -206,6 +226,19 @@ func (s *Scheduler) syncTask(taskset Task, task SyncConfig) {
 		s.ctx.Cancel()
 		return
 	}
+	syncSubcommand.SrcLocateOptions = utils.NewDefaultLocateOptions()
+	syncSubcommand.Concurrency = task.Concurrency
+	if syncSubcommand.Concurrency == 0 {
+		syncSubcommand.Concurrency = uint64(s.ctx.MaxConcurrency)
+	}
+	syncSubcommand.Mirror = task.Mirror && task.Direction != SyncDirectionWith
+
+	var progress sync.Event
+	syncSubcommand.Progress = func(ev sync.Event) {
+		progress = ev
+		s.ctx.GetLogger().Info("sync: %s %x: %d/%d done, %d failed",
+			ev.Action, ev.SnapshotID[:4], ev.Done, ev.Total, ev.Failed)
+	}
 	//	if taskset.Repository.Passphrase != "" {
 	//		syncSubcommand.DestinationRepositorySecret = []byte(taskset.Repository.Passphrase)
 	//		_ = syncSubcommand.DestinationRepositorySecret
@@ -227,8 +260,12 @@ func (s *Scheduler) syncTask(taskset Task, task SyncConfig) {
 			}
 			reporter := s.NewTaskReporter(s.ctx, repo, "sync", taskset.Name, taskset.Repository)
 
+			progress = sync.Event{}
 			retval, err := syncSubcommand.Execute(s.ctx, repo)
-			if err != nil || retval != 0 {
+			if progress.Failed != 0 && progress.Done != 0 {
+				s.ctx.GetLogger().Warn("sync: %s", err)
+				reporter.TaskWarning("Partial synchronization: %d of %d snapshots failed", progress.Failed, progress.Total)
+			} else if err != nil || retval != 0 {
 				s.ctx.GetLogger().Error("sync: %s", err)
 				reporter.TaskFailed(1, "Error executing sync: retval=%d, err=%s", retval, err)
 			} else {
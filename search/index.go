@@ -0,0 +1,303 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package search
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const INDEX_VERSION = 1
+
+var ErrIndexVersion = errors.New("unsupported content index version")
+
+// Index maps the trigrams found in the lowercased content of the text
+// files of a snapshot to the files holding them.
+type Index struct {
+	Version  int                 `msgpack:"version"`
+	Snapshot objects.MAC         `msgpack:"snapshot"`
+	MaxSize  int64               `msgpack:"max_size"`
+	Paths    []string            `msgpack:"paths"`
+	Trigrams map[uint32][]uint32 `msgpack:"trigrams"`
+}
+
+func trigram(a, b, c byte) uint32 {
+	return uint32(a)<<16 | uint32(b)<<8 | uint32(c)
+}
+
+func trigrams(data []byte) map[uint32]struct{} {
+	data = bytes.ToLower(data)
+	set := make(map[uint32]struct{})
+	for i := 0; i+3 <= len(data); i++ {
+		set[trigram(data[i], data[i+1], data[i+2])] = struct{}{}
+	}
+	return set
+}
+
+// BuildIndex reads every text file of the snapshot once per distinct
+// content and indexes it.
+func BuildIndex(ctx context.Context, snap *snapshot.Snapshot, maxSize int64) (*Index, error) {
+	if maxSize == 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &Index{
+		Version:  INDEX_VERSION,
+		Snapshot: snap.Header.Identifier,
+		MaxSize:  maxSize,
+		Trigrams: make(map[uint32][]uint32),
+	}
+
+	// files sharing the same content are only read once
+	byObject := make(map[objects.MAC][]uint32)
+	objectPaths := make([]objects.MAC, 0)
+	for entry, err := range fs.Files("/") {
+		if err != nil {
+			return nil, err
+		}
+		if !searchable(entry, maxSize) {
+			continue
+		}
+
+		pathIdx := uint32(len(idx.Paths))
+		idx.Paths = append(idx.Paths, entry.Path())
+		if _, ok := byObject[entry.Object]; !ok {
+			objectPaths = append(objectPaths, entry.Object)
+		}
+		byObject[entry.Object] = append(byObject[entry.Object], pathIdx)
+	}
+
+	for _, object := range objectPaths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		pathIdxs := byObject[object]
+		data, err := readContent(snap, idx.Paths[pathIdxs[0]])
+		if err != nil {
+			return nil, err
+		}
+		if isBinary(data) {
+			continue
+		}
+		for tri := range trigrams(data) {
+			idx.Trigrams[tri] = append(idx.Trigrams[tri], pathIdxs...)
+		}
+	}
+
+	for tri := range idx.Trigrams {
+		slices.Sort(idx.Trigrams[tri])
+	}
+	return idx, nil
+}
+
+// literals returns the literal strings that any match of the expression
+// must contain.
+func literals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{string(re.Rune)}
+	case syntax.OpCapture, syntax.OpPlus:
+		return literals(re.Sub[0])
+	case syntax.OpConcat:
+		var ret []string
+		for _, sub := range re.Sub {
+			ret = append(ret, literals(sub)...)
+		}
+		return ret
+	}
+	return nil
+}
+
+// Lookup returns the files that may match re.  It returns false when
+// the expression has no literal long enough to use the index, in which
+// case every file has to be searched.
+func (idx *Index) Lookup(re *regexp.Regexp) ([]string, bool) {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return nil, false
+	}
+
+	var required []uint32
+	for _, literal := range literals(parsed.Simplify()) {
+		for tri := range trigrams([]byte(literal)) {
+			required = append(required, tri)
+		}
+	}
+	if len(required) == 0 {
+		return nil, false
+	}
+
+	var result []uint32
+	for i, tri := range required {
+		postings := idx.Trigrams[tri]
+		if i == 0 {
+			result = slices.Clone(postings)
+			continue
+		}
+		result = intersect(result, postings)
+		if len(result) == 0 {
+			break
+		}
+	}
+
+	paths := make([]string, 0, len(result))
+	for _, pathIdx := range result {
+		paths = append(paths, idx.Paths[pathIdx])
+	}
+	return paths, true
+}
+
+func intersect(a, b []uint32) []uint32 {
+	ret := a[:0]
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			ret = append(ret, a[i])
+			i++
+			j++
+		}
+	}
+	return ret
+}
+
+// IndexCache stores the content indexes of a repository in the local
+// cache directory, one file per snapshot.
+type IndexCache struct {
+	dir string
+}
+
+func NewIndexCache(cacheDir string, repositoryID uuid.UUID) *IndexCache {
+	return &IndexCache{
+		dir: filepath.Join(cacheDir, "search", repositoryID.String()),
+	}
+}
+
+func (c *IndexCache) path(snapshotID objects.MAC) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%x.idx", snapshotID))
+}
+
+// Get returns the index of a snapshot, or an error satisfying
+// errors.Is(err, fs.ErrNotExist) if it was never built.
+func (c *IndexCache) Get(snapshotID objects.MAC) (*Index, error) {
+	data, err := os.ReadFile(c.path(snapshotID))
+	if err != nil {
+		return nil, err
+	}
+
+	var idx Index
+	if err := msgpack.Unmarshal(data, &idx); err != nil {
+		return nil, err
+	}
+	if idx.Version != INDEX_VERSION {
+		return nil, ErrIndexVersion
+	}
+	return &idx, nil
+}
+
+func (c *IndexCache) Put(idx *Index) error {
+	data, err := msgpack.Marshal(idx)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(c.dir, "idx.*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(idx.Snapshot))
+}
+
+// Delete evicts the index of a snapshot, if it was ever built.
+func (c *IndexCache) Delete(snapshotID objects.MAC) error {
+	if err := os.Remove(c.path(snapshotID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// GetOrBuild returns the cached index of the snapshot, building and
+// caching it first if needed.  An index built with a smaller maxSize
+// lacks the larger files and is rebuilt.
+func (c *IndexCache) GetOrBuild(ctx context.Context, snap *snapshot.Snapshot, maxSize int64) (*Index, error) {
+	if maxSize == 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	idx, err := c.Get(snap.Header.Identifier)
+	if err == nil && idx.MaxSize >= maxSize {
+		return idx, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, ErrIndexVersion) {
+		return nil, err
+	}
+
+	idx, err = BuildIndex(ctx, snap, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Put(idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// ParsePattern compiles a grep pattern, quoting it first when it is a
+// fixed string.
+func ParsePattern(pattern string, fixed, ignoreCase bool) (*regexp.Regexp, error) {
+	if fixed {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
@@ -0,0 +1,217 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package search implements full-text search over the content of the
+// text files of a snapshot, optionally narrowed by a trigram index kept
+// in the local cache.
+package search
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"iter"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+)
+
+// DefaultMaxSize is the size above which files are neither indexed nor
+// searched.
+const DefaultMaxSize = 8 << 20
+
+// content types that are searched besides text/*
+var textContentTypes = map[string]bool{
+	"application/javascript": true,
+	"application/json":       true,
+	"application/toml":       true,
+	"application/x-sh":       true,
+	"application/x-yaml":     true,
+	"application/xml":        true,
+	"application/yaml":       true,
+}
+
+// IsText reports whether files of the given content type are searched.
+func IsText(contentType string) bool {
+	contentType, _, _ = strings.Cut(contentType, ";")
+	contentType = strings.TrimSpace(strings.ToLower(contentType))
+	return strings.HasPrefix(contentType, "text/") || textContentTypes[contentType]
+}
+
+// isBinary looks for a NUL byte at the start of the content, which
+// filters out files whose content type was misdetected.
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) != -1
+}
+
+type Options struct {
+	// files larger than MaxSize are skipped, DefaultMaxSize if zero
+	MaxSize int64
+
+	// when set, only the files that may match according to the index
+	// are read
+	Index *Index
+}
+
+func (o *Options) maxSize() int64 {
+	if o == nil || o.MaxSize == 0 {
+		return DefaultMaxSize
+	}
+	return o.MaxSize
+}
+
+type Match struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+func searchable(entry *vfs.Entry, maxSize int64) bool {
+	if !entry.Stat().Mode().IsRegular() || entry.Size() > maxSize {
+		return false
+	}
+	return entry.ResolvedObject != nil && IsText(entry.ResolvedObject.ContentType)
+}
+
+func readContent(snap *snapshot.Snapshot, pathname string) ([]byte, error) {
+	rd, err := snap.NewReader(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	return io.ReadAll(rd)
+}
+
+// candidates yields the searchable entries below prefix, restricted to
+// those the index designates when one is given.
+func candidates(snap *snapshot.Snapshot, prefix string, re *regexp.Regexp, opts *Options) iter.Seq2[*vfs.Entry, error] {
+	return func(yield func(*vfs.Entry, error) bool) {
+		fs, err := snap.Filesystem()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		if opts != nil && opts.Index != nil {
+			paths, ok := opts.Index.Lookup(re)
+			if ok {
+				for _, pathname := range paths {
+					if !isBelow(prefix, pathname) {
+						continue
+					}
+					entry, err := fs.GetEntry(pathname)
+					if err != nil {
+						if !yield(nil, err) {
+							return
+						}
+						continue
+					}
+					if searchable(entry, opts.maxSize()) && !yield(entry, nil) {
+						return
+					}
+				}
+				return
+			}
+		}
+
+		root, err := fs.GetEntry(prefix)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		if !root.IsDir() {
+			if searchable(root, opts.maxSize()) {
+				yield(root, nil)
+			}
+			return
+		}
+
+		for entry, err := range fs.Files(prefix) {
+			if err != nil {
+				if !yield(nil, err) {
+					return
+				}
+				continue
+			}
+			if searchable(entry, opts.maxSize()) && !yield(entry, nil) {
+				return
+			}
+		}
+	}
+}
+
+func isBelow(prefix, pathname string) bool {
+	prefix = path.Clean(prefix)
+	if prefix == "/" || pathname == prefix {
+		return true
+	}
+	return strings.HasPrefix(pathname, prefix+"/")
+}
+
+// Grep yields the lines of the text files below prefix that match re.
+func Grep(ctx context.Context, snap *snapshot.Snapshot, prefix string, re *regexp.Regexp, opts *Options) iter.Seq2[*Match, error] {
+	if prefix == "" {
+		prefix = "/"
+	}
+
+	return func(yield func(*Match, error) bool) {
+		for entry, err := range candidates(snap, prefix, re, opts) {
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+			if err != nil {
+				if !yield(nil, err) {
+					return
+				}
+				continue
+			}
+
+			pathname := entry.Path()
+			data, err := readContent(snap, pathname)
+			if err != nil {
+				if !yield(nil, err) {
+					return
+				}
+				continue
+			}
+			if isBinary(data) || !re.Match(data) {
+				continue
+			}
+
+			scanner := bufio.NewScanner(bytes.NewReader(data))
+			scanner.Buffer(nil, len(data)+1)
+			lineno := 0
+			for scanner.Scan() {
+				lineno++
+				if !re.Match(scanner.Bytes()) {
+					continue
+				}
+				if !yield(&Match{Path: pathname, Line: lineno, Text: scanner.Text()}, nil) {
+					return
+				}
+			}
+		}
+	}
+}
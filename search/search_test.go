@@ -0,0 +1,139 @@
+package search
+
+import (
+	"context"
+	"os"
+	"path"
+	"regexp"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/snapshot"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func generateSnapshot(t *testing.T) *snapshot.Snapshot {
+	repo, _ := ptesting.GenerateRepository(t, nil, nil, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("etc"),
+		ptesting.NewMockDir("home"),
+		ptesting.NewMockFile("etc/app.conf", 0644, "listen = 0.0.0.0\nSecret = hunter2\n"),
+		ptesting.NewMockFile("etc/other.conf", 0644, "listen = 127.0.0.1\n"),
+		ptesting.NewMockFile("home/notes.txt", 0644, "remember the secret handshake\n"),
+		ptesting.NewMockFile("home/copy.txt", 0644, "remember the secret handshake\n"),
+	})
+	t.Cleanup(func() { snap.Close() })
+	return snap
+}
+
+func grep(t *testing.T, snap *snapshot.Snapshot, prefix string, re *regexp.Regexp, opts *Options) []Match {
+	var matches []Match
+	for match, err := range Grep(context.Background(), snap, prefix, re, opts) {
+		require.NoError(t, err)
+		matches = append(matches, *match)
+	}
+	return matches
+}
+
+func TestIsText(t *testing.T) {
+	require.True(t, IsText("text/plain; charset=utf-8"))
+	require.True(t, IsText("application/json"))
+	require.False(t, IsText("application/octet-stream"))
+	require.False(t, IsText("image/png"))
+}
+
+func TestGrep(t *testing.T) {
+	snap := generateSnapshot(t)
+
+	re, err := ParsePattern("secret", false, false)
+	require.NoError(t, err)
+	matches := grep(t, snap, "/", re, nil)
+	require.Len(t, matches, 2)
+	for _, match := range matches {
+		require.Equal(t, 1, match.Line)
+		require.Equal(t, "remember the secret handshake", match.Text)
+	}
+
+	re, err = ParsePattern("secret", false, true)
+	require.NoError(t, err)
+	require.Len(t, grep(t, snap, "/", re, nil), 3)
+
+	// restricted to a subtree
+	matches = grep(t, snap, path.Dir(matches[0].Path), re, nil)
+	require.Len(t, matches, 2)
+
+	re, err = ParsePattern("0.0.0.0", true, false)
+	require.NoError(t, err)
+	matches = grep(t, snap, "/", re, nil)
+	require.Len(t, matches, 1)
+	require.Equal(t, "app.conf", path.Base(matches[0].Path))
+
+	// files above the size limit are skipped
+	require.Len(t, grep(t, snap, "/", re, &Options{MaxSize: 4}), 0)
+}
+
+func TestIndex(t *testing.T) {
+	snap := generateSnapshot(t)
+
+	idx, err := BuildIndex(context.Background(), snap, 0)
+	require.NoError(t, err)
+	require.Len(t, idx.Paths, 4)
+
+	re, err := ParsePattern("hunter2", false, false)
+	require.NoError(t, err)
+	paths, ok := idx.Lookup(re)
+	require.True(t, ok)
+	require.Len(t, paths, 1)
+	require.Equal(t, "app.conf", path.Base(paths[0]))
+
+	re, err = ParsePattern("Handshake", false, true)
+	require.NoError(t, err)
+	paths, ok = idx.Lookup(re)
+	require.True(t, ok)
+	require.Len(t, paths, 2)
+
+	// no literal to narrow down the search with
+	re, err = ParsePattern("[0-9]+", false, false)
+	require.NoError(t, err)
+	_, ok = idx.Lookup(re)
+	require.False(t, ok)
+
+	re, err = ParsePattern("listen", false, false)
+	require.NoError(t, err)
+	require.Len(t, grep(t, snap, "/", re, &Options{Index: idx}), 2)
+
+	re, err = ParsePattern("nowhere to be found", false, false)
+	require.NoError(t, err)
+	require.Len(t, grep(t, snap, "/", re, &Options{Index: idx}), 0)
+
+	cache := NewIndexCache(t.TempDir(), uuid.New())
+	_, err = cache.Get(snap.Header.Identifier)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, cache.Put(idx))
+	cached, err := cache.Get(snap.Header.Identifier)
+	require.NoError(t, err)
+	require.Equal(t, idx.Paths, cached.Paths)
+	require.Equal(t, idx.Trigrams, cached.Trigrams)
+
+	require.NoError(t, cache.Delete(snap.Header.Identifier))
+	_, err = cache.Get(snap.Header.Identifier)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.NoError(t, cache.Delete(snap.Header.Identifier))
+
+	// an index built with a smaller max size is not reused for a larger one
+	cache = NewIndexCache(t.TempDir(), uuid.New())
+	small, err := cache.GetOrBuild(context.Background(), snap, 4)
+	require.NoError(t, err)
+	require.Empty(t, small.Paths)
+
+	cached, err = cache.GetOrBuild(context.Background(), snap, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(DefaultMaxSize), cached.MaxSize)
+	require.Len(t, cached.Paths, 4)
+
+	cached, err = cache.GetOrBuild(context.Background(), snap, 4)
+	require.NoError(t, err)
+	require.Len(t, cached.Paths, 4)
+}
@@ -17,6 +17,10 @@ var store storage.Store
 var ctx context.Context
 var lNoDelete bool
 
+// openStore is set when the repository is chosen by the client, as
+// over stdio, rather than by the server.
+var openStore func(pathname string) (storage.Store, error)
+
 func openRepository(w http.ResponseWriter, r *http.Request) {
 	var reqOpen network.ReqOpen
 	if err := json.NewDecoder(r.Body).Decode(&reqOpen); err != nil {
@@ -24,13 +28,23 @@ func openRepository(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var resOpen network.ResOpen
+	if openStore != nil {
+		st, err := openStore(reqOpen.Repository)
+		if err != nil {
+			resOpen.Err = err.Error()
+			json.NewEncoder(w).Encode(resOpen)
+			return
+		}
+		store = st
+	}
+
 	serializedConfig, err := store.Open(ctx)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	var resOpen network.ResOpen
 	resOpen.Configuration = serializedConfig
 	resOpen.Err = ""
 	if err := json.NewEncoder(w).Encode(resOpen); err != nil {
@@ -326,6 +340,16 @@ func Server(repo *repository.Repository, addr string, noDelete bool) error {
 	store = repo.Store()
 	ctx = repo.AppContext()
 
+	server := &http.Server{Addr: addr, Handler: handler()}
+	go func() {
+		<-repo.AppContext().Done()
+		server.Shutdown(repo.AppContext().Context)
+	}()
+
+	return server.ListenAndServe()
+}
+
+func handler() http.Handler {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("GET /", openRepository)
@@ -346,11 +370,5 @@ func Server(repo *repository.Repository, addr string, noDelete bool) error {
 	mux.HandleFunc("GET /lock", getLock)
 	mux.HandleFunc("DELETE /lock", deleteLock)
 
-	server := &http.Server{Addr: addr, Handler: mux}
-	go func() {
-		<-repo.AppContext().Done()
-		server.Shutdown(repo.AppContext().Context)
-	}()
-
-	return server.ListenAndServe()
+	return mux
 }
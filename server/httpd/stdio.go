@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/kcontext"
+	"github.com/PlakarKorp/kloset/storage"
+)
+
+// ServeStdio serves the requests read from rd on wr until rd is closed,
+// which is how the ssh connector talks to a plakar run on the remote
+// host.  The repository served is the one the client asks for, which
+// must be below jail if set.
+func ServeStdio(kctx *kcontext.KContext, rd io.Reader, wr io.Writer, noDelete bool, jail string) error {
+	lNoDelete = noDelete
+	ctx = kctx
+
+	if jail != "" {
+		resolved, err := filepath.EvalSymlinks(jail)
+		if err != nil {
+			return err
+		}
+		jail = resolved
+	}
+
+	openStore = func(pathname string) (storage.Store, error) {
+		pathname, err := jailed(jail, pathname)
+		if err != nil {
+			return nil, err
+		}
+		return storage.New(kctx, map[string]string{
+			"location": "fs:" + pathname,
+		})
+	}
+
+	listener := &stdioListener{
+		conn: &stdioConn{
+			Reader: rd,
+			Writer: wr,
+			closed: make(chan struct{}),
+		},
+	}
+
+	server := &http.Server{Handler: handler()}
+	go func() {
+		<-kctx.Done()
+		server.Close()
+	}()
+
+	err := server.Serve(listener)
+	if store != nil {
+		store.Close()
+	}
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// jailed returns the path of the repository requested by the client,
+// refusing it if it resolves outside of jail.  Relative paths are taken
+// from the jail, or from the current directory without one.
+func jailed(jail, pathname string) (string, error) {
+	if pathname == "" {
+		return "", fmt.Errorf("missing repository path")
+	}
+	if jail == "" {
+		return pathname, nil
+	}
+
+	if !filepath.IsAbs(pathname) {
+		pathname = filepath.Join(jail, pathname)
+	}
+	resolved, err := filepath.EvalSymlinks(pathname)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(jail, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: not within %s", pathname, jail)
+	}
+	return resolved, nil
+}
+
+// stdioListener hands out a single connection, then blocks until it is
+// closed to report the listener closed as well.
+type stdioListener struct {
+	conn     *stdioConn
+	accepted sync.Once
+}
+
+func (l *stdioListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.accepted.Do(func() {
+		conn = l.conn
+	})
+	if conn != nil {
+		return conn, nil
+	}
+
+	<-l.conn.closed
+	return nil, net.ErrClosed
+}
+
+func (l *stdioListener) Close() error {
+	return l.conn.Close()
+}
+
+func (l *stdioListener) Addr() net.Addr {
+	return stdioAddr{}
+}
+
+type stdioConn struct {
+	io.Reader
+	io.Writer
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (c *stdioConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *stdioConn) LocalAddr() net.Addr                { return stdioAddr{} }
+func (c *stdioConn) RemoteAddr() net.Addr               { return stdioAddr{} }
+func (c *stdioConn) SetDeadline(t time.Time) error      { return nil }
+func (c *stdioConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *stdioConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string { return "stdio" }
+func (stdioAddr) String() string  { return "stdio" }
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+)
+
+// Pool spreads the requests made to a server over several connections,
+// which are dialed on first use and dialed again once lost.  Writes are
+// pipelined: up to the pipeline parameter (64 by default) requests are
+// in flight per file.  The connections parameter sets the size of the
+// pool, 4 by default.
+type Pool struct {
+	endpoint *url.URL
+	params   map[string]string
+	opts     []sftp.ClientOption
+
+	mtx     sync.Mutex
+	signers []ssh.Signer
+	clients []*sftp.Client
+	next    int
+}
+
+func NewPool(endpoint *url.URL, params map[string]string) (*Pool, error) {
+	connections := 4
+	if value, ok := params["connections"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid connections value")
+		}
+		connections = n
+	}
+
+	pipeline := 64
+	if value, ok := params["pipeline"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid pipeline value")
+		}
+		pipeline = n
+	}
+
+	return &Pool{
+		endpoint: endpoint,
+		params:   params,
+		opts: []sftp.ClientOption{
+			sftp.UseConcurrentWrites(true),
+			sftp.MaxConcurrentRequestsPerFile(pipeline),
+		},
+		clients: make([]*sftp.Client, connections),
+	}, nil
+}
+
+// Client returns the next connection of the pool.  Dialing is done with
+// the pool locked so that the user is asked about the host key or the
+// passphrase of the identity only once.
+func (p *Pool) Client() (*sftp.Client, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	slot := p.next
+	p.next = (p.next + 1) % len(p.clients)
+
+	if client := p.clients[slot]; client != nil {
+		return client, nil
+	}
+
+	client, err := connect(p.endpoint, p.params, p.loadSigners, p.opts...)
+	if err != nil {
+		return nil, err
+	}
+	p.clients[slot] = client
+
+	go func() {
+		client.Wait()
+
+		p.mtx.Lock()
+		defer p.mtx.Unlock()
+		if p.clients[slot] == client {
+			p.clients[slot] = nil
+		}
+	}()
+
+	return client, nil
+}
+
+// loadSigners is only called while dialing, with the pool locked.
+func (p *Pool) loadSigners(keyPath string) ([]ssh.Signer, error) {
+	if p.signers == nil {
+		signers, err := loadSigners(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		p.signers = signers
+	}
+	return p.signers, nil
+}
+
+func (p *Pool) Close() error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	var errs []error
+	for i, client := range p.clients {
+		if client == nil {
+			continue
+		}
+		if err := client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		p.clients[i] = nil
+	}
+	return errors.Join(errs...)
+}
+
+// IsTransient tells the errors worth retrying: a lost connection, which
+// the pool replaces on the next request, on top of network errors.
+func IsTransient(err error) bool {
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) {
+		return true
+	}
+	return plakarstorage.IsTransient(err)
+}
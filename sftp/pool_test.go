@@ -0,0 +1,46 @@
+package sftp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolReconnects(t *testing.T) {
+	server, err := ptesting.NewMockSFTPServer(t)
+	require.NoError(t, err)
+	defer server.Close()
+
+	endpoint, err := url.Parse("sftp://" + server.Addr + "/")
+	require.NoError(t, err)
+
+	pool, err := NewPool(endpoint, map[string]string{
+		"identity":                 server.KeyFile,
+		"insecure_ignore_host_key": "true",
+		"connections":              "1",
+	})
+	require.NoError(t, err)
+	defer pool.Close()
+
+	client, err := pool.Client()
+	require.NoError(t, err)
+	_, err = client.Getwd()
+	require.NoError(t, err)
+
+	// a single connection is handed out round-robin
+	again, err := pool.Client()
+	require.NoError(t, err)
+	require.Same(t, client, again)
+
+	// a lost connection is replaced on the next request
+	client.Close()
+	require.Eventually(t, func() bool {
+		again, err = pool.Client()
+		return err == nil && again != client
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = again.Getwd()
+	require.NoError(t, err)
+}
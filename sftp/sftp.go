@@ -35,7 +35,31 @@ type config struct {
 	proxyCmd           string
 }
 
-func Connect(endpoint *url.URL, params map[string]string) (*sftp.Client, error) {
+func Connect(endpoint *url.URL, params map[string]string, opts ...sftp.ClientOption) (*sftp.Client, error) {
+	return connect(endpoint, params, loadSigners, opts...)
+}
+
+func connect(endpoint *url.URL, params map[string]string, signers func(string) ([]ssh.Signer, error), opts ...sftp.ClientOption) (*sftp.Client, error) {
+	client, err := dial(endpoint, params, signers)
+	if err != nil {
+		return nil, err
+	}
+
+	sftpClient, err := sftp.NewClient(client, opts...)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return sftpClient, nil
+}
+
+// Dial opens an SSH connection to the endpoint, honoring the ssh config,
+// the known hosts and the proxy command as the sftp connectors do.
+func Dial(endpoint *url.URL, params map[string]string) (*ssh.Client, error) {
+	return dial(endpoint, params, loadSigners)
+}
+
+func dial(endpoint *url.URL, params map[string]string, signers func(string) ([]ssh.Signer, error)) (*ssh.Client, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get home directory: %v", err)
@@ -91,7 +115,7 @@ func Connect(endpoint *url.URL, params map[string]string) (*sftp.Client, error)
 		User: config.user,
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
-				return loadSigners(config.identity)
+				return signers(config.identity)
 			}),
 		},
 		HostKeyCallback: hostKeyCallback,
@@ -100,14 +124,7 @@ func Connect(endpoint *url.URL, params map[string]string) (*sftp.Client, error)
 		return nil, fmt.Errorf("ssh handshake failed: %w", err)
 	}
 
-	client := ssh.NewClient(sshClientConn, chans, reqs)
-
-	sftpClient, err := sftp.NewClient(client)
-	if err != nil {
-		client.Close()
-		return nil, err
-	}
-	return sftpClient, nil
+	return ssh.NewClient(sshClientConn, chans, reqs), nil
 }
 
 func parseconfig(home string, params map[string]string, endpoint *url.URL) (*config, error) {
@@ -0,0 +1,353 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/dustin/go-humanize"
+)
+
+// ParseBlockCacheSize reads the cache_size parameter of a store
+// configuration, the size up to which the packfile blobs read are kept
+// on disk.  The cache is disabled unless it is set.
+func ParseBlockCacheSize(config map[string]string) (int64, error) {
+	value, ok := config["cache_size"]
+	if !ok {
+		return 0, nil
+	}
+	size, err := humanize.ParseBytes(value)
+	if err != nil || size > 1<<62 {
+		return 0, fmt.Errorf("invalid cache_size value")
+	}
+	return int64(size), nil
+}
+
+// BlockCacheStats counts the blobs read from the cache and from the
+// store, since the cache was created.
+type BlockCacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	HitBytes  int64 `json:"hit_bytes"`
+	MissBytes int64 `json:"miss_bytes"`
+	Evictions int64 `json:"evictions"`
+	Size      int64 `json:"-"`
+	Limit     int64 `json:"-"`
+}
+
+func (s BlockCacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// BlockCache wraps a store to keep the packfile blobs it reads in a
+// directory, evicting the least recently used ones past its limit.
+// Blobs never change once written, so a cached copy is always valid.
+//
+// The directory may be shared by several processes, the CLI and the
+// agent: entries are written atomically and their modification time
+// serves as the recency of use, so the size tracked by each process is
+// only an estimate that eviction corrects.
+type BlockCache struct {
+	storage.Store
+	dir   string
+	limit int64
+
+	size     atomic.Int64
+	evicting atomic.Bool
+
+	mtx   sync.Mutex
+	stats BlockCacheStats
+}
+
+// WithBlockCache returns store unchanged if limit is 0.
+func WithBlockCache(store storage.Store, dir string, limit int64) (storage.Store, error) {
+	if limit <= 0 {
+		return store, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	c := &BlockCache{Store: store, dir: dir, limit: limit}
+	size, err := c.scan(func(string, fs.FileInfo) {})
+	if err != nil {
+		return nil, err
+	}
+	c.size.Store(size)
+	return c, nil
+}
+
+// Unwrap returns the wrapped store.
+func (c *BlockCache) Unwrap() storage.Store {
+	return c.Store
+}
+
+// IsBlockCache returns the BlockCache of a store, looking through the
+// wrappers around it.
+func IsBlockCache(store storage.Store) (*BlockCache, bool) {
+	return find[*BlockCache](store)
+}
+
+func (c *BlockCache) path(mac objects.MAC, offset uint64, length uint32) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%02x", mac[0]),
+		fmt.Sprintf("%064x-%d-%d", mac, offset, length))
+}
+
+func (c *BlockCache) GetPackfileBlob(mac objects.MAC, offset uint64, length uint32) (io.Reader, error) {
+	pathname := c.path(mac, offset, length)
+
+	data, err := os.ReadFile(pathname)
+	if err == nil && len(data) == int(length) {
+		now := time.Now()
+		os.Chtimes(pathname, now, now)
+		c.count(true, len(data))
+		return bytes.NewReader(data), nil
+	}
+
+	rd, err := c.Store.GetPackfileBlob(mac, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	data, err = io.ReadAll(rd)
+	if closer, ok := rd.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.count(false, len(data))
+
+	// a failure to cache the blob only costs reading it again
+	if len(data) == int(length) && writeFile(pathname, data) == nil {
+		if c.size.Add(int64(len(data))) > c.limit {
+			c.evict()
+		}
+	}
+	return bytes.NewReader(data), nil
+}
+
+// DeletePackfile drops the blobs of the packfile, which can't be read
+// anymore.
+func (c *BlockCache) DeletePackfile(mac objects.MAC) error {
+	if err := c.Store.DeletePackfile(mac); err != nil {
+		return err
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(c.dir, fmt.Sprintf("%02x", mac[0]), fmt.Sprintf("%064x-*", mac)))
+	for _, match := range matches {
+		if fi, err := os.Stat(match); err == nil && os.Remove(match) == nil {
+			c.size.Add(-fi.Size())
+		}
+	}
+	return nil
+}
+
+func (c *BlockCache) count(hit bool, n int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if hit {
+		c.stats.Hits++
+		c.stats.HitBytes += int64(n)
+	} else {
+		c.stats.Misses++
+		c.stats.MissBytes += int64(n)
+	}
+}
+
+// writeFile writes a file atomically, creating its directory.
+func writeFile(pathname string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(pathname), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(pathname), "tmp.")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), pathname); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+type cacheEntry struct {
+	path  string
+	size  int64
+	mtime time.Time
+}
+
+// scan walks the entries of the cache, removing the temporary files
+// left behind by an interrupted process, and returns their total size.
+func (c *BlockCache) scan(fn func(string, fs.FileInfo)) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(c.dir, func(pathname string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || d.Name() == "STATS" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), "tmp.") {
+			if time.Since(info.ModTime()) > time.Hour {
+				os.Remove(pathname)
+			}
+			return nil
+		}
+		size += info.Size()
+		fn(pathname, info)
+		return nil
+	})
+	return size, err
+}
+
+// evict removes the least recently used entries until the cache is back
+// to 90% of its limit, leaving room for a while before the next round.
+func (c *BlockCache) evict() {
+	if !c.evicting.CompareAndSwap(false, true) {
+		return
+	}
+	defer c.evicting.Store(false)
+
+	var entries []cacheEntry
+	size, err := c.scan(func(pathname string, info fs.FileInfo) {
+		entries = append(entries, cacheEntry{pathname, info.Size(), info.ModTime()})
+	})
+	if err != nil {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].mtime.Before(entries[j].mtime)
+	})
+
+	target := c.limit / 10 * 9
+	var evictions int64
+	for _, entry := range entries {
+		if size <= target {
+			break
+		}
+		if os.Remove(entry.path) == nil {
+			size -= entry.size
+			evictions++
+		}
+	}
+	c.size.Store(size)
+
+	c.mtx.Lock()
+	c.stats.Evictions += evictions
+	c.mtx.Unlock()
+}
+
+// Stats returns the statistics of the cache, those of this process
+// added to the ones saved by the others.
+func (c *BlockCache) Stats() BlockCacheStats {
+	stats := c.loadStats()
+
+	c.mtx.Lock()
+	stats.Hits += c.stats.Hits
+	stats.Misses += c.stats.Misses
+	stats.HitBytes += c.stats.HitBytes
+	stats.MissBytes += c.stats.MissBytes
+	stats.Evictions += c.stats.Evictions
+	c.mtx.Unlock()
+
+	stats.Size = c.size.Load()
+	stats.Limit = c.limit
+	return stats
+}
+
+func (c *BlockCache) loadStats() BlockCacheStats {
+	var stats BlockCacheStats
+	data, err := os.ReadFile(filepath.Join(c.dir, "STATS"))
+	if err == nil {
+		json.Unmarshal(data, &stats)
+	}
+	return stats
+}
+
+// saveStats adds the statistics of this process to the saved ones.  Two
+// processes closing at once may lose the counts of one of them, which
+// is fine for statistics.
+func (c *BlockCache) saveStats() error {
+	stats := c.Stats()
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(c.dir, "STATS"), data); err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	c.stats = BlockCacheStats{}
+	c.mtx.Unlock()
+	return nil
+}
+
+func (c *BlockCache) Close() error {
+	return errors.Join(c.saveStats(), c.Store.Close())
+}
+
+// OpenBlockCache wraps the store opened for a repository with the block
+// cache configured for it, kept under cacheDir so that the CLI and the
+// agent share it.
+func OpenBlockCache(store storage.Store, storeConfig map[string]string, cacheDir string, serializedConfig []byte) (storage.Store, error) {
+	limit, err := ParseBlockCacheSize(storeConfig)
+	if err != nil || limit == 0 {
+		return store, err
+	}
+
+	config, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+	if err != nil {
+		return nil, err
+	}
+	return WithBlockCache(store, filepath.Join(cacheDir, "blocks", config.RepositoryID.String()), limit)
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// blobStore serves the blobs of in-memory packfiles and counts the reads
+// that reach it.
+type blobStore struct {
+	storage.Store
+
+	reads  int
+	closed bool
+	data   map[objects.MAC][]byte
+}
+
+func (s *blobStore) GetPackfileBlob(mac objects.MAC, offset uint64, length uint32) (io.Reader, error) {
+	s.reads++
+	data, ok := s.data[mac]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return bytes.NewReader(data[offset : offset+uint64(length)]), nil
+}
+
+func (s *blobStore) DeletePackfile(mac objects.MAC) error {
+	delete(s.data, mac)
+	return nil
+}
+
+func (s *blobStore) Close() error {
+	s.closed = true
+	return nil
+}
+
+func readBlob(t *testing.T, store storage.Store, mac objects.MAC, offset uint64, length uint32) string {
+	rd, err := store.GetPackfileBlob(mac, offset, length)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestParseBlockCacheSize(t *testing.T) {
+	size, err := ParseBlockCacheSize(map[string]string{})
+	require.NoError(t, err)
+	require.Equal(t, int64(0), size)
+
+	size, err = ParseBlockCacheSize(map[string]string{"cache_size": "2MiB"})
+	require.NoError(t, err)
+	require.Equal(t, int64(2<<20), size)
+
+	_, err = ParseBlockCacheSize(map[string]string{"cache_size": "lots"})
+	require.EqualError(t, err, "invalid cache_size value")
+}
+
+func TestBlockCache(t *testing.T) {
+	mac := objects.MAC{0x01}
+	backend := &blobStore{data: map[objects.MAC][]byte{mac: []byte("0123456789")}}
+
+	store, err := WithBlockCache(backend, t.TempDir(), 0)
+	require.NoError(t, err)
+	require.Same(t, backend, store)
+
+	dir := t.TempDir()
+	store, err = WithBlockCache(backend, dir, 1024)
+	require.NoError(t, err)
+	cache := store.(*BlockCache)
+	require.Same(t, backend, cache.Unwrap())
+
+	found, ok := IsBlockCache(WithRetry(store, DefaultRetryPolicy()))
+	require.True(t, ok)
+	require.Same(t, cache, found)
+	_, ok = IsBlockCache(backend)
+	require.False(t, ok)
+
+	require.Equal(t, "2345", readBlob(t, store, mac, 2, 4))
+	require.Equal(t, "2345", readBlob(t, store, mac, 2, 4))
+	require.Equal(t, "234", readBlob(t, store, mac, 2, 3))
+	require.Equal(t, 2, backend.reads)
+
+	stats := cache.Stats()
+	require.Equal(t, int64(1), stats.Hits)
+	require.Equal(t, int64(2), stats.Misses)
+	require.Equal(t, int64(7), stats.Size)
+	require.InDelta(t, 1.0/3, stats.HitRate(), 0.001)
+
+	// a truncated entry is fetched again
+	require.NoError(t, os.Truncate(cache.path(mac, 2, 4), 1))
+	require.Equal(t, "2345", readBlob(t, store, mac, 2, 4))
+	require.Equal(t, 3, backend.reads)
+
+	require.NoError(t, store.DeletePackfile(mac))
+	matches, err := filepath.Glob(filepath.Join(dir, "01", "*"))
+	require.NoError(t, err)
+	require.Empty(t, matches)
+
+	// the statistics are kept across processes sharing the directory
+	require.NoError(t, store.Close())
+	require.True(t, backend.closed)
+
+	store, err = WithBlockCache(backend, dir, 1024)
+	require.NoError(t, err)
+	stats = store.(*BlockCache).Stats()
+	require.Equal(t, int64(1), stats.Hits)
+	require.Equal(t, int64(3), stats.Misses)
+	require.Equal(t, int64(0), stats.Size)
+}
+
+func TestBlockCacheEviction(t *testing.T) {
+	backend := &blobStore{data: make(map[objects.MAC][]byte)}
+	for i := range 4 {
+		backend.data[objects.MAC{byte(i)}] = bytes.Repeat([]byte{byte(i)}, 100)
+	}
+
+	store, err := WithBlockCache(backend, t.TempDir(), 350)
+	require.NoError(t, err)
+	cache := store.(*BlockCache)
+
+	for i := range 3 {
+		readBlob(t, store, objects.MAC{byte(i)}, 0, 100)
+		past := time.Now().Add(time.Duration(i-10) * time.Minute)
+		require.NoError(t, os.Chtimes(cache.path(objects.MAC{byte(i)}, 0, 100), past, past))
+	}
+
+	// a hit makes the first blob the most recently used one
+	readBlob(t, store, objects.MAC{0}, 0, 100)
+	readBlob(t, store, objects.MAC{3}, 0, 100)
+
+	stats := cache.Stats()
+	require.Equal(t, int64(300), stats.Size)
+	require.Equal(t, int64(1), stats.Evictions)
+
+	_, err = os.Stat(cache.path(objects.MAC{1}, 0, 100))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	for _, i := range []byte{0, 2, 3} {
+		_, err = os.Stat(cache.path(objects.MAC{i}, 0, 100))
+		require.NoError(t, err)
+	}
+}
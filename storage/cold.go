@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+)
+
+var ErrArchived = errors.New("packfile is archived")
+
+// ArchivedError is returned when reading a packfile that is not thawed.
+type ArchivedError struct {
+	Packfile objects.MAC
+}
+
+func (e *ArchivedError) Error() string {
+	return fmt.Sprintf("%x: %s", e.Packfile, ErrArchived)
+}
+
+func (e *ArchivedError) Is(target error) bool {
+	return target == ErrArchived
+}
+
+// Thawer is implemented by the stores that can archive their
+// packfiles: a packfile can only be read once it has been thawed.
+type Thawer interface {
+	// ColdStorage reports whether the store archives its packfiles.
+	ColdStorage() bool
+
+	// Thaw requests a readable copy of an archived packfile, unless
+	// one was already requested, and reports whether it is readable.
+	Thaw(mac objects.MAC) (bool, error)
+}
+
+// IsColdStorage returns the Thawer of a store, or of the store it
+// wraps, if it archives its packfiles.
+func IsColdStorage(store storage.Store) (Thawer, bool) {
+	thawer, ok := find[Thawer](store)
+	if !ok || !thawer.ColdStorage() {
+		return nil, false
+	}
+	return thawer, true
+}
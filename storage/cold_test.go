@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/stretchr/testify/require"
+)
+
+type archiveStore struct {
+	storage.Store
+
+	archived bool
+}
+
+func (s *archiveStore) ColdStorage() bool {
+	return s.archived
+}
+
+func (s *archiveStore) Thaw(mac objects.MAC) (bool, error) {
+	return true, nil
+}
+
+func TestIsColdStorage(t *testing.T) {
+	backend := &archiveStore{archived: true}
+
+	thawer, ok := IsColdStorage(WithRetry(backend, DefaultRetryPolicy()))
+	require.True(t, ok)
+	require.Equal(t, backend, thawer)
+
+	backend.archived = false
+	_, ok = IsColdStorage(backend)
+	require.False(t, ok)
+
+	_, ok = IsColdStorage(&flakyStore{})
+	require.False(t, ok)
+}
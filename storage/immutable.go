@@ -0,0 +1,56 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package storage holds the extensions to the kloset storage interface
+// that some of the plakar connectors implement.
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/storage"
+)
+
+var ErrRetained = errors.New("object is under retention")
+
+// Retainer is implemented by the stores that can write states and
+// packfiles under retention.  Deleting them is deferred until their
+// retention expires, which callers must be prepared for.
+type Retainer interface {
+	// Immutable reports whether the store writes under retention.
+	Immutable() bool
+
+	// Retention returns the date until which a state or a packfile
+	// can't be deleted, the zero time if it can be deleted now.
+	Retention(rtype resources.Type, mac objects.MAC) (time.Time, error)
+
+	// ExtendRetention pushes the retention of a state or a packfile
+	// still in use to what the retention policy gives from now.
+	ExtendRetention(rtype resources.Type, mac objects.MAC) error
+}
+
+// IsImmutable returns the Retainer of a store, or of the store it
+// wraps, if it writes under retention.
+func IsImmutable(store storage.Store) (Retainer, bool) {
+	retainer, ok := find[Retainer](store)
+	if !ok || !retainer.Immutable() {
+		return nil, false
+	}
+	return retainer, true
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/stretchr/testify/require"
+)
+
+type retainStore struct {
+	storage.Store
+
+	immutable bool
+}
+
+func (s *retainStore) Immutable() bool {
+	return s.immutable
+}
+
+func (s *retainStore) Retention(rtype resources.Type, mac objects.MAC) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (s *retainStore) ExtendRetention(rtype resources.Type, mac objects.MAC) error {
+	return nil
+}
+
+func TestIsImmutable(t *testing.T) {
+	backend := &retainStore{immutable: true}
+
+	retainer, ok := IsImmutable(backend)
+	require.True(t, ok)
+	require.Equal(t, backend, retainer)
+
+	// found through the wrappers
+	retainer, ok = IsImmutable(WithRetry(backend, DefaultRetryPolicy()))
+	require.True(t, ok)
+	require.Equal(t, backend, retainer)
+
+	backend.immutable = false
+	_, ok = IsImmutable(WithRetry(backend, DefaultRetryPolicy()))
+	require.False(t, ok)
+
+	_, ok = IsImmutable(&flakyStore{})
+	require.False(t, ok)
+}
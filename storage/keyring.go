@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package storage
+
+import (
+	"io"
+
+	"github.com/PlakarKorp/kloset/storage"
+)
+
+// KeyringStore is implemented by the stores that keep the key slots of
+// an encrypted repository next to its configuration.  Unlike the
+// configuration, the keyring is rewritten as slots are changed.
+type KeyringStore interface {
+	// GetKeyring returns fs.ErrNotExist if no keyring was saved.
+	GetKeyring() (io.Reader, error)
+	PutKeyring(rd io.Reader) error
+}
+
+// HasKeyring returns the KeyringStore of a store, looking through the
+// wrappers around it.
+func HasKeyring(store storage.Store) (KeyringStore, bool) {
+	return find[KeyringStore](store)
+}
+
+// find returns the first of a store and the stores it wraps that
+// implements T.
+func find[T any](store storage.Store) (T, bool) {
+	for {
+		if impl, ok := store.(T); ok {
+			return impl, true
+		}
+		wrapper, ok := store.(interface{ Unwrap() storage.Store })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		store = wrapper.Unwrap()
+	}
+}
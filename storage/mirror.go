@@ -0,0 +1,49 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package storage
+
+import (
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+)
+
+// MemberReport tells what a member of a mirror misses of the states and
+// packfiles found on the other members.
+type MemberReport struct {
+	Member    string
+	Err       error
+	States    []objects.MAC
+	Packfiles []objects.MAC
+}
+
+func (r *MemberReport) InSync() bool {
+	return r.Err == nil && len(r.States) == 0 && len(r.Packfiles) == 0
+}
+
+// Reconciler is implemented by the stores writing to several members,
+// which may diverge when a write fails on some of them.
+type Reconciler interface {
+	// Reconcile lists the content of every member and reports what
+	// each of them misses.
+	Reconcile() ([]MemberReport, error)
+}
+
+// IsMirror returns the Reconciler of a store, looking through the
+// wrappers around it.
+func IsMirror(store storage.Store) (Reconciler, bool) {
+	return find[Reconciler](store)
+}
@@ -0,0 +1,254 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package storage
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/compression"
+	"github.com/PlakarKorp/kloset/encryption"
+	"github.com/PlakarKorp/kloset/hashing"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/dustin/go-humanize"
+	"gopkg.in/yaml.v3"
+)
+
+// Profile holds the parameters of a new repository, from a built-in
+// profile or a YAML file, overridden by the options of plakar create
+// and plakar ptar.  The parameters left empty keep the defaults.
+//
+// There is no compression level: the repositories compress at the
+// default level of their algorithm, whatever their configuration says.
+type Profile struct {
+	Hashing      string `yaml:"hashing"`
+	Compression  string `yaml:"compression"`
+	KDF          string `yaml:"kdf"`
+	KDFMemory    string `yaml:"kdf_memory"`
+	KDFTime      uint   `yaml:"kdf_time"`
+	KDFThreads   uint   `yaml:"kdf_threads"`
+	Chunking     string `yaml:"chunking"`
+	ChunkMinSize string `yaml:"chunk_min_size"`
+	ChunkAvgSize string `yaml:"chunk_avg_size"`
+	ChunkMaxSize string `yaml:"chunk_max_size"`
+	PackfileSize string `yaml:"packfile_size"`
+}
+
+var profiles = map[string]Profile{
+	"default": {},
+
+	// repositories written once and rarely read: the better ratio
+	// and few, large packfiles
+	"archive": {
+		Compression:  "gzip",
+		ChunkMinSize: "256KiB",
+		ChunkAvgSize: "4MiB",
+		ChunkMaxSize: "16MiB",
+		PackfileSize: "128MiB",
+	},
+
+	// laptops: cheap compression and a KDF that unlocks quickly
+	"fast": {
+		Compression:  "lz4",
+		KDF:          "argon2id",
+		KDFMemory:    "64MiB",
+		KDFTime:      2,
+		KDFThreads:   4,
+		PackfileSize: "8MiB",
+	},
+}
+
+// LoadProfile returns the built-in profile called name, or the one in
+// the YAML file at that path.
+func LoadProfile(name string) (*Profile, error) {
+	if profile, ok := profiles[name]; ok {
+		return &profile, nil
+	}
+
+	fp, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown profile %s: %w", name, err)
+	}
+	defer fp.Close()
+
+	var profile Profile
+	decoder := yaml.NewDecoder(fp)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&profile); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &profile, nil
+}
+
+// Flags registers the options overriding a profile, to be merged into
+// it once parsed.
+func (p *Profile) Flags(flags *flag.FlagSet) {
+	flags.StringVar(&p.Hashing, "hashing", "", "hashing algorithm to use for digests")
+	flags.StringVar(&p.Compression, "compression", "", "compression algorithm: lz4, gzip or none")
+	flags.StringVar(&p.KDF, "kdf", "", "key derivation function: argon2id, scrypt or pbkdf2")
+	flags.StringVar(&p.KDFMemory, "kdf-memory", "", "memory used by argon2id")
+	flags.UintVar(&p.KDFTime, "kdf-time", 0, "number of passes of argon2id")
+	flags.UintVar(&p.KDFThreads, "kdf-threads", 0, "number of threads of argon2id")
+	flags.StringVar(&p.Chunking, "chunking", "", "chunking algorithm: fastcdc or ultracdc")
+	flags.StringVar(&p.ChunkMinSize, "chunk-min-size", "", "minimum size of the chunks")
+	flags.StringVar(&p.ChunkAvgSize, "chunk-avg-size", "", "average size of the chunks")
+	flags.StringVar(&p.ChunkMaxSize, "chunk-max-size", "", "maximum size of the chunks")
+	flags.StringVar(&p.PackfileSize, "packfile-size", "", "maximum size of the packfiles")
+}
+
+// Merge overrides the parameters of p with the ones set in other.
+func (p *Profile) Merge(other *Profile) {
+	merge := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	merge(&p.Hashing, other.Hashing)
+	merge(&p.Compression, other.Compression)
+	merge(&p.KDF, other.KDF)
+	merge(&p.KDFMemory, other.KDFMemory)
+	merge(&p.Chunking, other.Chunking)
+	merge(&p.ChunkMinSize, other.ChunkMinSize)
+	merge(&p.ChunkAvgSize, other.ChunkAvgSize)
+	merge(&p.ChunkMaxSize, other.ChunkMaxSize)
+	merge(&p.PackfileSize, other.PackfileSize)
+
+	if other.KDFTime != 0 {
+		p.KDFTime = other.KDFTime
+	}
+	if other.KDFThreads != 0 {
+		p.KDFThreads = other.KDFThreads
+	}
+
+	// the costs of the KDF of the profile don't carry over to
+	// another one
+	if other.KDF != "" && other.KDFMemory == "" && other.KDFTime == 0 && other.KDFThreads == 0 {
+		p.KDFMemory, p.KDFTime, p.KDFThreads = "", 0, 0
+	}
+}
+
+// Configuration returns the configuration of a repository created with
+// the profile.
+func (p *Profile) Configuration() (*storage.Configuration, error) {
+	config := storage.NewConfiguration()
+
+	if p.Hashing != "" {
+		hashingConfiguration, err := hashing.LookupDefaultConfiguration(strings.ToUpper(p.Hashing))
+		if err != nil {
+			return nil, fmt.Errorf("unknown hashing algorithm %s", p.Hashing)
+		}
+		config.Hashing = *hashingConfiguration
+	}
+
+	switch strings.ToUpper(p.Compression) {
+	case "":
+	case "NONE":
+		config.Compression = nil
+	default:
+		compressionConfiguration, err := compression.LookupDefaultConfiguration(strings.ToUpper(p.Compression))
+		if err != nil {
+			return nil, fmt.Errorf("unknown compression algorithm %s", p.Compression)
+		}
+		config.Compression = compressionConfiguration
+	}
+
+	if p.KDF != "" || p.KDFMemory != "" || p.KDFTime != 0 || p.KDFThreads != 0 {
+		kdf := config.Encryption.KDFParams.KDF
+		if p.KDF != "" {
+			kdf = strings.ToUpper(p.KDF)
+		}
+		params, err := encryption.NewDefaultKDFParams(kdf)
+		if err != nil {
+			return nil, fmt.Errorf("unknown key derivation function %s", p.KDF)
+		}
+
+		if p.KDFMemory != "" || p.KDFTime != 0 || p.KDFThreads != 0 {
+			argon2 := params.Argon2idParams
+			if argon2 == nil {
+				return nil, fmt.Errorf("the memory, time and threads of the KDF only apply to argon2id")
+			}
+			if p.KDFTime != 0 {
+				if p.KDFTime > math.MaxUint32 {
+					return nil, fmt.Errorf("invalid kdf_time value")
+				}
+				argon2.Time = uint32(p.KDFTime)
+			}
+			if p.KDFThreads != 0 {
+				if p.KDFThreads > math.MaxUint8 {
+					return nil, fmt.Errorf("invalid kdf_threads value")
+				}
+				argon2.Threads = uint8(p.KDFThreads)
+			}
+			if p.KDFMemory != "" {
+				memory, err := humanize.ParseBytes(p.KDFMemory)
+				if err != nil || memory/1024 > math.MaxUint32 {
+					return nil, fmt.Errorf("invalid kdf_memory value")
+				}
+				argon2.Memory = uint32(memory / 1024)
+			}
+			// argon2 needs 8KiB per thread
+			if argon2.Memory < 8*uint32(argon2.Threads) {
+				return nil, fmt.Errorf("invalid kdf_memory value")
+			}
+		}
+		config.Encryption.KDFParams = *params
+	}
+
+	if p.Chunking != "" {
+		switch algorithm := strings.ToUpper(p.Chunking); algorithm {
+		case "FASTCDC", "ULTRACDC":
+			config.Chunking.Algorithm = algorithm
+		default:
+			return nil, fmt.Errorf("unknown chunking algorithm %s", p.Chunking)
+		}
+	}
+	for _, size := range []struct {
+		key   string
+		value string
+		dst   *uint32
+	}{
+		{"chunk_min_size", p.ChunkMinSize, &config.Chunking.MinSize},
+		{"chunk_avg_size", p.ChunkAvgSize, &config.Chunking.NormalSize},
+		{"chunk_max_size", p.ChunkMaxSize, &config.Chunking.MaxSize},
+	} {
+		if size.value == "" {
+			continue
+		}
+		n, err := humanize.ParseBytes(size.value)
+		if err != nil || n < 64 || n > 1<<30 {
+			return nil, fmt.Errorf("invalid %s value", size.key)
+		}
+		*size.dst = uint32(n)
+	}
+	if config.Chunking.MinSize >= config.Chunking.NormalSize ||
+		config.Chunking.NormalSize >= config.Chunking.MaxSize {
+		return nil, fmt.Errorf("the chunk sizes must be increasing from minimum to average to maximum")
+	}
+
+	if p.PackfileSize != "" {
+		size, err := humanize.ParseBytes(p.PackfileSize)
+		if err != nil || size == 0 {
+			return nil, fmt.Errorf("invalid packfile_size value")
+		}
+		config.Packfile.MaxSize = size
+	}
+
+	return config, nil
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfiles(t *testing.T) {
+	for name := range profiles {
+		profile, err := LoadProfile(name)
+		require.NoError(t, err)
+		_, err = profile.Configuration()
+		require.NoError(t, err, name)
+	}
+
+	profile, err := LoadProfile("archive")
+	require.NoError(t, err)
+	config, err := profile.Configuration()
+	require.NoError(t, err)
+	require.Equal(t, "GZIP", config.Compression.Algorithm)
+	require.Equal(t, uint32(4<<20), config.Chunking.NormalSize)
+	require.Equal(t, uint64(128<<20), config.Packfile.MaxSize)
+
+	profile, err = LoadProfile("fast")
+	require.NoError(t, err)
+	config, err = profile.Configuration()
+	require.NoError(t, err)
+	require.Equal(t, "LZ4", config.Compression.Algorithm)
+	require.Equal(t, "ARGON2ID", config.Encryption.KDFParams.KDF)
+	require.Equal(t, uint32(64<<10), config.Encryption.KDFParams.Argon2idParams.Memory)
+	require.Equal(t, uint8(4), config.Encryption.KDFParams.Argon2idParams.Threads)
+
+	// the defaults
+	profile, err = LoadProfile("default")
+	require.NoError(t, err)
+	config, err = profile.Configuration()
+	require.NoError(t, err)
+	require.Equal(t, "LZ4", config.Compression.Algorithm)
+
+	_, err = LoadProfile(filepath.Join(t.TempDir(), "missing.yml"))
+	require.Error(t, err)
+}
+
+func TestProfileFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.yml")
+	require.NoError(t, os.WriteFile(path, []byte("compression: gzip\nkdf: scrypt\npackfile_size: 64MB\n"), 0600))
+
+	profile, err := LoadProfile(path)
+	require.NoError(t, err)
+	config, err := profile.Configuration()
+	require.NoError(t, err)
+	require.Equal(t, "GZIP", config.Compression.Algorithm)
+	require.Equal(t, "SCRYPT", config.Encryption.KDFParams.KDF)
+	require.Equal(t, uint64(64_000_000), config.Packfile.MaxSize)
+
+	// unknown keys are rejected, the compression level among them
+	for _, data := range []string{"compresion: gzip\n", "compression_level: 9\n"} {
+		require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+		_, err = LoadProfile(path)
+		require.Error(t, err, data)
+	}
+}
+
+func TestProfileFlags(t *testing.T) {
+	profile, err := LoadProfile("fast")
+	require.NoError(t, err)
+
+	var overrides Profile
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	overrides.Flags(flags)
+	require.NoError(t, flags.Parse([]string{"-compression", "gzip", "-kdf", "pbkdf2", "-chunk-max-size", "8MiB"}))
+	profile.Merge(&overrides)
+
+	config, err := profile.Configuration()
+	require.NoError(t, err)
+	require.Equal(t, "GZIP", config.Compression.Algorithm)
+	require.Equal(t, "PBKDF2", config.Encryption.KDFParams.KDF)
+	require.Equal(t, uint32(8<<20), config.Chunking.MaxSize)
+	require.Equal(t, uint64(8<<20), config.Packfile.MaxSize)
+}
+
+func TestProfileErrors(t *testing.T) {
+	for _, profile := range []Profile{
+		{Hashing: "md5"},
+		{Compression: "zstd"},
+		{KDF: "bcrypt"},
+		{KDF: "scrypt", KDFTime: 3},
+		{KDFMemory: "lots"},
+		{KDFMemory: "8KiB", KDFThreads: 4},
+		{KDFThreads: 256},
+		{Chunking: "rabin"},
+		{ChunkMinSize: "32B"},
+		{ChunkMinSize: "2MiB"},
+		{ChunkMaxSize: "2GiB"},
+		{PackfileSize: "0"},
+	} {
+		_, err := profile.Configuration()
+		require.Error(t, err, "%+v", profile)
+	}
+}
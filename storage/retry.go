@@ -0,0 +1,391 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/storage"
+)
+
+// RetryPolicy tells how a RetryStore retries the operations failing
+// with a transient error: up to Attempts times in total, waiting Delay
+// before the first retry and doubling it up to MaxDelay.
+type RetryPolicy struct {
+	Attempts int
+	Delay    time.Duration
+	MaxDelay time.Duration
+
+	// Transient reports whether an error is worth retrying, it
+	// defaults to IsTransient.
+	Transient func(error) bool
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Second,
+		MaxDelay: 30 * time.Second,
+	}
+}
+
+// ParseRetryPolicy reads the retry (number of attempts, 1 to disable),
+// retry_delay and retry_max_delay parameters of a store configuration
+// on top of the default policy.
+func ParseRetryPolicy(config map[string]string) (RetryPolicy, error) {
+	policy := DefaultRetryPolicy()
+
+	if value, ok := config["retry"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return policy, fmt.Errorf("invalid retry value")
+		}
+		policy.Attempts = n
+	}
+	if value, ok := config["retry_delay"]; ok {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return policy, fmt.Errorf("invalid retry_delay value")
+		}
+		policy.Delay = d
+	}
+	if value, ok := config["retry_max_delay"]; ok {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return policy, fmt.Errorf("invalid retry_max_delay value")
+		}
+		policy.MaxDelay = d
+	}
+	if policy.MaxDelay < policy.Delay {
+		policy.MaxDelay = policy.Delay
+	}
+	return policy, nil
+}
+
+// IsTransient recognizes the network errors that a new attempt may
+// not run into.  Anything else, such as a missing packfile, is final.
+func IsTransient(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrPermission):
+		return false
+	case errors.Is(err, repository.ErrPackfileNotFound):
+		return false
+	case errors.Is(err, ErrRetained), errors.Is(err, ErrArchived):
+		return false
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, syscall.ETIMEDOUT):
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// RetryStore wraps a store to retry the operations failing with a
+// transient error.  Data written is rewound if it is seekable, or
+// spooled so that it can be sent again.  Data read is streamed,
+// and a transfer interrupted by a transient error is resumed where it
+// stopped: blobs by requesting the range left, whole objects by
+// reading them again up to that point.
+type RetryStore struct {
+	storage.Store
+	policy RetryPolicy
+}
+
+// WithRetry returns store unchanged if the policy makes one attempt.
+func WithRetry(store storage.Store, policy RetryPolicy) storage.Store {
+	if policy.Attempts <= 1 {
+		return store
+	}
+	if policy.Transient == nil {
+		policy.Transient = IsTransient
+	}
+	return &RetryStore{Store: store, policy: policy}
+}
+
+// Unwrap returns the wrapped store.
+func (s *RetryStore) Unwrap() storage.Store {
+	return s.Store
+}
+
+func (s *RetryStore) retry(fn func(attempt int) error) error {
+	delay := s.policy.Delay
+	for attempt := 0; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || attempt+1 >= s.policy.Attempts || !s.policy.Transient(err) {
+			return err
+		}
+
+		// full jitter keeps concurrent retries from hitting the
+		// backend at the same time
+		time.Sleep(delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)))
+		delay = min(delay*2, s.policy.MaxDelay)
+	}
+}
+
+// spool returns a reader over the data of rd, a function rewinding it
+// to the start of that data, and a function releasing it.
+func spool(rd io.Reader) (io.Reader, func() error, func(), error) {
+	if rs, ok := rd.(io.ReadSeeker); ok {
+		if start, err := rs.Seek(0, io.SeekCurrent); err == nil {
+			rewind := func() error {
+				_, err := rs.Seek(start, io.SeekStart)
+				return err
+			}
+			return rs, rewind, func() {}, nil
+		}
+	}
+
+	sp, err := NewSpool(rd)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	data := sp.Reader()
+	rewind := func() error {
+		_, err := data.Seek(0, io.SeekStart)
+		return err
+	}
+	return data, rewind, func() { sp.Close() }, nil
+}
+
+func (s *RetryStore) put(rd io.Reader, fn func(io.Reader) (int64, error)) (int64, error) {
+	data, rewind, release, err := spool(rd)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	var n int64
+	err = s.retry(func(int) error {
+		if err := rewind(); err != nil {
+			return err
+		}
+		var err error
+		n, err = fn(data)
+		return err
+	})
+	return n, err
+}
+
+// retryReader streams an object, opening it again from where it
+// stopped when a read fails with a transient error.
+type retryReader struct {
+	store  *RetryStore
+	open   func(offset uint64) (io.Reader, error)
+	rd     io.Reader
+	offset uint64
+
+	// broken is set when a read returned data along with a transient
+	// error, the object is opened again on the next read
+	broken bool
+}
+
+func (s *RetryStore) get(open func(offset uint64) (io.Reader, error)) (io.Reader, error) {
+	r := &retryReader{store: s, open: open}
+	if err := r.reopen(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *retryReader) reopen() error {
+	r.Close()
+	r.broken = false
+	return r.store.retry(func(int) error {
+		var err error
+		r.rd, err = r.open(r.offset)
+		return err
+	})
+}
+
+func (r *retryReader) Read(p []byte) (int, error) {
+	if r.broken {
+		if err := r.reopen(); err != nil {
+			return 0, err
+		}
+	}
+
+	// failures that make no progress count against the attempts of
+	// the policy, so that a broken transfer isn't resumed forever
+	for stalls := 1; ; stalls++ {
+		n, err := r.rd.Read(p)
+		r.offset += uint64(n)
+		if err == nil || err == io.EOF || !r.store.policy.Transient(err) {
+			return n, err
+		}
+		if n > 0 {
+			r.broken = true
+			return n, nil
+		}
+		if stalls >= r.store.policy.Attempts {
+			return 0, err
+		}
+		if err := r.reopen(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (r *retryReader) Close() error {
+	if closer, ok := r.rd.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// skip resumes an object that can only be read from the start by
+// discarding what was already read.
+func skip(open func() (io.Reader, error)) func(uint64) (io.Reader, error) {
+	return func(offset uint64) (io.Reader, error) {
+		rd, err := open()
+		if err != nil || offset == 0 {
+			return rd, err
+		}
+		if _, err := io.CopyN(io.Discard, rd, int64(offset)); err != nil {
+			if closer, ok := rd.(io.Closer); ok {
+				closer.Close()
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return rd, nil
+	}
+}
+
+// remove doesn't fail on a retry finding that an earlier attempt did
+// remove the object after all.
+func (s *RetryStore) remove(fn func() error) error {
+	return s.retry(func(attempt int) error {
+		err := fn()
+		if attempt > 0 && (errors.Is(err, fs.ErrNotExist) || errors.Is(err, repository.ErrPackfileNotFound)) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (s *RetryStore) list(fn func() ([]objects.MAC, error)) ([]objects.MAC, error) {
+	var ret []objects.MAC
+	err := s.retry(func(int) error {
+		var err error
+		ret, err = fn()
+		return err
+	})
+	return ret, err
+}
+
+func (s *RetryStore) Open(ctx context.Context) ([]byte, error) {
+	var config []byte
+	err := s.retry(func(int) error {
+		var err error
+		config, err = s.Store.Open(ctx)
+		return err
+	})
+	return config, err
+}
+
+func (s *RetryStore) GetStates() ([]objects.MAC, error) {
+	return s.list(s.Store.GetStates)
+}
+
+func (s *RetryStore) PutState(mac objects.MAC, rd io.Reader) (int64, error) {
+	return s.put(rd, func(rd io.Reader) (int64, error) {
+		return s.Store.PutState(mac, rd)
+	})
+}
+
+func (s *RetryStore) GetState(mac objects.MAC) (io.Reader, error) {
+	return s.get(skip(func() (io.Reader, error) {
+		return s.Store.GetState(mac)
+	}))
+}
+
+func (s *RetryStore) DeleteState(mac objects.MAC) error {
+	return s.remove(func() error {
+		return s.Store.DeleteState(mac)
+	})
+}
+
+func (s *RetryStore) GetPackfiles() ([]objects.MAC, error) {
+	return s.list(s.Store.GetPackfiles)
+}
+
+func (s *RetryStore) PutPackfile(mac objects.MAC, rd io.Reader) (int64, error) {
+	return s.put(rd, func(rd io.Reader) (int64, error) {
+		return s.Store.PutPackfile(mac, rd)
+	})
+}
+
+func (s *RetryStore) GetPackfile(mac objects.MAC) (io.Reader, error) {
+	return s.get(skip(func() (io.Reader, error) {
+		return s.Store.GetPackfile(mac)
+	}))
+}
+
+func (s *RetryStore) GetPackfileBlob(mac objects.MAC, offset uint64, length uint32) (io.Reader, error) {
+	return s.get(func(n uint64) (io.Reader, error) {
+		return s.Store.GetPackfileBlob(mac, offset+n, length-uint32(n))
+	})
+}
+
+func (s *RetryStore) DeletePackfile(mac objects.MAC) error {
+	return s.remove(func() error {
+		return s.Store.DeletePackfile(mac)
+	})
+}
+
+func (s *RetryStore) GetLocks() ([]objects.MAC, error) {
+	return s.list(s.Store.GetLocks)
+}
+
+func (s *RetryStore) PutLock(lockID objects.MAC, rd io.Reader) (int64, error) {
+	return s.put(rd, func(rd io.Reader) (int64, error) {
+		return s.Store.PutLock(lockID, rd)
+	})
+}
+
+func (s *RetryStore) GetLock(lockID objects.MAC) (io.Reader, error) {
+	return s.get(skip(func() (io.Reader, error) {
+		return s.Store.GetLock(lockID)
+	}))
+}
+
+func (s *RetryStore) DeleteLock(lockID objects.MAC) error {
+	return s.remove(func() error {
+		return s.Store.DeleteLock(lockID)
+	})
+}
@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyStore fails the first calls with err, the methods not needed by
+// the tests are left to the nil embedded store.
+type flakyStore struct {
+	storage.Store
+
+	failures int
+	err      error
+	calls    int
+	data     map[objects.MAC][]byte
+
+	// the next reads are cut after that many bytes
+	cuts    []int
+	partial bool
+	offsets []uint64
+}
+
+// cutReader fails with a dropped connection after n bytes, returned
+// along with the error if partial is set.
+type cutReader struct {
+	rd      io.Reader
+	n       int
+	partial bool
+}
+
+func (r *cutReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, syscall.ECONNRESET
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	n, err := r.rd.Read(p)
+	r.n -= n
+	if r.n == 0 && r.partial && err == nil {
+		return n, syscall.ECONNRESET
+	}
+	return n, err
+}
+
+func (s *flakyStore) reader(data []byte) io.Reader {
+	if len(s.cuts) == 0 {
+		return bytes.NewReader(data)
+	}
+	n := s.cuts[0]
+	s.cuts = s.cuts[1:]
+	return &cutReader{rd: bytes.NewReader(data), n: n, partial: s.partial}
+}
+
+func (s *flakyStore) fail() error {
+	s.calls++
+	if s.calls <= s.failures {
+		return s.err
+	}
+	return nil
+}
+
+func (s *flakyStore) PutPackfile(mac objects.MAC, rd io.Reader) (int64, error) {
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.fail(); err != nil {
+		return 0, err
+	}
+	s.data[mac] = data
+	return int64(len(data)), nil
+}
+
+func (s *flakyStore) GetPackfile(mac objects.MAC) (io.Reader, error) {
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+	data, ok := s.data[mac]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return s.reader(data), nil
+}
+
+func (s *flakyStore) GetPackfileBlob(mac objects.MAC, offset uint64, length uint32) (io.Reader, error) {
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+	data, ok := s.data[mac]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	s.offsets = append(s.offsets, offset)
+	return s.reader(data[offset : offset+uint64(length)]), nil
+}
+
+func (s *flakyStore) DeletePackfile(mac objects.MAC) error {
+	if _, ok := s.data[mac]; !ok {
+		return fs.ErrNotExist
+	}
+	// the packfile is removed but the reply is lost
+	delete(s.data, mac)
+	return s.fail()
+}
+
+func TestRetryStore(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, Delay: time.Millisecond, MaxDelay: time.Millisecond}
+	mac := objects.MAC{0x01}
+
+	backend := &flakyStore{failures: 2, err: syscall.ECONNRESET, data: make(map[objects.MAC][]byte)}
+	store := WithRetry(backend, policy)
+
+	n, err := store.PutPackfile(mac, bytes.NewReader([]byte("packfile")))
+	require.NoError(t, err)
+	require.Equal(t, int64(8), n)
+	require.Equal(t, 3, backend.calls)
+
+	backend.calls = 0
+	rd, err := store.GetPackfile(mac)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "packfile", string(data))
+
+	backend.calls = 0
+	require.NoError(t, store.DeletePackfile(mac))
+	require.Empty(t, backend.data)
+
+	// the last error is returned once the attempts are exhausted
+	backend.calls, backend.failures = 0, 5
+	_, err = store.PutPackfile(mac, bytes.NewReader([]byte("packfile")))
+	require.True(t, errors.Is(err, syscall.ECONNRESET), "%v", err)
+	require.Equal(t, 3, backend.calls)
+
+	// final errors aren't retried
+	backend.calls, backend.err = 0, fs.ErrPermission
+	_, err = store.PutPackfile(mac, bytes.NewReader([]byte("packfile")))
+	require.True(t, errors.Is(err, fs.ErrPermission), "%v", err)
+	require.Equal(t, 1, backend.calls)
+}
+
+func TestRetryStoreResume(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, Delay: time.Millisecond, MaxDelay: time.Millisecond}
+	mac := objects.MAC{0x01}
+	data := []byte("0123456789abcdefghij")
+
+	backend := &flakyStore{err: syscall.ECONNRESET, data: map[objects.MAC][]byte{mac: data}}
+	store := WithRetry(backend, policy)
+
+	// a blob is resumed by requesting the range left
+	backend.cuts = []int{4, 3}
+	rd, err := store.GetPackfileBlob(mac, 2, 10)
+	require.NoError(t, err)
+	got, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "23456789ab", string(got))
+	require.Equal(t, []uint64{2, 6, 9}, backend.offsets)
+
+	// a packfile is read again up to where it stopped
+	backend.cuts = []int{5}
+	rd, err = store.GetPackfile(mac)
+	require.NoError(t, err)
+	got, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+
+	// a read returning data along with the error is resumed too
+	backend.cuts = []int{4, 3}
+	backend.partial = true
+	backend.offsets = nil
+	rd, err = store.GetPackfileBlob(mac, 2, 10)
+	require.NoError(t, err)
+	got, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "23456789ab", string(got))
+	require.Equal(t, []uint64{2, 6, 9}, backend.offsets)
+
+	backend.cuts = []int{5}
+	rd, err = store.GetPackfile(mac)
+	require.NoError(t, err)
+	got, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+	backend.partial = false
+
+	// a transfer making no progress gives up
+	backend.cuts = []int{2, 0, 0, 0}
+	rd, err = store.GetPackfile(mac)
+	require.NoError(t, err)
+	_, err = io.ReadAll(rd)
+	require.True(t, errors.Is(err, syscall.ECONNRESET), "%v", err)
+}
+
+func TestRetryStoreSpool(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, Delay: time.Millisecond, MaxDelay: time.Millisecond}
+	mac := objects.MAC{0x01}
+
+	backend := &flakyStore{failures: 1, err: syscall.ECONNRESET, data: make(map[objects.MAC][]byte)}
+	store := WithRetry(backend, policy)
+
+	// larger than what is kept in memory, and not seekable
+	data := bytes.Repeat([]byte("plakar"), spoolBufferSize/3)
+	n, err := store.PutPackfile(mac, io.MultiReader(bytes.NewReader(data)))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, data, backend.data[mac])
+}
+
+func TestParseRetryPolicy(t *testing.T) {
+	policy, err := ParseRetryPolicy(map[string]string{})
+	require.NoError(t, err)
+	require.Equal(t, DefaultRetryPolicy(), policy)
+
+	policy, err = ParseRetryPolicy(map[string]string{"retry": "5", "retry_delay": "2s", "retry_max_delay": "1m"})
+	require.NoError(t, err)
+	require.Equal(t, 5, policy.Attempts)
+	require.Equal(t, 2*time.Second, policy.Delay)
+	require.Equal(t, time.Minute, policy.MaxDelay)
+
+	_, err = ParseRetryPolicy(map[string]string{"retry": "0"})
+	require.EqualError(t, err, "invalid retry value")
+
+	// a single attempt leaves the store unwrapped
+	backend := &flakyStore{}
+	require.Same(t, backend, WithRetry(backend, RetryPolicy{Attempts: 1}))
+}
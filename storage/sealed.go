@@ -0,0 +1,544 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	"golang.org/x/crypto/hkdf"
+)
+
+var ErrWriteOnly = errors.New("repository opened without its private key, it is write-only")
+
+var errSealedObject = errors.New("invalid sealed object")
+
+// A sealed object starts with a header holding the size of the data
+// and the ephemeral public key it was sealed with, followed by the data
+// in segments of the segment size, each encrypted with AES-256-GCM and
+// the header as additional data.  The segments are numbered in the
+// nonce, so they can't be reordered, and truncation is caught by the
+// size in the header.
+const (
+	sealedMagic      = "PLKSEALD"
+	sealedVersion    = 1
+	sealedHeaderSize = 8 + 4 + 4 + 8 + 32
+	sealedInfo       = "plakar sealed object"
+
+	// sealedCacheSize bounds the keys of the packfiles read that are
+	// kept to read more of their blobs.
+	sealedCacheSize = 1024
+)
+
+// SealedStore wraps the store of an asymmetric repository: the
+// packfiles and states are sealed to the public key of the repository,
+// and only the holder of its private key can read them back.  Locks
+// are stored as they are.
+//
+// Without the private key the store is write-only, which is enough to
+// back up.  The states written are then also kept in a directory, for
+// the repository to deduplicate against the data it wrote itself, as
+// long as the packfiles that were put with them still exist.
+type SealedStore struct {
+	storage.Store
+	public      *ecdh.PublicKey
+	private     *ecdh.PrivateKey
+	segmentSize int
+	dir         string
+
+	mtx     sync.Mutex
+	pending []objects.MAC
+	objects map[objects.MAC]*sealedObject
+}
+
+// NewSealedStore wraps store to seal to publicKey.  privateKey is nil
+// for a write-only store, whose states are kept in dir.
+func NewSealedStore(store storage.Store, publicKey, privateKey []byte, segmentSize int, dir string) (*SealedStore, error) {
+	public, err := ecdh.X25519().NewPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if segmentSize <= 0 {
+		return nil, fmt.Errorf("invalid segment size %d", segmentSize)
+	}
+
+	s := &SealedStore{
+		Store:       store,
+		public:      public,
+		segmentSize: segmentSize,
+		dir:         dir,
+		objects:     make(map[objects.MAC]*sealedObject),
+	}
+
+	if privateKey != nil {
+		s.private, err = ecdh.X25519().NewPrivateKey(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %w", err)
+		}
+		if !s.private.PublicKey().Equal(public) {
+			return nil, fmt.Errorf("the private key doesn't match the public key")
+		}
+	} else if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// IsSealed returns the SealedStore of a store, looking through the
+// wrappers around it.
+func IsSealed(store storage.Store) (*SealedStore, bool) {
+	return find[*SealedStore](store)
+}
+
+// Unwrap returns the wrapped store, which holds the sealed objects.
+func (s *SealedStore) Unwrap() storage.Store {
+	return s.Store
+}
+
+// WriteOnly reports whether the store was opened without the private
+// key.
+func (s *SealedStore) WriteOnly() bool {
+	return s.private == nil
+}
+
+type sealedObject struct {
+	header      []byte
+	size        uint64
+	segmentSize int
+	aead        cipher.AEAD
+}
+
+func newAEAD(shared, header []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, header[:sealedHeaderSize], []byte(sealedInfo)), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (o *sealedObject) nonce(segment int) []byte {
+	nonce := make([]byte, o.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(segment))
+	return nonce
+}
+
+// segments returns the number of segments of the object, an empty
+// object having one so that its header is authenticated.
+func (o *sealedObject) segments() int {
+	n := int((o.size + uint64(o.segmentSize) - 1) / uint64(o.segmentSize))
+	return max(n, 1)
+}
+
+// span returns the offset in the sealed object of the first of the
+// segments from first to last included, and their sealed size.
+func (o *sealedObject) span(first, last int) (uint64, uint64) {
+	overhead := uint64(o.aead.Overhead())
+	offset := uint64(sealedHeaderSize) + uint64(first)*(uint64(o.segmentSize)+overhead)
+
+	end := min(uint64(last+1)*uint64(o.segmentSize), o.size)
+	plain := end - uint64(first)*uint64(o.segmentSize)
+	return offset, plain + uint64(last-first+1)*overhead
+}
+
+// open decrypts the segments starting at first.
+func (o *sealedObject) open(data []byte, first int) ([]byte, error) {
+	sealedSegment := o.segmentSize + o.aead.Overhead()
+	plaintext := make([]byte, 0, len(data))
+	for i := first; len(data) > 0; i++ {
+		n := min(len(data), sealedSegment)
+		out, err := o.aead.Open(plaintext[len(plaintext):], o.nonce(i), data[:n], o.header)
+		if err != nil {
+			return nil, errSealedObject
+		}
+		plaintext = plaintext[:len(plaintext)+len(out)]
+		data = data[n:]
+	}
+	return plaintext, nil
+}
+
+// seal returns data sealed to the public key.
+func (s *SealedStore) seal(data []byte) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(s.public)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, sealedHeaderSize)
+	header = append(header, sealedMagic...)
+	header = binary.BigEndian.AppendUint32(header, sealedVersion)
+	header = binary.BigEndian.AppendUint32(header, uint32(s.segmentSize))
+	header = binary.BigEndian.AppendUint64(header, uint64(len(data)))
+	header = append(header, ephemeral.PublicKey().Bytes()...)
+
+	aead, err := newAEAD(shared, header)
+	if err != nil {
+		return nil, err
+	}
+	o := &sealedObject{header: header, size: uint64(len(data)), segmentSize: s.segmentSize, aead: aead}
+
+	out := make([]byte, 0, sealedHeaderSize+len(data)+o.segments()*aead.Overhead())
+	out = append(out, header...)
+	for i := range o.segments() {
+		segment := data[min(i*s.segmentSize, len(data)):min((i+1)*s.segmentSize, len(data))]
+		out = aead.Seal(out, o.nonce(i), segment, header)
+	}
+	return out, nil
+}
+
+// parseHeader returns the object sealed with a header, which the
+// private key opens.
+func (s *SealedStore) parseHeader(header []byte) (*sealedObject, error) {
+	if len(header) < sealedHeaderSize || string(header[:8]) != sealedMagic {
+		return nil, errSealedObject
+	}
+	if version := binary.BigEndian.Uint32(header[8:12]); version != sealedVersion {
+		return nil, fmt.Errorf("unsupported sealed object version %d", version)
+	}
+	segmentSize := binary.BigEndian.Uint32(header[12:16])
+	if segmentSize == 0 || segmentSize > 1<<30 {
+		return nil, errSealedObject
+	}
+
+	ephemeral, err := ecdh.X25519().NewPublicKey(header[24:sealedHeaderSize])
+	if err != nil {
+		return nil, errSealedObject
+	}
+	shared, err := s.private.ECDH(ephemeral)
+	if err != nil {
+		return nil, errSealedObject
+	}
+	aead, err := newAEAD(shared, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealedObject{
+		header:      header[:sealedHeaderSize:sealedHeaderSize],
+		size:        binary.BigEndian.Uint64(header[16:24]),
+		segmentSize: int(segmentSize),
+		aead:        aead,
+	}, nil
+}
+
+// unseal returns the data of a sealed object.
+func (s *SealedStore) unseal(rd io.Reader) (io.Reader, error) {
+	if closer, ok := rd.(io.Closer); ok {
+		defer closer.Close()
+	}
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := s.parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	_, length := o.span(0, o.segments()-1)
+	if uint64(len(data)-sealedHeaderSize) != length {
+		return nil, errSealedObject
+	}
+	plaintext, err := o.open(data[sealedHeaderSize:], 0)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(plaintext), nil
+}
+
+func (s *SealedStore) put(rd io.Reader, fn func(io.Reader) (int64, error)) ([]byte, int64, error) {
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, 0, err
+	}
+	sealed, err := s.seal(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := fn(bytes.NewReader(sealed))
+	return data, n, err
+}
+
+func (s *SealedStore) PutPackfile(mac objects.MAC, rd io.Reader) (int64, error) {
+	_, n, err := s.put(rd, func(rd io.Reader) (int64, error) {
+		return s.Store.PutPackfile(mac, rd)
+	})
+	if err == nil && s.WriteOnly() {
+		s.mtx.Lock()
+		s.pending = append(s.pending, mac)
+		s.mtx.Unlock()
+	}
+	return n, err
+}
+
+func (s *SealedStore) GetPackfile(mac objects.MAC) (io.Reader, error) {
+	if s.WriteOnly() {
+		return nil, ErrWriteOnly
+	}
+	rd, err := s.Store.GetPackfile(mac)
+	if err != nil {
+		return nil, err
+	}
+	return s.unseal(rd)
+}
+
+// object returns the sealed object of a packfile, reading its header
+// from the store unless it was read already.
+func (s *SealedStore) object(mac objects.MAC) (*sealedObject, error) {
+	s.mtx.Lock()
+	o, ok := s.objects[mac]
+	s.mtx.Unlock()
+	if ok {
+		return o, nil
+	}
+
+	rd, err := s.Store.GetPackfileBlob(mac, 0, sealedHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	header, err := io.ReadAll(rd)
+	if closer, ok := rd.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	o, err = s.parseHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mtx.Lock()
+	if len(s.objects) >= sealedCacheSize {
+		clear(s.objects)
+	}
+	s.objects[mac] = o
+	s.mtx.Unlock()
+	return o, nil
+}
+
+// GetPackfileBlob reads the segments holding the blob only.
+func (s *SealedStore) GetPackfileBlob(mac objects.MAC, offset uint64, length uint32) (io.Reader, error) {
+	if s.WriteOnly() {
+		return nil, ErrWriteOnly
+	}
+
+	o, err := s.object(mac)
+	if err != nil {
+		return nil, err
+	}
+	if offset+uint64(length) > o.size {
+		return nil, fmt.Errorf("blob out of the bounds of packfile %x", mac)
+	}
+	if length == 0 {
+		return bytes.NewReader(nil), nil
+	}
+
+	first := int(offset / uint64(o.segmentSize))
+	last := int((offset + uint64(length) - 1) / uint64(o.segmentSize))
+	sealedOffset, sealedLength := o.span(first, last)
+	if sealedLength > 1<<32-1 {
+		return nil, fmt.Errorf("blob too large")
+	}
+
+	rd, err := s.Store.GetPackfileBlob(mac, sealedOffset, uint32(sealedLength))
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(rd)
+	if closer, ok := rd.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) != sealedLength {
+		return nil, errSealedObject
+	}
+
+	plaintext, err := o.open(data, first)
+	if err != nil {
+		return nil, err
+	}
+	start := offset - uint64(first)*uint64(o.segmentSize)
+	return bytes.NewReader(plaintext[start : start+uint64(length)]), nil
+}
+
+func (s *SealedStore) DeletePackfile(mac objects.MAC) error {
+	s.mtx.Lock()
+	delete(s.objects, mac)
+	s.mtx.Unlock()
+	return s.Store.DeletePackfile(mac)
+}
+
+func (s *SealedStore) statePath(mac objects.MAC) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%064x", mac))
+}
+
+// PutState keeps the state in the directory of a write-only store,
+// along with the packfiles put since the previous one.
+func (s *SealedStore) PutState(mac objects.MAC, rd io.Reader) (int64, error) {
+	data, n, err := s.put(rd, func(rd io.Reader) (int64, error) {
+		return s.Store.PutState(mac, rd)
+	})
+	if err != nil || !s.WriteOnly() {
+		return n, err
+	}
+
+	s.mtx.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.mtx.Unlock()
+
+	var packfiles strings.Builder
+	for _, packfile := range pending {
+		fmt.Fprintf(&packfiles, "%064x\n", packfile)
+	}
+	if err := writeFile(s.statePath(mac)+".packfiles", []byte(packfiles.String())); err != nil {
+		return n, err
+	}
+	return n, writeFile(s.statePath(mac), data)
+}
+
+func (s *SealedStore) GetState(mac objects.MAC) (io.Reader, error) {
+	if s.WriteOnly() {
+		data, err := os.ReadFile(s.statePath(mac))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrWriteOnly
+		} else if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(data), nil
+	}
+
+	rd, err := s.Store.GetState(mac)
+	if err != nil {
+		return nil, err
+	}
+	return s.unseal(rd)
+}
+
+// GetStates returns the states kept by a write-only store that are
+// still in the repository, and whose packfiles all are.  The others are
+// forgotten: maintenance went through, and their blobs may have moved.
+func (s *SealedStore) GetStates() ([]objects.MAC, error) {
+	states, err := s.Store.GetStates()
+	if err != nil || !s.WriteOnly() {
+		return states, err
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteStates := make(map[objects.MAC]struct{}, len(states))
+	for _, mac := range states {
+		remoteStates[mac] = struct{}{}
+	}
+
+	var packfiles map[objects.MAC]struct{}
+	var ret []objects.MAC
+	for _, entry := range entries {
+		mac, err := parseMAC(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		keep := false
+		if _, ok := remoteStates[mac]; ok {
+			if packfiles == nil {
+				list, err := s.Store.GetPackfiles()
+				if err != nil {
+					return nil, err
+				}
+				packfiles = make(map[objects.MAC]struct{}, len(list))
+				for _, packfile := range list {
+					packfiles[packfile] = struct{}{}
+				}
+			}
+			keep, err = s.packfilesExist(mac, packfiles)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if keep {
+			ret = append(ret, mac)
+		} else {
+			os.Remove(s.statePath(mac))
+			os.Remove(s.statePath(mac) + ".packfiles")
+		}
+	}
+	return ret, nil
+}
+
+func (s *SealedStore) packfilesExist(state objects.MAC, packfiles map[objects.MAC]struct{}) (bool, error) {
+	f, err := os.Open(s.statePath(state) + ".packfiles")
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		mac, err := parseMAC(scanner.Text())
+		if err != nil {
+			return false, nil
+		}
+		if _, ok := packfiles[mac]; !ok {
+			return false, nil
+		}
+	}
+	return true, scanner.Err()
+}
+
+func parseMAC(name string) (objects.MAC, error) {
+	var mac objects.MAC
+	data, err := hex.DecodeString(name)
+	if err != nil || len(data) != len(mac) {
+		return mac, errSealedObject
+	}
+	copy(mac[:], data)
+	return mac, nil
+}
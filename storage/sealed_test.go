@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	bfs "github.com/PlakarKorp/plakar/connectors/fs/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func newSealedStores(t *testing.T) (storage.Store, *SealedStore, *SealedStore) {
+	store, err := bfs.NewStore(context.Background(), "fs", map[string]string{"location": "fs://" + t.TempDir() + "/repo"})
+	require.NoError(t, err)
+	require.NoError(t, store.Create(context.Background(), []byte("CONFIG")))
+
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	reader, err := NewSealedStore(store, private.PublicKey().Bytes(), private.Bytes(), 16, "")
+	require.NoError(t, err)
+	writer, err := NewSealedStore(store, private.PublicKey().Bytes(), nil, 16, t.TempDir())
+	require.NoError(t, err)
+	return store, reader, writer
+}
+
+func TestSealedStore(t *testing.T) {
+	store, reader, writer := newSealedStores(t)
+	require.True(t, writer.WriteOnly())
+	require.False(t, reader.WriteOnly())
+
+	data := strings.Repeat("0123456789", 10)
+	mac := objects.RandomMAC()
+	_, err := writer.PutPackfile(mac, strings.NewReader(data))
+	require.NoError(t, err)
+
+	// the packfile is sealed in the store
+	rd, err := store.GetPackfile(mac)
+	require.NoError(t, err)
+	sealed, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.NotContains(t, string(sealed), "0123456789")
+
+	_, err = writer.GetPackfile(mac)
+	require.ErrorIs(t, err, ErrWriteOnly)
+	_, err = writer.GetPackfileBlob(mac, 0, 10)
+	require.ErrorIs(t, err, ErrWriteOnly)
+
+	rd, err = reader.GetPackfile(mac)
+	require.NoError(t, err)
+	got, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, data, string(got))
+
+	// blobs within a segment, across segments and at the end
+	for _, r := range [][2]int{{0, 10}, {3, 5}, {12, 30}, {90, 10}, {16, 16}, {50, 0}} {
+		require.Equal(t, data[r[0]:r[0]+r[1]], readBlob(t, reader, mac, uint64(r[0]), uint32(r[1])))
+	}
+	_, err = reader.GetPackfileBlob(mac, 95, 10)
+	require.Error(t, err)
+
+	// an empty object
+	empty := objects.RandomMAC()
+	_, err = writer.PutPackfile(empty, bytes.NewReader(nil))
+	require.NoError(t, err)
+	rd, err = reader.GetPackfile(empty)
+	require.NoError(t, err)
+	got, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Empty(t, got)
+
+	// a tampered object doesn't open
+	sealed[len(sealed)-1] ^= 1
+	tampered := objects.RandomMAC()
+	_, err = store.PutPackfile(tampered, bytes.NewReader(sealed))
+	require.NoError(t, err)
+	_, err = reader.GetPackfile(tampered)
+	require.Error(t, err)
+}
+
+func TestSealedStoreStates(t *testing.T) {
+	store, reader, writer := newSealedStores(t)
+
+	packfile := objects.RandomMAC()
+	_, err := writer.PutPackfile(packfile, strings.NewReader("packfile"))
+	require.NoError(t, err)
+	state := objects.RandomMAC()
+	_, err = writer.PutState(state, strings.NewReader("state"))
+	require.NoError(t, err)
+
+	// another client's state is only seen with the private key
+	other := objects.RandomMAC()
+	_, err = reader.PutState(other, strings.NewReader("other"))
+	require.NoError(t, err)
+
+	states, err := reader.GetStates()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []objects.MAC{state, other}, states)
+	rd, err := reader.GetState(state)
+	require.NoError(t, err)
+	got, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "state", string(got))
+
+	states, err = writer.GetStates()
+	require.NoError(t, err)
+	require.Equal(t, []objects.MAC{state}, states)
+	rd, err = writer.GetState(state)
+	require.NoError(t, err)
+	got, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "state", string(got))
+	_, err = writer.GetState(other)
+	require.ErrorIs(t, err, ErrWriteOnly)
+
+	// once its packfile is gone, the state is forgotten
+	require.NoError(t, store.DeletePackfile(packfile))
+	states, err = writer.GetStates()
+	require.NoError(t, err)
+	require.Empty(t, states)
+	_, err = writer.GetState(state)
+	require.ErrorIs(t, err, ErrWriteOnly)
+}
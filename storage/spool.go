@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// spoolBufferSize is the amount of data a Spool keeps in memory, past
+// which it moves to a temporary file.
+const spoolBufferSize = 4 << 20
+
+// Spool holds the data of a reader so that it can be read several
+// times, possibly at once: in memory up to spoolBufferSize, in a
+// temporary file beyond.
+type Spool struct {
+	data io.ReaderAt
+	size int64
+	tmp  *os.File
+}
+
+func NewSpool(rd io.Reader) (*Spool, error) {
+	buf := make([]byte, spoolBufferSize+1)
+	n, err := io.ReadFull(rd, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &Spool{data: bytes.NewReader(buf[:n]), size: int64(n)}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "plakar-spool-")
+	if err != nil {
+		return nil, err
+	}
+	s := &Spool{data: tmp, tmp: tmp}
+	if _, err := tmp.Write(buf); err != nil {
+		s.Close()
+		return nil, err
+	}
+	rest, err := io.Copy(tmp, rd)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.size = int64(len(buf)) + rest
+	return s, nil
+}
+
+// Reader returns a reader over the data, independent of the others.
+func (s *Spool) Reader() *io.SectionReader {
+	return io.NewSectionReader(s.data, 0, s.size)
+}
+
+func (s *Spool) Size() int64 {
+	return s.size
+}
+
+// Close releases the data, the readers can't be used anymore.
+func (s *Spool) Close() error {
+	if s.tmp == nil {
+		return nil
+	}
+	s.tmp.Close()
+	return os.Remove(s.tmp.Name())
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool(t *testing.T) {
+	for _, size := range []int{0, 10, spoolBufferSize + 10} {
+		data := bytes.Repeat([]byte("x"), size)
+
+		// hide the bytes.Reader from the spool
+		sp, err := NewSpool(io.MultiReader(bytes.NewReader(data)))
+		require.NoError(t, err)
+		require.Equal(t, int64(size), sp.Size())
+		require.Equal(t, size > spoolBufferSize, sp.tmp != nil)
+
+		// readers are independent of each other
+		a, b := sp.Reader(), sp.Reader()
+		head := make([]byte, min(size, 5))
+		_, err = io.ReadFull(a, head)
+		require.NoError(t, err)
+		got, err := io.ReadAll(b)
+		require.NoError(t, err)
+		require.Equal(t, data, got)
+		got, err = io.ReadAll(a)
+		require.NoError(t, err)
+		require.Equal(t, data[len(head):], got)
+
+		require.NoError(t, sp.Close())
+	}
+}
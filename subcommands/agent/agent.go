@@ -36,7 +36,9 @@ import (
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/plakar/agent"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/keyring"
 	"github.com/PlakarKorp/plakar/scheduler"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/task"
 	"github.com/PlakarKorp/plakar/utils"
@@ -439,9 +441,24 @@ func handleClient(ctx *appcontext.AppContext, wg *sync.WaitGroup, conn net.Conn)
 			fmt.Fprintf(clientContext.Stderr, "Failed to open storage: %s\n", err)
 			return
 		}
+
+		store, err = plakarstorage.OpenBlockCache(store, storeConfig, clientContext.CacheDir, serializedConfig)
+		if err != nil {
+			clientContext.GetLogger().Warn("Failed to open block cache: %v", err)
+			fmt.Fprintf(clientContext.Stderr, "Failed to open block cache: %s\n", err)
+			return
+		}
 		defer store.Close()
 
-		repo, err = repository.New(clientContext.GetInner(), clientContext.GetSecret(), store, serializedConfig)
+		var secret []byte
+		store, secret, err = keyring.OpenStore(store, serializedConfig, clientContext.GetSecret(), clientContext.CacheDir)
+		if err != nil {
+			clientContext.GetLogger().Warn("Failed to open sealed store: %v", err)
+			fmt.Fprintf(clientContext.Stderr, "Failed to open sealed store: %s\n", err)
+			return
+		}
+
+		repo, err = repository.New(clientContext.GetInner(), secret, store, serializedConfig)
 		if err != nil {
 			clientContext.GetLogger().Warn("Failed to open repository: %v", err)
 			fmt.Fprintf(clientContext.Stderr, "Failed to open repository: %s\n", err)
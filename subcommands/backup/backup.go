@@ -28,6 +28,8 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/importer"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/identity"
+	"github.com/PlakarKorp/plakar/search"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/dustin/go-humanize"
@@ -35,7 +37,7 @@ import (
 )
 
 func init() {
-	subcommands.Register(func() subcommands.Subcommand { return &Backup{} }, subcommands.AgentSupport, "backup")
+	subcommands.Register(func() subcommands.Subcommand { return &Backup{} }, subcommands.AgentSupport|subcommands.WriteOnly, "backup")
 }
 
 type excludeFlags []string
@@ -52,6 +54,7 @@ func (e *excludeFlags) Set(value string) error {
 func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
 	var opt_excludes string
 	var opt_exclude excludeFlags
+	var opt_sign string
 	excludes := []string{}
 
 	cmd.Opts = make(map[string]string)
@@ -71,8 +74,10 @@ func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags.BoolVar(&cmd.Quiet, "quiet", false, "suppress output")
 	flags.BoolVar(&cmd.Silent, "silent", false, "suppress ALL output")
 	flags.BoolVar(&cmd.OptCheck, "check", false, "check the snapshot after creating it")
+	flags.BoolVar(&cmd.OptContentIndex, "content-index", false, "index the content of text files for plakar grep")
 	flags.Var(utils.NewOptsFlag(cmd.Opts), "o", "specify extra importer options")
 	flags.BoolVar(&cmd.DryRun, "scan", false, "do not actually perform a backup, just list the files")
+	flags.StringVar(&opt_sign, "sign", "", "sign the snapshot with the given identity instead of the default one")
 	//flags.BoolVar(&opt_stdio, "stdio", false, "output one line per file to stdout instead of the default interactive output")
 	flags.Parse(args)
 
@@ -105,8 +110,14 @@ func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
 		}
 	}
 
+	signer, err := identity.NewManager(ctx.ConfigDir).Signer(opt_sign)
+	if err != nil {
+		return fmt.Errorf("failed to load signing identity: %w", err)
+	}
+
 	cmd.RepositorySecret = ctx.GetSecret()
 	cmd.Excludes = excludes
+	cmd.Identity = signer
 	cmd.Path = flags.Arg(0)
 
 	return nil
@@ -125,6 +136,9 @@ type Backup struct {
 	OptCheck    bool
 	Opts        map[string]string
 	DryRun      bool
+	Identity    *identity.Identity
+
+	OptContentIndex bool
 }
 
 func (cmd *Backup) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
@@ -198,6 +212,19 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 		return 0, nil, objects.MAC{}, nil
 	}
 
+	signed := "unsigned"
+	if cmd.Identity != nil {
+		kp, err := cmd.Identity.Keypair()
+		if err != nil {
+			return 1, fmt.Errorf("failed to load signing identity: %w", err), objects.MAC{}, nil
+		}
+		// the snapshot builder signs with the keypair of the
+		// repository context.
+		repo.AppContext().Identity = cmd.Identity.Identifier
+		repo.AppContext().Keypair = kp
+		signed = "signed"
+	}
+
 	snap, err := snapshot.Create(repo, repository.DefaultType)
 	if err != nil {
 		ctx.GetLogger().Error("%s", err)
@@ -249,10 +276,25 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 		}
 	}
 
+	if cmd.OptContentIndex {
+		repo.RebuildState()
+
+		indexSnap, err := snapshot.Load(repo, snap.Header.Identifier)
+		if err != nil {
+			return 1, fmt.Errorf("failed to load snapshot: %w", err), objects.MAC{}, nil
+		}
+		defer indexSnap.Close()
+
+		indexes := search.NewIndexCache(ctx.CacheDir, repo.Configuration().RepositoryID)
+		if _, err := indexes.GetOrBuild(ctx, indexSnap, search.DefaultMaxSize); err != nil {
+			return 1, fmt.Errorf("failed to index snapshot: %w", err), objects.MAC{}, nil
+		}
+	}
+
 	totalSize := snap.Header.GetSource(0).Summary.Directory.Size + snap.Header.GetSource(0).Summary.Below.Size
 
 	ctx.GetLogger().Info("backup: created %s snapshot %x of size %s in %s (wrote %s)",
-		"unsigned",
+		signed,
 		snap.Header.GetIndexShortID(),
 		humanize.Bytes(totalSize),
 		snap.Header.Duration,
@@ -13,11 +13,13 @@ import (
 	"github.com/PlakarKorp/kloset/logging"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/kloset/versioning"
 	"github.com/PlakarKorp/plakar/appcontext"
 	_ "github.com/PlakarKorp/plakar/connectors/fs/importer"
 	bfs "github.com/PlakarKorp/plakar/connectors/fs/storage"
+	"github.com/PlakarKorp/plakar/identity"
 	"github.com/stretchr/testify/require"
 )
 
@@ -167,3 +169,53 @@ func TestExecuteCmdCreateDefaultWithExcludes(t *testing.T) {
 	lastline := lines[len(lines)-1]
 	require.Contains(t, lastline, "created unsigned snapshot")
 }
+
+func TestExecuteCmdCreateSigned(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+
+	ctx.MaxConcurrency = 1
+	ctx.ConfigDir = t.TempDir()
+
+	identities := identity.NewManager(ctx.ConfigDir)
+	alice, err := identities.Create("alice")
+	require.NoError(t, err)
+
+	args := []string{"-sign", "alice", tmpBackupDir}
+
+	subcommand := &Backup{}
+	err = subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+	require.NotNil(t, subcommand.Identity)
+
+	status, err, snapshotID, _ := subcommand.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	output := bufOut.String()
+	lines := strings.Split(strings.Trim(output, "\n"), "\n")
+	lastline := lines[len(lines)-1]
+	require.Contains(t, lastline, "created signed snapshot")
+
+	repo.RebuildState()
+	snap, err := snapshot.Load(repo, snapshotID)
+	require.NoError(t, err)
+	defer snap.Close()
+	require.Equal(t, alice.Identifier, snap.Header.Identity.Identifier)
+
+	verified, signer, err := identities.Verify(snap)
+	require.NoError(t, err)
+	require.Equal(t, identity.StatusTrusted, verified)
+	require.Equal(t, "alice", signer.Name)
+
+	require.NoError(t, identities.Remove("alice"))
+	verified, _, err = identities.Verify(snap)
+	require.NoError(t, err)
+	require.Equal(t, identity.StatusUntrusted, verified)
+
+	subcommand = &Backup{}
+	err = subcommand.Parse(ctx, []string{"-sign", "bob", tmpBackupDir})
+	require.ErrorIs(t, err, identity.ErrIdentityNotFound)
+}
@@ -22,9 +22,11 @@ import (
 	"fmt"
 
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/identity"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/thaw"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/google/uuid"
 )
@@ -48,6 +50,7 @@ func (cmd *Check) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags.BoolVar(&cmd.FastCheck, "fast", false, "enable fast checking (no digest verification)")
 	flags.BoolVar(&cmd.Quiet, "quiet", false, "suppress output")
 	flags.BoolVar(&cmd.Silent, "silent", false, "suppress ALL output")
+	flags.BoolVar(&cmd.Wait, "wait", false, "wait for packfiles in cold storage to be restored")
 	cmd.LocateOptions.InstallFlags(flags)
 
 	flags.Parse(args)
@@ -74,6 +77,7 @@ type Check struct {
 	Quiet         bool
 	Snapshots     []string
 	Silent        bool
+	Wait          bool
 }
 
 func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
@@ -81,6 +85,14 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 		go eventsProcessorStdio(ctx, cmd.Quiet)
 	}
 
+	thawer := thaw.New(ctx, repo)
+	if thawer != nil {
+		thawer.Wait = cmd.Wait
+		if err := thawer.Headers(); err != nil {
+			return 1, err
+		}
+	}
+
 	var snapshots []string
 	if len(cmd.Snapshots) == 0 {
 		snapshotIDs, err := utils.LocateSnapshotIDs(repo, cmd.LocateOptions)
@@ -123,6 +135,8 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 	}
 	defer checkCache.Close()
 
+	identities := identity.NewManager(ctx.ConfigDir)
+
 	failures := false
 	for _, arg := range snapshots {
 		snap, pathname, err := utils.OpenSnapshotByPath(repo, arg)
@@ -130,16 +144,27 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 			return 1, err
 		}
 
+		if thawer != nil {
+			if err := thawer.Snapshot(snap.Header.Identifier, pathname); err != nil {
+				snap.Close()
+				return 1, err
+			}
+		}
+
 		snap.SetCheckCache(checkCache)
 
 		if !cmd.NoVerify && snap.Header.Identity.Identifier != uuid.Nil {
-			if ok, err := snap.Verify(); err != nil {
+			if status, signer, err := identities.Verify(snap); err != nil {
 				ctx.GetLogger().Warn("%s", err)
-			} else if !ok {
+			} else if status == identity.StatusInvalid {
 				ctx.GetLogger().Info("snapshot %x signature verification failed", snap.Header.Identifier)
 				failures = true
+			} else if status == identity.StatusUntrusted {
+				ctx.GetLogger().Warn("snapshot %x signature verification succeeded, but identity %s is not trusted",
+					snap.Header.Identifier, snap.Header.Identity.Identifier)
 			} else {
-				ctx.GetLogger().Info("snapshot %x signature verification succeeded", snap.Header.Identifier)
+				ctx.GetLogger().Info("snapshot %x signature verification succeeded, signed by trusted identity %s",
+					snap.Header.Identifier, signer.Name)
 			}
 		}
 
@@ -18,18 +18,24 @@ package clone
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"flag"
 	"fmt"
 	"hash"
 	"io"
-	"os"
+	"slices"
+	"sync/atomic"
 
 	"github.com/PlakarKorp/kloset/hashing"
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/resources"
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/keyring"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/dustin/go-humanize"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -38,13 +44,18 @@ func init() {
 }
 
 func (cmd *Clone) Parse(ctx *appcontext.AppContext, args []string) error {
+	var noVerify bool
+
 	flags := flag.NewFlagSet("clone", flag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Fprintf(flags.Output(), "Usage: %s to /path/to/repository\n", flags.Name())
-		fmt.Fprintf(flags.Output(), "       %s to s3://bucket/path\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] to /path/to/repository\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s [OPTIONS] to s3://bucket/path\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
 		flags.PrintDefaults()
 	}
 
+	flags.BoolVar(&cmd.Delete, "delete", false, "delete the objects of the clone that are no longer in the repository")
+	flags.BoolVar(&noVerify, "no-verify", false, "don't read back the objects copied to verify them")
 	flags.Parse(args)
 
 	if flags.NArg() != 2 || flags.Arg(0) != "to" {
@@ -53,6 +64,7 @@ func (cmd *Clone) Parse(ctx *appcontext.AppContext, args []string) error {
 
 	cmd.RepositorySecret = ctx.GetSecret()
 	cmd.Dest = flags.Arg(1)
+	cmd.Verify = !noVerify
 
 	return nil
 }
@@ -60,22 +72,49 @@ func (cmd *Clone) Parse(ctx *appcontext.AppContext, args []string) error {
 type Clone struct {
 	subcommands.SubcommandBase
 
-	Dest string
+	Dest   string
+	Delete bool
+	Verify bool
+}
+
+// kind describes the objects of one type that are copied raw.
+type kind struct {
+	name   string
+	rtype  resources.Type
+	list   func(storage.Store) ([]objects.MAC, error)
+	get    func(storage.Store, objects.MAC) (io.Reader, error)
+	put    func(storage.Store, objects.MAC, io.Reader) (int64, error)
+	delete func(storage.Store, objects.MAC) error
+}
+
+// kinds are in the order they are copied: a state only lands in the
+// clone once the packfiles it references are there, so an interrupted
+// clone is consistent, and resumed by running it again.  Extra objects
+// are deleted in the reverse order.
+var kinds = []kind{
+	{"packfiles", resources.RT_PACKFILE, storage.Store.GetPackfiles, storage.Store.GetPackfile, storage.Store.PutPackfile, storage.Store.DeletePackfile},
+	{"states", resources.RT_STATE, storage.Store.GetStates, storage.Store.GetState, storage.Store.PutState, storage.Store.DeleteState},
+	{"locks", resources.RT_LOCK, storage.Store.GetLocks, storage.Store.GetLock, storage.Store.PutLock, storage.Store.DeleteLock},
 }
 
 func (cmd *Clone) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
 	sourceStore := repo.Store()
 
+	// the packfiles and states of an asymmetric repository are copied
+	// sealed, as kloset doesn't know about the sealing
+	if sealed, ok := plakarstorage.IsSealed(sourceStore); ok {
+		sourceStore = sealed.Unwrap()
+	}
+
 	configuration := repo.Configuration()
 
 	serializedConfig, err := configuration.ToBytes()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to decode storage configuration: %s\n", err)
-		return 1, err
+		return 1, fmt.Errorf("failed to decode storage configuration: %w", err)
 	}
 
 	var hasher hash.Hash
-	if configuration.Encryption != nil {
+	if configuration.Encryption != nil && !keyring.IsAsymmetric(configuration.Encryption) {
 		hasher = hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, ctx.GetSecret())
 	} else {
 		hasher = hashing.GetHasher(storage.DEFAULT_HASHING_ALGORITHM)
@@ -96,76 +135,248 @@ func (cmd *Clone) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 		return 1, err
 	}
 
-	cloneStore, err := storage.Create(ctx.GetInner(), storeConfig, wrappedSerializedConfig)
+	// the key slots go along, the clone is unlocked like its source
+	k, err := keyring.Load(sourceStore)
+	if err != nil {
+		return 1, fmt.Errorf("could not read the key slots: %w", err)
+	}
+
+	cloneStore, err := cmd.open(ctx, storeConfig, &configuration, wrappedSerializedConfig)
+	if err != nil {
+		return 1, err
+	}
+	defer cloneStore.Close()
+
+	if _, ok := plakarstorage.HasKeyring(cloneStore); k != nil && !ok {
+		return 1, fmt.Errorf("could not create repository: %w", keyring.ErrUnsupported)
+	}
+	if k != nil {
+		if err := keyring.Save(cloneStore, k); err != nil {
+			return 1, fmt.Errorf("could not copy the key slots: %w", err)
+		}
+	}
+
+	// the objects copied are read back the way the repository reads
+	// them, through the sealing of an asymmetric repository
+	verifyStore, _, err := keyring.OpenStore(cloneStore, wrappedSerializedConfig, ctx.GetSecret(), ctx.CacheDir)
+	if err != nil {
+		return 1, err
+	}
+
+	for _, k := range kinds {
+		copied, size, err := cmd.copy(ctx, repo, k, sourceStore, cloneStore, verifyStore)
+		if copied != 0 || err == nil {
+			ctx.GetLogger().Info("clone: %d %s copied (%s)", copied, k.name, humanize.Bytes(uint64(size)))
+		}
+		if err != nil {
+			return 1, fmt.Errorf("failed to copy %s: %w", k.name, err)
+		}
+	}
+
+	if cmd.Delete {
+		for _, k := range slices.Backward(kinds) {
+			deleted, err := cmd.deleteExtra(ctx, k, sourceStore, cloneStore)
+			if deleted != 0 || err == nil {
+				ctx.GetLogger().Info("clone: %d %s deleted", deleted, k.name)
+			}
+			if err != nil {
+				return 1, fmt.Errorf("failed to delete %s: %w", k.name, err)
+			}
+		}
+	}
+
+	return 0, nil
+}
+
+// open returns the store of the clone, which is created unless it
+// exists already, in which case it must be a clone of the repository.
+func (cmd *Clone) open(ctx *appcontext.AppContext, storeConfig map[string]string, configuration *storage.Configuration, wrappedConfig []byte) (storage.Store, error) {
+	cloneStore, err := storage.New(ctx.GetInner(), storeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not create repository: %w", err)
+	}
+
+	existingConfig, err := cloneStore.Open(ctx)
+	if err != nil {
+		if err := cloneStore.Create(ctx, wrappedConfig); err != nil {
+			cloneStore.Close()
+			return nil, fmt.Errorf("could not create repository: %w", err)
+		}
+		return cloneStore, nil
+	}
+
+	existing, err := storage.NewConfigurationFromWrappedBytes(existingConfig)
+	if err != nil {
+		cloneStore.Close()
+		return nil, fmt.Errorf("could not read the configuration of %s: %w", cmd.Dest, err)
+	}
+	if existing.RepositoryID != configuration.RepositoryID {
+		cloneStore.Close()
+		return nil, fmt.Errorf("%s is not a clone of this repository", cmd.Dest)
+	}
+	return cloneStore, nil
+}
+
+// missing returns the objects of src that dst doesn't have.
+func missing(k kind, src, dst storage.Store) ([]objects.MAC, error) {
+	srcList, err := k.list(src)
+	if err != nil {
+		return nil, err
+	}
+	dstList, err := k.list(dst)
 	if err != nil {
-		return 1, fmt.Errorf("could not create repository: %w", err)
+		return nil, err
+	}
+
+	present := make(map[objects.MAC]struct{}, len(dstList))
+	for _, mac := range dstList {
+		present[mac] = struct{}{}
 	}
 
-	packfileMACs, err := sourceStore.GetPackfiles()
+	var ret []objects.MAC
+	for _, mac := range srcList {
+		if _, ok := present[mac]; !ok {
+			ret = append(ret, mac)
+		}
+	}
+	return ret, nil
+}
+
+// copy copies the objects of a kind that the clone is missing, and
+// returns how many were copied and their size.
+func (cmd *Clone) copy(ctx *appcontext.AppContext, repo *repository.Repository, k kind, src, dst, verifyStore storage.Store) (int, int64, error) {
+	macs, err := missing(k, src, dst)
 	if err != nil {
-		return 1, fmt.Errorf("could not get packfiles list from repository: %w", err)
+		return 0, 0, err
 	}
 
+	var copied, size atomic.Int64
 	wg := new(errgroup.Group)
 	wg.SetLimit(ctx.MaxConcurrency)
-	for _, packfileMAC := range packfileMACs {
-		if err := ctx.Err(); err != nil {
+	for _, mac := range macs {
+		if ctx.Err() != nil {
 			break
 		}
 
-		packfileMAC := packfileMAC
 		wg.Go(func() error {
-			rd, err := sourceStore.GetPackfile(packfileMAC)
+			rd, err := k.get(src, mac)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "could not get packfile from repository: %s\n", err)
-				return err
+				return fmt.Errorf("could not get %x from repository: %w", mac, err)
 			}
 
-			_, err = cloneStore.PutPackfile(packfileMAC, rd)
+			// the digest of what was copied, to compare with the
+			// object read back
+			hasher := sha256.New()
+			n, err := k.put(dst, mac, io.TeeReader(rd, hasher))
+			if closer, ok := rd.(io.Closer); ok {
+				closer.Close()
+			}
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "could not put packfile to repository: %s\n", err)
-				return err
+				return fmt.Errorf("could not put %x to clone: %w", mac, err)
 			}
 
+			if cmd.Verify {
+				if err := verify(repo, k, dst, verifyStore, mac, hasher.Sum(nil)); err != nil {
+					// don't leave it for a resumed clone to skip
+					k.delete(dst, mac)
+					return fmt.Errorf("verification of %x failed: %w", mac, err)
+				}
+			}
+
+			copied.Add(1)
+			size.Add(n)
 			return nil
 		})
 	}
 	if err := wg.Wait(); err != nil {
-		return 1, fmt.Errorf("failed to process packfiles: %v", err)
+		return int(copied.Load()), size.Load(), err
+	}
+	return int(copied.Load()), size.Load(), ctx.Err()
+}
+
+// verify reads an object back from the clone and checks that it holds
+// the data copied, of which sum is the SHA-256 digest, and that its serialized MAC is valid when read the
+// way the repository reads it.  Packfiles are not checked against their
+// name: those of a ptar archive are named randomly.
+func verify(repo *repository.Repository, k kind, dst, verifyStore storage.Store, mac objects.MAC, sum []byte) error {
+	rd, err := k.get(dst, mac)
+	if err != nil {
+		return err
+	}
+	if closer, ok := rd.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	hasher := sha256.New()
+	if verifyStore == dst {
+		err = deserialize(repo, k, io.TeeReader(rd, hasher))
+	} else {
+		// the sealing of an asymmetric repository is only undone
+		// by reading the object again through it
+		if _, err = io.Copy(hasher, rd); err == nil {
+			err = readBack(repo, k, verifyStore, mac)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(hasher.Sum(nil), sum) {
+		return fmt.Errorf("content differs from the repository")
+	}
+	return nil
+}
+
+func readBack(repo *repository.Repository, k kind, store storage.Store, mac objects.MAC) error {
+	rd, err := k.get(store, mac)
+	if err != nil {
+		return err
+	}
+	if closer, ok := rd.(io.Closer); ok {
+		defer closer.Close()
 	}
+	return deserialize(repo, k, rd)
+}
 
-	indexesMACs, err := sourceStore.GetStates()
+// deserialize reads rd to its end, failing if its MAC is invalid.
+func deserialize(repo *repository.Repository, k kind, rd io.Reader) error {
+	_, data, err := storage.Deserialize(repo.GetMACHasher(), k.rtype, rd)
 	if err != nil {
-		return 1, fmt.Errorf("could not get packfiles list from repository: %w", err)
+		return err
+	}
+	if _, err := io.Copy(io.Discard, data); err != nil {
+		return err
 	}
+	_, err = io.Copy(io.Discard, rd)
+	return err
+}
 
-	wg = new(errgroup.Group)
+// deleteExtra deletes the objects of a kind that the clone has but not
+// the repository, and returns how many were deleted.
+func (cmd *Clone) deleteExtra(ctx *appcontext.AppContext, k kind, src, dst storage.Store) (int, error) {
+	macs, err := missing(k, dst, src)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted atomic.Int64
+	wg := new(errgroup.Group)
 	wg.SetLimit(ctx.MaxConcurrency)
-	for _, indexMAC := range indexesMACs {
-		if err := ctx.Err(); err != nil {
+	for _, mac := range macs {
+		if ctx.Err() != nil {
 			break
 		}
 
-		indexMAC := indexMAC
 		wg.Go(func() error {
-			data, err := sourceStore.GetState(indexMAC)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "could not get index from repository: %s\n", err)
-				return err
+			if err := k.delete(dst, mac); err != nil {
+				return fmt.Errorf("could not delete %x from clone: %w", mac, err)
 			}
-
-			_, err = cloneStore.PutState(indexMAC, data)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "could not put packfile to repository: %s\n", err)
-				return err
-			}
-
+			deleted.Add(1)
 			return nil
 		})
 	}
 	if err := wg.Wait(); err != nil {
-		return 1, fmt.Errorf("failed to process states: %v", err)
+		return int(deleted.Load()), err
 	}
-
-	return 0, nil
+	return int(deleted.Load()), ctx.Err()
 }
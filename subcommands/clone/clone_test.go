@@ -6,7 +6,13 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/storage"
 	_ "github.com/PlakarKorp/plakar/connectors/fs/exporter"
+	bfs "github.com/PlakarKorp/plakar/connectors/fs/storage"
+	_ "github.com/PlakarKorp/plakar/connectors/ptar/storage"
+	"github.com/PlakarKorp/plakar/subcommands/ptar"
 	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/stretchr/testify/require"
 )
@@ -51,3 +57,104 @@ func TestExecuteCmdClone(t *testing.T) {
 	_, err = os.Stat(outputDir)
 	require.NoError(t, err)
 }
+
+func TestExecuteCmdCloneIncremental(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("foo.txt", 0644, "hello foo"),
+	})
+	snap.Close()
+
+	outputDir := filepath.Join(t.TempDir(), "clone")
+	clone := func(args ...string) {
+		bufOut.Reset()
+		subcommand := &Clone{}
+		require.NoError(t, subcommand.Parse(ctx, append(args, "to", outputDir)))
+		status, err := subcommand.Execute(ctx, repo)
+		require.NoError(t, err)
+		require.Equal(t, 0, status)
+	}
+
+	clone()
+	require.Contains(t, bufOut.String(), "clone: 1 states copied")
+
+	// only the new objects are copied
+	clone()
+	require.Contains(t, bufOut.String(), "clone: 0 packfiles copied")
+	require.Contains(t, bufOut.String(), "clone: 0 states copied")
+
+	snap = ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("bar.txt", 0644, "hello bar"),
+	})
+	snap.Close()
+	clone()
+	require.Contains(t, bufOut.String(), "clone: 1 states copied")
+
+	// extra objects are only deleted with -delete
+	cloneStore, err := bfs.NewStore(ctx, "fs", map[string]string{"location": "fs://" + outputDir})
+	require.NoError(t, err)
+	_, err = cloneStore.Open(ctx)
+	require.NoError(t, err)
+	_, err = cloneStore.PutState(objects.RandomMAC(), bytes.NewReader([]byte("extra")))
+	require.NoError(t, err)
+
+	clone()
+	require.NotContains(t, bufOut.String(), "deleted")
+	clone("-delete")
+	require.Contains(t, bufOut.String(), "clone: 1 states deleted")
+	states, err := cloneStore.GetStates()
+	require.NoError(t, err)
+	require.Len(t, states, 2)
+
+	// the destination must be a clone of the repository
+	other, _ := ptesting.GenerateRepository(t, nil, nil, nil)
+	subcommand := &Clone{}
+	require.NoError(t, subcommand.Parse(ctx, []string{"to", other.Location()}))
+	_, err = subcommand.Execute(ctx, repo)
+	require.ErrorContains(t, err, "is not a clone of this repository")
+}
+
+func TestExecuteCmdClonePtar(t *testing.T) {
+	srcRepo, _ := ptesting.GenerateRepository(t, nil, nil, nil)
+	snap := ptesting.GenerateSnapshot(t, srcRepo, []ptesting.MockFile{
+		ptesting.NewMockFile("foo.txt", 0644, "hello foo"),
+	})
+	snap.Close()
+
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepositoryWithoutConfig(t, bufOut, bufErr, nil)
+
+	archive := filepath.Join(t.TempDir(), "test.ptar")
+	subcommand := &ptar.Ptar{}
+	require.NoError(t, subcommand.Parse(ctx, []string{"-plaintext", "-o", archive, "-k", srcRepo.Location()}))
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// the packfiles of a ptar archive aren't named after their content
+	store, config, err := storage.Open(ctx.GetInner(), map[string]string{"location": "ptar://" + archive})
+	require.NoError(t, err)
+	ptarRepo, err := repository.New(ctx.GetInner(), nil, store, config)
+	require.NoError(t, err)
+	defer ptarRepo.Close()
+
+	outputDir := filepath.Join(t.TempDir(), "clone")
+	clone := &Clone{}
+	require.NoError(t, clone.Parse(ctx, []string{"to", outputDir}))
+	status, err = clone.Execute(ctx, ptarRepo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "clone: 1 states copied")
+
+	cloneStore, err := bfs.NewStore(ctx, "fs", map[string]string{"location": "fs://" + outputDir})
+	require.NoError(t, err)
+	_, err = cloneStore.Open(ctx)
+	require.NoError(t, err)
+	packfiles, err := cloneStore.GetPackfiles()
+	require.NoError(t, err)
+	require.NotEmpty(t, packfiles)
+}
@@ -23,16 +23,15 @@ import (
 	"hash"
 	"io"
 	"os"
-	"strings"
 
-	"github.com/PlakarKorp/kloset/compression"
-	"github.com/PlakarKorp/kloset/encryption"
 	"github.com/PlakarKorp/kloset/hashing"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/resources"
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/kloset/versioning"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/keyring"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 )
@@ -43,6 +42,9 @@ func init() {
 
 func (cmd *Create) Parse(ctx *appcontext.AppContext, args []string) error {
 	var allow_weak bool
+	var profileName string
+	var overrides plakarstorage.Profile
+	var noCompression bool
 
 	flags := flag.NewFlagSet("create", flag.ExitOnError)
 	flags.Usage = func() {
@@ -53,18 +55,33 @@ func (cmd *Create) Parse(ctx *appcontext.AppContext, args []string) error {
 	}
 
 	flags.BoolVar(&allow_weak, "weak-passphrase", false, "allow weak passphrase to protect the repository")
-	flags.StringVar(&cmd.Hashing, "hashing", hashing.DEFAULT_HASHING_ALGORITHM, "hashing algorithm to use for digests")
+	flags.StringVar(&profileName, "profile", "default", "parameters of the repository: default, archive, fast or the path to a YAML file")
+	overrides.Flags(flags)
 	flags.BoolVar(&cmd.NoEncryption, "plaintext", false, "disable transparent encryption")
-	flags.BoolVar(&cmd.NoCompression, "no-compression", false, "disable transparent compression")
+	flags.BoolVar(&cmd.Asymmetric, "asymmetric", false, "encrypt to a key pair, so that backing up doesn't need the passphrase")
+	flags.BoolVar(&noCompression, "no-compression", false, "disable transparent compression")
 	flags.Parse(args)
 
 	if flags.NArg() != 0 {
 		return fmt.Errorf("%s: too many parameters", flag.CommandLine.Name())
 	}
+	if cmd.Asymmetric && cmd.NoEncryption {
+		return fmt.Errorf("%s: -asymmetric and -plaintext are mutually exclusive", flag.CommandLine.Name())
+	}
+
+	if noCompression {
+		overrides.Compression = "none"
+	}
 
-	if hashing.GetHasher(strings.ToUpper(cmd.Hashing)) == nil {
-		return fmt.Errorf("%s: unknown hashing algorithm", flag.CommandLine.Name())
+	profile, err := plakarstorage.LoadProfile(profileName)
+	if err != nil {
+		return err
+	}
+	profile.Merge(&overrides)
+	if _, err := profile.Configuration(); err != nil {
+		return err
 	}
+	cmd.Profile = *profile
 
 	minEntropBits := 80.
 	if allow_weak {
@@ -106,39 +123,48 @@ func (cmd *Create) Parse(ctx *appcontext.AppContext, args []string) error {
 type Create struct {
 	subcommands.SubcommandBase
 
-	Hashing       string
-	NoEncryption  bool
-	NoCompression bool
+	Profile      plakarstorage.Profile
+	NoEncryption bool
+	Asymmetric   bool
 }
 
 func (cmd *Create) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
-	storageConfiguration := storage.NewConfiguration()
-	if cmd.NoCompression {
-		storageConfiguration.Compression = nil
-	} else {
-		storageConfiguration.Compression = compression.NewDefaultConfiguration()
-	}
-
-	hashingConfiguration, err := hashing.LookupDefaultConfiguration(strings.ToUpper(cmd.Hashing))
+	storageConfiguration, err := cmd.Profile.Configuration()
 	if err != nil {
 		return 1, err
 	}
-	storageConfiguration.Hashing = *hashingConfiguration
 
 	var hasher hash.Hash
+	var k *keyring.Keyring
 	if !cmd.NoEncryption {
-		key, err := encryption.DeriveKey(storageConfiguration.Encryption.KDFParams,
-			cmd.RepositorySecret)
+		typ := keyring.TypePassphrase
+		if ctx.KeyFromFile != "" {
+			typ = keyring.TypeKeyfile
+		}
+
+		if cmd.Asymmetric {
+			keyring.NewAsymmetricConfiguration(storageConfiguration.Encryption)
+		}
+
+		var key []byte
+		k, key, err = keyring.Create(repo.Store(), storageConfiguration.Encryption,
+			"default", typ, cmd.RepositorySecret)
 		if err != nil {
 			return 1, err
 		}
 
-		canary, err := encryption.DeriveCanary(storageConfiguration.Encryption, key)
+		canary, err := keyring.DeriveCanary(storageConfiguration.Encryption, key)
 		if err != nil {
 			return 1, err
 		}
 		storageConfiguration.Encryption.Canary = canary
-		hasher = hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, key)
+
+		// kloset opens asymmetric repositories without a key
+		if cmd.Asymmetric {
+			hasher = hashing.GetHasher(storage.DEFAULT_HASHING_ALGORITHM)
+		} else {
+			hasher = hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, key)
+		}
 	} else {
 		storageConfiguration.Encryption = nil
 		hasher = hashing.GetHasher(storage.DEFAULT_HASHING_ALGORITHM)
@@ -162,5 +188,11 @@ func (cmd *Create) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 		return 1, err
 	}
 
+	if k != nil {
+		if err := keyring.Save(repo.Store(), k); err != nil {
+			return 1, fmt.Errorf("failed to save the key slots, the repository can't be unlocked: %w", err)
+		}
+	}
+
 	return 0, nil
 }
@@ -1,14 +1,19 @@
 package create
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"syscall"
 	"testing"
 
+	"github.com/PlakarKorp/kloset/hashing"
 	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/plakar/appcontext"
 	_ "github.com/PlakarKorp/plakar/connectors/fs/storage"
+	"github.com/PlakarKorp/plakar/keyring"
 	"github.com/creack/pty"
 	"github.com/stretchr/testify/require"
 )
@@ -44,6 +49,29 @@ func TestExecuteCmdCreateDefaultWithHashing(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestExecuteCmdCreateWithProfile(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	defer ctx.Close()
+
+	repo, err := repository.Inexistent(ctx.GetInner(), map[string]string{"location": t.TempDir() + "/repo"})
+	require.NoError(t, err)
+
+	subcommand := &Create{}
+	err = subcommand.Parse(ctx, []string{"-plaintext", "-profile", "archive", "-packfile-size", "64MiB"})
+	require.NoError(t, err)
+	require.Equal(t, "gzip", subcommand.Profile.Compression)
+	require.Equal(t, "4MiB", subcommand.Profile.ChunkAvgSize)
+	require.Equal(t, "64MiB", subcommand.Profile.PackfileSize)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	subcommand = &Create{}
+	err = subcommand.Parse(ctx, []string{"-plaintext", "-profile", "laptop"})
+	require.Error(t, err)
+}
+
 func TestExecuteCmdCreateDefaultWithoutCompression(t *testing.T) {
 	tmpRepoDirRoot, err := os.MkdirTemp("", "tmp_repo")
 	require.NoError(t, err)
@@ -207,3 +235,46 @@ func TestExecuteCmdCreateDefaultWithEnvPassphrase(t *testing.T) {
 	_, err = os.Stat(fmt.Sprintf("%s/repo/CONFIG", tmpRepoDirRoot))
 	require.NotNil(t, err)
 }
+
+func TestExecuteCmdCreateAsymmetric(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	defer ctx.Close()
+
+	location := t.TempDir() + "/repo"
+	repo, err := repository.Inexistent(ctx.GetInner(), map[string]string{"location": location})
+	require.NoError(t, err)
+
+	t.Setenv("PLAKAR_PASSPHRASE", "aZeRtY123456$#@!@")
+
+	subcommand := &Create{}
+	err = subcommand.Parse(ctx, []string{"-asymmetric", "-plaintext"})
+	require.Error(t, err)
+
+	subcommand = &Create{}
+	err = subcommand.Parse(ctx, []string{"-asymmetric"})
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	store, serializedConfig, err := storage.Open(ctx.GetInner(), map[string]string{"location": location})
+	require.NoError(t, err)
+	config, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+	require.NoError(t, err)
+	require.True(t, keyring.IsAsymmetric(config.Encryption))
+
+	key, err := keyring.Unlock(store, config.Encryption, []byte("aZeRtY123456$#@!@"))
+	require.NoError(t, err)
+
+	public, err := keyring.PublicKey(key)
+	require.NoError(t, err)
+	require.Equal(t, config.Encryption.Canary, public)
+
+	// kloset opens it without a key
+	_, secret, err := keyring.OpenStore(store, serializedConfig, key, t.TempDir())
+	require.NoError(t, err)
+	require.Nil(t, secret)
+	_, _, err = storage.Deserialize(hashing.GetHasher(storage.DEFAULT_HASHING_ALGORITHM), resources.RT_CONFIG, bytes.NewReader(serializedConfig))
+	require.NoError(t, err)
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package grep
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/search"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/dustin/go-humanize"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Grep{} }, subcommands.AgentSupport, "grep")
+}
+
+func (cmd *Grep) Parse(ctx *appcontext.AppContext, args []string) error {
+	var opt_maxSize string
+
+	cmd.LocateOptions = utils.NewDefaultLocateOptions()
+
+	flags := flag.NewFlagSet("grep", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] PATTERN [SNAPSHOT[:PATH]]...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.BoolVar(&cmd.IgnoreCase, "i", false, "ignore case distinctions")
+	flags.BoolVar(&cmd.Fixed, "F", false, "interpret the pattern as a fixed string")
+	flags.BoolVar(&cmd.FilesOnly, "l", false, "only print the names of the matching files")
+	flags.BoolVar(&cmd.Index, "index", false, "use the content index of the snapshots, building it if needed")
+	flags.StringVar(&opt_maxSize, "max-size", humanize.IBytes(search.DefaultMaxSize), "skip files larger than this size")
+	cmd.LocateOptions.InstallFlags(flags)
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("a pattern is required")
+	}
+
+	maxSize, err := humanize.ParseBytes(opt_maxSize)
+	if err != nil {
+		return fmt.Errorf("invalid -max-size: %w", err)
+	}
+
+	cmd.Pattern, err = search.ParsePattern(flags.Arg(0), cmd.Fixed, cmd.IgnoreCase)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	cmd.LocateOptions.MaxConcurrency = ctx.MaxConcurrency
+	cmd.LocateOptions.SortOrder = utils.LocateSortOrderAscending
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.MaxSize = int64(maxSize)
+	cmd.Snapshots = flags.Args()[1:]
+
+	return nil
+}
+
+type Grep struct {
+	subcommands.SubcommandBase
+
+	LocateOptions *utils.LocateOptions
+	IgnoreCase    bool
+	Fixed         bool
+	FilesOnly     bool
+	Index         bool
+	MaxSize       int64
+	Pattern       *regexp.Regexp
+	Snapshots     []string
+}
+
+func (cmd *Grep) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	type target struct {
+		snapshotID objects.MAC
+		path       string
+	}
+
+	var targets []target
+	if len(cmd.Snapshots) == 0 {
+		snapshotIDs, err := utils.LocateSnapshotIDs(repo, cmd.LocateOptions)
+		if err != nil {
+			return 1, fmt.Errorf("grep: could not fetch snapshots list: %w", err)
+		}
+		for _, snapshotID := range snapshotIDs {
+			targets = append(targets, target{snapshotID: snapshotID, path: "/"})
+		}
+	} else {
+		for _, snapPath := range cmd.Snapshots {
+			prefix, path := utils.ParseSnapshotPath(snapPath)
+			snapshotID, err := utils.LocateSnapshotByPrefix(repo, prefix)
+			if err != nil {
+				return 1, fmt.Errorf("grep: %s: %w", snapPath, err)
+			}
+			targets = append(targets, target{snapshotID: snapshotID, path: path})
+		}
+	}
+
+	indexes := search.NewIndexCache(ctx.CacheDir, repo.Configuration().RepositoryID)
+
+	matched := false
+	errors := 0
+	for _, t := range targets {
+		snap, err := snapshot.Load(repo, t.snapshotID)
+		if err != nil {
+			return 1, fmt.Errorf("grep: could not get snapshot: %w", err)
+		}
+
+		opts := &search.Options{MaxSize: cmd.MaxSize}
+		if cmd.Index {
+			opts.Index, err = indexes.GetOrBuild(ctx, snap, cmd.MaxSize)
+			if err != nil {
+				snap.Close()
+				return 1, fmt.Errorf("grep: could not index snapshot: %w", err)
+			}
+		}
+
+		lastPath := ""
+		for match, err := range search.Grep(ctx, snap, t.path, cmd.Pattern, opts) {
+			if err != nil {
+				if err := ctx.Err(); err != nil {
+					snap.Close()
+					return 1, err
+				}
+				ctx.GetLogger().Error("grep: %x: %s", snap.Header.GetIndexShortID(), err)
+				errors++
+				continue
+			}
+
+			matched = true
+			if cmd.FilesOnly {
+				if match.Path != lastPath {
+					fmt.Fprintf(ctx.Stdout, "%x:%s\n", snap.Header.GetIndexShortID(), utils.SanitizeText(match.Path))
+				}
+			} else {
+				fmt.Fprintf(ctx.Stdout, "%x:%s:%d:%s\n", snap.Header.GetIndexShortID(),
+					utils.SanitizeText(match.Path), match.Line, utils.SanitizeText(match.Text))
+			}
+			lastPath = match.Path
+		}
+		snap.Close()
+	}
+
+	if errors != 0 {
+		return 1, fmt.Errorf("errors occurred")
+	}
+	if !matched {
+		return 1, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,95 @@
+package grep
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	os.Setenv("TZ", "UTC")
+}
+
+func generateSnapshot(t *testing.T, bufOut *bytes.Buffer, bufErr *bytes.Buffer) (*repository.Repository, *snapshot.Snapshot, *appcontext.AppContext) {
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockDir("another_subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy\npassword=secret\n"),
+		ptesting.NewMockFile("subdir/foo.txt", 0644, "hello foo"),
+		ptesting.NewMockFile("another_subdir/bar.txt", 0644, "hello bar"),
+	})
+	ctx.CacheDir = t.TempDir()
+	return repo, snap, ctx
+}
+
+func TestExecuteCmdGrepDefault(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	subcommand := &Grep{}
+	err := subcommand.Parse(ctx, []string{"password"})
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// output should look like this
+	// d92a4c73:/subdir/dummy.txt:2:password=secret
+	lines := strings.Split(strings.Trim(bufOut.String(), "\n"), "\n")
+	require.Len(t, lines, 1)
+	require.True(t, strings.HasSuffix(lines[0], "/subdir/dummy.txt:2:password=secret"))
+}
+
+func TestExecuteCmdGrepWithIndex(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	shortID := hex.EncodeToString(snap.Header.GetIndexShortID())
+
+	subcommand := &Grep{}
+	err := subcommand.Parse(ctx, []string{"-index", "-l", "-i", "HELLO", shortID})
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	lines := strings.Split(strings.Trim(bufOut.String(), "\n"), "\n")
+	require.Len(t, lines, 3)
+	for _, line := range lines {
+		require.True(t, strings.HasPrefix(line, shortID+":"))
+	}
+}
+
+func TestExecuteCmdGrepNoMatch(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	subcommand := &Grep{}
+	err := subcommand.Parse(ctx, []string{"nothing", hex.EncodeToString(snap.Header.GetIndexShortID()) + ":/another_subdir"})
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 1, status)
+	require.Empty(t, bufOut.String())
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package identity
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/identity"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Identity{} }, subcommands.BeforeRepositoryOpen, "identity")
+}
+
+type Identity struct {
+	subcommands.SubcommandBase
+
+	args []string
+}
+
+func (cmd *Identity) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("identity", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [create|default|export|import|list|remove]\n", flags.Name())
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+	cmd.args = flags.Args()
+
+	return nil
+}
+
+func (cmd *Identity) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	im := identity.NewManager(ctx.ConfigDir)
+	if err := cmd_identity(ctx, im, cmd.args); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func cmd_identity(ctx *appcontext.AppContext, im *identity.Manager, args []string) error {
+	usage := "usage: plakar identity [create|default|export|import|list|remove]"
+	cmd := "list"
+	if len(args) > 0 {
+		cmd = args[0]
+		args = args[1:]
+	}
+
+	switch cmd {
+	case "create":
+		usage := "usage: plakar identity create <name>"
+		if len(args) != 1 {
+			return fmt.Errorf(usage)
+		}
+		id, err := im.Create(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(ctx.Stdout, "created identity %s (%s)\n", id.Name, id.Identifier)
+		return nil
+
+	case "list", "ls":
+		usage := "usage: plakar identity list"
+		if len(args) != 0 {
+			return fmt.Errorf(usage)
+		}
+		def, err := im.Default()
+		if err != nil {
+			return err
+		}
+		list, err := im.List()
+		if err != nil {
+			return err
+		}
+		for _, id := range list {
+			kind := "trusted"
+			if id.HasPrivateKey() {
+				kind = "signing"
+			}
+			if def != nil && def.Identifier == id.Identifier {
+				kind += " default"
+			}
+			fmt.Fprintf(ctx.Stdout, "%s %s %s %s\n",
+				id.Created.UTC().Format("2006-01-02T15:04:05Z"), id.Identifier, id.Name, kind)
+		}
+		return nil
+
+	case "default":
+		usage := "usage: plakar identity default <identity>"
+		if len(args) != 1 {
+			return fmt.Errorf(usage)
+		}
+		return im.SetDefault(args[0])
+
+	case "export":
+		usage := "usage: plakar identity export [-private] <identity>"
+		flags := flag.NewFlagSet("identity export", flag.ContinueOnError)
+		private := flags.Bool("private", false, "include the private key")
+		if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+			return fmt.Errorf(usage)
+		}
+		data, err := im.Export(flags.Arg(0), *private)
+		if err != nil {
+			return err
+		}
+		_, err = ctx.Stdout.Write(data)
+		return err
+
+	case "import":
+		usage := "usage: plakar identity import [file]"
+		if len(args) > 1 {
+			return fmt.Errorf(usage)
+		}
+		var data []byte
+		var err error
+		if len(args) == 0 || args[0] == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(args[0])
+		}
+		if err != nil {
+			return err
+		}
+		id, err := im.Import(data)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(ctx.Stdout, "imported identity %s (%s)\n", id.Name, id.Identifier)
+		return nil
+
+	case "remove", "rm":
+		usage := "usage: plakar identity remove <identity>"
+		if len(args) != 1 {
+			return fmt.Errorf(usage)
+		}
+		return im.Remove(args[0])
+
+	default:
+		return fmt.Errorf(usage)
+	}
+}
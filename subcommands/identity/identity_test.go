@@ -0,0 +1,67 @@
+package identity
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteCmdIdentity(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+
+	newContext := func() *appcontext.AppContext {
+		ctx := appcontext.NewAppContext()
+		ctx.ConfigDir = t.TempDir()
+		ctx.Stdout = bufOut
+		return ctx
+	}
+
+	run := func(ctx *appcontext.AppContext, args ...string) error {
+		subcommand := &Identity{}
+		require.NoError(t, subcommand.Parse(ctx, args))
+		_, err := subcommand.Execute(ctx, nil)
+		return err
+	}
+
+	src := newContext()
+	require.NoError(t, run(src, "create", "alice"))
+	require.Contains(t, bufOut.String(), "created identity alice")
+	require.NoError(t, run(src, "create", "bob"))
+	require.Error(t, run(src, "create"))
+
+	bufOut.Reset()
+	require.NoError(t, run(src))
+	lines := strings.Split(strings.TrimSpace(bufOut.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], "alice signing default")
+	require.Contains(t, lines[1], "bob signing")
+
+	require.NoError(t, run(src, "default", "bob"))
+	bufOut.Reset()
+	require.NoError(t, run(src, "list"))
+	require.Contains(t, bufOut.String(), "bob signing default")
+
+	bufOut.Reset()
+	require.NoError(t, run(src, "export", "alice"))
+	exported := filepath.Join(t.TempDir(), "alice.yml")
+	require.NoError(t, os.WriteFile(exported, bufOut.Bytes(), 0600))
+
+	dst := newContext()
+	bufOut.Reset()
+	require.NoError(t, run(dst, "import", exported))
+	require.Contains(t, bufOut.String(), "imported identity alice")
+
+	bufOut.Reset()
+	require.NoError(t, run(dst, "list"))
+	require.Contains(t, bufOut.String(), "alice trusted\n")
+
+	require.NoError(t, run(dst, "remove", "alice"))
+	bufOut.Reset()
+	require.NoError(t, run(dst, "list"))
+	require.Empty(t, bufOut.String())
+}
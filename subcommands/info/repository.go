@@ -8,6 +8,7 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/subcommands"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
 	"github.com/dustin/go-humanize"
 )
 
@@ -65,7 +66,6 @@ func (cmd *InfoRepository) Execute(ctx *appcontext.AppContext, repo *repository.
 	if repo.Configuration().Compression != nil {
 		fmt.Fprintln(ctx.Stdout, "Compression:")
 		fmt.Fprintln(ctx.Stdout, " - Algorithm:", repo.Configuration().Compression.Algorithm)
-		fmt.Fprintln(ctx.Stdout, " - Level:", repo.Configuration().Compression.Level)
 	}
 
 	if repo.Configuration().Encryption != nil {
@@ -81,7 +81,9 @@ func (cmd *InfoRepository) Execute(ctx *appcontext.AppContext, repo *repository.
 			fmt.Fprintf(ctx.Stdout, "   - SaltSize: %d\n", repo.Configuration().Encryption.KDFParams.Argon2idParams.SaltSize)
 			fmt.Fprintf(ctx.Stdout, "   - KeyLen: %d\n", repo.Configuration().Encryption.KDFParams.Argon2idParams.KeyLen)
 			fmt.Fprintf(ctx.Stdout, "   - Time: %d\n", repo.Configuration().Encryption.KDFParams.Argon2idParams.Time)
-			fmt.Fprintf(ctx.Stdout, "   - Memory: %d\n", repo.Configuration().Encryption.KDFParams.Argon2idParams.Memory)
+			fmt.Fprintf(ctx.Stdout, "   - Memory: %s (%d KiB)\n",
+				humanize.IBytes(uint64(repo.Configuration().Encryption.KDFParams.Argon2idParams.Memory)*1024),
+				repo.Configuration().Encryption.KDFParams.Argon2idParams.Memory)
 			fmt.Fprintf(ctx.Stdout, "   - Thread: %d\n", repo.Configuration().Encryption.KDFParams.Argon2idParams.Threads)
 		case "SCRYPT":
 			fmt.Fprintf(ctx.Stdout, "   - SaltSize: %d\n", repo.Configuration().Encryption.KDFParams.ScryptParams.SaltSize)
@@ -111,5 +113,41 @@ func (cmd *InfoRepository) Execute(ctx *appcontext.AppContext, repo *repository.
 	fmt.Fprintf(ctx.Stdout, "Storage size: %s (%d bytes)\n", humanize.Bytes(uint64(storageSize)), uint64(storageSize))
 	fmt.Fprintf(ctx.Stdout, "Logical size: %s (%d bytes)\n", humanize.Bytes(uint64(logicalSize)), logicalSize)
 
+	if cache, ok := plakarstorage.IsBlockCache(repo.Store()); ok {
+		stats := cache.Stats()
+		fmt.Fprintln(ctx.Stdout, "Block cache:")
+		fmt.Fprintf(ctx.Stdout, " - Size: %s (%d bytes)\n", humanize.Bytes(uint64(stats.Size)), stats.Size)
+		fmt.Fprintf(ctx.Stdout, " - Limit: %s (%d bytes)\n", humanize.Bytes(uint64(stats.Limit)), stats.Limit)
+		fmt.Fprintf(ctx.Stdout, " - Hits: %d (%s)\n", stats.Hits, humanize.Bytes(uint64(stats.HitBytes)))
+		fmt.Fprintf(ctx.Stdout, " - Misses: %d (%s)\n", stats.Misses, humanize.Bytes(uint64(stats.MissBytes)))
+		fmt.Fprintf(ctx.Stdout, " - Evictions: %d\n", stats.Evictions)
+		fmt.Fprintf(ctx.Stdout, " - Hit rate: %.1f%%\n", stats.HitRate()*100)
+	}
+
+	if mirror, ok := plakarstorage.IsMirror(repo.Store()); ok {
+		reports, err := mirror.Reconcile()
+		if err != nil {
+			return 1, fmt.Errorf("unable to reconcile the mirror: %w", err)
+		}
+		fmt.Fprintln(ctx.Stdout, "Mirror:")
+		for _, report := range reports {
+			switch {
+			case report.Err != nil:
+				fmt.Fprintf(ctx.Stdout, " - %s: unavailable: %s\n", report.Member, report.Err)
+			case report.InSync():
+				fmt.Fprintf(ctx.Stdout, " - %s: in sync\n", report.Member)
+			default:
+				fmt.Fprintf(ctx.Stdout, " - %s: missing %d states, %d packfiles\n",
+					report.Member, len(report.States), len(report.Packfiles))
+				for _, mac := range report.States {
+					fmt.Fprintf(ctx.Stdout, "   - state %x\n", mac)
+				}
+				for _, mac := range report.Packfiles {
+					fmt.Fprintf(ctx.Stdout, "   - packfile %x\n", mac)
+				}
+			}
+		}
+	}
+
 	return 0, nil
 }
@@ -0,0 +1,303 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package key
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/keyring"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/PlakarKorp/plakar/utils/qrcode"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Key{} }, 0, "key")
+}
+
+type Key struct {
+	subcommands.SubcommandBase
+
+	args []string
+}
+
+func (cmd *Key) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("key", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [add|export-recovery|list|passwd|remove]\n", flags.Name())
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+	cmd.args = flags.Args()
+	cmd.RepositorySecret = ctx.GetSecret()
+
+	return nil
+}
+
+func (cmd *Key) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	if repo.Configuration().Encryption == nil {
+		return 1, fmt.Errorf("the repository is not encrypted")
+	}
+
+	if err := cmd_key(ctx, repo, cmd.args); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// load returns the keyring of the repository, a new legacy one if it
+// predates key slots.
+func load(repo *repository.Repository) (*keyring.Keyring, error) {
+	if _, ok := plakarstorage.HasKeyring(repo.Store()); !ok {
+		return nil, keyring.ErrUnsupported
+	}
+
+	k, err := keyring.Load(repo.Store())
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		k = &keyring.Keyring{Version: keyring.VERSION, Legacy: true}
+	}
+	return k, nil
+}
+
+// warnLegacy reminds that the slots of a repository predating them
+// don't replace the passphrase given at creation: it derives the key
+// that encrypts the data and can't be revoked.
+func warnLegacy(ctx *appcontext.AppContext, k *keyring.Keyring) {
+	if k.Legacy {
+		fmt.Fprintf(ctx.Stderr, "key: the passphrase given at creation still unlocks the repository and can't be revoked,\n")
+		fmt.Fprintf(ctx.Stderr, "key: synchronize the snapshots to a new repository to retire it\n")
+	}
+}
+
+// getSecret prompts for a new passphrase, or reads the keyfile, which is
+// generated if it doesn't exist yet.
+func getSecret(ctx *appcontext.AppContext, keyfile string, minEntropyBits float64) (string, []byte, error) {
+	if keyfile == "" {
+		passphrase, err := utils.GetPassphraseConfirm("key slot", minEntropyBits)
+		return keyring.TypePassphrase, passphrase, err
+	}
+
+	data, err := os.ReadFile(keyfile)
+	if errors.Is(err, fs.ErrNotExist) {
+		var buf [32]byte
+		if _, err := rand.Read(buf[:]); err != nil {
+			return "", nil, err
+		}
+		data = []byte(hex.EncodeToString(buf[:]) + "\n")
+
+		fp, err := os.OpenFile(keyfile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return "", nil, err
+		}
+		if _, err := fp.Write(data); err != nil {
+			fp.Close()
+			return "", nil, err
+		}
+		if err := fp.Close(); err != nil {
+			return "", nil, err
+		}
+		fmt.Fprintf(ctx.Stdout, "generated keyfile %s\n", keyfile)
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	// read the same way as the -keyfile option
+	return keyring.TypeKeyfile, []byte(strings.TrimSuffix(string(data), "\n")), nil
+}
+
+func cmd_key(ctx *appcontext.AppContext, repo *repository.Repository, args []string) error {
+	cmd := "list"
+	if len(args) > 0 {
+		cmd = args[0]
+		args = args[1:]
+	}
+
+	switch cmd {
+	case "add":
+		usage := "usage: plakar key add [-keyfile path] [-name name] [-weak-passphrase]"
+		flags := flag.NewFlagSet("key add", flag.ContinueOnError)
+		keyfile := flags.String("keyfile", "", "unlock the slot with a keyfile, generated if missing")
+		name := flags.String("name", "", "name of the slot")
+		weak := flags.Bool("weak-passphrase", false, "allow a weak passphrase")
+		if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+			return fmt.Errorf(usage)
+		}
+
+		k, err := load(repo)
+		if err != nil {
+			return err
+		}
+
+		minEntropyBits := 80.
+		if *weak {
+			minEntropyBits = 0.
+		}
+		typ, secret, err := getSecret(ctx, *keyfile, minEntropyBits)
+		if err != nil {
+			return err
+		}
+
+		slot, err := k.Add(*name, typ, secret, ctx.GetSecret())
+		if err != nil {
+			return err
+		}
+		if err := keyring.Save(repo.Store(), k); err != nil {
+			return err
+		}
+		fmt.Fprintf(ctx.Stdout, "added key slot %s\n", slot.ID)
+		warnLegacy(ctx, k)
+		return nil
+
+	case "list", "ls":
+		usage := "usage: plakar key list"
+		if len(args) != 0 {
+			return fmt.Errorf(usage)
+		}
+
+		k, err := load(repo)
+		if errors.Is(err, keyring.ErrUnsupported) {
+			k = &keyring.Keyring{Legacy: true}
+		} else if err != nil {
+			return err
+		}
+
+		if k.Legacy {
+			fmt.Fprintf(ctx.Stdout, "%-20s %-8s %-10s %s\n", "-", "-", keyring.TypePassphrase,
+				"(given at creation, can't be changed or revoked)")
+		}
+		for _, slot := range k.Slots {
+			fmt.Fprintf(ctx.Stdout, "%-20s %-8s %-10s %s\n",
+				slot.Created.UTC().Format("2006-01-02T15:04:05Z"), slot.ID, slot.Type, slot.Name)
+		}
+		return nil
+
+	case "passwd":
+		usage := "usage: plakar key passwd [-keyfile path] [-weak-passphrase] [slot]"
+		flags := flag.NewFlagSet("key passwd", flag.ContinueOnError)
+		keyfile := flags.String("keyfile", "", "unlock the slot with a keyfile, generated if missing")
+		weak := flags.Bool("weak-passphrase", false, "allow a weak passphrase")
+		if err := flags.Parse(args); err != nil || flags.NArg() > 1 {
+			return fmt.Errorf(usage)
+		}
+
+		k, err := load(repo)
+		if err != nil {
+			return err
+		}
+
+		var slot *keyring.Slot
+		if flags.NArg() == 1 {
+			if slot = k.Find(flags.Arg(0)); slot == nil {
+				return fmt.Errorf("no such key slot: %s", flags.Arg(0))
+			}
+		} else if len(k.Slots) == 1 {
+			slot = &k.Slots[0]
+		} else if len(k.Slots) == 0 {
+			return fmt.Errorf("the passphrase given at creation derives the repository key and can't be changed or revoked, " +
+				"add a key slot or synchronize the snapshots to a new repository instead")
+		} else {
+			return fmt.Errorf("the repository has several key slots, name the one to change")
+		}
+
+		minEntropyBits := 80.
+		if *weak {
+			minEntropyBits = 0.
+		}
+		typ, secret, err := getSecret(ctx, *keyfile, minEntropyBits)
+		if err != nil {
+			return err
+		}
+
+		if err := k.Change(slot, typ, secret, ctx.GetSecret()); err != nil {
+			return err
+		}
+		if err := keyring.Save(repo.Store(), k); err != nil {
+			return err
+		}
+		warnLegacy(ctx, k)
+		return nil
+
+	case "remove", "rm":
+		usage := "usage: plakar key remove slot"
+		if len(args) != 1 {
+			return fmt.Errorf(usage)
+		}
+
+		k, err := load(repo)
+		if err != nil {
+			return err
+		}
+
+		slot := k.Find(args[0])
+		if slot == nil {
+			return fmt.Errorf("no such key slot: %s", args[0])
+		}
+		if err := k.Remove(slot); err != nil {
+			return err
+		}
+		return keyring.Save(repo.Store(), k)
+
+	case "export-recovery":
+		usage := "usage: plakar key export-recovery [-qr]"
+		flags := flag.NewFlagSet("key export-recovery", flag.ContinueOnError)
+		qr := flags.Bool("qr", false, "also print the recovery key as a QR code")
+		if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+			return fmt.Errorf(usage)
+		}
+
+		recovery := keyring.FormatRecoveryKey(ctx.GetSecret())
+
+		fmt.Fprintf(ctx.Stdout, "Recovery key of repository %s:\n\n", repo.Configuration().RepositoryID)
+		groups := strings.Split(recovery, "-")
+		for len(groups) > 0 {
+			n := min(7, len(groups))
+			fmt.Fprintf(ctx.Stdout, "    %s\n", strings.Join(groups[:n], "-"))
+			groups = groups[n:]
+		}
+		fmt.Fprintf(ctx.Stdout, "\nIt opens the repository with plakar -recovery-key whatever happens\n")
+		fmt.Fprintf(ctx.Stdout, "to the key slots, and can't be revoked: keep it somewhere safe.\n")
+
+		if *qr {
+			code, err := qrcode.Encode([]byte(recovery))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(ctx.Stdout)
+			if err := code.Render(ctx.Stdout); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("usage: plakar key [add|export-recovery|list|passwd|remove]")
+	}
+}
@@ -0,0 +1,83 @@
+package key
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/keyring"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteCmdKey(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	passphrase := []byte("creation")
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, &passphrase)
+	encryption := repo.Configuration().Encryption
+
+	run := func(args ...string) error {
+		subcommand := &Key{}
+		require.NoError(t, subcommand.Parse(ctx, args))
+		_, err := subcommand.Execute(ctx, repo)
+		return err
+	}
+
+	// the repository predates key slots, a keyring is created
+	keyfile := filepath.Join(t.TempDir(), "plakar.key")
+	require.NoError(t, run("add", "-name", "laptop", "-keyfile", keyfile))
+	require.Contains(t, bufOut.String(), "generated keyfile "+keyfile)
+	require.Contains(t, bufErr.String(), "given at creation still unlocks the repository")
+
+	data, err := os.ReadFile(keyfile)
+	require.NoError(t, err)
+	secret := []byte(strings.TrimSuffix(string(data), "\n"))
+
+	key, err := keyring.Unlock(repo.Store(), encryption, secret)
+	require.NoError(t, err)
+	require.Equal(t, ctx.GetSecret(), key)
+	key, err = keyring.Unlock(repo.Store(), encryption, passphrase)
+	require.NoError(t, err)
+	require.Equal(t, ctx.GetSecret(), key)
+
+	bufOut.Reset()
+	require.NoError(t, run("list"))
+	lines := strings.Split(strings.TrimSpace(bufOut.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], "given at creation")
+	require.Contains(t, lines[1], "keyfile")
+	require.Contains(t, lines[1], "laptop")
+
+	// the only slot is changed by default
+	newKeyfile := filepath.Join(t.TempDir(), "new.key")
+	require.NoError(t, run("passwd", "-keyfile", newKeyfile))
+	data, err = os.ReadFile(newKeyfile)
+	require.NoError(t, err)
+	_, err = keyring.Unlock(repo.Store(), encryption, []byte(strings.TrimSuffix(string(data), "\n")))
+	require.NoError(t, err)
+
+	require.Error(t, run("remove", "desktop"))
+	require.NoError(t, run("remove", "laptop"))
+
+	k, err := keyring.Load(repo.Store())
+	require.NoError(t, err)
+	require.True(t, k.Legacy)
+	require.Empty(t, k.Slots)
+
+	require.EqualError(t, run("passwd"),
+		"the passphrase given at creation derives the repository key and can't be changed or revoked, "+
+			"add a key slot or synchronize the snapshots to a new repository instead")
+
+	bufOut.Reset()
+	require.NoError(t, run("export-recovery", "-qr"))
+	lines = strings.Split(bufOut.String(), "\n")
+	require.Contains(t, lines[0], repo.Configuration().RepositoryID.String())
+	key, err = keyring.UnlockRecovery(encryption, lines[2]+lines[3])
+	require.NoError(t, err)
+	require.Equal(t, ctx.GetSecret(), key)
+	require.Contains(t, bufOut.String(), "█")
+}
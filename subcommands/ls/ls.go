@@ -29,6 +29,7 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/identity"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/dustin/go-humanize"
@@ -96,24 +97,32 @@ func (cmd *Ls) list_snapshots(ctx *appcontext.AppContext, repo *repository.Repos
 		return fmt.Errorf("ls: could not fetch snapshots list: %w", err)
 	}
 
+	identities := identity.NewManager(ctx.ConfigDir)
 	for _, snapshotID := range snapshotIDs {
 		snap, err := snapshot.Load(repo, snapshotID)
 		if err != nil {
 			return fmt.Errorf("ls: could not fetch snapshot: %w", err)
 		}
 
+		status, _, err := identities.Verify(snap)
+		if err != nil {
+			ctx.GetLogger().Warn("ls: %x: %s", snap.Header.GetIndexShortID(), err)
+		}
+
 		if !cmd.DisplayUUID {
-			fmt.Fprintf(ctx.Stdout, "%s %10s%10s%10s %s\n",
+			fmt.Fprintf(ctx.Stdout, "%s %10s %-9s%10s%10s %s\n",
 				snap.Header.Timestamp.UTC().Format(time.RFC3339),
 				hex.EncodeToString(snap.Header.GetIndexShortID()),
+				status,
 				humanize.Bytes(snap.Header.GetSource(0).Summary.Directory.Size+snap.Header.GetSource(0).Summary.Below.Size),
 				snap.Header.Duration.Round(time.Second),
 				utils.SanitizeText(snap.Header.GetSource(0).Importer.Directory))
 		} else {
 			indexID := snap.Header.GetIndexID()
-			fmt.Fprintf(ctx.Stdout, "%s %3s%10s%10s %s\n",
+			fmt.Fprintf(ctx.Stdout, "%s %3s %-9s%10s%10s %s\n",
 				snap.Header.Timestamp.UTC().Format(time.RFC3339),
 				hex.EncodeToString(indexID[:]),
+				status,
 				humanize.Bytes(snap.Header.GetSource(0).Summary.Directory.Size+snap.Header.GetSource(0).Summary.Below.Size),
 				snap.Header.Duration.Round(time.Second),
 				utils.SanitizeText(snap.Header.GetSource(0).Importer.Directory))
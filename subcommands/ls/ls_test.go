@@ -61,9 +61,10 @@ func TestExecuteCmdLsDefault(t *testing.T) {
 	lines := strings.Split(strings.Trim(output, "\n"), "\n")
 	require.Equal(t, 1, len(lines))
 	fields := strings.Fields(lines[0])
-	require.Equal(t, 6, len(fields))
+	require.Equal(t, 7, len(fields))
 	require.Equal(t, snap.Header.Timestamp.Local().Format(time.RFC3339), fields[0])
 	require.Equal(t, hex.EncodeToString(snap.Header.GetIndexShortID()), fields[1])
+	require.Equal(t, "unsigned", fields[2])
 	require.Equal(t, snap.Header.GetSource(0).Importer.Directory, fields[len(fields)-1])
 }
 
@@ -139,9 +140,10 @@ func TestExecuteCmdLsFilterUuid(t *testing.T) {
 	lines := strings.Split(strings.Trim(output, "\n"), "\n")
 	require.Equal(t, 1, len(lines))
 	fields := strings.Fields(lines[0])
-	require.Equal(t, 6, len(fields))
+	require.Equal(t, 7, len(fields))
 	require.Equal(t, snap.Header.Timestamp.Local().Format(time.RFC3339), fields[0])
 	indexId := snap.Header.GetIndexID()
 	require.Equal(t, hex.EncodeToString(indexId[:]), fields[1])
+	require.Equal(t, "unsigned", fields[2])
 	require.Equal(t, snap.Header.GetSource(0).Importer.Directory, fields[len(fields)-1])
 }
@@ -30,6 +30,7 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/resources"
 	"github.com/PlakarKorp/kloset/snapshot"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"golang.org/x/sync/errgroup"
 )
@@ -56,6 +57,9 @@ type Maintenance struct {
 	repository    *repository.Repository
 	maintenanceID objects.MAC
 	cutoff        time.Time
+
+	// set when the store keeps its objects under retention
+	retainer plakarstorage.Retainer
 }
 
 // Builds the local cache of snapshot -> packfiles
@@ -221,12 +225,27 @@ func (cmd *Maintenance) sweepPass(ctx *appcontext.AppContext, cache *caching.Mai
 
 	// First go over all the packfiles coloured by first pass.
 	blobRemoved := 0
+	retained := 0
 	toDelete := map[objects.MAC]struct{}{}
 	for packfileMAC, deletionTime := range cmd.repository.ListDeletedPackfiles() {
 		if deletionTime.After(cmd.cutoff) {
 			continue
 		}
 
+		// A packfile still under retention can't be deleted yet, it
+		// stays coloured until a later run finds it expired.
+		if cmd.retainer != nil {
+			until, err := cmd.retainer.Retention(resources.RT_PACKFILE, packfileMAC)
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "maintenance: Failed to get retention of packfile %x: %s\n", packfileMAC, err)
+				continue
+			}
+			if !until.IsZero() {
+				retained++
+				continue
+			}
+		}
+
 		// At this point we have to re-check if our packfile is really unused,
 		// because we could have had a concurrent backup with the coloring
 		// phase.
@@ -263,6 +282,9 @@ func (cmd *Maintenance) sweepPass(ctx *appcontext.AppContext, cache *caching.Mai
 	}
 
 	fmt.Fprintf(ctx.Stdout, "maintenance: %d blobs and %d packfiles were removed\n", blobRemoved, len(toDelete))
+	if retained > 0 {
+		fmt.Fprintf(ctx.Stdout, "maintenance: %d packfiles are under retention, their deletion is deferred\n", retained)
+	}
 
 	if len(toDelete) > 0 {
 		if err := cmd.repository.PutCurrentState(); err != nil {
@@ -328,6 +350,14 @@ func (cmd *Maintenance) Execute(ctx *appcontext.AppContext, repo *repository.Rep
 		return 1, err
 	}
 
+	if retainer, ok := plakarstorage.IsImmutable(repo.Store()); ok {
+		cmd.retainer = retainer
+		if err := cmd.retentionPass(ctx); err != nil {
+			fmt.Fprintf(ctx.Stderr, "maintenance: Retention pass failed %s\n", err)
+			return 1, err
+		}
+	}
+
 	if err := cmd.sweepPass(ctx, cache); err != nil {
 		fmt.Fprintf(ctx.Stderr, "maintenance: Sweep pass failed %s\n", err)
 		return 1, err
@@ -336,6 +366,39 @@ func (cmd *Maintenance) Execute(ctx *appcontext.AppContext, repo *repository.Rep
 	return 0, nil
 }
 
+// retentionPass extends the retention of the states and of the packfiles
+// that are still in use, so that they remain protected for as long as
+// the retention policy of the store says past their last use.
+func (cmd *Maintenance) retentionPass(ctx *appcontext.AppContext) error {
+	extended := 0
+	for packfileMAC := range cmd.repository.ListPackfiles() {
+		has, err := cmd.repository.HasDeletedPackfile(packfileMAC)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if err := cmd.retainer.ExtendRetention(resources.RT_PACKFILE, packfileMAC); err != nil {
+			return err
+		}
+		extended++
+	}
+
+	states, err := cmd.repository.GetStates()
+	if err != nil {
+		return err
+	}
+	for _, stateID := range states {
+		if err := cmd.retainer.ExtendRetention(resources.RT_STATE, stateID); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(ctx.Stdout, "maintenance: Checked retention of %d packfiles and %d states\n", extended, len(states))
+	return nil
+}
+
 func (cmd *Maintenance) Lock() (chan bool, error) {
 	lockless, _ := strconv.ParseBool(os.Getenv("PLAKAR_LOCKLESS"))
 	lockDone := make(chan bool)
@@ -25,11 +25,28 @@ import (
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/plakarfs"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/anacrolix/fuse"
 	"github.com/anacrolix/fuse/fs"
 )
 
 func (cmd *Mount) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	var pfs *plakarfs.FS
+	if cmd.SnapshotPath == "" {
+		pfs = plakarfs.NewFS(repo, cmd.Mountpoint)
+	} else {
+		prefix, pathname := utils.ParseSnapshotPath(cmd.SnapshotPath)
+		snapshotID, err := utils.LocateSnapshotByPrefix(repo, prefix)
+		if err != nil {
+			return 1, fmt.Errorf("mount: %v", err)
+		}
+		pfs, err = plakarfs.NewSnapshotFS(repo, snapshotID, pathname)
+		if err != nil {
+			return 1, fmt.Errorf("mount: %s: %v", cmd.SnapshotPath, err)
+		}
+	}
+	defer pfs.Close()
+
 	c, err := fuse.Mount(
 		cmd.Mountpoint,
 		fuse.FSName("plakar"),
@@ -46,7 +63,7 @@ func (cmd *Mount) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 		fuse.Unmount(cmd.Mountpoint)
 	}()
 
-	err = fs.Serve(c, plakarfs.NewFS(repo, cmd.Mountpoint))
+	err = fs.Serve(c, pfs)
 	if err != nil {
 		return 1, err
 	}
@@ -31,16 +31,21 @@ func init() {
 func (cmd *Mount) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags := flag.NewFlagSet("mount", flag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Fprintf(flags.Output(), "Usage: %s PATH\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "Usage: %s [SNAPSHOT[:PATH]] MOUNTPOINT\n", flags.Name())
 	}
 	flags.Parse(args)
 
-	if flags.NArg() != 1 {
+	switch flags.NArg() {
+	case 1:
+		cmd.Mountpoint = flags.Arg(0)
+	case 2:
+		cmd.SnapshotPath = flags.Arg(0)
+		cmd.Mountpoint = flags.Arg(1)
+	default:
 		return fmt.Errorf("need mountpoint")
 	}
 
 	cmd.RepositorySecret = ctx.GetSecret()
-	cmd.Mountpoint = flags.Arg(0)
 
 	return nil
 }
@@ -48,5 +53,6 @@ func (cmd *Mount) Parse(ctx *appcontext.AppContext, args []string) error {
 type Mount struct {
 	subcommands.SubcommandBase
 
-	Mountpoint string
+	SnapshotPath string
+	Mountpoint   string
 }
@@ -18,6 +18,7 @@ package ptar
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"hash"
@@ -26,7 +27,6 @@ import (
 	"os"
 	"strings"
 
-	"github.com/PlakarKorp/kloset/compression"
 	"github.com/PlakarKorp/kloset/encryption"
 	"github.com/PlakarKorp/kloset/hashing"
 	"github.com/PlakarKorp/kloset/objects"
@@ -37,6 +37,8 @@ import (
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/kloset/versioning"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/keyring"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/google/uuid"
@@ -63,6 +65,9 @@ func (l *listFlag) Set(value string) error {
 }
 
 func (cmd *Ptar) Parse(ctx *appcontext.AppContext, args []string) error {
+	var profileName string
+	var overrides plakarstorage.Profile
+	var noCompression bool
 
 	cmd.KlosetUUID = uuid.Must(uuid.NewRandom())
 
@@ -73,9 +78,10 @@ func (cmd *Ptar) Parse(ctx *appcontext.AppContext, args []string) error {
 		flags.PrintDefaults()
 	}
 
-	flags.StringVar(&cmd.Hashing, "hashing", hashing.DEFAULT_HASHING_ALGORITHM, "hashing algorithm to use for digests")
+	flags.StringVar(&profileName, "profile", "default", "parameters of the archive: default, archive, fast or the path to a YAML file")
+	overrides.Flags(flags)
 	flags.BoolVar(&cmd.NoEncryption, "plaintext", false, "disable transparent encryption")
-	flags.BoolVar(&cmd.NoCompression, "no-compression", false, "disable transparent compression")
+	flags.BoolVar(&noCompression, "no-compression", false, "disable transparent compression")
 	flags.BoolVar(&cmd.Overwrite, "overwrite", false, "overwrite the ptar archive if it already exists")
 	flags.Var(&cmd.SyncTargets, "k", "add a kloset location to include in the ptar archive (can be specified multiple times)")
 	flags.Var(&cmd.SyncTargets, "kloset", "add a kloset location to include in the ptar archive (can be specified multiple times)")
@@ -115,12 +121,11 @@ func (cmd *Ptar) Parse(ctx *appcontext.AppContext, args []string) error {
 
 		if peerStoreConfig.Encryption != nil {
 			if pass, ok := storeConfig["passphrase"]; ok {
-				key, err := encryption.DeriveKey(peerStoreConfig.Encryption.KDFParams, []byte(pass))
-				if err != nil {
-					return err
-				}
-				if !encryption.VerifyCanary(peerStoreConfig.Encryption, key) {
+				key, err := keyring.Unlock(peerStore, peerStoreConfig.Encryption, []byte(pass))
+				if errors.Is(err, keyring.ErrCantUnlock) {
 					return fmt.Errorf("invalid passphrase")
+				} else if err != nil {
+					return err
 				}
 				peerSecret = key
 			} else {
@@ -131,12 +136,11 @@ func (cmd *Ptar) Parse(ctx *appcontext.AppContext, args []string) error {
 						continue
 					}
 
-					key, err := encryption.DeriveKey(peerStoreConfig.Encryption.KDFParams, passphrase)
-					if err != nil {
-						return err
-					}
-					if !encryption.VerifyCanary(peerStoreConfig.Encryption, key) {
+					key, err := keyring.Unlock(peerStore, peerStoreConfig.Encryption, passphrase)
+					if errors.Is(err, keyring.ErrCantUnlock) {
 						return fmt.Errorf("invalid passphrase")
+					} else if err != nil {
+						return err
 					}
 					peerSecret = key
 					break
@@ -145,16 +149,30 @@ func (cmd *Ptar) Parse(ctx *appcontext.AppContext, args []string) error {
 		}
 
 		peerCtx := appcontext.NewAppContextFrom(ctx)
-		_, err = repository.NewNoRebuild(peerCtx.GetInner(), peerSecret, peerStore, peerStoreSerializedConfig)
+		peerStore, secret, err := keyring.OpenStore(peerStore, peerStoreSerializedConfig, peerSecret, ctx.CacheDir)
+		if err != nil {
+			return err
+		}
+		_, err = repository.NewNoRebuild(peerCtx.GetInner(), secret, peerStore, peerStoreSerializedConfig)
 		if err != nil {
 			return err
 		}
 		cmd.SyncSecrets = append(cmd.SyncSecrets, peerSecret)
 	}
 
-	if hashing.GetHasher(strings.ToUpper(cmd.Hashing)) == nil {
-		return fmt.Errorf("%s: unknown hashing algorithm", flag.CommandLine.Name())
+	if noCompression {
+		overrides.Compression = "none"
+	}
+
+	profile, err := plakarstorage.LoadProfile(profileName)
+	if err != nil {
+		return err
 	}
+	profile.Merge(&overrides)
+	if _, err := profile.Configuration(); err != nil {
+		return err
+	}
+	cmd.Profile = *profile
 
 	if !cmd.NoEncryption {
 		var passphrase []byte
@@ -194,11 +212,10 @@ type Ptar struct {
 	KlosetPath string
 	KlosetUUID uuid.UUID
 
-	AllowWeak     bool
-	Hashing       string
-	NoEncryption  bool
-	NoCompression bool
-	Overwrite     bool
+	AllowWeak    bool
+	Profile      plakarstorage.Profile
+	NoEncryption bool
+	Overwrite    bool
 
 	SyncTargets   listFlag
 	SyncSecrets   [][]byte
@@ -206,26 +223,15 @@ type Ptar struct {
 }
 
 func (cmd *Ptar) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
-	storageConfiguration := storage.NewConfiguration()
-	storageConfiguration.RepositoryID = cmd.KlosetUUID
-
-	if cmd.NoCompression {
-		storageConfiguration.Compression = nil
-	} else {
-		storageConfiguration.Compression = compression.NewDefaultConfiguration()
-	}
-
-	hashingConfiguration, err := hashing.LookupDefaultConfiguration(strings.ToUpper(cmd.Hashing))
+	storageConfiguration, err := cmd.Profile.Configuration()
 	if err != nil {
 		return 1, err
 	}
-	storageConfiguration.Hashing = *hashingConfiguration
+	storageConfiguration.RepositoryID = cmd.KlosetUUID
 
 	var hasher hash.Hash
 	var key []byte
 	if !cmd.NoEncryption {
-		storageConfiguration.Encryption = encryption.NewDefaultConfiguration()
-
 		key, err = encryption.DeriveKey(storageConfiguration.Encryption.KDFParams,
 			cmd.RepositorySecret)
 		if err != nil {
@@ -244,7 +250,10 @@ func (cmd *Ptar) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 		hasher = hashing.GetHasher(storage.DEFAULT_HASHING_ALGORITHM)
 	}
 
-	storageConfiguration.Packfile.MaxSize = math.MaxUint64
+	// a single packfile unless told otherwise
+	if cmd.Profile.PackfileSize == "" {
+		storageConfiguration.Packfile.MaxSize = math.MaxUint64
+	}
 
 	serializedConfig, err := storageConfiguration.ToBytes()
 	if err != nil {
@@ -306,8 +315,13 @@ func (cmd *Ptar) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 			return 1, fmt.Errorf("could not open source store %s: %s", syncTarget, err)
 		}
 
+		peerStore, secret, err := keyring.OpenStore(peerStore, peerStoreSerializedConfig, cmd.SyncSecrets[i], ctx.CacheDir)
+		if err != nil {
+			return 1, fmt.Errorf("could not open source store %s: %s", syncTarget, err)
+		}
+
 		srcCtx := appcontext.NewAppContextFrom(ctx)
-		srcRepository, err := repository.New(srcCtx.GetInner(), cmd.SyncSecrets[i], peerStore, peerStoreSerializedConfig)
+		srcRepository, err := repository.New(srcCtx.GetInner(), secret, peerStore, peerStoreSerializedConfig)
 		if err != nil {
 			return 1, fmt.Errorf("could not open source repository %s: %s", syncTarget, err)
 		}
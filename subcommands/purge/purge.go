@@ -0,0 +1,491 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package purge
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/btree"
+	"github.com/PlakarKorp/kloset/caching"
+	"github.com/PlakarKorp/kloset/iterator"
+	"github.com/PlakarKorp/kloset/kcontext"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/derive"
+	"github.com/PlakarKorp/plakar/keyring"
+	"github.com/PlakarKorp/plakar/search"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+	"golang.org/x/sync/errgroup"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Purge{} }, subcommands.AgentSupport, "purge")
+}
+
+type pathFlags []string
+
+func (p *pathFlags) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *pathFlags) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+func (cmd *Purge) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("purge", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] -path GLOB [SNAPSHOT...]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Var((*pathFlags)(&cmd.Paths), "path", "glob pattern of the paths to purge, can be specified multiple times")
+	flags.Parse(args)
+
+	if len(cmd.Paths) == 0 {
+		return fmt.Errorf("no path specified, use -path")
+	}
+	if _, err := derive.NewFilter(nil, cmd.Paths); err != nil {
+		return err
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.Snapshots = flags.Args()
+
+	return nil
+}
+
+type Purge struct {
+	subcommands.SubcommandBase
+
+	Paths     []string
+	Snapshots []string
+}
+
+// rewrite is a snapshot to replace by a copy without the purged entries.
+// Snapshots sharing packfiles with the purged entries are copied whole,
+// so that they no longer reference those packfiles.
+type rewrite struct {
+	snapshotID objects.MAC
+	purged     int
+	derivedID  objects.MAC
+}
+
+func (cmd *Purge) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	filter, err := derive.NewFilter(nil, cmd.Paths)
+	if err != nil {
+		return 1, err
+	}
+
+	var snapshotIDs []objects.MAC
+	if len(cmd.Snapshots) == 0 {
+		for snapshotID := range repo.ListSnapshots() {
+			snapshotIDs = append(snapshotIDs, snapshotID)
+		}
+	} else {
+		for _, prefix := range cmd.Snapshots {
+			snapshotID, err := utils.LocateSnapshotByPrefix(repo, prefix)
+			if err != nil {
+				return 1, err
+			}
+			snapshotIDs = append(snapshotIDs, snapshotID)
+		}
+	}
+
+	erased := make(map[objects.MAC]struct{})
+	var rewrites []*rewrite
+	for _, snapshotID := range snapshotIDs {
+		snap, err := snapshot.Load(repo, snapshotID)
+		if err != nil {
+			return 1, err
+		}
+		purged, err := erasedPackfiles(repo, snap, filter, erased)
+		snap.Close()
+		if err != nil {
+			return 1, fmt.Errorf("%x: %w", snapshotID[:4], err)
+		}
+		if purged != 0 {
+			rewrites = append(rewrites, &rewrite{snapshotID: snapshotID, purged: purged})
+		}
+	}
+
+	if len(rewrites) == 0 {
+		fmt.Fprintf(ctx.Stdout, "purge: no entry matched, nothing to do\n")
+		return 0, nil
+	}
+
+	sharing, err := sharingSnapshots(ctx, repo, rewrites, erased)
+	if err != nil {
+		return 1, err
+	}
+	rewrites = append(rewrites, sharing...)
+
+	writer, cleanup, err := openRewriter(ctx, repo, erased)
+	if err != nil {
+		return 1, err
+	}
+	defer cleanup()
+
+	// The patterns may name the very data being erased, only the
+	// number of entries purged is recorded.
+	purgedAt := time.Now().UTC().Format(time.RFC3339)
+	for _, rw := range rewrites {
+		if err := ctx.Err(); err != nil {
+			discard(ctx, repo, rewrites)
+			return 1, err
+		}
+
+		opts := &derive.Options{MaxConcurrency: uint64(ctx.MaxConcurrency)}
+		if rw.purged != 0 {
+			opts.Filter = filter
+			opts.Context = []header.KeyValue{{
+				Key:   "Purged",
+				Value: fmt.Sprintf("%s %d entries", purgedAt, rw.purged),
+			}}
+		}
+
+		snap, err := snapshot.Load(repo, rw.snapshotID)
+		if err != nil {
+			discard(ctx, repo, rewrites)
+			return 1, err
+		}
+		derivedID, err := derive.Snapshot(snap, writer, opts)
+		snap.Close()
+		if err != nil {
+			discard(ctx, repo, rewrites)
+			return 1, fmt.Errorf("failed to rewrite snapshot %x: %w", rw.snapshotID[:4], err)
+		}
+		rw.derivedID = derivedID
+	}
+
+	// The original snapshots go away and the packfiles to erase are
+	// coloured for deletion in the same state.  A backup running
+	// meanwhile may still have used one of them, maintenance checks for
+	// this before deleting it.
+	purgeID := objects.RandomMAC()
+	scanCache, err := repo.AppContext().GetCache().Scan(purgeID)
+	if err != nil {
+		discard(ctx, repo, rewrites)
+		return 1, err
+	}
+	defer scanCache.Close()
+
+	repoWriter := repo.NewRepositoryWriter(scanCache, purgeID, repository.DefaultType)
+	for _, rw := range rewrites {
+		if err := repoWriter.DeleteStateResource(resources.RT_SNAPSHOT, rw.snapshotID); err != nil {
+			discard(ctx, repo, rewrites)
+			return 1, err
+		}
+	}
+	for packfile := range erased {
+		if err := repoWriter.DeleteStateResource(resources.RT_PACKFILE, packfile); err != nil {
+			discard(ctx, repo, rewrites)
+			return 1, err
+		}
+	}
+	if err := repoWriter.CommitTransaction(purgeID); err != nil {
+		discard(ctx, repo, rewrites)
+		return 1, err
+	}
+
+	// the content indexes of the original snapshots hold the paths
+	// and trigrams of the erased entries
+	indexes := search.NewIndexCache(ctx.CacheDir, repo.Configuration().RepositoryID)
+	for _, rw := range rewrites {
+		if err := indexes.Delete(rw.snapshotID); err != nil {
+			ctx.GetLogger().Warn("purge: failed to evict the index of %x: %s", rw.snapshotID[:4], err)
+		}
+	}
+
+	for _, rw := range rewrites {
+		if rw.purged != 0 {
+			fmt.Fprintf(ctx.Stdout, "purge: %x rewritten as %x, %d entries purged\n",
+				rw.snapshotID[:4], rw.derivedID[:4], rw.purged)
+		} else {
+			fmt.Fprintf(ctx.Stdout, "purge: %x rewritten as %x, it shared data with purged entries\n",
+				rw.snapshotID[:4], rw.derivedID[:4])
+		}
+	}
+	fmt.Fprintf(ctx.Stdout, "purge: %d snapshots rewritten, %d packfiles left for maintenance to delete\n",
+		len(rewrites), len(erased))
+
+	return 0, nil
+}
+
+// erasedPackfiles adds to erased the packfiles holding the entries of
+// snap matched by the filter, along with those holding the tree nodes
+// of snap that still name them, and returns the number of entries
+// matched.
+func erasedPackfiles(repo *repository.Repository, snap *snapshot.Snapshot, filter *derive.Filter, erased map[objects.MAC]struct{}) (int, error) {
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return 0, err
+	}
+
+	packfiles := make(map[objects.MAC]struct{})
+	add := func(Type resources.Type, mac objects.MAC) error {
+		packfile, exists, err := repo.GetPackfileForBlob(Type, mac)
+		if err != nil {
+			return err
+		}
+		if exists {
+			packfiles[packfile] = struct{}{}
+		}
+		return nil
+	}
+
+	purged := 0
+	err = fs.WalkDir("/", func(pathname string, entry *vfs.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !filter.Excluded(pathname) {
+			return nil
+		}
+
+		purged++
+		if err := add(resources.RT_VFS_ENTRY, entry.MAC); err != nil {
+			return err
+		}
+		if entry.HasObject() {
+			if err := add(resources.RT_OBJECT, entry.Object); err != nil {
+				return err
+			}
+			for _, chunk := range entry.ResolvedObject.Chunks {
+				if err := add(resources.RT_CHUNK, chunk.ContentMAC); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil || purged == 0 {
+		return 0, err
+	}
+
+	// The nodes of the trees and of the content-type index are keyed by
+	// pathname, those naming a purged entry are erased too.
+	type tree struct {
+		node, entry resources.Type
+		iter        iterator.Iterator[objects.MAC, *btree.Node[string, objects.MAC, objects.MAC]]
+		pathname    func(key string) string
+	}
+	trees := []tree{
+		{resources.RT_VFS_NODE, resources.RT_VFS_ENTRY, fs.IterNodes(), vfsPathname},
+		{resources.RT_ERROR_NODE, resources.RT_ERROR_ENTRY, fs.IterErrorNodes(), vfsPathname},
+		{resources.RT_XATTR_NODE, resources.RT_XATTR_ENTRY, fs.XattrNodes(), xattrPathname},
+	}
+	for _, index := range snap.Header.GetSource(0).Indexes {
+		rd, err := repo.GetBlob(resources.RT_BTREE_ROOT, index.Value)
+		if err != nil {
+			return 0, err
+		}
+		store := repository.NewRepositoryStore[string, objects.MAC](repo, resources.RT_BTREE_NODE)
+		idx, err := btree.Deserialize(rd, store, strings.Compare)
+		if err != nil {
+			return 0, err
+		}
+		trees = append(trees, tree{resources.RT_BTREE_NODE, resources.RT_VFS_ENTRY, idx.IterDFS(), indexPathname})
+	}
+
+	for _, tree := range trees {
+		for tree.iter.Next() {
+			mac, node := tree.iter.Current()
+			for i, key := range node.Keys {
+				if !filter.Excluded(tree.pathname(key)) {
+					continue
+				}
+				if err := add(tree.node, mac); err != nil {
+					return 0, err
+				}
+				if i < len(node.Values) {
+					if err := add(tree.entry, node.Values[i]); err != nil {
+						return 0, err
+					}
+				}
+			}
+		}
+		if err := tree.iter.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	for packfile := range packfiles {
+		erased[packfile] = struct{}{}
+	}
+	return purged, nil
+}
+
+// discard removes the snapshots rewritten so far, the originals are
+// kept when the purge fails.
+func discard(ctx *appcontext.AppContext, repo *repository.Repository, rewrites []*rewrite) {
+	for _, rw := range rewrites {
+		if rw.derivedID == (objects.MAC{}) {
+			continue
+		}
+		if err := repo.DeleteSnapshot(rw.derivedID); err != nil {
+			ctx.GetLogger().Warn("purge: failed to remove snapshot %x: %s", rw.derivedID[:4], err)
+		}
+	}
+}
+
+func vfsPathname(key string) string {
+	return key
+}
+
+// xattrPathname strips the attribute name from the key of an xattr,
+// which follows the pathname after a separator depending on its type.
+func xattrPathname(key string) string {
+	if i := strings.LastIndexAny(key, ":@#"); i > 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// indexPathname strips the content type from the key of an entry in
+// the content-type index, in the form /type/subtype/path.
+func indexPathname(key string) string {
+	parts := strings.SplitN(key, "/", 4)
+	if len(parts) < 4 {
+		return key
+	}
+	return "/" + parts[3]
+}
+
+// sharingSnapshots returns the snapshots, other than those rewritten,
+// that reference one of the erased packfiles.
+func sharingSnapshots(ctx *appcontext.AppContext, repo *repository.Repository, rewrites []*rewrite, erased map[objects.MAC]struct{}) ([]*rewrite, error) {
+	skip := make(map[objects.MAC]struct{})
+	for _, rw := range rewrites {
+		skip[rw.snapshotID] = struct{}{}
+	}
+
+	var mu sync.Mutex
+	var sharing []*rewrite
+
+	wg := new(errgroup.Group)
+	wg.SetLimit(ctx.MaxConcurrency)
+	for snapshotID := range repo.ListSnapshots() {
+		if _, ok := skip[snapshotID]; ok {
+			continue
+		}
+		wg.Go(func() error {
+			snap, err := snapshot.Load(repo, snapshotID)
+			if err != nil {
+				return err
+			}
+			defer snap.Close()
+
+			packfiles, err := snap.ListPackfiles()
+			if err != nil {
+				return err
+			}
+			for packfile, err := range packfiles {
+				if err != nil {
+					return err
+				}
+				if _, ok := erased[packfile]; ok {
+					mu.Lock()
+					sharing = append(sharing, &rewrite{snapshotID: snapshotID})
+					mu.Unlock()
+					return nil
+				}
+			}
+			return nil
+		})
+	}
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+	return sharing, nil
+}
+
+// erasedStateCache reports the erased packfiles as deleted, so that a
+// repository state built on it stores again the blobs they hold instead
+// of referencing them.
+type erasedStateCache struct {
+	caching.StateCache
+	erased map[objects.MAC]struct{}
+}
+
+func (c *erasedStateCache) HasDeleted(blobType resources.Type, blobCsum objects.MAC) (bool, error) {
+	if blobType == resources.RT_PACKFILE {
+		if _, ok := c.erased[blobCsum]; ok {
+			return true, nil
+		}
+	}
+	return c.StateCache.HasDeleted(blobType, blobCsum)
+}
+
+// openRewriter opens the repository a second time, with a state and
+// caches of its own in which the erased packfiles are deleted.  The
+// snapshots are read from repo and rewritten through it.
+func openRewriter(ctx *appcontext.AppContext, repo *repository.Repository, erased map[objects.MAC]struct{}) (*repository.Repository, func(), error) {
+	serializedConfig, err := repo.Store().Open(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secret := ctx.GetSecret()
+	if keyring.IsAsymmetric(repo.Configuration().Encryption) {
+		secret = nil
+	}
+
+	dir, err := os.MkdirTemp(ctx.CacheDir, "purge-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cache := caching.NewManager(dir)
+	cleanup := func() {
+		cache.Close()
+		os.RemoveAll(dir)
+	}
+
+	kctx := kcontext.NewKContextFrom(ctx.GetInner())
+	kctx.SetCache(cache)
+
+	writer, err := repository.NewNoRebuild(kctx, secret, repo.Store(), serializedConfig)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	stateCache, err := cache.Repository(repo.Configuration().RepositoryID)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if err := writer.RebuildStateWithCache(&erasedStateCache{StateCache: stateCache, erased: erased}); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	return writer, cleanup, nil
+}
@@ -0,0 +1,114 @@
+package purge
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/search"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func generateSnapshot(t *testing.T, bufOut *bytes.Buffer, bufErr *bytes.Buffer) (*repository.Repository, *snapshot.Snapshot, *appcontext.AppContext) {
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockDir("secrets"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("secrets/credentials", 0600, "hunter2"),
+	})
+	return repo, snap, ctx
+}
+
+func TestExecuteCmdPurge(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	ctx.CacheDir = t.TempDir()
+	indexes := search.NewIndexCache(ctx.CacheDir, repo.Configuration().RepositoryID)
+	_, err := indexes.GetOrBuild(ctx, snap, 0)
+	require.NoError(t, err)
+
+	subcommand := &Purge{}
+	err = subcommand.Parse(ctx, []string{"-path", "/secrets/*"})
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), fmt.Sprintf("purge: %x rewritten as", snap.Header.Identifier[:4]))
+
+	_, err = indexes.Get(snap.Header.Identifier)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.Contains(t, bufOut.String(), "1 entries purged")
+
+	require.NoError(t, repo.RebuildState())
+	snapshotIDs, err := repo.GetSnapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshotIDs, 1)
+	require.NotEqual(t, snap.Header.Identifier, snapshotIDs[0])
+
+	purged, err := snapshot.Load(repo, snapshotIDs[0])
+	require.NoError(t, err)
+	defer purged.Close()
+
+	require.Equal(t, snap.Header.Timestamp.Unix(), purged.Header.Timestamp.Unix())
+	require.Contains(t, purged.Header.GetContext("Purged"), " 1 entries")
+	require.NotContains(t, purged.Header.GetContext("Purged"), "secrets")
+
+	fs, err := purged.Filesystem()
+	require.NoError(t, err)
+	_, err = fs.Stat("/subdir/dummy.txt")
+	require.NoError(t, err)
+	_, err = fs.Stat("/secrets")
+	require.NoError(t, err)
+	_, err = fs.Stat("/secrets/credentials")
+	require.Error(t, err)
+
+	deleted := 0
+	for range repo.ListDeletedPackfiles() {
+		deleted++
+	}
+	require.NotZero(t, deleted)
+}
+
+func TestExecuteCmdPurgeNoMatch(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	subcommand := &Purge{}
+	err := subcommand.Parse(ctx, []string{"-path", "/nonexistent"})
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "nothing to do")
+
+	snapshotIDs, err := repo.GetSnapshots()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(snapshotIDs))
+}
+
+func TestParseCmdPurgeNoPath(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	_, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	subcommand := &Purge{}
+	err := subcommand.Parse(ctx, []string{})
+	require.Error(t, err)
+}
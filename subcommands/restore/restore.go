@@ -26,7 +26,9 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/exporter"
 	"github.com/PlakarKorp/plakar/appcontext"
+	plakarexporter "github.com/PlakarKorp/plakar/exporter"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/thaw"
 	"github.com/PlakarKorp/plakar/utils"
 )
 
@@ -55,6 +57,7 @@ func (cmd *Restore) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags.StringVar(&pullPath, "to", "", "base directory where pull will restore")
 	flags.BoolVar(&cmd.Quiet, "quiet", false, "do not print progress")
 	flags.BoolVar(&cmd.Silent, "silent", false, "do not print ANY progress")
+	flags.BoolVar(&cmd.Wait, "wait", false, "wait for packfiles in cold storage to be restored")
 	flags.Parse(args)
 
 	if flags.NArg() != 0 {
@@ -91,6 +94,7 @@ type Restore struct {
 	Concurrency uint64
 	Quiet       bool
 	Silent      bool
+	Wait        bool
 	Snapshots   []string
 }
 
@@ -98,6 +102,14 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 	if !cmd.Silent {
 		go eventsProcessorStdio(ctx, cmd.Quiet)
 	}
+	thawer := thaw.New(ctx, repo)
+	if thawer != nil {
+		thawer.Wait = cmd.Wait
+		if err := thawer.Headers(); err != nil {
+			return 1, err
+		}
+	}
+
 	var snapshots []string
 	if len(cmd.Snapshots) == 0 {
 		locateOptions := utils.NewDefaultLocateOptions()
@@ -184,7 +196,22 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 		if err != nil {
 			return 1, err
 		}
+		defer snap.Close()
+
+		if thawer != nil {
+			if err := thawer.Snapshot(snap.Header.Identifier, pathname); err != nil {
+				return 1, err
+			}
+		}
+
 		opts.Strip = snap.Header.GetSource(0).Importer.Directory
+		if xattrExporter, ok := exporterInstance.(plakarexporter.XattrExporter); ok {
+			lookup, err := plakarexporter.ExtendedAttributes(snap, exporterInstance.Root(), opts.Strip)
+			if err != nil {
+				return 1, err
+			}
+			xattrExporter.SetXattrLookup(lookup)
+		}
 
 		err = snap.Restore(exporterInstance, exporterInstance.Root(), pathname, opts)
 
@@ -195,7 +222,6 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 			snap.Header.GetIndexShortID(),
 			pathname,
 			cmd.Target)
-		snap.Close()
 	}
 	return 0, nil
 }
@@ -17,15 +17,25 @@
 package rm
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
+	"github.com/dustin/go-humanize"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/term"
 )
 
 func init() {
@@ -42,6 +52,8 @@ func (cmd *Rm) Parse(ctx *appcontext.AppContext, args []string) error {
 		flags.PrintDefaults()
 	}
 
+	flags.Uint64Var(&cmd.Concurrency, "concurrency", uint64(ctx.MaxConcurrency), "maximum number of parallel tasks")
+	flags.BoolVar(&cmd.DryRun, "dry-run", false, "list the snapshots that would be removed without removing them")
 	cmd.LocateOptions.InstallFlags(flags)
 	flags.Parse(args)
 
@@ -50,6 +62,13 @@ func (cmd *Rm) Parse(ctx *appcontext.AppContext, args []string) error {
 	} else if flags.NArg() == 0 && cmd.LocateOptions.Empty() {
 		return fmt.Errorf("no filter specified, not going to remove everything")
 	}
+	if cmd.Concurrency == 0 {
+		return fmt.Errorf("concurrency must be at least 1")
+	}
+
+	// The confirmation is asked by the agent through the forwarded
+	// stdin, but only the client knows whether it is a terminal.
+	cmd.Interactive = term.IsTerminal(int(os.Stdin.Fd()))
 
 	cmd.RepositorySecret = ctx.GetSecret()
 	cmd.Snapshots = flags.Args()
@@ -61,6 +80,9 @@ type Rm struct {
 	subcommands.SubcommandBase
 
 	LocateOptions *utils.LocateOptions
+	Concurrency   uint64
+	DryRun        bool
+	Interactive   bool
 	Snapshots     []string
 }
 
@@ -73,34 +95,124 @@ func (cmd *Rm) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 		}
 		snapshots = append(snapshots, snapshotIDs...)
 	} else {
+		errors := 0
 		for _, prefix := range cmd.Snapshots {
 			snapshotID, err := utils.LocateSnapshotByPrefix(repo, prefix)
 			if err != nil {
+				ctx.GetLogger().Error("rm: %s", err)
+				errors++
 				continue
 			}
 			snapshots = append(snapshots, snapshotID)
 		}
+		if errors != 0 {
+			return 1, fmt.Errorf("failed to locate %d snapshots, nothing removed", errors)
+		}
+	}
+
+	if cmd.DryRun || cmd.Interactive {
+		if err := cmd.list(ctx, repo, snapshots); err != nil {
+			return 1, err
+		}
+		if cmd.DryRun {
+			return 0, nil
+		}
+		if len(snapshots) != 0 && !confirm(ctx, len(snapshots)) {
+			return 1, fmt.Errorf("removal not confirmed")
+		}
+	}
+
+	if len(snapshots) == 0 {
+		return 0, nil
 	}
 
-	errors := 0
-	wg := sync.WaitGroup{}
-	for _, snap := range snapshots {
-		wg.Add(1)
-		go func(snapshotID objects.MAC) {
-			err := repo.DeleteSnapshot(snapshotID)
+	// All the snapshots are removed in a single state, so that the
+	// repository sees either all of them or none as removed.
+	rmID := objects.RandomMAC()
+	scanCache, err := repo.AppContext().GetCache().Scan(rmID)
+	if err != nil {
+		return 1, err
+	}
+	defer scanCache.Close()
+
+	repoWriter := repo.NewRepositoryWriter(scanCache, rmID, repository.DefaultType)
+	for _, snapshotID := range snapshots {
+		if err := repoWriter.DeleteStateResource(resources.RT_SNAPSHOT, snapshotID); err != nil {
+			return 1, err
+		}
+	}
+	if err := repoWriter.CommitTransaction(rmID); err != nil {
+		return 1, fmt.Errorf("failed to remove %d snapshots: %w", len(snapshots), err)
+	}
+
+	for _, snapshotID := range snapshots {
+		ctx.GetLogger().Info("rm: removal of %x completed successfully", snapshotID[:4])
+	}
+	return 0, nil
+}
+
+// list prints the date, size and tags of the snapshots to remove,
+// loading their headers in parallel.
+func (cmd *Rm) list(ctx *appcontext.AppContext, repo *repository.Repository, snapshots []objects.MAC) error {
+	concurrency := cmd.Concurrency
+	if concurrency == 0 {
+		concurrency = uint64(ctx.MaxConcurrency)
+	}
+
+	type entry struct {
+		timestamp time.Time
+		line      string
+	}
+	entries := make([]entry, len(snapshots))
+	var size uint64
+	var mu sync.Mutex
+
+	wg := new(errgroup.Group)
+	wg.SetLimit(int(concurrency))
+	for i, snapshotID := range snapshots {
+		wg.Go(func() error {
+			snap, err := snapshot.Load(repo, snapshotID)
 			if err != nil {
-				ctx.GetLogger().Error("%s", err)
-				errors++
+				return fmt.Errorf("%x: %w", snapshotID[:4], err)
 			}
-			ctx.GetLogger().Info("rm: removal of %x completed successfully", snapshotID[:4])
-			wg.Done()
-		}(snap)
+			defer snap.Close()
+
+			summary := snap.Header.GetSource(0).Summary
+			snapSize := summary.Directory.Size + summary.Below.Size
+			line := fmt.Sprintf("%s %x %10s %s",
+				snap.Header.Timestamp.UTC().Format(time.RFC3339),
+				snapshotID[:4],
+				humanize.Bytes(snapSize),
+				utils.SanitizeText(strings.Join(snap.Header.Tags, ",")))
+			entries[i] = entry{snap.Header.Timestamp, strings.TrimRight(line, " ")}
+
+			mu.Lock()
+			size += snapSize
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := wg.Wait(); err != nil {
+		return err
 	}
-	wg.Wait()
 
-	if errors != 0 {
-		return 1, fmt.Errorf("failed to remove %d snapshots", errors)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].timestamp.After(entries[j].timestamp)
+	})
+	for _, e := range entries {
+		fmt.Fprintln(ctx.Stdout, e.line)
 	}
+	fmt.Fprintf(ctx.Stdout, "rm: %d snapshots to remove, %s\n", len(snapshots), humanize.Bytes(size))
+	return nil
+}
 
-	return 0, nil
+func confirm(ctx *appcontext.AppContext, count int) bool {
+	fmt.Fprintf(ctx.Stdout, "Remove %d snapshots? [y/N] ", count)
+
+	answer, err := bufio.NewReader(ctx.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
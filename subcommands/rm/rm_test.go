@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/PlakarKorp/kloset/repository"
@@ -75,3 +76,89 @@ func TestExecuteCmdRmWithSnapshot(t *testing.T) {
 	output := bufOut.String()
 	require.Contains(t, output, fmt.Sprintf("info: rm: removal of %s completed successfully", hex.EncodeToString(snap.Header.GetIndexShortID())))
 }
+
+func TestExecuteCmdRmDryRun(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	args := []string{"-dry-run", hex.EncodeToString(snap.Header.GetIndexShortID())}
+
+	subcommand := &Rm{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	output := bufOut.String()
+	require.Contains(t, output, hex.EncodeToString(snap.Header.GetIndexShortID()))
+	require.Contains(t, output, "rm: 1 snapshots to remove")
+	require.NotContains(t, output, "completed successfully")
+
+	require.NoError(t, repo.RebuildState())
+	snapshotIDs, err := repo.GetSnapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshotIDs, 1)
+}
+
+func TestExecuteCmdRmConfirm(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	args := []string{hex.EncodeToString(snap.Header.GetIndexShortID())}
+
+	subcommand := &Rm{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+	subcommand.Interactive = true
+
+	ctx.Stdin = strings.NewReader("n\n")
+	status, err := subcommand.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+	require.Contains(t, bufOut.String(), "Remove 1 snapshots? [y/N]")
+
+	require.NoError(t, repo.RebuildState())
+	snapshotIDs, err := repo.GetSnapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshotIDs, 1)
+
+	ctx.Stdin = strings.NewReader("y\n")
+	status, err = subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	require.NoError(t, repo.RebuildState())
+	snapshotIDs, err = repo.GetSnapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshotIDs, 0)
+}
+
+func TestExecuteCmdRmUnknownSnapshot(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	args := []string{hex.EncodeToString(snap.Header.GetIndexShortID()), "ffffffff"}
+
+	subcommand := &Rm{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+
+	snapshotIDs, err := repo.GetSnapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshotIDs, 1)
+}
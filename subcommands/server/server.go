@@ -19,6 +19,7 @@ package server
 import (
 	"flag"
 	"fmt"
+	"os"
 
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/kloset/repository"
@@ -41,8 +42,14 @@ func (cmd *Server) Parse(ctx *appcontext.AppContext, args []string) error {
 
 	flags.StringVar(&cmd.ListenAddr, "listen", "127.0.0.1:9876", "address to listen on")
 	flags.BoolVar(&opt_allowdelete, "allow-delete", false, "enable delete operations")
+	flags.BoolVar(&cmd.Stdio, "stdio", false, "serve on standard input and output, as over ssh")
+	flags.StringVar(&cmd.Jail, "jail", "", "with -stdio, only serve repositories within this directory")
 	flags.Parse(args)
 
+	if cmd.Jail != "" && !cmd.Stdio {
+		return fmt.Errorf("-jail requires -stdio")
+	}
+
 	noDelete := true
 	if opt_allowdelete {
 		noDelete = false
@@ -59,9 +66,20 @@ type Server struct {
 
 	ListenAddr string
 	NoDelete   bool
+	Stdio      bool
+	Jail       string
 }
 
 func (cmd *Server) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	if cmd.Stdio {
+		// standard output carries the responses, keep logs off it
+		ctx.GetLogger().SetOutput(os.Stderr)
+		if err := httpd.ServeStdio(ctx.GetInner(), ctx.Stdin, ctx.Stdout, cmd.NoDelete, cmd.Jail); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+
 	httpd.Server(repo, cmd.ListenAddr, cmd.NoDelete)
 	return 0, nil
 }
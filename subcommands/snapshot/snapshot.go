@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package snapshot
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/derive"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &SnapshotFilter{} }, subcommands.AgentSupport, "snapshot", "filter")
+}
+
+type SnapshotFilter struct {
+	subcommands.SubcommandBase
+
+	Concurrency uint64
+	Filter      *derive.Filter
+	Snapshots   []string
+}
+
+func (cmd *SnapshotFilter) Parse(ctx *appcontext.AppContext, args []string) error {
+	cmd.Filter = &derive.Filter{}
+
+	flags := flag.NewFlagSet("snapshot filter", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] SNAPSHOT...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Uint64Var(&cmd.Concurrency, "concurrency", uint64(ctx.MaxConcurrency), "maximum number of parallel tasks")
+	cmd.Filter.InstallFlags(flags)
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no snapshot specified")
+	}
+	if cmd.Filter.Empty() {
+		return fmt.Errorf("no filter specified, use -include or -exclude")
+	}
+	if err := cmd.Filter.Compile(); err != nil {
+		return err
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.Snapshots = flags.Args()
+
+	return nil
+}
+
+func (cmd *SnapshotFilter) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	errors := 0
+	for _, prefix := range cmd.Snapshots {
+		if err := ctx.Err(); err != nil {
+			return 1, err
+		}
+
+		snapshotID, err := utils.LocateSnapshotByPrefix(repo, prefix)
+		if err != nil {
+			ctx.GetLogger().Error("snapshot filter: %s", err)
+			errors++
+			continue
+		}
+
+		snap, err := snapshot.Load(repo, snapshotID)
+		if err != nil {
+			ctx.GetLogger().Error("snapshot filter: %x: %s", snapshotID[:4], err)
+			errors++
+			continue
+		}
+
+		derivedID, err := derive.Snapshot(snap, repo, &derive.Options{
+			Filter:         cmd.Filter,
+			MaxConcurrency: cmd.Concurrency,
+		})
+		snap.Close()
+		if err != nil {
+			ctx.GetLogger().Error("snapshot filter: %x: %s", snapshotID[:4], err)
+			errors++
+			continue
+		}
+
+		ctx.GetLogger().Info("snapshot filter: created snapshot %x from %x", derivedID[:4], snapshotID[:4])
+	}
+
+	if errors != 0 {
+		return 1, fmt.Errorf("failed to filter %d snapshots", errors)
+	}
+	return 0, nil
+}
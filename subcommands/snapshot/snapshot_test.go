@@ -0,0 +1,76 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func generateSnapshot(t *testing.T, bufOut *bytes.Buffer, bufErr *bytes.Buffer) (*repository.Repository, *snapshot.Snapshot, *appcontext.AppContext) {
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockDir("cache"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("cache/big.bin", 0644, "cached data"),
+	})
+	return repo, snap, ctx
+}
+
+func TestExecuteCmdSnapshotFilter(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	indexId := snap.Header.GetIndexID()
+	subcommand := &SnapshotFilter{}
+	err := subcommand.Parse(ctx, []string{"-exclude", "/cache", hex.EncodeToString(indexId[:])})
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), fmt.Sprintf("from %x", indexId[:4]))
+
+	require.NoError(t, repo.RebuildState())
+	snapshotIDs, err := repo.GetSnapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshotIDs, 2)
+
+	for _, snapshotID := range snapshotIDs {
+		if snapshotID == snap.Header.Identifier {
+			continue
+		}
+		derived, err := snapshot.Load(repo, snapshotID)
+		require.NoError(t, err)
+		defer derived.Close()
+
+		fs, err := derived.Filesystem()
+		require.NoError(t, err)
+		_, err = fs.Stat("/subdir/dummy.txt")
+		require.NoError(t, err)
+		_, err = fs.Stat("/cache")
+		require.Error(t, err)
+	}
+}
+
+func TestParseCmdSnapshotFilterNoPattern(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	_, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	subcommand := &SnapshotFilter{}
+	err := subcommand.Parse(ctx, []string{"abcd"})
+	require.Error(t, err)
+}
@@ -19,6 +19,10 @@ const (
 	BeforeRepositoryOpen
 	AgentSupport
 	IgnoreVersion
+
+	// WriteOnly commands don't need the private key of an
+	// asymmetric repository, which is only asked for if configured.
+	WriteOnly
 )
 
 type Subcommand interface {
@@ -17,18 +17,24 @@
 package sync
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	gosync "sync"
+	"time"
 
-	"github.com/PlakarKorp/kloset/encryption"
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/derive"
+	"github.com/PlakarKorp/plakar/keyring"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
+	"github.com/dustin/go-humanize"
+	"golang.org/x/sync/errgroup"
 )
 
 func init() {
@@ -37,6 +43,7 @@ func init() {
 
 func (cmd *Sync) Parse(ctx *appcontext.AppContext, args []string) error {
 	cmd.SrcLocateOptions = utils.NewDefaultLocateOptions()
+	cmd.Filter = &derive.Filter{}
 
 	flags := flag.NewFlagSet("sync", flag.ExitOnError)
 	flags.Usage = func() {
@@ -44,10 +51,21 @@ func (cmd *Sync) Parse(ctx *appcontext.AppContext, args []string) error {
 		fmt.Fprintf(flags.Output(), "       %s [SNAPSHOT] from REPOSITORY\n", flags.Name())
 		flags.PrintDefaults()
 	}
+	flags.Uint64Var(&cmd.Concurrency, "concurrency", uint64(ctx.MaxConcurrency), "maximum number of snapshots synchronized in parallel")
+	flags.BoolVar(&cmd.DryRun, "dry-run", false, "list the snapshots that would be synchronized without transferring them")
+	flags.BoolVar(&cmd.Mirror, "mirror", false, "remove snapshots from the destination that no longer exist at the source")
+	cmd.Filter.InstallFlags(flags)
 	cmd.SrcLocateOptions.InstallFlags(flags)
 
 	flags.Parse(args)
 
+	if cmd.Concurrency == 0 {
+		return fmt.Errorf("concurrency must be at least 1")
+	}
+	if err := cmd.Filter.Compile(); err != nil {
+		return err
+	}
+
 	direction := ""
 	peerRepositoryPath := ""
 
@@ -71,6 +89,12 @@ func (cmd *Sync) Parse(ctx *appcontext.AppContext, args []string) error {
 	if direction != "to" && direction != "from" && direction != "with" {
 		return fmt.Errorf("invalid direction, must be to, from or with")
 	}
+	if cmd.Mirror && direction == "with" {
+		return fmt.Errorf("-mirror cannot be used with a bidirectional synchronization")
+	}
+	if !cmd.Filter.Empty() && direction == "with" {
+		return fmt.Errorf("-include and -exclude cannot be used with a bidirectional synchronization")
+	}
 
 	storeConfig, err := ctx.Config.GetRepository(peerRepositoryPath)
 	if err != nil {
@@ -90,12 +114,11 @@ func (cmd *Sync) Parse(ctx *appcontext.AppContext, args []string) error {
 	var peerSecret []byte
 	if peerStoreConfig.Encryption != nil {
 		if pass, ok := storeConfig["passphrase"]; ok {
-			key, err := encryption.DeriveKey(peerStoreConfig.Encryption.KDFParams, []byte(pass))
-			if err != nil {
-				return err
-			}
-			if !encryption.VerifyCanary(peerStoreConfig.Encryption, key) {
+			key, err := keyring.Unlock(peerStore, peerStoreConfig.Encryption, []byte(pass))
+			if errors.Is(err, keyring.ErrCantUnlock) {
 				return fmt.Errorf("invalid passphrase")
+			} else if err != nil {
+				return err
 			}
 			peerSecret = key
 		} else {
@@ -106,12 +129,11 @@ func (cmd *Sync) Parse(ctx *appcontext.AppContext, args []string) error {
 					continue
 				}
 
-				key, err := encryption.DeriveKey(peerStoreConfig.Encryption.KDFParams, passphrase)
-				if err != nil {
-					return err
-				}
-				if !encryption.VerifyCanary(peerStoreConfig.Encryption, key) {
+				key, err := keyring.Unlock(peerStore, peerStoreConfig.Encryption, passphrase)
+				if errors.Is(err, keyring.ErrCantUnlock) {
 					return fmt.Errorf("invalid passphrase")
+				} else if err != nil {
+					return err
 				}
 				peerSecret = key
 				break
@@ -121,7 +143,11 @@ func (cmd *Sync) Parse(ctx *appcontext.AppContext, args []string) error {
 
 	peerCtx := appcontext.NewAppContextFrom(ctx)
 	peerCtx.SetSecret(peerSecret)
-	_, err = repository.NewNoRebuild(peerCtx.GetInner(), peerCtx.GetSecret(), peerStore, peerStoreSerializedConfig)
+	peerStore, secret, err := keyring.OpenStore(peerStore, peerStoreSerializedConfig, peerCtx.GetSecret(), ctx.CacheDir)
+	if err != nil {
+		return err
+	}
+	_, err = repository.NewNoRebuild(peerCtx.GetInner(), secret, peerStore, peerStoreSerializedConfig)
 	if err != nil {
 		return err
 	}
@@ -140,9 +166,42 @@ type Sync struct {
 	PeerRepositoryLocation string
 	PeerRepositorySecret   []byte
 
-	Direction string
+	Direction   string
+	Concurrency uint64
+	DryRun      bool
+	Mirror      bool
 
 	SrcLocateOptions *utils.LocateOptions
+
+	// Filter, if not empty, restricts the entries copied, the
+	// synchronized snapshots are then derived from the source ones.
+	Filter *derive.Filter
+
+	// Progress, if set, is called each time a snapshot has been
+	// synchronized or deleted, successfully or not.  Calls are
+	// serialized.
+	Progress func(Event) `msgpack:"-"`
+}
+
+// Event reports the outcome of a single operation of a synchronization
+// along with the counters of the whole run.
+type Event struct {
+	Action      string // "sync" or "delete"
+	SnapshotID  objects.MAC
+	Source      string
+	Destination string
+	Size        uint64
+	Err         error
+
+	Done   int
+	Failed int
+	Total  int
+}
+
+type job struct {
+	action     string
+	src, dst   *repository.Repository
+	snapshotID objects.MAC
 }
 
 func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
@@ -158,7 +217,11 @@ func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 
 	peerCtx := appcontext.NewAppContextFrom(ctx)
 	peerCtx.SetSecret(cmd.PeerRepositorySecret)
-	peerRepository, err := repository.New(peerCtx.GetInner(), peerCtx.GetSecret(), peerStore, peerStoreSerializedConfig)
+	peerStore, secret, err := keyring.OpenStore(peerStore, peerStoreSerializedConfig, peerCtx.GetSecret(), ctx.CacheDir)
+	if err != nil {
+		return 1, fmt.Errorf("could not open peer store %s: %s", cmd.PeerRepositoryLocation, err)
+	}
+	peerRepository, err := repository.New(peerCtx.GetInner(), secret, peerStore, peerStoreSerializedConfig)
 	if err != nil {
 		return 1, fmt.Errorf("could not open peer repository %s: %s", cmd.PeerRepositoryLocation, err)
 	}
@@ -200,84 +263,188 @@ func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 		dstSnapshotsMap[snapshotID] = struct{}{}
 	}
 
-	srcSyncList := make([]objects.MAC, 0)
-
 	srcSnapshotIDs, err := utils.LocateSnapshotIDs(srcRepository, cmd.SrcLocateOptions)
 	if err != nil {
-		return 1, fmt.Errorf("could not locate snapshots in source repository %s: %s", dstRepository.Location(), err)
+		return 1, fmt.Errorf("could not locate snapshots in source repository %s: %s", srcRepository.Location(), err)
 	}
 
+	jobs := make([]job, 0)
 	for _, snapshotID := range srcSnapshotIDs {
 		if _, exists := dstSnapshotsMap[snapshotID]; !exists {
-			srcSyncList = append(srcSyncList, snapshotID)
-		}
-	}
-
-	for _, snapshotID := range srcSyncList {
-		if err := ctx.Err(); err != nil {
-			return 1, err
-		}
-
-		err := synchronize(ctx, srcRepository, dstRepository, snapshotID)
-		if err != nil {
-			ctx.GetLogger().Error("failed to synchronize snapshot %x from source repository %s: %s",
-				snapshotID[:4], srcRepository.Location(), err)
+			jobs = append(jobs, job{"sync", srcRepository, dstRepository, snapshotID})
 		}
 	}
 
-	if cmd.Direction == "with" {
+	if cmd.Direction == "with" || cmd.Mirror {
 		dstSnapshotIDs, err := utils.LocateSnapshotIDs(dstRepository, cmd.SrcLocateOptions)
 		if err != nil {
 			return 1, fmt.Errorf("could not locate snapshots in peer repository %s: %s", dstRepository.Location(), err)
 		}
 
-		dstSyncList := make([]objects.MAC, 0)
 		for _, snapshotID := range dstSnapshotIDs {
-			if _, exists := srcSnapshotsMap[snapshotID]; !exists {
-				dstSyncList = append(dstSyncList, snapshotID)
+			if _, exists := srcSnapshotsMap[snapshotID]; exists {
+				continue
+			}
+			if cmd.Mirror {
+				jobs = append(jobs, job{"delete", srcRepository, dstRepository, snapshotID})
+			} else {
+				jobs = append(jobs, job{"sync", dstRepository, srcRepository, snapshotID})
 			}
 		}
+	}
 
-		for _, snapshotID := range dstSyncList {
-			if err := ctx.Err(); err != nil {
-				return 1, err
-			}
-			err := synchronize(ctx, dstRepository, srcRepository, snapshotID)
-			if err != nil {
-				ctx.GetLogger().Error("failed to synchronize snapshot %x from peer repository %s: %s",
-					snapshotID[:4], dstRepository.Location(), err)
+	if cmd.DryRun {
+		return cmd.dryRun(ctx, jobs)
+	}
+
+	var mtx gosync.Mutex
+	var synchronized, deleted, failed int
+	done := func(j job, size uint64, err error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		if err != nil {
+			failed++
+			if j.action == "delete" {
+				ctx.GetLogger().Error("failed to delete snapshot %x from %s: %s",
+					j.snapshotID[:4], j.dst.Location(), err)
+			} else {
+				ctx.GetLogger().Error("failed to synchronize snapshot %x from %s: %s",
+					j.snapshotID[:4], j.src.Location(), err)
 			}
+		} else if j.action == "delete" {
+			deleted++
+		} else {
+			synchronized++
+		}
+
+		if cmd.Progress != nil {
+			cmd.Progress(Event{
+				Action:      j.action,
+				SnapshotID:  j.snapshotID,
+				Source:      j.src.Location(),
+				Destination: j.dst.Location(),
+				Size:        size,
+				Err:         err,
+				Done:        synchronized + deleted,
+				Failed:      failed,
+				Total:       len(jobs),
+			})
 		}
+	}
+
+	wg := new(errgroup.Group)
+	wg.SetLimit(int(cmd.Concurrency))
+	for _, j := range jobs {
+		if err := ctx.Err(); err != nil {
+			break
+		}
+		wg.Go(func() error {
+			if j.action == "delete" {
+				err := j.dst.DeleteSnapshot(j.snapshotID)
+				if err == nil {
+					ctx.GetLogger().Info("sync: removal of %x from %s completed", j.snapshotID[:4], j.dst.Location())
+				}
+				done(j, 0, err)
+			} else {
+				size, err := cmd.synchronize(ctx, j.src, j.dst, j.snapshotID)
+				done(j, size, err)
+			}
+			return nil
+		})
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return 1, err
+	}
+
+	if cmd.Direction == "with" {
 		ctx.GetLogger().Info("sync: synchronization between %s and %s completed: %d snapshots synchronized",
 			srcRepository.Location(),
 			dstRepository.Location(),
-			len(srcSyncList)+len(dstSyncList))
-	} else if cmd.Direction == "to" {
-		ctx.GetLogger().Info("sync: synchronization from %s to %s completed: %d snapshots synchronized",
-			srcRepository.Location(),
-			dstRepository.Location(),
-			len(srcSyncList))
+			synchronized)
 	} else {
 		ctx.GetLogger().Info("sync: synchronization from %s to %s completed: %d snapshots synchronized",
-			dstRepository.Location(),
 			srcRepository.Location(),
-			len(srcSyncList))
+			dstRepository.Location(),
+			synchronized)
+	}
+	if cmd.Mirror {
+		ctx.GetLogger().Info("sync: %d snapshots removed from %s", deleted, dstRepository.Location())
 	}
 
+	if failed != 0 {
+		return 1, fmt.Errorf("%d of %d snapshots failed to synchronize", failed, len(jobs))
+	}
 	return 0, nil
 }
 
-func synchronize(ctx *appcontext.AppContext, srcRepository, dstRepository *repository.Repository, snapshotID objects.MAC) error {
+func (cmd *Sync) dryRun(ctx *appcontext.AppContext, jobs []job) (int, error) {
+	var total uint64
+	var synchronized, deleted int
+	for _, j := range jobs {
+		if j.action == "delete" {
+			fmt.Fprintf(ctx.Stdout, "delete %x from %s\n", j.snapshotID[:4], j.dst.Location())
+			deleted++
+			continue
+		}
+
+		snap, err := snapshot.Load(j.src, j.snapshotID)
+		if err != nil {
+			return 1, fmt.Errorf("could not load snapshot %x from %s: %w", j.snapshotID[:4], j.src.Location(), err)
+		}
+		size := snapshotSize(snap)
+		fmt.Fprintf(ctx.Stdout, "sync %x %s %10s to %s\n",
+			j.snapshotID[:4],
+			snap.Header.Timestamp.UTC().Format(time.RFC3339),
+			humanize.Bytes(size),
+			j.dst.Location())
+		snap.Close()
+
+		total += size
+		synchronized++
+	}
+
+	fmt.Fprintf(ctx.Stdout, "%d snapshots to synchronize, at most %s to transfer", synchronized, humanize.Bytes(total))
+	if cmd.Mirror {
+		fmt.Fprintf(ctx.Stdout, ", %d snapshots to remove", deleted)
+	}
+	fmt.Fprintf(ctx.Stdout, "\n")
+	return 0, nil
+}
+
+// snapshotSize returns the logical size of a snapshot.  Chunks already
+// present in the destination are not transferred again, so it is only
+// an upper bound of what a synchronization moves.
+func snapshotSize(snap *snapshot.Snapshot) uint64 {
+	summary := snap.Header.GetSource(0).Summary
+	return summary.Directory.Size + summary.Below.Size
+}
+
+func (cmd *Sync) synchronize(ctx *appcontext.AppContext, srcRepository, dstRepository *repository.Repository, snapshotID objects.MAC) (uint64, error) {
 	ctx.GetLogger().Info("Synchronizing snapshot %x from %s to %s", snapshotID, srcRepository.Location(), dstRepository.Location())
 	srcSnapshot, err := snapshot.Load(srcRepository, snapshotID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer srcSnapshot.Close()
 
+	if cmd.Filter != nil && !cmd.Filter.Empty() {
+		_, err := derive.Snapshot(srcSnapshot, dstRepository, &derive.Options{
+			Filter:         cmd.Filter,
+			KeepIdentifier: true,
+		})
+		if err != nil {
+			return 0, err
+		}
+		ctx.GetLogger().Info("Synchronization of %x finished", snapshotID)
+		return snapshotSize(srcSnapshot), nil
+	}
+
 	dstSnapshot, err := snapshot.Create(dstRepository, repository.DefaultType)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer dstSnapshot.Close()
 
@@ -285,11 +452,13 @@ func synchronize(ctx *appcontext.AppContext, srcRepository, dstRepository *repos
 	dstSnapshot.Header = srcSnapshot.Header
 
 	if err := srcSnapshot.Synchronize(dstSnapshot); err != nil {
-		return err
+		return 0, err
 	}
 
-	err = dstSnapshot.Commit(nil, true)
+	if err := dstSnapshot.Commit(nil, true); err != nil {
+		return 0, err
+	}
 
 	ctx.GetLogger().Info("Synchronization of %x finished", snapshotID)
-	return err
+	return snapshotSize(srcSnapshot), nil
 }
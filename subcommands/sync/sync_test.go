@@ -130,3 +130,122 @@ func TestExecuteCmdSyncWithEncryption(t *testing.T) {
 	output := bufOut.String()
 	require.Contains(t, strings.Trim(output, "\n"), fmt.Sprintf("info: sync: synchronization between %s and %s completed: 1 snapshots synchronized", localRepo.Location(), peerRepo.Location()))
 }
+
+func TestExecuteCmdSyncDryRun(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	localRepo, snap, lctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	peerRepo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	subcommand := &Sync{}
+	err := subcommand.Parse(lctx, []string{"-dry-run", "to", peerRepo.Location()})
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(lctx, localRepo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	indexId := snap.Header.GetIndexID()
+	output := bufOut.String()
+	require.Contains(t, output, fmt.Sprintf("sync %x ", indexId[:4]))
+	require.Contains(t, output, "1 snapshots to synchronize, at most")
+
+	peerSnapshots, err := peerRepo.GetSnapshots()
+	require.NoError(t, err)
+	require.Len(t, peerSnapshots, 0)
+}
+
+func TestExecuteCmdSyncMirror(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	localRepo, snap, lctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	peerRepo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	var events []Event
+	subcommand := &Sync{}
+	err := subcommand.Parse(lctx, []string{"-concurrency", "4", "to", peerRepo.Location()})
+	require.NoError(t, err)
+	subcommand.Progress = func(ev Event) { events = append(events, ev) }
+
+	status, err := subcommand.Execute(lctx, localRepo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Len(t, events, 1)
+	require.Equal(t, "sync", events[0].Action)
+	require.Equal(t, 1, events[0].Done)
+	require.Equal(t, 1, events[0].Total)
+
+	indexId := snap.Header.GetIndexID()
+	require.NoError(t, localRepo.DeleteSnapshot(indexId))
+	require.NoError(t, localRepo.RebuildState())
+
+	subcommand = &Sync{}
+	err = subcommand.Parse(lctx, []string{"-mirror", "to", peerRepo.Location()})
+	require.NoError(t, err)
+
+	status, err = subcommand.Execute(lctx, localRepo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), fmt.Sprintf("info: sync: 1 snapshots removed from %s", peerRepo.Location()))
+}
+
+func TestParseCmdSyncMirrorWith(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	_, snap, lctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	peerRepo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	subcommand := &Sync{}
+	err := subcommand.Parse(lctx, []string{"-mirror", "with", peerRepo.Location()})
+	require.Error(t, err)
+}
+
+func TestExecuteCmdSyncFiltered(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	localRepo, snap, lctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	peerRepo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	subcommand := &Sync{}
+	err := subcommand.Parse(lctx, []string{"-include", "/subdir", "-exclude", "*/foo.txt", "to", peerRepo.Location()})
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(lctx, localRepo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	require.NoError(t, peerRepo.RebuildState())
+	peerSnap, err := snapshot.Load(peerRepo, snap.Header.Identifier)
+	require.NoError(t, err)
+	defer peerSnap.Close()
+
+	fs, err := peerSnap.Filesystem()
+	require.NoError(t, err)
+	_, err = fs.Stat("/subdir/dummy.txt")
+	require.NoError(t, err)
+	_, err = fs.Stat("/subdir/foo.txt")
+	require.Error(t, err)
+	_, err = fs.Stat("/another_subdir")
+	require.Error(t, err)
+
+	// the derived snapshot keeps its identifier, so it is not synchronized again
+	subcommand = &Sync{}
+	err = subcommand.Parse(lctx, []string{"-include", "/subdir", "to", peerRepo.Location()})
+	require.NoError(t, err)
+	status, err = subcommand.Execute(lctx, localRepo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "completed: 0 snapshots synchronized")
+}
@@ -22,15 +22,18 @@ package ui
 import (
 	"flag"
 	"fmt"
+	"time"
 
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/subcommands"
 	v2 "github.com/PlakarKorp/plakar/ui/v2"
+	"github.com/PlakarKorp/plakar/users"
 	"github.com/google/uuid"
 )
 
 func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &UiUser{} }, subcommands.BeforeRepositoryOpen, "ui", "user")
 	subcommands.Register(func() subcommands.Subcommand { return &Ui{} }, subcommands.AgentSupport, "ui")
 }
 
@@ -45,6 +48,7 @@ func (cmd *Ui) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags.StringVar(&cmd.Addr, "addr", "", "address to listen on")
 	flags.BoolVar(&cmd.Cors, "cors", false, "enable CORS")
 	flags.BoolVar(&cmd.NoAuth, "no-auth", false, "don't use authentication")
+	flags.StringVar(&cmd.SessionTTL, "session-ttl", "12h", "lifetime of user sessions")
 	flags.BoolVar(&cmd.NoSpawn, "no-spawn", false, "don't spawn browser")
 	flags.Parse(args)
 
@@ -56,10 +60,11 @@ func (cmd *Ui) Parse(ctx *appcontext.AppContext, args []string) error {
 type Ui struct {
 	subcommands.SubcommandBase
 
-	Addr    string
-	Cors    bool
-	NoAuth  bool
-	NoSpawn bool
+	Addr       string
+	Cors       bool
+	NoAuth     bool
+	NoSpawn    bool
+	SessionTTL string
 }
 
 func (cmd *Ui) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
@@ -71,6 +76,28 @@ func (cmd *Ui) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 
 	if !cmd.NoAuth {
 		ui_opts.Token = uuid.NewString()
+
+		um, err := users.NewManager(ctx.ConfigDir)
+		if err != nil {
+			return 1, fmt.Errorf("failed to load user accounts: %w", err)
+		}
+
+		if !um.Empty() {
+			ttl, err := time.ParseDuration(cmd.SessionTTL)
+			if err != nil || ttl <= 0 {
+				return 1, fmt.Errorf("invalid session ttl: %s", cmd.SessionTTL)
+			}
+			ui_opts.SessionTTL = ttl
+
+			audit, err := users.NewAuditLog(ctx.ConfigDir)
+			if err != nil {
+				return 1, fmt.Errorf("failed to open audit log: %w", err)
+			}
+			defer audit.Close()
+
+			ui_opts.Users = um
+			ui_opts.Audit = audit
+		}
 	}
 
 	err := v2.Ui(repo, ctx, cmd.Addr, &ui_opts)
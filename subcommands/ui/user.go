@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ui
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/users"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+type UiUser struct {
+	subcommands.SubcommandBase
+
+	args []string
+}
+
+func (cmd *UiUser) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("ui user", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [add|ls|key|passwd|revoke|rm|role]\n", flags.Name())
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+	cmd.args = flags.Args()
+
+	return nil
+}
+
+func (cmd *UiUser) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	um, err := users.NewManager(ctx.ConfigDir)
+	if err != nil {
+		return 1, err
+	}
+
+	if err := cmd_ui_user(ctx, um, cmd.args); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func getUserPassword(name string) ([]byte, error) {
+	return utils.GetPassphraseConfirm("user "+name, 60)
+}
+
+func cmd_ui_user(ctx *appcontext.AppContext, um *users.Manager, args []string) error {
+	usage := "usage: plakar ui user [add|ls|key|passwd|revoke|rm|role]"
+	cmd := "ls"
+	if len(args) > 0 {
+		cmd = args[0]
+		args = args[1:]
+	}
+
+	var roles []string
+	for _, role := range users.Roles() {
+		roles = append(roles, string(role))
+	}
+
+	switch cmd {
+	case "add":
+		usage := "usage: plakar ui user add [-no-password] <name> <" + strings.Join(roles, "|") + ">"
+		flags := flag.NewFlagSet("ui user add", flag.ContinueOnError)
+		noPassword := flags.Bool("no-password", false, "create an account usable with api keys only")
+		if err := flags.Parse(args); err != nil || flags.NArg() != 2 {
+			return fmt.Errorf(usage)
+		}
+		name := flags.Arg(0)
+		role, err := users.ParseRole(flags.Arg(1))
+		if err != nil {
+			return err
+		}
+
+		var password []byte
+		if !*noPassword {
+			password, err = getUserPassword(name)
+			if err != nil {
+				return err
+			}
+		}
+		return um.Add(name, role, password)
+
+	case "ls":
+		usage := "usage: plakar ui user ls"
+		if len(args) != 0 {
+			return fmt.Errorf(usage)
+		}
+		list, err := um.List()
+		if err != nil {
+			return err
+		}
+		for _, user := range list {
+			fmt.Fprintf(ctx.Stdout, "%s %s %s keys=%d\n",
+				user.Created.UTC().Format("2006-01-02T15:04:05Z"), user.Name, user.Role, len(user.APIKeys))
+			for _, key := range user.APIKeys {
+				fmt.Fprintf(ctx.Stdout, "    key %s %s\n", key.ID,
+					key.Created.UTC().Format("2006-01-02T15:04:05Z"))
+			}
+		}
+		return nil
+
+	case "key":
+		usage := "usage: plakar ui user key <name>"
+		if len(args) != 1 {
+			return fmt.Errorf(usage)
+		}
+		key, err := um.NewAPIKey(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(ctx.Stdout, key)
+		return nil
+
+	case "revoke":
+		usage := "usage: plakar ui user revoke <name> <key-id>"
+		if len(args) != 2 {
+			return fmt.Errorf(usage)
+		}
+		return um.RevokeAPIKey(args[0], args[1])
+
+	case "passwd":
+		usage := "usage: plakar ui user passwd <name>"
+		if len(args) != 1 {
+			return fmt.Errorf(usage)
+		}
+		if _, err := um.Get(args[0]); err != nil {
+			return err
+		}
+		password, err := getUserPassword(args[0])
+		if err != nil {
+			return err
+		}
+		return um.SetPassword(args[0], password)
+
+	case "rm":
+		usage := "usage: plakar ui user rm <name>"
+		if len(args) != 1 {
+			return fmt.Errorf(usage)
+		}
+		return um.Remove(args[0])
+
+	case "role":
+		usage := "usage: plakar ui user role <name> <" + strings.Join(roles, "|") + ">"
+		if len(args) != 2 {
+			return fmt.Errorf(usage)
+		}
+		role, err := users.ParseRole(args[1])
+		if err != nil {
+			return err
+		}
+		return um.SetRole(args[0], role)
+
+	default:
+		return fmt.Errorf(usage)
+	}
+}
@@ -1,9 +1,19 @@
 package testing
 
 import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,11 +25,56 @@ type MockFTPServer struct {
 	listener net.Listener
 	Files    map[string][]byte
 	Dirs     map[string]bool
+	Modes    map[string]os.FileMode
 	auth     map[string]string
 	mu       sync.RWMutex // Protect concurrent access to Files and Dirs
+
+	// CertPEM is the self-signed certificate of an FTPS server
+	CertPEM   []byte
+	tlsConfig *tls.Config
+	implicit  bool
 }
 
 func NewMockFTPServer() (*MockFTPServer, error) {
+	return newMockFTPServer(nil, false)
+}
+
+// NewMockFTPSServer returns a server that accepts AUTH TLS, or that
+// only speaks TLS if implicit is set.
+func NewMockFTPSServer(implicit bool) (*MockFTPServer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %v", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	server, err := newMockFTPServer(config, implicit)
+	if err != nil {
+		return nil, err
+	}
+	server.CertPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return server, nil
+}
+
+func newMockFTPServer(tlsConfig *tls.Config, implicit bool) (*MockFTPServer, error) {
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create listener: %v", err)
@@ -30,9 +85,12 @@ func NewMockFTPServer() (*MockFTPServer, error) {
 		listener: listener,
 		Files:    make(map[string][]byte),
 		Dirs:     make(map[string]bool),
+		Modes:    make(map[string]os.FileMode),
 		auth: map[string]string{
 			"test": "test",
 		},
+		tlsConfig: tlsConfig,
+		implicit:  implicit,
 	}
 
 	// Start the server
@@ -47,25 +105,75 @@ func (s *MockFTPServer) serve() {
 		if err != nil {
 			return
 		}
+		if s.implicit {
+			conn = tls.Server(conn, s.tlsConfig)
+		}
 		go s.handleConnection(conn)
 	}
 }
 
+// lookup tells if pathname is a known file or directory, the clients
+// name them with or without a leading slash.
+func (s *MockFTPServer) lookup(pathname string) (string, bool, bool) {
+	for _, name := range []string{pathname, strings.TrimPrefix(pathname, "/")} {
+		if _, ok := s.Files[name]; ok {
+			return name, true, false
+		}
+		if _, ok := s.Dirs[name]; ok {
+			return name, false, true
+		}
+	}
+	return pathname, false, false
+}
+
 func (s *MockFTPServer) handleConnection(conn net.Conn) {
-	defer conn.Close()
+	defer func() { conn.Close() }()
 
 	var username string
 	var authenticated bool
+	var protected bool
 	var dataConn net.Conn
 	var dataListener net.Listener
+	var activeAddr string
+	var offset int64
+	var renameFrom string
+
+	reply := func(format string, args ...any) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	// openData returns the data connection of the next transfer,
+	// dialing the client in active mode.
+	openData := func() net.Conn {
+		dc := dataConn
+		if activeAddr != "" {
+			var err error
+			dc, err = net.Dial("tcp", activeAddr)
+			if err != nil {
+				return nil
+			}
+			activeAddr = ""
+		}
+		dataConn = nil
+		if dc != nil && protected {
+			dc = tls.Server(dc, s.tlsConfig)
+		}
+		return dc
+	}
+	closeData := func(dc net.Conn) {
+		dc.Close()
+		if dataListener != nil {
+			dataListener.Close()
+			dataListener = nil
+		}
+	}
 
 	// Send welcome message
-	conn.Write([]byte("220 Welcome to mock FTP server\r\n"))
+	reply("220 Welcome to mock FTP server")
 
-	// Command handling
-	buf := make([]byte, 1024)
+	reader := bufio.NewReader(conn)
 	for {
-		n, err := conn.Read(buf)
+		line, err := reader.ReadString('\n')
 		if err != nil {
 			if dataListener != nil {
 				dataListener.Close()
@@ -73,34 +181,68 @@ func (s *MockFTPServer) handleConnection(conn net.Conn) {
 			return
 		}
 
-		cmd := strings.TrimSpace(string(buf[:n]))
-		switch {
-		case strings.HasPrefix(cmd, "USER"):
-			username = strings.TrimPrefix(cmd, "USER ")
-			username = strings.TrimSpace(username)
-			conn.Write([]byte("331 Please specify the password\r\n"))
-		case strings.HasPrefix(cmd, "PASS"):
-			password := strings.TrimPrefix(cmd, "PASS ")
-			password = strings.TrimSpace(password)
-			if expectedPass, exists := s.auth[username]; exists && expectedPass == password {
+		cmd := strings.TrimSpace(line)
+		verb, arg, _ := strings.Cut(cmd, " ")
+		verb = strings.ToUpper(verb)
+
+		if !authenticated {
+			switch verb {
+			case "USER", "PASS", "AUTH", "PBSZ", "PROT", "FEAT", "SYST", "QUIT":
+			default:
+				reply("530 Please login with USER and PASS")
+				continue
+			}
+		}
+
+		switch verb {
+		case "AUTH":
+			if s.tlsConfig == nil || s.implicit {
+				reply("502 TLS not available")
+				continue
+			}
+			reply("234 Proceed with negotiation")
+			conn = tls.Server(conn, s.tlsConfig)
+			reader = bufio.NewReader(conn)
+		case "PBSZ":
+			reply("200 PBSZ=0")
+		case "PROT":
+			if arg != "P" || s.tlsConfig == nil {
+				reply("536 Only P is supported")
+				continue
+			}
+			protected = true
+			reply("200 Protection level set to P")
+		case "USER":
+			username = strings.TrimSpace(arg)
+			reply("331 Please specify the password")
+		case "PASS":
+			password := strings.TrimSpace(arg)
+			s.mu.RLock()
+			expectedPass, exists := s.auth[username]
+			s.mu.RUnlock()
+			if exists && expectedPass == password {
 				authenticated = true
-				conn.Write([]byte("230 Login successful\r\n"))
+				reply("230 Login successful")
 			} else {
-				conn.Write([]byte("530 Login incorrect\r\n"))
+				reply("530 Login incorrect")
 				return
 			}
-		case strings.HasPrefix(cmd, "SYST"):
-			conn.Write([]byte("215 UNIX Type: L8\r\n"))
-		case strings.HasPrefix(cmd, "FEAT"):
-			conn.Write([]byte("211-Features:\r\n"))
-			conn.Write([]byte(" PASV\r\n"))
-			conn.Write([]byte(" UTF8\r\n"))
-			conn.Write([]byte("211 End\r\n"))
-		case strings.HasPrefix(cmd, "PWD"):
-			conn.Write([]byte("257 \"/\" is current directory\r\n"))
-		case strings.HasPrefix(cmd, "TYPE"):
-			conn.Write([]byte("200 Type set to I\r\n"))
-		case strings.HasPrefix(cmd, "PASV"):
+		case "SYST":
+			reply("215 UNIX Type: L8")
+		case "FEAT":
+			reply("211-Features:")
+			reply(" PASV")
+			reply(" SIZE")
+			reply(" UTF8")
+			if s.tlsConfig != nil {
+				reply(" AUTH TLS")
+			}
+			reply("211 End")
+		case "PWD":
+			reply("257 \"/\" is current directory")
+		case "TYPE":
+			reply("200 Type set to I")
+		case "PASV":
 			// Close any existing data listener
 			if dataListener != nil {
 				dataListener.Close()
@@ -110,7 +252,7 @@ func (s *MockFTPServer) handleConnection(conn net.Conn) {
 			var err error
 			dataListener, err = net.Listen("tcp", "127.0.0.1:0")
 			if err != nil {
-				conn.Write([]byte("425 Can't open data connection\r\n"))
+				reply("425 Can't open data connection")
 				continue
 			}
 
@@ -119,206 +261,248 @@ func (s *MockFTPServer) handleConnection(conn net.Conn) {
 			port, _ := strconv.Atoi(portStr)
 
 			// Send the passive mode response with the port number
-			conn.Write([]byte(fmt.Sprintf("227 Entering Passive Mode (127,0,0,1,%d,%d)\r\n", port>>8, port&0xFF)))
+			reply("227 Entering Passive Mode (127,0,0,1,%d,%d)", port>>8, port&0xFF)
 
 			// Accept the data connection
 			dataConn, err = dataListener.Accept()
 			if err != nil {
-				conn.Write([]byte("425 Can't open data connection\r\n"))
+				reply("425 Can't open data connection")
 				dataListener.Close()
 				dataListener = nil
 				continue
 			}
-		case strings.HasPrefix(cmd, "LIST"):
-			if !authenticated {
-				conn.Write([]byte("530 Please login with USER and PASS\r\n"))
+		case "PORT":
+			var h [6]int
+			if _, err := fmt.Sscanf(arg, "%d,%d,%d,%d,%d,%d", &h[0], &h[1], &h[2], &h[3], &h[4], &h[5]); err != nil {
+				reply("501 Syntax error")
 				continue
 			}
-
+			activeAddr = fmt.Sprintf("%d.%d.%d.%d:%d", h[0], h[1], h[2], h[3], h[4]<<8|h[5])
+			reply("200 PORT command successful")
+		case "LIST":
 			// Parse the path argument if present
-			parts := strings.Fields(cmd)
-			listPath := "/"
-			if len(parts) > 1 {
-				listPath = parts[1]
-			}
+			listPath := strings.TrimSpace(arg)
 			if listPath == "" {
 				listPath = "/"
 			}
 
-			if dataConn == nil {
-				conn.Write([]byte("425 Can't open data connection\r\n"))
+			s.mu.RLock()
+			name, isFile, isDir := s.lookup(listPath)
+			s.mu.RUnlock()
+			if listPath != "/" && !isFile && !isDir {
+				reply("550 No such file or directory")
+				continue
+			}
+
+			dc := openData()
+			if dc == nil {
+				reply("425 Can't open data connection")
 				continue
 			}
 
-			conn.Write([]byte("150 Opening data connection\r\n"))
+			reply("150 Opening data connection")
 
 			s.mu.RLock()
-			normPath := strings.TrimPrefix(listPath, "/")
-			if listPath == "/" {
-				// Root directory listing: return . and .. plus all files and dirs in root
-				entry := "drwxr-xr-x  2 ftp ftp     4096 Jan 01 00:00 .\r\n"
-				dataConn.Write([]byte(entry))
-				entry = "drwxr-xr-x  2 ftp ftp     4096 Jan 01 00:00 ..\r\n"
-				dataConn.Write([]byte(entry))
-
-				// List directories in root
-				for dir := range s.Dirs {
-					if parentDir(dir) == "/" && dir != "/" {
-						entry := fmt.Sprintf("drwxr-xr-x  2 ftp ftp     4096 Jan 01 00:00 %s\r\n", basename(dir))
-						dataConn.Write([]byte(entry))
-					}
-				}
-
-				// List files in root
-				for file := range s.Files {
-					if parentDir(file) == "/" {
-						content := s.Files[file]
-						entry := fmt.Sprintf("-rw-r--r--  1 ftp ftp %8d Jan 01 00:00 %s\r\n", len(content), basename(file))
-						dataConn.Write([]byte(entry))
-					}
-				}
-			} else if _, ok := s.Files[normPath]; ok {
-				content := s.Files[normPath]
-				entry := fmt.Sprintf("-rw-r--r--  1 ftp ftp %8d Jan 01 00:00 %s\r\n", len(content), basename(normPath))
-				dataConn.Write([]byte(entry))
+			if isFile {
+				content := s.Files[name]
+				fmt.Fprintf(dc, "-rw-r--r--  1 ftp ftp %8d Jan 01 00:00 %s\r\n", len(content), basename(name))
 			} else {
-				// Directory listing
-				entry := "drwxr-xr-x  2 ftp ftp     4096 Jan 01 00:00 .\r\n"
-				dataConn.Write([]byte(entry))
-				entry = "drwxr-xr-x  2 ftp ftp     4096 Jan 01 00:00 ..\r\n"
-				dataConn.Write([]byte(entry))
+				// Directory listing: return . and .. plus all files and dirs in it
+				fmt.Fprintf(dc, "drwxr-xr-x  2 ftp ftp     4096 Jan 01 00:00 .\r\n")
+				fmt.Fprintf(dc, "drwxr-xr-x  2 ftp ftp     4096 Jan 01 00:00 ..\r\n")
 
 				// List directories
 				for dir := range s.Dirs {
 					if parentDir(dir) == listPath && dir != listPath {
-						entry := fmt.Sprintf("drwxr-xr-x  2 ftp ftp     4096 Jan 01 00:00 %s\r\n", basename(dir))
-						dataConn.Write([]byte(entry))
+						fmt.Fprintf(dc, "drwxr-xr-x  2 ftp ftp     4096 Jan 01 00:00 %s\r\n", basename(dir))
 					}
 				}
 
 				// List files
-				for file := range s.Files {
+				for file, content := range s.Files {
 					if parentDir(file) == listPath {
-						content := s.Files[file]
-						entry := fmt.Sprintf("-rw-r--r--  1 ftp ftp %8d Jan 01 00:00 %s\r\n", len(content), basename(file))
-						dataConn.Write([]byte(entry))
+						fmt.Fprintf(dc, "-rw-r--r--  1 ftp ftp %8d Jan 01 00:00 %s\r\n", len(content), basename(file))
 					}
 				}
 			}
 			s.mu.RUnlock()
 
-			// Give the client time to read the data
-			time.Sleep(100 * time.Millisecond)
-			dataConn.Close()
-			dataConn = nil
-			if dataListener != nil {
-				dataListener.Close()
-				dataListener = nil
+			closeData(dc)
+			reply("226 Transfer complete")
+
+		case "MKD":
+			dir := arg
+			s.mu.Lock()
+			if _, _, isDir := s.lookup(dir); isDir {
+				s.mu.Unlock()
+				reply("550 Directory already exists")
+				continue
 			}
-			conn.Write([]byte("226 Transfer complete\r\n"))
+			s.Dirs[dir] = true
+			s.mu.Unlock()
+			reply("257 \"%s\" directory created", dir)
 
-		case strings.HasPrefix(cmd, "MKD"):
-			if !authenticated {
-				conn.Write([]byte("530 Please login with USER and PASS\r\n"))
+		case "RMD":
+			s.mu.Lock()
+			name, _, isDir := s.lookup(arg)
+			if isDir {
+				delete(s.Dirs, name)
+			}
+			s.mu.Unlock()
+			if !isDir {
+				reply("550 No such directory")
 				continue
 			}
-			dir := strings.TrimPrefix(cmd, "MKD ")
+			reply("250 Directory removed")
+
+		case "DELE":
 			s.mu.Lock()
-			s.Dirs[dir] = true
+			name, isFile, _ := s.lookup(arg)
+			if isFile {
+				delete(s.Files, name)
+			}
 			s.mu.Unlock()
-			conn.Write([]byte("257 \"" + dir + "\" directory created\r\n"))
+			if !isFile {
+				reply("550 No such file")
+				continue
+			}
+			reply("250 File deleted")
 
-		case strings.HasPrefix(cmd, "STOR"):
-			if !authenticated {
-				conn.Write([]byte("530 Please login with USER and PASS\r\n"))
+		case "RNFR":
+			s.mu.RLock()
+			name, isFile, isDir := s.lookup(arg)
+			s.mu.RUnlock()
+			if !isFile && !isDir {
+				reply("550 No such file or directory")
 				continue
 			}
-			file := strings.TrimPrefix(cmd, "STOR ")
-			if dataConn == nil {
-				conn.Write([]byte("425 Can't open data connection\r\n"))
+			renameFrom = name
+			reply("350 Ready for RNTO")
+
+		case "RNTO":
+			if renameFrom == "" {
+				reply("503 RNFR required first")
 				continue
 			}
-			conn.Write([]byte("150 Ok to send data\r\n"))
+			s.mu.Lock()
+			if content, ok := s.Files[renameFrom]; ok {
+				delete(s.Files, renameFrom)
+				s.Files[arg] = content
+			} else {
+				delete(s.Dirs, renameFrom)
+				s.Dirs[arg] = true
+			}
+			s.mu.Unlock()
+			renameFrom = ""
+			reply("250 Rename successful")
 
-			// Read file data from data connection
-			data := make([]byte, 1024)
-			n, err := dataConn.Read(data)
-			if err != nil && err != io.EOF {
-				conn.Write([]byte("550 Error reading file\r\n"))
+		case "SIZE":
+			s.mu.RLock()
+			name, isFile, _ := s.lookup(arg)
+			size := len(s.Files[name])
+			s.mu.RUnlock()
+			if !isFile {
+				reply("550 No such file")
+				continue
+			}
+			reply("213 %d", size)
+
+		case "REST":
+			n, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || n < 0 {
+				reply("501 Invalid offset")
+				continue
+			}
+			offset = n
+			reply("350 Restarting at %d", n)
+
+		case "SITE":
+			var mode os.FileMode
+			var file string
+			if _, err := fmt.Sscanf(arg, "CHMOD %o %s", &mode, &file); err != nil {
+				reply("501 Syntax error")
 				continue
 			}
 			s.mu.Lock()
-			s.Files[file] = data[:n]
+			name, isFile, isDir := s.lookup(file)
+			if isFile || isDir {
+				s.Modes[name] = mode
+			}
 			s.mu.Unlock()
-			dataConn.Close()
-			dataConn = nil
-			if dataListener != nil {
-				dataListener.Close()
-				dataListener = nil
+			if !isFile && !isDir {
+				reply("550 No such file or directory")
+				continue
+			}
+			reply("200 SITE CHMOD command ok")
+
+		case "STOR":
+			file := arg
+			dc := openData()
+			if dc == nil {
+				reply("425 Can't open data connection")
+				continue
+			}
+			reply("150 Ok to send data")
+
+			// Read file data from data connection
+			data, err := io.ReadAll(dc)
+			closeData(dc)
+			if err != nil {
+				reply("550 Error reading file")
+				continue
 			}
-			conn.Write([]byte("226 Transfer complete\r\n"))
+			s.mu.Lock()
+			s.Files[file] = data
+			s.mu.Unlock()
+			reply("226 Transfer complete")
 
-		case strings.HasPrefix(cmd, "QUIT"):
-			conn.Write([]byte("221 Goodbye\r\n"))
+		case "QUIT":
+			reply("221 Goodbye")
 			if dataListener != nil {
 				dataListener.Close()
 			}
 			return
 
-		case strings.HasPrefix(cmd, "STAT"):
-			if !authenticated {
-				conn.Write([]byte("530 Please login with USER and PASS\r\n"))
-				continue
-			}
-			path := strings.TrimSpace(strings.TrimPrefix(cmd, "STAT"))
+		case "STAT":
+			path := strings.TrimSpace(arg)
 			if path == "" {
 				path = "/"
 			}
 			s.mu.RLock()
 			if _, ok := s.Dirs[path]; ok {
-				conn.Write([]byte("213 Directory status\r\n"))
+				reply("213 Directory status")
 			} else if _, ok := s.Files[path]; ok {
-				conn.Write([]byte("213 File status\r\n"))
+				reply("213 File status")
 			} else {
-				conn.Write([]byte("550 File not found\r\n"))
+				reply("550 File not found")
 			}
 			s.mu.RUnlock()
 
-		case strings.HasPrefix(cmd, "RETR"):
-			if !authenticated {
-				conn.Write([]byte("530 Please login with USER and PASS\r\n"))
-				continue
-			}
-			file := strings.TrimSpace(strings.TrimPrefix(cmd, "RETR"))
-			normPath := strings.TrimPrefix(file, "/")
+		case "RETR":
+			file := strings.TrimSpace(arg)
 			s.mu.RLock()
-			content, ok := s.Files[file]
-			content2, ok2 := s.Files[normPath]
+			name, isFile, _ := s.lookup(file)
+			content := s.Files[name]
 			s.mu.RUnlock()
-			if !ok && !ok2 {
-				conn.Write([]byte("550 File not found\r\n"))
+			if !isFile {
+				reply("550 File not found")
 				continue
 			}
-			if dataConn == nil {
-				conn.Write([]byte("425 Can't open data connection\r\n"))
+			start := min(offset, int64(len(content)))
+			offset = 0
+
+			dc := openData()
+			if dc == nil {
+				reply("425 Can't open data connection")
 				continue
 			}
-			if ok2 && !ok {
-				content = content2
-			}
-			conn.Write([]byte("150 Opening data connection\r\n"))
-			dataConn.Write(content)
-			time.Sleep(100 * time.Millisecond)
-			dataConn.Close()
-			dataConn = nil
-			if dataListener != nil {
-				dataListener.Close()
-				dataListener = nil
-			}
-			conn.Write([]byte("226 Transfer complete\r\n"))
+			reply("150 Opening data connection")
+			dc.Write(content[start:])
+			closeData(dc)
+			reply("226 Transfer complete")
 
 		default:
-			conn.Write([]byte("500 Unknown command\r\n"))
+			reply("500 Unknown command")
 		}
 	}
 }
@@ -76,6 +76,7 @@ func (m *MockFile) ScanResult() *importer.ScanResult {
 
 type testingOptions struct {
 	name string
+	tags []string
 	gen  func(chan<- *importer.ScanResult)
 }
 
@@ -93,6 +94,12 @@ func WithName(name string) TestingOptions {
 	}
 }
 
+func WithTags(tags ...string) TestingOptions {
+	return func(o *testingOptions) {
+		o.tags = tags
+	}
+}
+
 func GenerateFiles(t *testing.T, files []MockFile) string {
 	tmpBackupDir, err := os.MkdirTemp("", "tmp_to_backup")
 	require.NoError(t, err)
@@ -149,7 +156,7 @@ func GenerateSnapshot(t *testing.T, repo *repository.Repository, files []MockFil
 		imp.(*MockImporter).SetFiles(files)
 	}
 
-	builder.Backup(imp, &snapshot.BackupOptions{Name: o.name, MaxConcurrency: 1})
+	builder.Backup(imp, &snapshot.BackupOptions{Name: o.name, Tags: o.tags, MaxConcurrency: 1})
 
 	err = builder.Repository().RebuildState()
 	require.NoError(t, err)
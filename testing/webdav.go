@@ -0,0 +1,95 @@
+package testing
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// MockWebDAVServer serves a temporary directory over WebDAV, asking for
+// the test/test credentials with the basic or digest scheme if auth is
+// set.
+type MockWebDAVServer struct {
+	Addr     string
+	RootDir  string
+	Username string
+	Password string
+	server   *httptest.Server
+	nonce    string
+}
+
+func NewMockWebDAVServer(t *testing.T, auth string) *MockWebDAVServer {
+	s := &MockWebDAVServer{
+		RootDir:  t.TempDir(),
+		Username: "test",
+		Password: "test",
+		nonce:    "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+	}
+
+	var handler http.Handler = &webdav.Handler{
+		FileSystem: webdav.Dir(s.RootDir),
+		LockSystem: webdav.NewMemLS(),
+	}
+	switch auth {
+	case "basic":
+		handler = s.basic(handler)
+	case "digest":
+		handler = s.digest(handler)
+	}
+
+	s.server = httptest.NewServer(handler)
+	s.Addr = s.server.Listener.Addr().String()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *MockWebDAVServer) Close() {
+	s.server.Close()
+}
+
+func (s *MockWebDAVServer) basic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != s.Username || password != s.Password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="plakar"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *MockWebDAVServer) digest(next http.Handler) http.Handler {
+	hash := func(values ...string) string {
+		sum := md5.Sum([]byte(strings.Join(values, ":")))
+		return hex.EncodeToString(sum[:])
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := make(map[string]string)
+		if value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Digest "); ok {
+			for _, param := range strings.Split(value, ", ") {
+				key, value, _ := strings.Cut(param, "=")
+				params[key] = strings.Trim(value, `"`)
+			}
+		}
+
+		ha1 := hash(s.Username, "plakar", s.Password)
+		ha2 := hash(r.Method, params["uri"])
+		expected := hash(ha1, s.nonce, params["nc"], params["cnonce"], "auth", ha2)
+		if params["username"] != s.Username || params["nonce"] != s.nonce ||
+			params["uri"] != r.URL.RequestURI() || params["response"] != expected {
+			w.Header().Set("WWW-Authenticate",
+				fmt.Sprintf(`Digest realm="plakar", qop="auth", nonce=%q, opaque="5ccc069c403ebaf9f0171e9517f40e41"`, s.nonce))
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,310 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package thaw makes the packfiles of a cold storage repository
+// readable before a command reads them.
+//
+// The packfiles needed are found from the repository state, except for
+// those holding the snapshot metadata below the VFS root, which is only
+// known once the metadata above it is read.  Planning is thus repeated
+// until it no longer runs into an archived packfile.  The restores
+// requested are recorded in the cache so that a command interrupted or
+// run without waiting resumes them the next time.
+package thaw
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+)
+
+var ErrPending = errors.New("packfiles are being restored from cold storage")
+
+type Thaw struct {
+	ctx    *appcontext.AppContext
+	repo   *repository.Repository
+	thawer plakarstorage.Thawer
+	path   string
+
+	// Wait polls the store every Interval until the packfiles are
+	// readable, instead of failing with ErrPending.
+	Wait     bool
+	Interval time.Duration
+}
+
+// New returns nil if the store of the repository isn't cold storage.
+func New(ctx *appcontext.AppContext, repo *repository.Repository) *Thaw {
+	thawer, ok := plakarstorage.IsColdStorage(repo.Store())
+	if !ok {
+		return nil
+	}
+	return &Thaw{
+		ctx:      ctx,
+		repo:     repo,
+		thawer:   thawer,
+		path:     filepath.Join(ctx.CacheDir, "thaw", repo.Configuration().RepositoryID.String()+".json"),
+		Interval: 15 * time.Minute,
+	}
+}
+
+// plan returns the packfiles to thaw, and false if some could not be
+// known yet because the metadata leading to them is archived.
+type plan func() (map[objects.MAC]struct{}, bool, error)
+
+// Headers thaws the packfiles holding the snapshot headers missing from
+// the cache, which locating snapshots reads.
+func (t *Thaw) Headers() error {
+	return t.run(func() (map[objects.MAC]struct{}, bool, error) {
+		cache, err := t.ctx.GetCache().Repository(t.repo.Configuration().RepositoryID)
+		if err != nil {
+			return nil, false, err
+		}
+
+		packfiles := make(map[objects.MAC]struct{})
+		for snapshotID := range t.repo.ListSnapshots() {
+			if data, err := cache.GetSnapshot(snapshotID); err == nil && data != nil {
+				continue
+			}
+			if err := t.add(packfiles, resources.RT_SNAPSHOT, snapshotID); err != nil {
+				return nil, false, err
+			}
+		}
+		return packfiles, true, nil
+	})
+}
+
+// Snapshot thaws the packfiles holding the metadata and the data read
+// when restoring or checking pathname in a snapshot.
+func (t *Thaw) Snapshot(snapshotID objects.MAC, pathname string) error {
+	return t.run(func() (map[objects.MAC]struct{}, bool, error) {
+		packfiles := make(map[objects.MAC]struct{})
+		complete := true
+
+		archived := func(err error) bool {
+			var archivedErr *plakarstorage.ArchivedError
+			if !errors.As(err, &archivedErr) {
+				return false
+			}
+			packfiles[archivedErr.Packfile] = struct{}{}
+			complete = false
+			return true
+		}
+
+		if err := t.add(packfiles, resources.RT_SNAPSHOT, snapshotID); err != nil {
+			return nil, false, err
+		}
+
+		snap, err := snapshot.Load(t.repo, snapshotID)
+		if err != nil {
+			if archived(err) {
+				return packfiles, complete, nil
+			}
+			return nil, false, err
+		}
+		defer snap.Close()
+
+		source := snap.Header.GetSource(0)
+		for rtype, mac := range map[resources.Type]objects.MAC{
+			resources.RT_VFS_BTREE:   source.VFS.Root,
+			resources.RT_ERROR_BTREE: source.VFS.Errors,
+			resources.RT_XATTR_BTREE: source.VFS.Xattrs,
+		} {
+			if err := t.add(packfiles, rtype, mac); err != nil {
+				return nil, false, err
+			}
+		}
+
+		fs, err := snap.Filesystem()
+		if err != nil {
+			if archived(err) {
+				return packfiles, complete, nil
+			}
+			return nil, false, err
+		}
+
+		for entry, err := range fs.Files(pathname) {
+			if err != nil {
+				if archived(err) {
+					continue
+				}
+				return nil, false, err
+			}
+			if !entry.HasObject() {
+				continue
+			}
+			if err := t.add(packfiles, resources.RT_OBJECT, entry.Object); err != nil {
+				return nil, false, err
+			}
+			for _, chunk := range entry.ResolvedObject.Chunks {
+				if err := t.add(packfiles, resources.RT_CHUNK, chunk.ContentMAC); err != nil {
+					return nil, false, err
+				}
+			}
+		}
+		return packfiles, complete, nil
+	})
+}
+
+func (t *Thaw) add(packfiles map[objects.MAC]struct{}, rtype resources.Type, mac objects.MAC) error {
+	packfile, exists, err := t.repo.GetPackfileForBlob(rtype, mac)
+	if err != nil {
+		return err
+	}
+	if exists {
+		packfiles[packfile] = struct{}{}
+	}
+	return nil
+}
+
+func (t *Thaw) run(fn plan) error {
+	previous := -1
+	for {
+		needed, complete, err := fn()
+		if err != nil {
+			return err
+		}
+
+		pending, err := t.load()
+		if err != nil {
+			return err
+		}
+
+		waiting := 0
+		oldest := time.Now()
+		for mac := range needed {
+			readable, err := t.thawer.Thaw(mac)
+			if err != nil {
+				return err
+			}
+			if readable {
+				delete(pending, mac)
+				continue
+			}
+			requested, ok := pending[mac]
+			if !ok {
+				requested = time.Now()
+				pending[mac] = requested
+			}
+			if requested.Before(oldest) {
+				oldest = requested
+			}
+			waiting++
+		}
+
+		if err := t.save(pending); err != nil {
+			return err
+		}
+
+		if waiting == 0 {
+			if complete {
+				return nil
+			}
+			// the metadata read so far is readable, plan again
+			// unless that didn't get us any further
+			if len(needed) == previous {
+				return fmt.Errorf("could not plan the packfiles to restore")
+			}
+			previous = len(needed)
+			continue
+		}
+
+		if !t.Wait {
+			return fmt.Errorf("%w: %d packfiles pending since %s, run the command again once they are readable",
+				ErrPending, waiting, oldest.Format(time.RFC3339))
+		}
+
+		t.ctx.GetLogger().Info("thaw: waiting for %d packfiles to be restored", waiting)
+		select {
+		case <-t.ctx.Done():
+			return t.ctx.Err()
+		case <-time.After(t.Interval):
+		}
+	}
+}
+
+// load returns the restores requested so far and when they were.
+func (t *Thaw) load() (map[objects.MAC]time.Time, error) {
+	pending := make(map[objects.MAC]time.Time)
+
+	data, err := os.ReadFile(t.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return pending, nil
+		}
+		return nil, err
+	}
+
+	var saved map[string]time.Time
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %w", t.path, err)
+	}
+	for key, requested := range saved {
+		buf, err := hex.DecodeString(key)
+		if err != nil || len(buf) != len(objects.MAC{}) {
+			continue
+		}
+		pending[objects.MAC(buf)] = requested
+	}
+	return pending, nil
+}
+
+func (t *Thaw) save(pending map[objects.MAC]time.Time) error {
+	if len(pending) == 0 {
+		if err := os.Remove(t.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	saved := make(map[string]time.Time, len(pending))
+	for mac, requested := range pending {
+		saved[hex.EncodeToString(mac[:])] = requested
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), "thaw.*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), t.path)
+}
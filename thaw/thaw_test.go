@@ -0,0 +1,33 @@
+package thaw
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingPersistence(t *testing.T) {
+	th := &Thaw{path: filepath.Join(t.TempDir(), "thaw", "repository.json")}
+
+	pending, err := th.load()
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	requested := time.Now().Add(-time.Hour).Truncate(time.Second)
+	pending[objects.MAC{0x01}] = requested
+	require.NoError(t, th.save(pending))
+
+	pending, err = th.load()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.True(t, requested.Equal(pending[objects.MAC{0x01}]))
+
+	// nothing pending removes the file
+	require.NoError(t, th.save(map[objects.MAC]time.Time{}))
+	_, err = os.Stat(th.path)
+	require.True(t, os.IsNotExist(err))
+}
@@ -24,10 +24,12 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"time"
 
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/api"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/users"
 	"github.com/PlakarKorp/plakar/utils"
 )
 
@@ -36,6 +38,12 @@ type UiOptions struct {
 	NoSpawn        bool
 	Cors           bool
 	Token          string
+
+	// When Users holds accounts, requests must authenticate as one of
+	// them and Token is only used to sign sessions and URLs.
+	Users      *users.Manager
+	Audit      *users.AuditLog
+	SessionTTL time.Duration
 }
 
 //go:embed frontend/*
@@ -43,7 +51,12 @@ var content embed.FS
 
 func Ui(repo *repository.Repository, ctx *appcontext.AppContext, addr string, opts *UiOptions) error {
 	server := http.NewServeMux()
-	api.SetupRoutes(server, repo, ctx, opts.Token)
+	multiUser := opts.Users != nil && !opts.Users.Empty()
+	auth := api.NewAuthenticator(opts.Token, opts.Users, opts.Audit)
+	if opts.SessionTTL != 0 {
+		auth.SetSessionTTL(opts.SessionTTL)
+	}
+	api.SetupRoutesWithAuth(server, repo, ctx, auth)
 
 	// Serve files from the ./frontend directory
 	server.HandleFunc("/{path...}", func(w http.ResponseWriter, r *http.Request) {
@@ -88,7 +101,7 @@ func Ui(repo *repository.Repository, ctx *appcontext.AppContext, addr string, op
 	}
 
 	var url string
-	if opts.Token == "" {
+	if opts.Token == "" || multiUser {
 		url = fmt.Sprintf("http://%s", addr)
 	} else {
 		url = fmt.Sprintf("http://%s?plakar_token=%s", addr, opts.Token)
@@ -114,6 +127,7 @@ func Ui(repo *repository.Repository, ctx *appcontext.AppContext, addr string, op
 		<-repo.AppContext().Done()
 		s.Shutdown(repo.AppContext().Context)
 	}()
+	defer api.CloseRepositories()
 
 	return s.ListenAndServe()
 }
@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package users
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const AUDIT_FILE = "audit.log"
+
+type AuditEvent struct {
+	Timestamp  time.Time `json:"timestamp"`
+	User       string    `json:"user"`
+	Role       Role      `json:"role,omitempty"`
+	Action     string    `json:"action"`
+	Repository string    `json:"repository,omitempty"`
+	Snapshot   string    `json:"snapshot,omitempty"`
+	Paths      []string  `json:"paths,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Success    bool      `json:"success"`
+}
+
+// AuditLog appends one JSON document per line to the audit file.  A nil
+// *AuditLog is valid and discards every event.
+type AuditLog struct {
+	mtx sync.Mutex
+	fp  *os.File
+}
+
+func NewAuditLog(configDir string) (*AuditLog, error) {
+	fp, err := os.OpenFile(filepath.Join(configDir, AUDIT_FILE),
+		os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{fp: fp}, nil
+}
+
+func (a *AuditLog) Record(event *AuditEvent) error {
+	if a == nil {
+		return nil
+	}
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	_, err = a.fp.Write(data)
+	return err
+}
+
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.fp.Close()
+}
@@ -0,0 +1,452 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+const USERS_FILE = "users.yml"
+
+// API keys are handed out as "plakar_<id>_<secret>", only the id and a
+// hash of the secret are stored.
+const apiKeyPrefix = "plakar_"
+
+var (
+	ErrUserExists      = errors.New("user already exists")
+	ErrUserNotFound    = errors.New("user not found")
+	ErrInvalidRole     = errors.New("invalid role")
+	ErrBadCredentials  = errors.New("invalid credentials")
+	ErrKeyNotFound     = errors.New("api key not found")
+	ErrInvalidUsername = errors.New("invalid username")
+)
+
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleRestorer Role = "restorer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+)
+
+var roles = []Role{RoleViewer, RoleRestorer, RoleOperator, RoleAdmin}
+
+func Roles() []Role {
+	return slices.Clone(roles)
+}
+
+func ParseRole(s string) (Role, error) {
+	r := Role(strings.ToLower(s))
+	if !slices.Contains(roles, r) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidRole, s)
+	}
+	return r, nil
+}
+
+func (r Role) rank() int {
+	return slices.Index(roles, r)
+}
+
+// Allows returns true if a user with role r may perform an operation that
+// requires the role required.  Roles are strictly ordered, each one
+// including the permissions of the previous ones.
+func (r Role) Allows(required Role) bool {
+	rank := r.rank()
+	return rank >= 0 && rank >= required.rank()
+}
+
+type APIKey struct {
+	ID      string    `yaml:"id"`
+	Hash    string    `yaml:"hash"`
+	Created time.Time `yaml:"created"`
+}
+
+type User struct {
+	Name         string    `yaml:"-"`
+	Role         Role      `yaml:"role"`
+	PasswordHash string    `yaml:"password,omitempty"`
+	APIKeys      []APIKey  `yaml:"api_keys,omitempty"`
+	Created      time.Time `yaml:"created"`
+}
+
+// Manager maintains the local user accounts stored in the configuration
+// directory.  The file is reloaded whenever it changes on disk so that
+// accounts edited with `plakar ui user` are picked up by a running UI.
+type Manager struct {
+	path string
+
+	mtx     sync.Mutex
+	modTime time.Time
+	users   map[string]*User
+}
+
+func NewManager(configDir string) (*Manager, error) {
+	m := &Manager{
+		path:  filepath.Join(configDir, USERS_FILE),
+		users: make(map[string]*User),
+	}
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Manager) reload() error {
+	info, err := os.Stat(m.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			m.users = make(map[string]*User)
+			m.modTime = time.Time{}
+			return nil
+		}
+		return err
+	}
+
+	if info.ModTime().Equal(m.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		return err
+	}
+
+	users := make(map[string]*User)
+	if err := yaml.Unmarshal(data, &users); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", m.path, err)
+	}
+	for name, user := range users {
+		user.Name = name
+	}
+
+	m.users = users
+	m.modTime = info.ModTime()
+	return nil
+}
+
+func (m *Manager) save() error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(m.path), "users.*.yml")
+	if err != nil {
+		return err
+	}
+
+	err = yaml.NewEncoder(tmpFile).Encode(m.users)
+	tmpFile.Close()
+
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), 0600)
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), m.path)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	if info, err := os.Stat(m.path); err == nil {
+		m.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// Empty returns true if no account was configured, in which case the UI
+// falls back to the single shared token.
+func (m *Manager) Empty() bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if err := m.reload(); err != nil {
+		return false
+	}
+	return len(m.users) == 0
+}
+
+func (m *Manager) List() ([]User, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+
+	ret := make([]User, 0, len(m.users))
+	for _, user := range m.users {
+		ret = append(ret, *user)
+	}
+	slices.SortFunc(ret, func(a, b User) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return ret, nil
+}
+
+func (m *Manager) Get(name string) (*User, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+
+	user, ok := m.users[name]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	ret := *user
+	return &ret, nil
+}
+
+func validUsername(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == '@':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Manager) Add(name string, role Role, password []byte) error {
+	if !validUsername(name) {
+		return ErrInvalidUsername
+	}
+	if role.rank() < 0 {
+		return ErrInvalidRole
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if err := m.reload(); err != nil {
+		return err
+	}
+	if _, ok := m.users[name]; ok {
+		return ErrUserExists
+	}
+
+	user := &User{
+		Name:    name,
+		Role:    role,
+		Created: time.Now(),
+	}
+	if password != nil {
+		hash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		user.PasswordHash = string(hash)
+	}
+
+	m.users[name] = user
+	return m.save()
+}
+
+func (m *Manager) Remove(name string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if err := m.reload(); err != nil {
+		return err
+	}
+	if _, ok := m.users[name]; !ok {
+		return ErrUserNotFound
+	}
+	delete(m.users, name)
+	return m.save()
+}
+
+func (m *Manager) SetRole(name string, role Role) error {
+	if role.rank() < 0 {
+		return ErrInvalidRole
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if err := m.reload(); err != nil {
+		return err
+	}
+	user, ok := m.users[name]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.Role = role
+	return m.save()
+}
+
+func (m *Manager) SetPassword(name string, password []byte) error {
+	hash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if err := m.reload(); err != nil {
+		return err
+	}
+	user, ok := m.users[name]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.PasswordHash = string(hash)
+	return m.save()
+}
+
+// Authenticate checks the password of the given user and returns the
+// account on success.
+func (m *Manager) Authenticate(name string, password []byte) (*User, error) {
+	user, err := m.Get(name)
+	if err != nil {
+		// compare against a dummy hash to not leak which users exist
+		bcrypt.CompareHashAndPassword(dummyHash(), password)
+		return nil, ErrBadCredentials
+	}
+	if user.PasswordHash == "" {
+		return nil, ErrBadCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), password) != nil {
+		return nil, ErrBadCredentials
+	}
+	return user, nil
+}
+
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("plakar"), bcrypt.DefaultCost)
+	return hash
+})
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIKey generates a new API key for the user.  The returned key is
+// only available once, the manager only keeps a hash of it.
+func (m *Manager) NewAPIKey(name string) (string, error) {
+	var id [4]byte
+	var secret [24]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(secret[:]); err != nil {
+		return "", err
+	}
+	keyID := hex.EncodeToString(id[:])
+	keySecret := hex.EncodeToString(secret[:])
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if err := m.reload(); err != nil {
+		return "", err
+	}
+	user, ok := m.users[name]
+	if !ok {
+		return "", ErrUserNotFound
+	}
+	user.APIKeys = append(user.APIKeys, APIKey{
+		ID:      keyID,
+		Hash:    hashSecret(keySecret),
+		Created: time.Now(),
+	})
+	if err := m.save(); err != nil {
+		return "", err
+	}
+
+	return apiKeyPrefix + keyID + "_" + keySecret, nil
+}
+
+func (m *Manager) RevokeAPIKey(name, keyID string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if err := m.reload(); err != nil {
+		return err
+	}
+	user, ok := m.users[name]
+	if !ok {
+		return ErrUserNotFound
+	}
+	idx := slices.IndexFunc(user.APIKeys, func(k APIKey) bool {
+		return k.ID == keyID
+	})
+	if idx == -1 {
+		return ErrKeyNotFound
+	}
+	user.APIKeys = slices.Delete(user.APIKeys, idx, idx+1)
+	return m.save()
+}
+
+// IsAPIKey returns true if the given string looks like an API key.
+func IsAPIKey(key string) bool {
+	return strings.HasPrefix(key, apiKeyPrefix)
+}
+
+// AuthenticateAPIKey returns the account that owns the given API key.
+func (m *Manager) AuthenticateAPIKey(key string) (*User, error) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return nil, ErrBadCredentials
+	}
+	keyID, secret, ok := strings.Cut(rest, "_")
+	if !ok {
+		return nil, ErrBadCredentials
+	}
+	hash := hashSecret(secret)
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	for _, user := range m.users {
+		for _, k := range user.APIKeys {
+			if k.ID != keyID {
+				continue
+			}
+			if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash)) == 1 {
+				ret := *user
+				return &ret, nil
+			}
+			return nil, ErrBadCredentials
+		}
+	}
+	return nil, ErrBadCredentials
+}
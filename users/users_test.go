@@ -0,0 +1,124 @@
+package users
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoles(t *testing.T) {
+	require.True(t, RoleAdmin.Allows(RoleViewer))
+	require.True(t, RoleOperator.Allows(RoleRestorer))
+	require.True(t, RoleRestorer.Allows(RoleRestorer))
+	require.False(t, RoleViewer.Allows(RoleRestorer))
+	require.False(t, RoleOperator.Allows(RoleAdmin))
+	require.False(t, Role("bogus").Allows(RoleViewer))
+
+	role, err := ParseRole("Operator")
+	require.NoError(t, err)
+	require.Equal(t, RoleOperator, role)
+
+	_, err = ParseRole("root")
+	require.ErrorIs(t, err, ErrInvalidRole)
+}
+
+func TestManager(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	m, err := NewManager(tmpDir)
+	require.NoError(t, err)
+	require.True(t, m.Empty())
+
+	require.NoError(t, m.Add("alice", RoleAdmin, []byte("correct horse")))
+	require.ErrorIs(t, m.Add("alice", RoleViewer, nil), ErrUserExists)
+	require.ErrorIs(t, m.Add("bad name", RoleViewer, nil), ErrInvalidUsername)
+	require.NoError(t, m.Add("bob", RoleViewer, nil))
+	require.False(t, m.Empty())
+
+	info, err := os.Stat(filepath.Join(tmpDir, USERS_FILE))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	user, err := m.Authenticate("alice", []byte("correct horse"))
+	require.NoError(t, err)
+	require.Equal(t, RoleAdmin, user.Role)
+
+	_, err = m.Authenticate("alice", []byte("wrong"))
+	require.ErrorIs(t, err, ErrBadCredentials)
+	_, err = m.Authenticate("bob", []byte(""))
+	require.ErrorIs(t, err, ErrBadCredentials)
+	_, err = m.Authenticate("nobody", []byte("correct horse"))
+	require.ErrorIs(t, err, ErrBadCredentials)
+
+	// a second manager sees the accounts saved by the first one
+	m2, err := NewManager(tmpDir)
+	require.NoError(t, err)
+	list, err := m2.List()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, "alice", list[0].Name)
+	require.Equal(t, "bob", list[1].Name)
+
+	require.NoError(t, m.SetRole("bob", RoleRestorer))
+	user, err = m.Get("bob")
+	require.NoError(t, err)
+	require.Equal(t, RoleRestorer, user.Role)
+
+	require.NoError(t, m.Remove("bob"))
+	require.ErrorIs(t, m.Remove("bob"), ErrUserNotFound)
+}
+
+func TestAPIKeys(t *testing.T) {
+	m, err := NewManager(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, m.Add("ci", RoleRestorer, nil))
+
+	key, err := m.NewAPIKey("ci")
+	require.NoError(t, err)
+	require.True(t, IsAPIKey(key))
+
+	user, err := m.AuthenticateAPIKey(key)
+	require.NoError(t, err)
+	require.Equal(t, "ci", user.Name)
+
+	_, err = m.AuthenticateAPIKey(key + "x")
+	require.ErrorIs(t, err, ErrBadCredentials)
+
+	user, err = m.Get("ci")
+	require.NoError(t, err)
+	require.Len(t, user.APIKeys, 1)
+
+	require.NoError(t, m.RevokeAPIKey("ci", user.APIKeys[0].ID))
+	_, err = m.AuthenticateAPIKey(key)
+	require.ErrorIs(t, err, ErrBadCredentials)
+}
+
+func TestAuditLog(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	var nilLog *AuditLog
+	require.NoError(t, nilLog.Record(&AuditEvent{Action: "noop"}))
+
+	audit, err := NewAuditLog(tmpDir)
+	require.NoError(t, err)
+	require.NoError(t, audit.Record(&AuditEvent{User: "alice", Action: "download", Paths: []string{"/etc/passwd"}, Success: true}))
+	require.NoError(t, audit.Close())
+
+	fp, err := os.Open(filepath.Join(tmpDir, AUDIT_FILE))
+	require.NoError(t, err)
+	defer fp.Close()
+
+	scanner := bufio.NewScanner(fp)
+	require.True(t, scanner.Scan())
+
+	var event AuditEvent
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+	require.Equal(t, "alice", event.User)
+	require.Equal(t, "download", event.Action)
+	require.False(t, event.Timestamp.IsZero())
+}
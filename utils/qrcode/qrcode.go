@@ -0,0 +1,453 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package qrcode encodes short payloads as QR codes, in byte mode at
+// error correction level M, and renders them in a terminal.  Only the
+// versions without version information blocks (1 to 6) are supported,
+// which is enough for about a hundred bytes.
+package qrcode
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+var ErrTooLong = errors.New("qrcode: data too long")
+
+// blocks describes the error correction blocks of a version at level
+// M, which are all of the same size up to version 6.
+type blocks struct {
+	count int
+	data  int
+	ecc   int
+}
+
+var versions = [...]blocks{
+	1: {1, 16, 10},
+	2: {1, 28, 16},
+	3: {1, 44, 26},
+	4: {2, 32, 18},
+	5: {2, 43, 24},
+	6: {4, 27, 16},
+}
+
+type Code struct {
+	Version int
+	Size    int
+
+	dark     [][]bool
+	reserved [][]bool
+}
+
+// Encode returns the QR code of data in the smallest version that can
+// hold it.
+func Encode(data []byte) (*Code, error) {
+	version := 0
+	for v := 1; v < len(versions); v++ {
+		// mode indicator and 8-bit character count
+		if 4+8+8*len(data) <= 8*versions[v].count*versions[v].data {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	c := &Code{Version: version, Size: 17 + 4*version}
+	c.dark = make([][]bool, c.Size)
+	c.reserved = make([][]bool, c.Size)
+	for y := range c.Size {
+		c.dark[y] = make([]bool, c.Size)
+		c.reserved[y] = make([]bool, c.Size)
+	}
+
+	c.drawPatterns()
+	c.drawCodewords(codewords(version, data))
+
+	best, bestPenalty := 0, -1
+	for mask := range 8 {
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		c.applyMask(mask)
+	}
+	c.applyMask(best)
+	c.drawFormat(best)
+
+	return c, nil
+}
+
+// Dark returns whether the module at column x and row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	return c.dark[y][x]
+}
+
+// Render writes the code to w with Unicode half blocks, two rows of
+// modules per line, surrounded by the four modules wide quiet zone.
+// Light modules are the ones drawn so that the code reads on the dark
+// background of most terminals.
+func (c *Code) Render(w io.Writer) error {
+	const quiet = 4
+
+	light := func(x, y int) bool {
+		x, y = x-quiet, y-quiet
+		if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+			return true
+		}
+		return !c.dark[y][x]
+	}
+
+	var sb strings.Builder
+	for y := 0; y < c.Size+2*quiet; y += 2 {
+		for x := 0; x < c.Size+2*quiet; x++ {
+			top, bottom := light(x, y), y+1 < c.Size+2*quiet && light(x, y+1)
+			switch {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (c *Code) set(x, y int, dark bool) {
+	c.dark[y][x] = dark
+	c.reserved[y][x] = true
+}
+
+func (c *Code) drawPatterns() {
+	for i := 8; i < c.Size-8; i++ {
+		c.set(i, 6, i%2 == 0)
+		c.set(6, i, i%2 == 0)
+	}
+
+	// finder patterns and their separators
+	for _, corner := range [][2]int{{0, 0}, {c.Size - 7, 0}, {0, c.Size - 7}} {
+		for dy := -1; dy <= 7; dy++ {
+			for dx := -1; dx <= 7; dx++ {
+				x, y := corner[0]+dx, corner[1]+dy
+				if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+					continue
+				}
+				d := max(abs(dx-3), abs(dy-3))
+				c.set(x, y, d != 2 && d != 4)
+			}
+		}
+	}
+
+	// up to version 6 there's a single alignment pattern, near the
+	// bottom right corner
+	if c.Version > 1 {
+		center := c.Size - 7
+		for dy := -2; dy <= 2; dy++ {
+			for dx := -2; dx <= 2; dx++ {
+				c.set(center+dx, center+dy, max(abs(dx), abs(dy)) != 1)
+			}
+		}
+	}
+
+	// reserve the format areas, drawn once the mask is chosen
+	c.drawFormat(0)
+}
+
+// drawFormat draws the level and mask, protected by a BCH code, next
+// to the finder patterns.
+func (c *Code) drawFormat(mask int) {
+	const levelM = 0
+
+	data := levelM<<3 | mask
+	rem := data
+	for range 10 {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return (bits>>i)&1 != 0
+	}
+
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.set(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size-15+i, bit(i))
+	}
+	c.set(8, c.Size-8, true)
+}
+
+// drawCodewords fills the data area in the zigzag order, two columns
+// at a time from the bottom right corner.  The remainder bits are left
+// light.
+func (c *Code) drawCodewords(codewords []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.reserved[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				c.dark[y][x] = (codewords[i/8]>>(7-i%8))&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := range c.Size {
+		for x := range c.Size {
+			if c.reserved[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (y/2+x/3)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				c.dark[y][x] = !c.dark[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the code with the rules of the specification, the
+// mask giving the lowest score being the easiest to scan.
+func (c *Code) penalty() int {
+	penalty := 0
+
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return c.dark[x][y]
+		}
+		return c.dark[y][x]
+	}
+
+	finder := []bool{true, false, true, true, true, false, true}
+	for _, transpose := range []bool{false, true} {
+		for y := range c.Size {
+			run := 1
+			for x := 1; x <= c.Size; x++ {
+				if x < c.Size && at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += 3 + run - 5
+				}
+				run = 1
+			}
+
+			for x := 0; x+len(finder) <= c.Size; x++ {
+				match := true
+				for i, dark := range finder {
+					if at(x+i, y, transpose) != dark {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				light := func(from, to int) bool {
+					for i := from; i < to; i++ {
+						if i >= 0 && i < c.Size && at(i, y, transpose) {
+							return false
+						}
+					}
+					return true
+				}
+				if light(x-4, x) || light(x+7, x+11) {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := range c.Size {
+		for x := range c.Size {
+			if c.dark[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 && c.dark[y][x] == c.dark[y-1][x] &&
+				c.dark[y][x] == c.dark[y][x-1] && c.dark[y][x] == c.dark[y-1][x-1] {
+				penalty += 3
+			}
+		}
+	}
+	penalty += 10 * (abs(dark*100/(c.Size*c.Size)-50) / 5)
+
+	return penalty
+}
+
+// codewords returns the data codewords of version followed by their
+// error correction, interleaved across blocks.
+func codewords(version int, data []byte) []byte {
+	b := versions[version]
+	capacity := b.count * b.data
+
+	bits := make([]bool, 0, capacity*8)
+	push := func(value, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (value>>i)&1 != 0)
+		}
+	}
+	push(0b0100, 4)
+	push(len(data), 8)
+	for _, c := range data {
+		push(int(c), 8)
+	}
+	push(0, min(4, capacity*8-len(bits)))
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+
+	payload := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var c byte
+		for j := range 8 {
+			if bits[i+j] {
+				c |= 1 << (7 - j)
+			}
+		}
+		payload = append(payload, c)
+	}
+	for pad := byte(0xec); len(payload) < capacity; pad ^= 0xec ^ 0x11 {
+		payload = append(payload, pad)
+	}
+
+	ecc := make([][]byte, b.count)
+	for i := range b.count {
+		ecc[i] = reedSolomon(payload[i*b.data:(i+1)*b.data], b.ecc)
+	}
+
+	out := make([]byte, 0, b.count*(b.data+b.ecc))
+	for i := range b.data {
+		for j := range b.count {
+			out = append(out, payload[j*b.data+i])
+		}
+	}
+	for i := range b.ecc {
+		for j := range b.count {
+			out = append(out, ecc[j][i])
+		}
+	}
+	return out
+}
+
+var gfExp [512]byte
+var gfLog [256]byte
+
+func init() {
+	x := 1
+	for i := range 255 {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// reedSolomon returns the n error correction codewords of data.
+func reedSolomon(data []byte, n int) []byte {
+	generator := []byte{1}
+	for i := range n {
+		next := make([]byte, len(generator)+1)
+		for j, c := range generator {
+			next[j] ^= c
+			next[j+1] ^= gfMul(c, gfExp[i])
+		}
+		generator = next
+	}
+
+	rem := make([]byte, len(data)+n)
+	copy(rem, data)
+	for i := range data {
+		c := rem[i]
+		if c == 0 {
+			continue
+		}
+		for j := 1; j < len(generator); j++ {
+			rem[i+j] ^= gfMul(generator[j], c)
+		}
+	}
+	return rem[len(data):]
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
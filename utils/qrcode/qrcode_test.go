@@ -0,0 +1,67 @@
+package qrcode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" at 1-M in alphanumeric mode
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	require.Equal(t, []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}, reedSolomon(data, 10))
+}
+
+func TestEncode(t *testing.T) {
+	c, err := Encode([]byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, 1, c.Version)
+	require.Equal(t, 21, c.Size)
+
+	c, err = Encode([]byte(strings.Repeat("A", 70)))
+	require.NoError(t, err)
+	require.Equal(t, 5, c.Version)
+	require.Equal(t, 37, c.Size)
+
+	// finder patterns
+	for _, corner := range [][2]int{{0, 0}, {c.Size - 7, 0}, {0, c.Size - 7}} {
+		require.True(t, c.Dark(corner[0], corner[1]))
+		require.False(t, c.Dark(corner[0]+1, corner[1]+1))
+		require.True(t, c.Dark(corner[0]+3, corner[1]+3))
+	}
+	require.True(t, c.Dark(8, c.Size-8))
+
+	// both copies of the format information match
+	var first, second int
+	for i := 0; i <= 5; i++ {
+		if c.Dark(8, i) {
+			first |= 1 << i
+		}
+	}
+	for i := 0; i < 6; i++ {
+		if c.Dark(c.Size-1-i, 8) {
+			second |= 1 << i
+		}
+	}
+	require.Equal(t, first, second)
+
+	_, err = Encode(make([]byte, 107))
+	require.ErrorIs(t, err, ErrTooLong)
+}
+
+func TestRender(t *testing.T) {
+	c, err := Encode([]byte("hello"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, c.Render(&buf))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, (21+8+1)/2)
+	for _, line := range lines {
+		require.Equal(t, 21+8, len([]rune(line)))
+	}
+	require.Equal(t, strings.Repeat("█", 29), lines[0])
+}
@@ -17,12 +17,15 @@
 package utils
 
 import (
+	"bufio"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/mail"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"runtime"
@@ -174,6 +177,20 @@ func GetPassphrase(prefix string) ([]byte, error) {
 	return readpassphrase(in, out, prefix+" passphrase: ")
 }
 
+// GetRecoveryKey prompts for a recovery key, as transcribed from paper.
+func GetRecoveryKey(prefix string) ([]byte, error) {
+	var in, out = os.Stdin, os.Stderr
+
+	// use the tty for I/O if possible
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err == nil {
+		in, out = tty, tty
+		defer tty.Close()
+	}
+
+	return readpassphrase(in, out, prefix+" recovery key: ")
+}
+
 func GetPassphraseConfirm(prefix string, minEntropyBits float64) ([]byte, error) {
 	var in, out = os.Stdin, os.Stderr
 
@@ -207,6 +224,52 @@ func GetPassphraseConfirm(prefix string, minEntropyBits float64) ([]byte, error)
 	return passphrase1, nil
 }
 
+// GetPassphraseFromCommand runs the given command through the shell and
+// returns the single line it printed.
+func GetPassphraseFromCommand(cmd string) ([]byte, error) {
+	var c *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		c = exec.Command("cmd", "/C", cmd)
+	default: // assume unix-esque
+		c = exec.Command("/bin/sh", "-c", cmd)
+	}
+
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+
+	var pass string
+	var lines int
+	scan := bufio.NewScanner(stdout)
+	for scan.Scan() {
+		pass = scan.Text()
+		lines++
+	}
+
+	// don't deadlock in case the scanner fails
+	io.Copy(io.Discard, stdout)
+
+	if err := c.Wait(); err != nil {
+		return nil, err
+	}
+
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	if lines != 1 {
+		return nil, fmt.Errorf("passphrase_cmd returned too many lines")
+	}
+
+	return []byte(pass), nil
+}
+
 func GetCacheDir(appName string) (string, error) {
 	var cacheDir string
 
@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package webdav
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// digest answers the challenge of a server using the digest scheme of
+// RFC 7616, with the auth quality of protection.  The nonce is reused
+// until the server marks it stale.
+type digest struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       bool
+	stale     bool
+
+	nc uint32
+}
+
+func parseDigest(challenge string) (*digest, error) {
+	params := parseParams(challenge)
+
+	d := &digest{
+		realm:     params["realm"],
+		nonce:     params["nonce"],
+		opaque:    params["opaque"],
+		algorithm: params["algorithm"],
+		stale:     strings.EqualFold(params["stale"], "true"),
+	}
+	if d.nonce == "" {
+		return nil, fmt.Errorf("digest challenge without a nonce")
+	}
+
+	switch strings.ToUpper(d.algorithm) {
+	case "", "MD5", "MD5-SESS", "SHA-256", "SHA-256-SESS":
+	default:
+		return nil, fmt.Errorf("unsupported digest algorithm %s", d.algorithm)
+	}
+
+	if qop, ok := params["qop"]; ok {
+		for _, value := range strings.Split(qop, ",") {
+			if strings.TrimSpace(value) == "auth" {
+				d.qop = true
+			}
+		}
+		if !d.qop {
+			return nil, fmt.Errorf("unsupported digest qop %s", qop)
+		}
+	}
+
+	return d, nil
+}
+
+// parseParams splits the comma-separated parameters of a challenge,
+// whose quoted values may contain commas themselves.
+func parseParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params
+		}
+
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return params
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " \t")
+
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			s = rest[min(i+1, len(rest)):]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end == -1 {
+				end = len(rest)
+			}
+			value.WriteString(strings.TrimSpace(rest[:end]))
+			s = rest[end:]
+		}
+		params[key] = value.String()
+	}
+}
+
+func (d *digest) hash(values ...string) string {
+	var h hash.Hash
+	if strings.HasPrefix(strings.ToUpper(d.algorithm), "SHA-256") {
+		h = sha256.New()
+	} else {
+		h = md5.New()
+	}
+	h.Write([]byte(strings.Join(values, ":")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// authorization must be called with the client locked, as it bumps the
+// nonce count.
+func (d *digest) authorization(username, password, method, uri string) (string, error) {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(buf[:])
+
+	d.nc++
+	nc := fmt.Sprintf("%08x", d.nc)
+
+	ha1 := d.hash(username, d.realm, password)
+	if strings.HasSuffix(strings.ToUpper(d.algorithm), "-SESS") {
+		ha1 = d.hash(ha1, d.nonce, cnonce)
+	}
+	ha2 := d.hash(method, uri)
+
+	var response string
+	if d.qop {
+		response = d.hash(ha1, d.nonce, nc, cnonce, "auth", ha2)
+	} else {
+		response = d.hash(ha1, d.nonce, ha2)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%q, realm=%q, nonce=%q, uri=%q, response=%q`,
+		username, d.realm, d.nonce, uri, response)
+	if d.algorithm != "" {
+		fmt.Fprintf(&b, ", algorithm=%s", d.algorithm)
+	}
+	if d.opaque != "" {
+		fmt.Fprintf(&b, ", opaque=%q", d.opaque)
+	}
+	if d.qop {
+		fmt.Fprintf(&b, ", qop=auth, nc=%s, cnonce=%q", nc, cnonce)
+	}
+	return b.String(), nil
+}
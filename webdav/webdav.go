@@ -0,0 +1,544 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package webdav is the WebDAV client shared by the storage, importer
+// and exporter connectors, configured with:
+//
+//	username, password	credentials, also read from the location
+//	auth		auto, basic or digest.  With auto, the default,
+//			the scheme is the one the server asks for.
+//	use_tls		defaults to true
+//	ca_bundle	PEM file of additional certificate authorities
+//	tls_insecure_skip_verify	don't verify the server certificate
+package webdav
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	plakarstorage "github.com/PlakarKorp/plakar/storage"
+)
+
+type Client struct {
+	base   url.URL
+	client *http.Client
+
+	username string
+	password string
+	auth     string
+
+	mtx    sync.Mutex
+	digest *digest
+}
+
+func Connect(location *url.URL, params map[string]string) (*Client, error) {
+	useTls := true
+	if value, ok := params["use_tls"]; ok {
+		tmp, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid use_tls value")
+		}
+		useTls = tmp
+	}
+
+	auth := params["auth"]
+	switch auth {
+	case "":
+		auth = "auto"
+	case "auto", "basic", "digest":
+	default:
+		return nil, fmt.Errorf("invalid auth value")
+	}
+
+	username := location.User.Username()
+	password, _ := location.User.Password()
+	if value, ok := params["username"]; ok {
+		username = value
+	}
+	if value, ok := params["password"]; ok {
+		password = value
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{}
+	if value, ok := params["tls_insecure_skip_verify"]; ok {
+		skip, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls_insecure_skip_verify value")
+		}
+		transport.TLSClientConfig.InsecureSkipVerify = skip
+	}
+	if bundle := params["ca_bundle"]; bundle != "" {
+		pem, err := os.ReadFile(bundle)
+		if err != nil {
+			return nil, fmt.Errorf("read ca_bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in ca_bundle %s", bundle)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	scheme := "https"
+	if !useTls {
+		scheme = "http"
+	}
+
+	return &Client{
+		base:     url.URL{Scheme: scheme, Host: location.Host},
+		client:   &http.Client{Transport: transport},
+		username: username,
+		password: password,
+		auth:     auth,
+	}, nil
+}
+
+// IsTransient tells the errors worth retrying: the server failing or
+// throttling requests, on top of network errors.
+func IsTransient(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError ||
+			statusErr.StatusCode == http.StatusRequestTimeout ||
+			statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	return plakarstorage.IsTransient(err)
+}
+
+type StatusError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Method, e.Path, e.Status)
+}
+
+func statusError(method, pathname string, res *http.Response) error {
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		return &fs.PathError{Op: method, Path: pathname, Err: fs.ErrNotExist}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return &fs.PathError{Op: method, Path: pathname, Err: fs.ErrPermission}
+	}
+	return &StatusError{Method: method, Path: pathname, StatusCode: res.StatusCode, Status: res.Status}
+}
+
+func (c *Client) url(pathname string) string {
+	u := c.base
+	u.Path = path.Clean("/" + pathname)
+	return u.String()
+}
+
+func (c *Client) do(method, pathname string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.url(pathname), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return c.send(req)
+}
+
+// send answers the authentication challenge of the server.  A body that
+// can't be sent twice is preceded by a request without one, for the
+// server to tell the scheme it wants.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if c.username != "" && req.Body != nil && req.Body != http.NoBody && req.GetBody == nil && !c.ready() {
+		res, err := c.send(&http.Request{
+			Method: http.MethodOptions,
+			URL:    req.URL,
+			Header: make(http.Header),
+			Host:   req.Host,
+		})
+		if err != nil {
+			return nil, err
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+
+	if err := c.authorize(req); err != nil {
+		return nil, err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusUnauthorized || c.username == "" {
+		return res, nil
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return res, nil
+	}
+
+	retry, err := c.challenge(res.Header.Values("WWW-Authenticate"))
+	if err != nil || !retry {
+		return res, err
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	if req.GetBody != nil {
+		if req.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.authorize(req); err != nil {
+		return nil, err
+	}
+	return c.client.Do(req)
+}
+
+// ready tells if the credentials can be sent without a challenge.
+func (c *Client) ready() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.digest != nil || c.auth == "basic"
+}
+
+func (c *Client) authorize(req *http.Request) error {
+	if c.username == "" {
+		return nil
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	switch {
+	case c.digest != nil:
+		authorization, err := c.digest.authorization(c.username, c.password, req.Method, req.URL.RequestURI())
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", authorization)
+	case c.auth == "basic":
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return nil
+}
+
+// challenge records the scheme asked by the server, and returns true if
+// the request is worth sending again with it.
+func (c *Client) challenge(challenges []string) (bool, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, value := range challenges {
+		scheme, params, _ := strings.Cut(value, " ")
+		switch strings.ToLower(scheme) {
+		case "digest":
+			if c.auth == "basic" {
+				continue
+			}
+			d, err := parseDigest(params)
+			if err != nil {
+				return false, err
+			}
+			// a challenge to credentials already sent with a
+			// nonce that isn't stale means they are wrong
+			retry := c.digest == nil || d.stale
+			c.digest = d
+			return retry, nil
+		case "basic":
+			if c.auth != "auto" {
+				continue
+			}
+			c.auth = "basic"
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// FileInfo describes a resource, as reported by PROPFIND.
+type FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi *FileInfo) Name() string       { return fi.name }
+func (fi *FileInfo) Size() int64        { return fi.size }
+func (fi *FileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *FileInfo) IsDir() bool        { return fi.isDir }
+func (fi *FileInfo) Sys() any           { return nil }
+
+func (fi *FileInfo) Mode() fs.FileMode {
+	if fi.isDir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+const propfind = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/><D:getlastmodified/></D:prop></D:propfind>`
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ContentLength string `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+func (c *Client) propfind(pathname string, depth string) (map[string]*FileInfo, error) {
+	res, err := c.do("PROPFIND", pathname, strings.NewReader(propfind), http.Header{
+		"Depth":        {depth},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusMultiStatus {
+		return nil, statusError("PROPFIND", pathname, res)
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("PROPFIND %s: %w", pathname, err)
+	}
+
+	infos := make(map[string]*FileInfo, len(ms.Responses))
+	for _, response := range ms.Responses {
+		href, err := url.Parse(response.Href)
+		if err != nil {
+			return nil, fmt.Errorf("PROPFIND %s: %w", pathname, err)
+		}
+		name := path.Clean("/" + href.Path)
+
+		fi := &FileInfo{name: path.Base(name)}
+		for _, propstat := range response.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			prop := propstat.Prop
+			fi.isDir = prop.ResourceType.Collection != nil
+			if prop.ContentLength != "" {
+				fi.size, _ = strconv.ParseInt(prop.ContentLength, 10, 64)
+			}
+			if prop.LastModified != "" {
+				fi.modTime, _ = http.ParseTime(prop.LastModified)
+			}
+		}
+		infos[name] = fi
+	}
+	return infos, nil
+}
+
+func (c *Client) Stat(pathname string) (*FileInfo, error) {
+	infos, err := c.propfind(pathname, "0")
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range infos {
+		return fi, nil
+	}
+	return nil, &fs.PathError{Op: "PROPFIND", Path: pathname, Err: fs.ErrNotExist}
+}
+
+// ReadDir returns the entries of a collection, in no particular order.
+func (c *Client) ReadDir(pathname string) ([]*FileInfo, error) {
+	infos, err := c.propfind(pathname, "1")
+	if err != nil {
+		return nil, err
+	}
+
+	self := path.Clean("/" + pathname)
+	entries := make([]*FileInfo, 0, len(infos))
+	for name, fi := range infos {
+		if name == self {
+			continue
+		}
+		entries = append(entries, fi)
+	}
+	return entries, nil
+}
+
+// Mkdir fails with fs.ErrExist if pathname already exists.
+func (c *Client) Mkdir(pathname string) error {
+	res, err := c.do("MKCOL", pathname, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusCreated, http.StatusOK:
+		return nil
+	case http.StatusMethodNotAllowed:
+		return &fs.PathError{Op: "MKCOL", Path: pathname, Err: fs.ErrExist}
+	}
+	return statusError("MKCOL", pathname, res)
+}
+
+func (c *Client) MkdirAll(pathname string) error {
+	pathname = path.Clean("/" + pathname)
+	if pathname == "/" {
+		return nil
+	}
+
+	if fi, err := c.Stat(pathname); err == nil {
+		if !fi.IsDir() {
+			return &fs.PathError{Op: "MKCOL", Path: pathname, Err: fs.ErrExist}
+		}
+		return nil
+	}
+
+	if err := c.MkdirAll(path.Dir(pathname)); err != nil {
+		return err
+	}
+	if err := c.Mkdir(pathname); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+func (c *Client) Open(pathname string) (io.ReadCloser, error) {
+	res, err := c.do("GET", pathname, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, statusError("GET", pathname, res)
+	}
+	return res.Body, nil
+}
+
+// OpenRange reads length bytes at offset, skipping to it if the server
+// ignores the range.
+func (c *Client) OpenRange(pathname string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	res, err := c.do("GET", pathname, nil, http.Header{
+		"Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		if _, err := io.CopyN(io.Discard, res.Body, offset); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		res.Body.Close()
+		return nil, fmt.Errorf("invalid length")
+	default:
+		res.Body.Close()
+		return nil, statusError("GET", pathname, res)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(res.Body, length), res.Body}, nil
+}
+
+// Put writes rd to pathname, size may be -1 if unknown.
+func (c *Client) Put(pathname string, rd io.Reader, size int64) error {
+	req, err := http.NewRequest("PUT", c.url(pathname), rd)
+	if err != nil {
+		return err
+	}
+	if size >= 0 {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body, req.GetBody = http.NoBody, nil
+		}
+	}
+
+	res, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusCreated, http.StatusNoContent, http.StatusOK:
+		return nil
+	}
+	return statusError("PUT", pathname, res)
+}
+
+func (c *Client) Remove(pathname string) error {
+	res, err := c.do("DELETE", pathname, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusNoContent, http.StatusOK, http.StatusAccepted:
+		return nil
+	}
+	return statusError("DELETE", pathname, res)
+}
+
+// Rename moves oldpath to newpath, replacing it if it exists.
+func (c *Client) Rename(oldpath, newpath string) error {
+	res, err := c.do("MOVE", oldpath, nil, http.Header{
+		"Destination": {c.url(newpath)},
+		"Overwrite":   {"T"},
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusCreated, http.StatusNoContent:
+		return nil
+	}
+	return statusError("MOVE", oldpath, res)
+}
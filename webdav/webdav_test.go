@@ -0,0 +1,111 @@
+package webdav
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"strings"
+	"testing"
+
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	for _, auth := range []string{"", "basic", "digest"} {
+		t.Run("auth="+auth, func(t *testing.T) {
+			server := ptesting.NewMockWebDAVServer(t, auth)
+
+			location, err := url.Parse("webdav://test:test@" + server.Addr + "/")
+			require.NoError(t, err)
+			client, err := Connect(location, map[string]string{"use_tls": "false"})
+			require.NoError(t, err)
+
+			require.NoError(t, client.MkdirAll("/a/b"))
+			require.NoError(t, client.MkdirAll("/a/b"))
+			err = client.Mkdir("/a")
+			require.True(t, errors.Is(err, fs.ErrExist), "%v", err)
+
+			// a reader that can't be rewound, sent after a probe
+			data := "0123456789"
+			require.NoError(t, client.Put("/a/b/file", io.MultiReader(strings.NewReader(data)), -1))
+			require.NoError(t, client.Put("/a/empty", bytes.NewReader(nil), 0))
+
+			fi, err := client.Stat("/a/b/file")
+			require.NoError(t, err)
+			require.Equal(t, "file", fi.Name())
+			require.Equal(t, int64(len(data)), fi.Size())
+			require.False(t, fi.IsDir())
+
+			entries, err := client.ReadDir("/a")
+			require.NoError(t, err)
+			require.Len(t, entries, 2)
+
+			rd, err := client.OpenRange("/a/b/file", 2, 3)
+			require.NoError(t, err)
+			read, err := io.ReadAll(rd)
+			require.NoError(t, err)
+			require.NoError(t, rd.Close())
+			require.Equal(t, "234", string(read))
+
+			require.NoError(t, client.Rename("/a/b/file", "/a/moved"))
+			_, err = client.Open("/a/b/file")
+			require.True(t, errors.Is(err, fs.ErrNotExist), "%v", err)
+
+			rd, err = client.Open("/a/moved")
+			require.NoError(t, err)
+			read, err = io.ReadAll(rd)
+			require.NoError(t, err)
+			require.NoError(t, rd.Close())
+			require.Equal(t, data, string(read))
+
+			require.NoError(t, client.Remove("/a/moved"))
+			_, err = client.Stat("/a/moved")
+			require.True(t, errors.Is(err, fs.ErrNotExist), "%v", err)
+		})
+	}
+}
+
+func TestClientWrongCredentials(t *testing.T) {
+	for _, auth := range []string{"basic", "digest"} {
+		server := ptesting.NewMockWebDAVServer(t, auth)
+
+		location, err := url.Parse("webdav://test:wrong@" + server.Addr + "/")
+		require.NoError(t, err)
+		client, err := Connect(location, map[string]string{"use_tls": "false"})
+		require.NoError(t, err)
+
+		_, err = client.Stat("/")
+		require.True(t, errors.Is(err, fs.ErrPermission), "%s: %v", auth, err)
+	}
+}
+
+func TestConnectOptions(t *testing.T) {
+	location, err := url.Parse("webdav://localhost/")
+	require.NoError(t, err)
+
+	_, err = Connect(location, map[string]string{"auth": "ntlm"})
+	require.EqualError(t, err, "invalid auth value")
+
+	_, err = Connect(location, map[string]string{"use_tls": "maybe"})
+	require.EqualError(t, err, "invalid use_tls value")
+
+	_, err = Connect(location, map[string]string{"tls_insecure_skip_verify": "maybe"})
+	require.EqualError(t, err, "invalid tls_insecure_skip_verify value")
+
+	require.True(t, IsTransient(&StatusError{StatusCode: 503}))
+	require.True(t, IsTransient(&StatusError{StatusCode: 429}))
+	require.False(t, IsTransient(&StatusError{StatusCode: 409}))
+}
+
+func TestParseParams(t *testing.T) {
+	params := parseParams(`realm="a, b", qop="auth,auth-int", nonce=abc, stale=TRUE`)
+	require.Equal(t, map[string]string{
+		"realm": "a, b",
+		"qop":   "auth,auth-int",
+		"nonce": "abc",
+		"stale": "TRUE",
+	}, params)
+}